@@ -0,0 +1,20 @@
+#!/bin/bash
+
+set -o errtrace
+set -o nounset
+
+# add-bridge.sh BRIDGE TUNNEL TUNNEL_DST_PORT - creates, unless it already
+# exists, the bridge with the same pipeline as the one created by setup.sh.
+#   BRIDGE:          Bridge name
+#   TUNNEL:          Name of the bridge's VXLAN tunnel port
+#   TUNNEL_DST_PORT: UDP port of the bridge's VXLAN tunnel
+export BRIDGE=$1
+export TUNNEL=$2
+export TUNNEL_DST_PORT=$3
+export HOSTPORT=
+
+dir="$(dirname "$0")"
+
+sudo ovs-vsctl br-exists $BRIDGE && exit 0
+
+"$dir/setup.sh"
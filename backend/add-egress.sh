@@ -0,0 +1,76 @@
+#!/bin/bash
+
+set -o errtrace
+set -o nounset
+
+dir="$(dirname "$0")"
+source "$dir/config.sh"
+source "$dir/utils.sh"
+
+# add-egress.sh NAME COOKIE NS SUBNETS DESTS GW_MACS [UPLINK] [SNATS] - (Re)installs an egress gateway
+#   NAME:      Name of the gateway
+#   COOKIE:    Cookie of the gateway's flows
+#   NS:        Namespace of the gateway's endpoints
+#   SUBNETS:   Space separated subnets of the gateway's endpoints
+#   DESTS:     Space separated destinations routed through the gateway
+#   GW_MACS:   Comma separated MACs of the router of NS
+#   UPLINK:    Interface the packets leave the node through, any but the host
+#              port if empty
+#   SNATS:     Space separated SRC,IP of the endpoints with a fixed egress IP
+NAME=$1
+COOKIE=$2
+NS=$3
+SUBNETS=$4
+DESTS=$5
+GW_MACS=$6
+UPLINK=${7:-}
+SNATS=${8:-}
+
+del_egress $COOKIE/-1 $NAME
+
+OFPORT=$(get_ofport $BRIDGE $HOSTPORT)
+HOST_MAC=$(cat /sys/class/net/$HOSTPORT/address)
+OUT="! -o $HOSTPORT"
+[ "$UPLINK" ] && {
+	OUT="-o $UPLINK"
+}
+
+sudo sysctl -q -w net.ipv4.ip_forward=1
+
+for SUBNET in $SUBNETS; do
+	sudo ip route replace $SUBNET dev $HOSTPORT
+
+	# The host reaches the endpoints through the router of NS
+	arp_respond_subnet $BRIDGE $SUBNET ${GW_MACS%%,*} $OFPORT $COOKIE
+	ofctl add-flow $BRIDGE "priority=40000, table=$TBL_PRE, cookie=$COOKIE, \
+			in_port=$OFPORT, ip, nw_dst=$SUBNET, \
+			actions=load:${NS}->$REG_NS_OF, \
+				goto_table:$TBL_MAIN"
+
+	for DEST in $DESTS; do
+		# Route the packets of the endpoints to the host, after their
+		# namespace's routes and endpoints
+		for GW_MAC in ${GW_MACS//,/ }; do
+			ofctl add-flow $BRIDGE "priority=5, table=$TBL_MAIN, cookie=$COOKIE, \
+					$REG_NS=$NS, dl_dst=$GW_MAC, ip, nw_src=$SUBNET, nw_dst=$DEST, \
+					actions=load:${OFPORT}->$REG_PORT_OF, \
+						mod_dl_dst:$HOST_MAC, \
+						dec_ttl, \
+						mod_dl_src:$GW_MAC, \
+						goto_table:$TBL_POLICY"
+		done
+
+		sudo iptables -t nat -A POSTROUTING -s $SUBNET -d $DEST $OUT \
+			-m comment --comment cilium-egress:$NAME -j MASQUERADE
+	done
+done
+
+# Fixed egress IPs go before the masquerading
+for SNAT in $SNATS; do
+	for DEST in $DESTS; do
+		sudo iptables -t nat -I POSTROUTING -s ${SNAT%%,*}/32 -d $DEST $OUT \
+			-m comment --comment cilium-egress:$NAME -j SNAT --to-source ${SNAT#*,}
+	done
+done
+
+exit 0
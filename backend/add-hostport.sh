@@ -0,0 +1,62 @@
+#!/bin/bash
+
+set -o errtrace
+set -o nounset
+
+dir="$(dirname "$0")"
+source "$dir/config.sh"
+source "$dir/utils.sh"
+
+# add-hostport.sh COOKIE NS HOST_IP HOST_PORT PROTO IP PORT GW_MAC - (Re)publishes a host port
+#   COOKIE:    Cookie of the host port's flows
+#   NS:        Namespace of the endpoint
+#   HOST_IP:   IP of the node the port is published on
+#   HOST_PORT: Port published on HOST_IP
+#   PROTO:     Protocol, tcp or udp
+#   IP:        IP of the endpoint
+#   PORT:      Port of the endpoint
+#   GW_MAC:    MAC of the endpoint's gateway
+COOKIE=$1
+NS=$2
+HOST_IP=$3
+HOST_PORT=$4
+PROTO=$5
+IP=$6
+PORT=$7
+GW_MAC=$8
+KEY="$HOST_IP:$HOST_PORT/$PROTO"
+
+del_hostport $COOKIE/-1 $KEY
+
+OFPORT=$(get_ofport $BRIDGE $HOSTPORT)
+HOST_MAC=$(cat /sys/class/net/$HOSTPORT/address)
+
+sudo sysctl -q -w net.ipv4.ip_forward=1
+sudo ip route replace $IP/32 dev $HOSTPORT
+
+# The host reaches the endpoint through its gateway
+arp_respond_subnet $BRIDGE $IP/32 $GW_MAC $OFPORT $COOKIE
+ofctl add-flow $BRIDGE "priority=40000, table=$TBL_PRE, cookie=$COOKIE, \
+		in_port=$OFPORT, ip, nw_dst=$IP, \
+		actions=load:${NS}->$REG_NS_OF, \
+			goto_table:$TBL_MAIN"
+
+# Route the replies of the endpoint to the host, after its namespace's routes
+# and endpoints
+ofctl add-flow $BRIDGE "priority=6, table=$TBL_MAIN, cookie=$COOKIE, \
+		$REG_NS=$NS, dl_dst=$GW_MAC, $PROTO, nw_src=$IP, tp_src=$PORT, \
+		actions=load:${OFPORT}->$REG_PORT_OF, \
+			mod_dl_dst:$HOST_MAC, \
+			dec_ttl, \
+			mod_dl_src:$GW_MAC, \
+			goto_table:$TBL_POLICY"
+
+# DNAT the packets to HOST_IP:HOST_PORT, from other hosts and from this one
+for CHAIN in PREROUTING OUTPUT; do
+	sudo iptables -t nat -A $CHAIN -d $HOST_IP -p $PROTO --dport $HOST_PORT \
+		-m comment --comment cilium-hostport:$KEY -j DNAT --to-destination $IP:$PORT
+done
+sudo iptables -I FORWARD -d $IP -p $PROTO --dport $PORT \
+	-m comment --comment cilium-hostport:$KEY -j ACCEPT
+
+exit 0
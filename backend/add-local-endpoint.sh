@@ -34,11 +34,13 @@ ofctl add-flow $BRIDGE "table=$TBL_MAIN, cookie=$COOKIE, \
 arp_optimize $BRIDGE $IP $MAC $BD $OFPORT $GRP $COOKIE
 
 # Map dIP to dGRP and OFPORT and perform L3
-ofctl add-flow $BRIDGE "priority=15, table=$TBL_MAIN, cookie=$COOKIE, \
-			$REG_NS=$NS, dl_dst=$LOGICAL_ROUTER_MAC, ip, nw_dst=$IP, \
+for GW_MAC in ${ROUTER_MACS//,/ }; do
+	ofctl add-flow $BRIDGE "priority=15, table=$TBL_MAIN, cookie=$COOKIE, \
+			$REG_NS=$NS, dl_dst=$GW_MAC, ip, nw_dst=$IP, \
 			actions=load:${GRP}->$REG_DGRP_OF, \
 				load:${OFPORT}->$REG_PORT_OF, \
 				mod_dl_dst:$MAC, \
 				dec_ttl, \
-				mod_dl_src:$LOGICAL_ROUTER_MAC, \
+				mod_dl_src:$ROUTER_MAC, \
 				goto_table:$TBL_POLICY"
+done
@@ -0,0 +1,42 @@
+#!/bin/bash
+
+set -o errtrace
+set -o nounset
+
+dir="$(dirname "$0")"
+source "$dir/config.sh"
+source "$dir/utils.sh"
+
+# add-router.sh NS COOKIE GATEWAYS ROUTES - (Re)installs the router of a namespace
+#   NS:        Namespace of the router
+#   COOKIE:    Cookie of the router's flows
+#   GATEWAYS:  Space separated IP,MAC of the router in each subnet
+#   ROUTES:    Space separated SUBNET,NS,MAC of the other namespaces' subnets
+#              reachable from NS, MAC is one of the other namespace's router
+NS=$1
+COOKIE=$2
+GATEWAYS=${3:-}
+ROUTES=${4:-}
+
+ofctl del-flows $BRIDGE cookie=$COOKIE/-1 | true
+
+# Reply to ARP requests for the gateways
+for GW in $GATEWAYS; do
+	arp_respond_ns $BRIDGE ${GW%%,*} ${GW#*,} $NS $COOKIE
+done
+
+# Move the packets routed to other namespaces to the other namespace's router
+for ROUTE in $ROUTES; do
+	SUBNET=$(echo $ROUTE | cut -d, -f1)
+	TO_NS=$(echo $ROUTE | cut -d, -f2)
+	TO_MAC=$(echo $ROUTE | cut -d, -f3)
+	for GW in $GATEWAYS; do
+		ofctl add-flow $BRIDGE "priority=12, table=$TBL_MAIN, cookie=$COOKIE, \
+				$REG_NS=$NS, dl_dst=${GW#*,}, ip, nw_dst=$SUBNET, \
+				actions=load:${TO_NS}->$REG_NS_OF, \
+					mod_dl_dst:$TO_MAC, \
+					resubmit(,$TBL_MAIN)"
+	done
+done
+
+exit 0
@@ -1,13 +1,22 @@
 #!/bin/bash
 
-BRIDGE="lxc-br0"
-TUNNEL="vx0"
-HOSTPORT="host0"
+# The bridge, its tunnel port and its VXLAN UDP port can be overwritten to
+# manage other bridges, which don't have a host port.
+BRIDGE="${BRIDGE:-lxc-br0}"
+TUNNEL="${TUNNEL:-vx0}"
+TUNNEL_DST_PORT="${TUNNEL_DST_PORT:-4789}"
+HOSTPORT="${HOSTPORT-host0}"
 OFVERSION="OpenFlow13,OpenFlow12,OpenFlow11,OpenFlow10"
 
+# Default router of the namespaces without their own router
 LOGICAL_ROUTER=192.0.2.1
 LOGICAL_ROUTER_MAC=dd:dd:dd:dd:dd:dd
 
+# MACs, comma separated, of the router of an endpoint's namespace, the one of
+# its subnet's gateway first
+ROUTER_MACS="${ROUTER_MACS:-$LOGICAL_ROUTER_MAC}"
+ROUTER_MAC="${ROUTER_MACS%%,*}"
+
 # Pipeline
 TBL_PRE=0
 TBL_MAIN=1
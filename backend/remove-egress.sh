@@ -0,0 +1,24 @@
+#!/bin/bash
+
+set -o errtrace
+set -o nounset
+
+dir="$(dirname "$0")"
+source "$dir/config.sh"
+source "$dir/utils.sh"
+
+# remove-egress.sh COOKIE NAME [SUBNETS] - Removes an egress gateway
+#   COOKIE:    Cookie, and mask, of the gateway's flows
+#   NAME:      Name of the gateway, all gateways if empty
+#   SUBNETS:   Space separated subnets whose routes are removed
+COOKIE=$1
+NAME=${2:-}
+SUBNETS=${3:-}
+
+del_egress $COOKIE $NAME
+
+for SUBNET in $SUBNETS; do
+	sudo ip route del $SUBNET dev $HOSTPORT 2> /dev/null
+done
+
+exit 0
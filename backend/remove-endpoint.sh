@@ -10,7 +10,7 @@ COOKIE=$(hex2cookie $1)
 PORT=$2
 
 [ -z "PORT" ] && {
-	PORT=$(ofctl dump-flows lxc-br0 cookie=$COOKIE/-1,table=0)
+	PORT=$(ofctl dump-flows $BRIDGE cookie=$COOKIE/-1,table=0)
 	PORT=$(echo $PORT | sed -n 's/.*in_port=\([0-9]*\).*/\1/p')
 }
 
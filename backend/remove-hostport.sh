@@ -0,0 +1,24 @@
+#!/bin/bash
+
+set -o errtrace
+set -o nounset
+
+dir="$(dirname "$0")"
+source "$dir/config.sh"
+source "$dir/utils.sh"
+
+# remove-hostport.sh COOKIE KEY [IP] - Removes a published host port
+#   COOKIE:    Cookie, and mask, of the host port's flows
+#   KEY:       Host IP, port and protocol, in the form IP:PORT/PROTO
+#   IP:        IP of the endpoint, whose route is removed
+COOKIE=$1
+KEY=$2
+IP=${3:-}
+
+del_hostport $COOKIE $KEY
+
+[ "$IP" ] && {
+	sudo ip route del $IP/32 dev $HOSTPORT 2> /dev/null
+}
+
+exit 0
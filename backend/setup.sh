@@ -26,15 +26,18 @@ echo "Creating tunnel port $TUNNEL ..."
 sudo ovs-vsctl add-port $BRIDGE $TUNNEL -- \
 	set Interface $TUNNEL type=vxlan \
 		options:remote_ip=flow \
-		options:key=flow
+		options:key=flow \
+		options:dst_port=$TUNNEL_DST_PORT
 TUNNEL_OFPORT=$(get_ofport $BRIDGE $TUNNEL)
 
 add_flow_broadcast $BRIDGE $BD_MAIN
 add_router $BRIDGE $LOGICAL_ROUTER $BD_MAIN $LOGICAL_ROUTER_MAC
 
-sudo ovs-vsctl add-port $BRIDGE $HOSTPORT -- \
-	set Interface $HOSTPORT type=internal
-sudo ip link set $HOSTPORT up
+[ "$HOSTPORT" ] && {
+	sudo ovs-vsctl add-port $BRIDGE $HOSTPORT -- \
+		set Interface $HOSTPORT type=internal
+	sudo ip link set $HOSTPORT up
+}
 
 # Default policy: allow everything
 ofctl add-flow $BRIDGE "table=$TBL_POLICY, cookie=0xfffffffffffffff, actions=output:$REG_PORT_OF"
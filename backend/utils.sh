@@ -143,6 +143,33 @@ function arp_optimize()
         ofctl add-flow $BRNAME "priority=15, table=$TBL_MAIN, $FILTER, actions=$ACTIONS"
 }
 
+# arp_respond_ns BRIDGE IP MAC NS COOKIE - Install flow to repond to ARP requests for IP with MAC in NS
+#   BRIDGE:     Name of bridge to configure
+#   IP:         IP to respond to
+#   MAC:        MAC to respond with
+#   NS:         Namespace context to respond in
+#   COOKIE:     Cookie of the flow
+#
+function arp_respond_ns()
+{
+        local BRNAME=$1
+        local IP=$2
+        local MAC=$3
+
+        local FILTER="arp, arp_op=1, arp_tpa=$IP, $REG_NS=$4"
+
+        local ACTIONS="move:NXM_OF_ETH_SRC[]->NXM_OF_ETH_DST[], \
+                       mod_dl_src:$MAC, \
+                       load:2->NXM_OF_ARP_OP[], \
+                       move:NXM_NX_ARP_SHA[]->NXM_NX_ARP_THA[], \
+                       load:$(mac2hex $MAC)->NXM_NX_ARP_SHA[], \
+                       move:NXM_OF_ARP_SPA[]->NXM_OF_ARP_TPA[], \
+                       load:$(ip2hex $IP)->NXM_OF_ARP_SPA[], \
+                       in_port"
+
+        ofctl add-flow $BRNAME "priority=20, table=$TBL_MAIN, cookie=$5, $FILTER, actions=$ACTIONS"
+}
+
 # add_router BRIDGE ADDR BD - Agent is told to setup a new default router
 #   BRIDGE:     Name of bridge
 #   ADDR:       Address of default router
@@ -158,3 +185,74 @@ function add_router()
         # The agent can choose any MAC address as long as it is unique
         arp_respond $BRNAME $ADDR $ROUTER_MAC $BD
 }
+
+# arp_respond_subnet BRIDGE SUBNET MAC OFPORT COOKIE - Install flow to repond to ARP requests for SUBNET from OFPORT with MAC
+#   BRIDGE:     Name of bridge to configure
+#   SUBNET:     Subnet whose addresses are responded to
+#   MAC:        MAC to respond with
+#   OFPORT:     OF port the requests come from
+#   COOKIE:     Cookie of the flow
+#
+# Installs a flow in the $TBL_PRE, before the port's own mappings, so the
+# packets of the host port to the addresses of SUBNET are sent to MAC.
+#
+function arp_respond_subnet()
+{
+        local BRNAME=$1
+        local MAC=$3
+
+        local FILTER="in_port=$4, arp, arp_op=1, arp_tpa=$2"
+
+        local ACTIONS="move:NXM_OF_ETH_SRC[]->NXM_OF_ETH_DST[], \
+                       mod_dl_src:$MAC, \
+                       load:2->NXM_OF_ARP_OP[], \
+                       move:NXM_NX_ARP_SHA[]->NXM_NX_ARP_THA[], \
+                       load:$(mac2hex $MAC)->NXM_NX_ARP_SHA[], \
+                       move:NXM_OF_ARP_TPA[]->NXM_NX_REG5[], \
+                       move:NXM_OF_ARP_SPA[]->NXM_OF_ARP_TPA[], \
+                       move:NXM_NX_REG5[]->NXM_OF_ARP_SPA[], \
+                       in_port"
+
+        ofctl add-flow $BRNAME "priority=40000, table=$TBL_PRE, cookie=$5, $FILTER, actions=$ACTIONS"
+}
+
+# del_egress COOKIE [NAME] - Remove the flows and the NAT rules of an egress gateway
+#   COOKIE:     Cookie, and mask, of the gateway's flows
+#   [NAME:]     Name of the gateway, all gateways if empty
+#
+function del_egress()
+{
+        local COOKIE=$1
+        local COMMENT="--comment cilium-egress:${2:-}"
+
+        # An exact name is followed by the rule's target
+        [[ ${2:-} ]] && {
+                COMMENT="$COMMENT "
+        }
+
+        ofctl del-flows $BRIDGE "cookie=$COOKIE" | true
+
+        sudo iptables -t nat -S POSTROUTING | grep -F -- "$COMMENT" | sed 's/^-A/-D/' | \
+                while read RULE; do
+                        sudo iptables -t nat $RULE
+                done
+}
+
+# del_hostport COOKIE KEY - Remove the flows and the iptables rules of a published host port
+#   COOKIE:     Cookie, and mask, of the host port's flows
+#   KEY:        Host IP, port and protocol, in the form IP:PORT/PROTO
+#
+function del_hostport()
+{
+        local COOKIE=$1
+        local COMMENT="--comment cilium-hostport:$2 "
+
+        ofctl del-flows $BRIDGE "cookie=$COOKIE" | true
+
+        for TABLE in nat filter; do
+                sudo iptables -t $TABLE -S | grep -F -- "$COMMENT" | sed 's/^-A/-D/' | \
+                        while read RULE; do
+                                sudo iptables -t $TABLE $RULE
+                        done
+        done
+}
@@ -4,27 +4,39 @@ import (
 	"flag"
 	"fmt"
 	"io/ioutil"
+	"net"
 	"net/http"
 	"os"
 	"strconv"
+	"strings"
 	"sync"
 	"time"
 
 	c "github.com/cilium-team/cilium/cilium/config"
 	h "github.com/cilium-team/cilium/cilium/hook"
+	"github.com/cilium-team/cilium/cilium/hook/prehook"
 	m "github.com/cilium-team/cilium/cilium/messages"
+	"github.com/cilium-team/cilium/cilium/proxy"
 	u "github.com/cilium-team/cilium/cilium/utils"
+	ub "github.com/cilium-team/cilium/cilium/utils/bus"
 	uc "github.com/cilium-team/cilium/cilium/utils/comm"
 	ucdb "github.com/cilium-team/cilium/cilium/utils/comm/db"
+	ue "github.com/cilium-team/cilium/cilium/utils/events"
+	uo "github.com/cilium-team/cilium/cilium/utils/overlay"
+	up "github.com/cilium-team/cilium/cilium/utils/profile"
 	upr "github.com/cilium-team/cilium/cilium/utils/profile/runnables"
+	uprc "github.com/cilium-team/cilium/cilium/utils/profile/runnables/constraints"
 	uprd "github.com/cilium-team/cilium/cilium/utils/profile/runnables/docker"
 	upri "github.com/cilium-team/cilium/cilium/utils/profile/runnables/intent"
 	uprk "github.com/cilium-team/cilium/cilium/utils/profile/runnables/kubernetes"
+	uprs "github.com/cilium-team/cilium/cilium/utils/profile/runnables/secrets"
+	uprw "github.com/cilium-team/cilium/cilium/utils/profile/runnables/webhook"
+	us "github.com/cilium-team/cilium/cilium/utils/secrets"
+	uw "github.com/cilium-team/cilium/cilium/utils/workqueue"
 
 	"github.com/cilium-team/cilium/Godeps/_workspace/src/github.com/ant0ine/go-json-rest/rest"
 	dfsouza "github.com/cilium-team/cilium/Godeps/_workspace/src/github.com/fsouza/go-dockerclient"
 	"github.com/cilium-team/cilium/Godeps/_workspace/src/github.com/op/go-logging"
-	d "github.com/cilium-team/cilium/Godeps/_workspace/src/github.com/samalba/dockerclient"
 )
 
 var (
@@ -34,7 +46,28 @@ var (
 	listOnlyForEvents bool
 	deleteDB          bool
 	flushConfig       bool
+	exportFile        string
+	importFile        string
+	importMode        string
+	deleteNetwork     string
+	deleteEgress      string
+	egress            bool
+	dbType            string
+	dbFile            string
 	port              int
+	nodeLeaseTTL      int
+	dockerProxy       string
+	dockerProxyTLS    string
+	dockerUpstream    string
+	dockerProxySwarm  bool
+	secretsKeyFile    string
+	overlay           bool
+	overlayVNIScheme  string
+	overlayMTU        int
+	overlayManager    *uo.Manager
+	identityLabels    string
+	bridges           string
+	hooksFailOpen     bool
 	log               = logging.MustGetLogger("cilium")
 	wg                sync.WaitGroup
 	stdoutFormat      = logging.MustStringFormatter(
@@ -46,6 +79,8 @@ var (
 	logsDateFormat    = `-2006-01-02`
 	logNameTimeFormat = time.RFC3339
 	containersInCache = u.NewSet()
+	workQueue         = uw.NewQueue(uw.DefaultWorkers, uw.DefaultCapacity)
+	dispatcher        *ub.Dispatcher
 	refreshNetConfig  = 60 //seconds
 )
 
@@ -53,6 +88,16 @@ const (
 	dockerDaemonPreBaseAddr     = "/docker/daemon/cilium-adapter"
 	dockerSwarmPreBaseAddr      = "/docker/swarm/cilium-adapter"
 	kubernetesMasterPreBaseAddr = "/kubernetes/master/cilium-adapter"
+	nodesAddr                   = "/nodes"
+	auditAddr                   = "/audit"
+	quotasAddr                  = "/quotas"
+	deadLettersAddr             = "/dead-letters"
+	eventsAddr                  = "/events"
+	endpointHistoryAddr         = "/endpoint-history"
+	ipHolderAddr                = "/ip-holder"
+	overlayPeersAddr            = "/overlay/peers"
+	networksAddr                = "/networks"
+	identitiesAddr              = "/identities"
 )
 
 func init() {
@@ -61,12 +106,32 @@ func init() {
 	flag.StringVar(&filename, "f", "", "Configuration file or directory containing configuration files that will be written in the distributed database (Accepted formats: ProfileFile, DNSConfig and HA-ProxyConfig)")
 	flag.BoolVar(&deleteDB, "D", false, "Deletes all information inside database")
 	flag.BoolVar(&flushConfig, "F", false, "Clear configuration but keep state in database")
+	flag.StringVar(&exportFile, "export", "", "Exports all information inside database to the given file")
+	flag.StringVar(&importFile, "import", "", "Imports all information from the given file, previously created with -export, into the database")
+	flag.StringVar(&importMode, "import-mode", ucdb.ImportMerge, "Import mode, valid options are (merge|replace|check)")
+	flag.StringVar(&deleteNetwork, "delete-network", "", "Deletes the network with the given name from the database, networks with endpoints aren't deleted")
+	flag.StringVar(&deleteEgress, "delete-egress", "", "Deletes the egress gateway with the given name from the database, the nodes remove it on their next sync")
 	flag.BoolVar(&events, "e", true, "Listens for docker events so it can automatically clean IPs and configurations used by stopped and deleted containers.")
 	flag.BoolVar(&listOnlyForEvents, "o", false, "Listen mode only. It only listens for events from a particular docker daemon.")
+	flag.StringVar(&dbType, "db", ucdb.ElasticDB, "Database used to store all information, valid options are (elastic|memory). The memory database is only suitable for single-node clusters")
+	flag.StringVar(&dbFile, "db-file", "", "File where the memory database is persisted, if not set the memory database is lost when cilium exits")
 	flag.IntVar(&port, "P", 8080, "Cilium's listening port.")
+	flag.IntVar(&nodeLeaseTTL, "n", 30, "Node's lease time, in seconds. Nodes that don't renew their lease within this time are considered dead and all of their endpoints are removed by the alive node with the lowest IP.")
+	flag.StringVar(&dockerProxy, "docker-proxy", "", "Comma separated addresses where cilium serves the docker API, applying its hooks without powerstrip, e.g. unix:///var/run/cilium-docker.sock. tcp:// addresses require -docker-proxy-tls")
+	flag.StringVar(&dockerProxyTLS, "docker-proxy-tls", "", "Directory with the ca.pem, cert.pem and key.pem used to serve the docker API proxy's tcp:// addresses over TLS, only clients with a certificate signed by ca.pem are allowed")
+	flag.StringVar(&dockerUpstream, "docker-proxy-upstream", uc.DockerEndpoint(), "Docker daemon, or swarm master, where the docker API proxy forwards all requests to")
+	flag.BoolVar(&dockerProxySwarm, "docker-proxy-swarm", false, "The docker API proxy forwards requests to a swarm master instead of a docker daemon")
+	flag.StringVar(&secretsKeyFile, "secrets-key", us.DefaultKeyFile, "File with the key, 32 bytes hex encoded, used to encrypt the secrets stored in the database. All nodes must use the same key")
+	flag.BoolVar(&overlay, "overlay", false, "Creates a VXLAN tunnel to each node, instead of relying on the flow based tunnel port, and reports their state")
+	flag.StringVar(&overlayVNIScheme, "overlay-vni-scheme", uo.VNIGroup, "How the overlay carries the source endpoint in the VNI, valid options are (group|bd-group|namespace-group). All nodes must use the same scheme")
+	flag.IntVar(&overlayMTU, "overlay-mtu", uo.DefaultMTU, "MTU of the network between the nodes, endpoints' interfaces get it minus the VXLAN overhead")
+	flag.StringVar(&identityLabels, "identity-labels", "com.intent.service,"+up.IdentityOwnerLabel, "Comma separated keys of the container labels whose values make the identity allocated to containers with auto-group, "+up.IdentityOwnerLabel+" is the owners of the policies covering the container")
+	flag.StringVar(&bridges, "bridges", "", "Comma separated bridges, besides "+u.DefaultBridge+", where intents can attach endpoints with net-conf's br. They are created on demand and all nodes must set the same bridges in the same order")
+	flag.BoolVar(&egress, "egress", false, "Installs, on "+u.DefaultBridge+", the egress gateways stored in the database, masquerading their endpoints through this node's uplink")
+	flag.BoolVar(&hooksFailOpen, "hooks-fail-open", false, "Lets docker and kubernetes requests through, unmodified, when the policies covering them can't be read from the database, instead of denying them")
 	flag.Parse()
 
-	if len(filename) == 0 {
+	if len(filename) == 0 && len(exportFile) == 0 && len(importFile) == 0 {
 		setupRunnables()
 	}
 
@@ -76,30 +141,77 @@ func init() {
 	log.Debug("filename: %+v", filename)
 	log.Debug("deleteDB: %+v", deleteDB)
 	log.Debug("flushConfig: %+v", flushConfig)
+	log.Debug("exportFile: %+v", exportFile)
+	log.Debug("importFile: %+v", importFile)
+	log.Debug("importMode: %+v", importMode)
+	log.Debug("deleteNetwork: %+v", deleteNetwork)
+	log.Debug("deleteEgress: %+v", deleteEgress)
 	log.Debug("events: %+v", events)
 	log.Debug("listOnlyForEvents: %+v", listOnlyForEvents)
 	log.Debug("port: %+v", port)
+	log.Debug("dbType: %+v", dbType)
+	log.Debug("dbFile: %+v", dbFile)
+	log.Debug("nodeLeaseTTL: %+v", nodeLeaseTTL)
+	log.Debug("dockerProxy: %+v", dockerProxy)
+	log.Debug("dockerUpstream: %+v", dockerUpstream)
+	log.Debug("dockerProxySwarm: %+v", dockerProxySwarm)
+	log.Debug("dockerProxyTLS: %+v", dockerProxyTLS)
+	log.Debug("secretsKeyFile: %+v", secretsKeyFile)
+	log.Debug("overlay: %+v", overlay)
+	log.Debug("overlayVNIScheme: %+v", overlayVNIScheme)
+	log.Debug("overlayMTU: %+v", overlayMTU)
+	log.Debug("identityLabels: %+v", identityLabels)
+	log.Debug("bridges: %+v", bridges)
+	log.Debug("egress: %+v", egress)
 	log.Debug("HOST_IP = %+v", os.Getenv("HOST_IP"))
+	log.Debug("TUNNEL_IP = %+v", os.Getenv("TUNNEL_IP"))
 	log.Debug("DOCKER_CERT_PATH = %+v", os.Getenv("DOCKER_CERT_PATH"))
 	log.Debug("DOCKER_HOST = %+v", os.Getenv("DOCKER_HOST"))
 	log.Debug("ELASTIC_PORT = %+v", os.Getenv("ELASTIC_PORT"))
 	log.Debug("ELASTIC_IP = %+v", os.Getenv("ELASTIC_IP"))
 	log.Debug("PIPEWORK = %+v", os.Getenv("PIPEWORK"))
+
+	if err := ucdb.SetDriver(dbType, dbFile); err != nil {
+		log.Fatalf("Failed while setting up the database: %s", err)
+	}
+	if err := us.LoadKeyFile(secretsKeyFile); err != nil {
+		log.Fatalf("Failed while loading the secrets key: %s", err)
+	}
+	if err := u.SetBridges(strings.Split(bridges, ",")); err != nil {
+		log.Fatalf("Failed while setting the bridges: %s", err)
+	}
+	// Changes made by one-shot database operations are audited as made by
+	// the user running them.
+	if user := os.Getenv("USER"); user != "" && isDatabaseOperation() {
+		ucdb.SetAuditIdentity(user, os.Getenv("HOST_IP"))
+	}
 }
 
 func setupRunnables() {
 	log.Debug("Registering runnables")
-	// Order matters, we want intent to be the last one so it can perform
-	// actions based on all merged configurations and policies.
-	if err := upr.Register(uprd.Name, uprd.DockerRunnable{}); err != nil {
-		log.Fatal("Failed while registering a runnable: ", err)
-	}
-	if err := upr.Register(uprk.Name, uprk.KubernetesRunnable{}); err != nil {
-		log.Fatal("Failed while registering a runnable: ", err)
-	}
-	if err := upr.Register(upri.Name, upri.IntentRunnable{}); err != nil {
-		log.Fatal("Failed while registering a runnable: ", err)
+	// Runnables are executed by stage: configurations are merged and patched
+	// by webhooks, the result is checked by constraints, secrets are only set
+	// afterwards so they are never sent to webhooks, and intent is the last one
+	// so it can perform actions based on all merged configurations and
+	// policies.
+	for _, r := range []struct {
+		name     string
+		stage    upr.Stage
+		runnable upr.PolicyRunnable
+	}{
+		{uprd.Name, upr.MergeStage, uprd.DockerRunnable{}},
+		{uprk.Name, upr.MergeStage, uprk.KubernetesRunnable{}},
+		{uprw.Name, upr.WebhookStage, uprw.WebhookRunnable{}},
+		{uprc.Name, upr.ConstraintsStage, uprc.ConstraintsRunnable{}},
+		{uprs.Name, upr.SecretsStage, uprs.SecretsRunnable{}},
+		{upri.Name, upr.IntentStage, upri.IntentRunnable{}},
+	} {
+		if err := upr.Register(r.name, r.stage, r.runnable); err != nil {
+			log.Fatal("Failed while registering a runnable: ", err)
+		}
 	}
+	upri.SetIdentityLabels(strings.Split(identityLabels, ","))
+	prehook.SetFailOpen(hooksFailOpen)
 }
 
 func setupLOG() {
@@ -108,8 +220,8 @@ func setupLOG() {
 		log.Fatal(err)
 	}
 
-	if len(filename) != 0 || deleteDB {
-		backend := logging.NewLogBackend(os.Stderr, "", 0)
+	if isDatabaseOperation() {
+		backend := logging.NewLogBackend(us.NewRedactingWriter(os.Stderr), "", 0)
 		oBF := logging.NewBackendFormatter(backend, fileFormat)
 		backendLeveled := logging.SetBackend(oBF)
 		backendLeveled.SetLevel(level, "")
@@ -124,11 +236,12 @@ func setupLOG() {
 		if err != nil {
 			log.Error("Error while creating log file: %v", err)
 		}
-		fileBackend := logging.NewLogBackend(fo, "", 0)
+		// Values of secrets are redacted from all log output.
+		fileBackend := logging.NewLogBackend(us.NewRedactingWriter(fo), "", 0)
 
 		fBF := logging.NewBackendFormatter(fileBackend, fileFormat)
 
-		backend := logging.NewLogBackend(os.Stderr, "", 0)
+		backend := logging.NewLogBackend(us.NewRedactingWriter(os.Stderr), "", 0)
 		oBF := logging.NewBackendFormatter(backend, fileFormat)
 
 		backendLeveled := logging.SetBackend(fBF, oBF)
@@ -137,8 +250,28 @@ func setupLOG() {
 	}
 }
 
-func databaseOperations(delDB, flushCfg bool, fname string) (bool, error) {
-	exit := delDB || flushCfg || len(fname) != 0
+// isDatabaseOperation returns true if cilium was started to perform a database
+// operation and exit.
+func isDatabaseOperation() bool {
+	return len(filename) != 0 || deleteDB || len(exportFile) != 0 || len(importFile) != 0 || len(deleteNetwork) != 0 ||
+		len(deleteEgress) != 0
+}
+
+func databaseOperations(delDB, flushCfg bool, fname, exportFname, importFname, importMode, delNetwork, delEgress string) (bool, error) {
+	exit := delDB || flushCfg || len(fname) != 0 || len(exportFname) != 0 || len(importFname) != 0 || len(delNetwork) != 0 ||
+		len(delEgress) != 0
+
+	if len(exportFname) != 0 {
+		fo, err := os.Create(exportFname)
+		if err != nil {
+			return exit, err
+		}
+		defer fo.Close()
+		if err := ucdb.ExportDb(fo); err != nil {
+			return exit, err
+		}
+		log.Info("Database successfuly exported to %s", exportFname)
+	}
 
 	if delDB {
 		if err := ucdb.InitDb(""); err != nil {
@@ -158,11 +291,44 @@ func databaseOperations(delDB, flushCfg bool, fname string) (bool, error) {
 		}
 		log.Info("File successfuly stored")
 	}
+	if len(importFname) != 0 {
+		fi, err := os.Open(importFname)
+		if err != nil {
+			return exit, err
+		}
+		defer fi.Close()
+		if err := ucdb.ImportDb("", fi, importMode); err != nil {
+			return exit, err
+		}
+		log.Info("File %s successfuly imported (mode: %s)", importFname, importMode)
+	}
+	if len(delNetwork) != 0 {
+		dbConn, err := ucdb.NewConn()
+		if err != nil {
+			return exit, err
+		}
+		defer dbConn.Close()
+		if err := u.DeleteNetwork(dbConn, delNetwork); err != nil {
+			return exit, err
+		}
+		log.Info("Network %s successfuly deleted", delNetwork)
+	}
+	if len(delEgress) != 0 {
+		dbConn, err := ucdb.NewConn()
+		if err != nil {
+			return exit, err
+		}
+		defer dbConn.Close()
+		if err := dbConn.DeleteEgress(delEgress); err != nil {
+			return exit, err
+		}
+		log.Info("Egress gateway %s successfuly deleted", delEgress)
+	}
 	return exit, nil
 }
 
 func main() {
-	if exit, err := databaseOperations(deleteDB, flushConfig, filename); err != nil {
+	if exit, err := databaseOperations(deleteDB, flushConfig, filename, exportFile, importFile, importMode, deleteNetwork, deleteEgress); err != nil {
 		log.Error("Error: %+v", err)
 		os.Exit(-1)
 	} else if exit {
@@ -173,32 +339,70 @@ func main() {
 	if err != nil {
 		log.Error("%+v", err)
 	}
-	if events {
-		dockerclient, err := uc.NewDockerClientSamalba()
-		if err != nil {
-			log.Error("Error: %s", err)
-			return
+	if dbConn != nil {
+		dispatcher = ub.NewDispatcher(dbConn, ub.Default())
+		go dispatcher.Run(nil)
+	}
+	if overlay && dbConn != nil {
+		if err := setupOverlay(dbConn); err != nil {
+			log.Fatalf("Failed while setting up the overlay: %s", err)
+		}
+	}
+	if egress && dbConn != nil {
+		if err := u.FlushEgress(); err != nil {
+			log.Fatalf("Failed while flushing the egress gateways: %s", err)
 		}
+		go syncEgress(dbConn)
+	}
+	// Secrets that this node never resolves may still show up in requests
+	// relayed through it.
+	if dbConn != nil {
+		if secrets, err := dbConn.GetSecrets(); err != nil {
+			log.Warning("Unable to get secrets to redact: %s", err)
+		} else {
+			us.RedactAll(secrets)
+		}
+	}
 
-		log.Info("Trying to get docker client info")
-		wait := 1 * time.Second
-		retries := 10
-		for {
-			log.Info("Attempt %d...", 11-retries)
-			if _, err := dockerclient.Info(); err == nil {
-				break
-			}
-			if retries < 0 {
-				log.Error("Unable to monitor for events on the given docker client")
-				return
+	capabilities := []string{}
+	if events {
+		capabilities = append(capabilities, u.CapabilityDockerEvents)
+	}
+	if !listOnlyForEvents {
+		capabilities = append(capabilities, u.CapabilityHooks)
+	}
+	if node := u.NewLocalNode(capabilities...); node.IP == "" {
+		log.Error("HOST_IP isn't set, this node won't be registered nor collect dead nodes")
+	} else if dbConn != nil {
+		go nodeHeartbeat(dbConn, node)
+		go collectDeadNodes(dbConn, node.IP)
+	}
+
+	dockerclient, err := uc.NewDockerClient()
+	if err != nil {
+		log.Error("%s", err)
+		h.SetQueue(workQueue, nil)
+	} else {
+		h.SetQueue(workQueue, func(container string) (string, error) {
+			c, err := dockerclient.InspectContainer(container)
+			if err != nil {
+				return "", err
 			}
-			time.Sleep(wait)
-			wait += wait
-			retries--
+			return c.ID, nil
+		})
+	}
+
+	if events {
+		log.Info("Trying to get docker client info")
+		if err := uc.WaitForDockerReady(dockerclient, 10); err != nil {
+			log.Error("Unable to monitor for events on the given docker client")
+			return
 		}
 		log.Info("Connection successful")
 
-		dockerclient.StartMonitorEvents(listenForEvents, nil, dbConn)
+		monitor := ue.NewMonitor(ue.NewDockerSource(dockerclient), time.Now().Unix())
+		setupEventHandlers(monitor, dbConn)
+		go monitor.Run(nil)
 
 		if listOnlyForEvents {
 			wg.Add(1)
@@ -215,15 +419,25 @@ func main() {
 		&rest.Route{"POST", dockerDaemonPreBaseAddr, DockerDaemonRequestsHandler},
 		&rest.Route{"POST", dockerSwarmPreBaseAddr, DockerSwarmRequestsHandler},
 		&rest.Route{"POST", kubernetesMasterPreBaseAddr, KubernetesMasterRequestHandler},
+		rest.Get(nodesAddr, NodesHandler),
+		rest.Get(auditAddr, AuditHandler),
+		rest.Get(quotasAddr, QuotasHandler),
+		rest.Get(deadLettersAddr, DeadLettersHandler),
+		rest.Get(endpointHistoryAddr, EndpointHistoryHandler),
+		rest.Get(ipHolderAddr, IPHolderHandler),
+		rest.Get(overlayPeersAddr, OverlayPeersHandler),
+		rest.Get(networksAddr, NetworksHandler),
+		rest.Get(identitiesAddr, IdentitiesHandler),
 	)
 	if err != nil {
 		log.Fatalf("%s", err)
 	}
-	dockerclient, err := uc.NewDockerClient()
-	if err != nil {
-		log.Error("%s", err)
-	}
 	api.SetApp(router)
+	if len(dockerProxy) != 0 {
+		if err := startDockerProxy(dbConn, dockerProxy, dockerProxyTLS, dockerUpstream, dockerProxySwarm); err != nil {
+			log.Fatalf("Failed while starting the docker API proxy: %s", err)
+		}
+	}
 	log.Info("cilium has started")
 	log.Info("Updating state based on the other nodes")
 
@@ -238,10 +452,44 @@ func main() {
 		}
 	}()
 
-	log.Fatal(http.ListenAndServe(":"+strconv.Itoa(port), api.MakeHandler()))
+	// Events are streamed outside of the rest api since its middlewares
+	// buffer responses.
+	mux := http.NewServeMux()
+	mux.Handle(eventsAddr, ub.NewHandler(ub.Default()))
+	mux.Handle("/", api.MakeHandler())
+	log.Fatal(http.ListenAndServe(":"+strconv.Itoa(port), mux))
 
 }
 
+// startDockerProxy serves, on all given comma separated addresses, the docker
+// API of upstream with cilium's hooks applied in-process. tcp addresses are
+// served over TLS with the certificates in certPath. The service slots
+// reserved for the containers that docker fails to create are freed in
+// dbConn, if any.
+func startDockerProxy(dbConn ucdb.Db, addrs, certPath, upstream string, swarm bool) error {
+	baseAddr, routes := dockerDaemonPreBaseAddr, proxy.DockerDaemonRoutes
+	if swarm {
+		baseAddr, routes = dockerSwarmPreBaseAddr, proxy.DockerSwarmRoutes
+	}
+	p, err := proxy.NewProxy(baseAddr, upstream, routes)
+	if err != nil {
+		return err
+	}
+	if dbConn != nil {
+		p.SetRollback(func(clientReq m.ClientRequest) {
+			if err := upri.ReleaseFailedCreateSlot(dbConn, clientReq.Body); err != nil {
+				log.Warning("Unable to release slot of failed request %s: %s", clientReq.Request, err)
+			}
+		})
+	}
+	for _, addr := range strings.Split(addrs, ",") {
+		go func(addr string) {
+			log.Fatal(p.ListenAndServe(addr, certPath))
+		}(strings.TrimSpace(addr))
+	}
+	return nil
+}
+
 func DockerDaemonRequestsHandler(w rest.ResponseWriter, req *rest.Request) {
 	RequestsHandler(dockerDaemonPreBaseAddr, w, req)
 }
@@ -279,6 +527,10 @@ func RequestsHandler(baseAddr string, w rest.ResponseWriter, req *rest.Request)
 	response, err := hook.ProcessRequest(baseAddr, powerStripReq.ClientRequest.Request, content)
 	if err != nil {
 		log.Warning("ProcessRequest: %+v", err.Error())
+		if denial, ok := err.(upr.Denial); ok {
+			rest.Error(w, denial.Error(), denial.StatusCode())
+			return
+		}
 		rest.Error(w, fmt.Sprintf("Error: %s", err.Error()), http.StatusInternalServerError)
 		return
 	}
@@ -290,50 +542,413 @@ func RequestsHandler(baseAddr string, w rest.ResponseWriter, req *rest.Request)
 	}
 }
 
-func listenForEvents(event *d.Event, ec chan error, args ...interface{}) {
-	if event != nil {
-		go func(event d.Event) {
-			dbConn := args[0].(ucdb.Db)
-			log.Debug("Msg received listen only %s", event)
-			switch event.Status {
-			case "create":
-				maxAttemps := 3
-				if containersInCache.Add(event.Id) < maxAttemps {
-					log.Info("Adding endpoint for %s", event.Id)
-					if err := u.AddEndpoint(dbConn, event.Id); err != nil {
-						if attemps := containersInCache.IncFail(event.Id); attemps >= maxAttemps {
-							containersInCache.Set(event.Id, u.Failed)
-						}
-					} else {
-						containersInCache.Set(event.Id, u.Configured)
-					}
+// DeadLettersHandler returns the work, e.g. adding the endpoint of a
+// container or posting an event to a subscription, that failed all its
+// attempts.
+func DeadLettersHandler(w rest.ResponseWriter, req *rest.Request) {
+	deadLetters := workQueue.DeadLetters()
+	if dispatcher != nil {
+		deadLetters = append(deadLetters, dispatcher.DeadLetters()...)
+	}
+	if err := w.WriteJson(&deadLetters); err != nil {
+		log.Error("Error WriteJson: ", err.Error())
+		rest.Error(w, fmt.Sprintf("Error: %s", err.Error()), http.StatusInternalServerError)
+		return
+	}
+}
+
+// NodesHandler writes all nodes registered in the cluster.
+func NodesHandler(w rest.ResponseWriter, req *rest.Request) {
+	dbConn, err := ucdb.NewConn()
+	if err != nil {
+		rest.Error(w, fmt.Sprintf("Error: %s", err.Error()), http.StatusInternalServerError)
+		return
+	}
+	nodes, err := dbConn.GetNodes()
+	if err != nil {
+		log.Error("GetNodes: %+v", err.Error())
+		rest.Error(w, fmt.Sprintf("Error: %s", err.Error()), http.StatusInternalServerError)
+		return
+	}
+	if err = w.WriteJson(&nodes); err != nil {
+		log.Error("Error WriteJson: ", err.Error())
+		rest.Error(w, fmt.Sprintf("Error: %s", err.Error()), http.StatusInternalServerError)
+		return
+	}
+}
+
+// QuotasHandler writes all quotas, or the ones of the 'owner' query parameter,
+// with the resources currently used of them.
+func QuotasHandler(w rest.ResponseWriter, req *rest.Request) {
+	owner := req.URL.Query().Get("owner")
+	dbConn, err := ucdb.NewConn()
+	if err != nil {
+		rest.Error(w, fmt.Sprintf("Error: %s", err.Error()), http.StatusInternalServerError)
+		return
+	}
+	quotas, err := dbConn.GetQuotas()
+	if err != nil {
+		log.Error("GetQuotas: %+v", err.Error())
+		rest.Error(w, fmt.Sprintf("Error: %s", err.Error()), http.StatusInternalServerError)
+		return
+	}
+	usages, err := dbConn.GetContainerUsages()
+	if err != nil {
+		log.Error("GetContainerUsages: %+v", err.Error())
+		rest.Error(w, fmt.Sprintf("Error: %s", err.Error()), http.StatusInternalServerError)
+		return
+	}
+	statuses := []up.QuotaStatus{}
+	for _, quota := range quotas {
+		if owner == "" || quota.Owner == owner {
+			statuses = append(statuses, up.QuotaStatus{Quota: quota, Usage: quota.Usage(usages)})
+		}
+	}
+	if err = w.WriteJson(&statuses); err != nil {
+		log.Error("Error WriteJson: ", err.Error())
+		rest.Error(w, fmt.Sprintf("Error: %s", err.Error()), http.StatusInternalServerError)
+		return
+	}
+}
+
+// AuditHandler writes the audit entries selected by the 'container', 'owner',
+// 'since' and 'until' query parameters. Times are in RFC3339 format.
+func AuditHandler(w rest.ResponseWriter, req *rest.Request) {
+	query := req.URL.Query()
+	filter := up.AuditFilter{
+		Container: query.Get("container"),
+		Owner:     query.Get("owner"),
+	}
+	for param, t := range map[string]*time.Time{"since": &filter.Since, "until": &filter.Until} {
+		if value := query.Get(param); value != "" {
+			parsed, err := time.Parse(time.RFC3339, value)
+			if err != nil {
+				rest.Error(w, fmt.Sprintf("Invalid %s: %s", param, err.Error()), http.StatusBadRequest)
+				return
+			}
+			*t = parsed
+		}
+	}
+	dbConn, err := ucdb.NewConn()
+	if err != nil {
+		rest.Error(w, fmt.Sprintf("Error: %s", err.Error()), http.StatusInternalServerError)
+		return
+	}
+	entries, err := dbConn.GetAuditEntries(filter)
+	if err != nil {
+		log.Error("GetAuditEntries: %+v", err.Error())
+		rest.Error(w, fmt.Sprintf("Error: %s", err.Error()), http.StatusInternalServerError)
+		return
+	}
+	if err = w.WriteJson(&entries); err != nil {
+		log.Error("Error WriteJson: ", err.Error())
+		rest.Error(w, fmt.Sprintf("Error: %s", err.Error()), http.StatusInternalServerError)
+		return
+	}
+}
+
+// EndpointHistoryHandler writes the endpoint records selected by the
+// 'container', 'ip', 'since' and 'until' query parameters. Times are in
+// RFC3339 format.
+func EndpointHistoryHandler(w rest.ResponseWriter, req *rest.Request) {
+	query := req.URL.Query()
+	filter := up.EndpointRecordFilter{Container: query.Get("container")}
+	if value := query.Get("ip"); value != "" {
+		if filter.IP = net.ParseIP(value); filter.IP == nil {
+			rest.Error(w, fmt.Sprintf("Invalid ip: %s", value), http.StatusBadRequest)
+			return
+		}
+	}
+	for param, t := range map[string]*time.Time{"since": &filter.Since, "until": &filter.Until} {
+		if value := query.Get(param); value != "" {
+			parsed, err := time.Parse(time.RFC3339, value)
+			if err != nil {
+				rest.Error(w, fmt.Sprintf("Invalid %s: %s", param, err.Error()), http.StatusBadRequest)
+				return
+			}
+			*t = parsed
+		}
+	}
+	dbConn, err := ucdb.NewConn()
+	if err != nil {
+		rest.Error(w, fmt.Sprintf("Error: %s", err.Error()), http.StatusInternalServerError)
+		return
+	}
+	records, err := dbConn.GetEndpointRecords(filter)
+	if err != nil {
+		log.Error("GetEndpointRecords: %+v", err.Error())
+		rest.Error(w, fmt.Sprintf("Error: %s", err.Error()), http.StatusInternalServerError)
+		return
+	}
+	if err = w.WriteJson(&records); err != nil {
+		log.Error("Error WriteJson: ", err.Error())
+		rest.Error(w, fmt.Sprintf("Error: %s", err.Error()), http.StatusInternalServerError)
+		return
+	}
+}
+
+// IPHolderHandler writes the record of the endpoint that had the 'ip' query
+// parameter at the time, in RFC3339 format, of the 'at' query parameter, now
+// if it isn't set.
+func IPHolderHandler(w rest.ResponseWriter, req *rest.Request) {
+	query := req.URL.Query()
+	ip := net.ParseIP(query.Get("ip"))
+	if ip == nil {
+		rest.Error(w, fmt.Sprintf("Invalid ip: %s", query.Get("ip")), http.StatusBadRequest)
+		return
+	}
+	at := time.Now()
+	if value := query.Get("at"); value != "" {
+		parsed, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			rest.Error(w, fmt.Sprintf("Invalid at: %s", err.Error()), http.StatusBadRequest)
+			return
+		}
+		at = parsed
+	}
+	dbConn, err := ucdb.NewConn()
+	if err != nil {
+		rest.Error(w, fmt.Sprintf("Error: %s", err.Error()), http.StatusInternalServerError)
+		return
+	}
+	record, found, err := ucdb.GetIPHolder(dbConn, ip, at)
+	if err != nil {
+		log.Error("GetIPHolder: %+v", err.Error())
+		rest.Error(w, fmt.Sprintf("Error: %s", err.Error()), http.StatusInternalServerError)
+		return
+	}
+	if !found {
+		rest.NotFound(w, req)
+		return
+	}
+	if err = w.WriteJson(&record); err != nil {
+		log.Error("Error WriteJson: ", err.Error())
+		rest.Error(w, fmt.Sprintf("Error: %s", err.Error()), http.StatusInternalServerError)
+		return
+	}
+}
+
+// NetworksHandler writes all networks with the IDs allocated to them.
+func NetworksHandler(w rest.ResponseWriter, req *rest.Request) {
+	dbConn, err := ucdb.NewConn()
+	if err != nil {
+		rest.Error(w, fmt.Sprintf("Error: %s", err.Error()), http.StatusInternalServerError)
+		return
+	}
+	networks, err := dbConn.GetNetworks()
+	if err != nil {
+		log.Error("GetNetworks: %+v", err.Error())
+		rest.Error(w, fmt.Sprintf("Error: %s", err.Error()), http.StatusInternalServerError)
+		return
+	}
+	if err = w.WriteJson(&networks); err != nil {
+		log.Error("Error WriteJson: ", err.Error())
+		rest.Error(w, fmt.Sprintf("Error: %s", err.Error()), http.StatusInternalServerError)
+		return
+	}
+}
+
+// IdentitiesHandler writes all identities, or the ones selected by the
+// 'selector' query parameter with the format key1=regex1,key2=regex2.
+func IdentitiesHandler(w rest.ResponseWriter, req *rest.Request) {
+	var selector map[string]string
+	if value := req.URL.Query().Get("selector"); value != "" {
+		var err error
+		if selector, err = up.ParseSelector(value); err != nil {
+			rest.Error(w, fmt.Sprintf("Invalid selector: %s", err.Error()), http.StatusBadRequest)
+			return
+		}
+	}
+	dbConn, err := ucdb.NewConn()
+	if err != nil {
+		rest.Error(w, fmt.Sprintf("Error: %s", err.Error()), http.StatusInternalServerError)
+		return
+	}
+	identities, err := dbConn.GetIdentities()
+	if err != nil {
+		log.Error("GetIdentities: %+v", err.Error())
+		rest.Error(w, fmt.Sprintf("Error: %s", err.Error()), http.StatusInternalServerError)
+		return
+	}
+	selected := []up.Identity{}
+	for _, identity := range identities.Identities {
+		if identity.Selects(selector) {
+			selected = append(selected, identity)
+		}
+	}
+	if err = w.WriteJson(&selected); err != nil {
+		log.Error("Error WriteJson: ", err.Error())
+		rest.Error(w, fmt.Sprintf("Error: %s", err.Error()), http.StatusInternalServerError)
+		return
+	}
+}
+
+// OverlayPeersHandler writes the state of the tunnels to the peers, nothing
+// if the overlay isn't enabled.
+func OverlayPeersHandler(w rest.ResponseWriter, req *rest.Request) {
+	peers := []uo.PeerStatus{}
+	if overlayManager != nil {
+		peers = overlayManager.Peers()
+	}
+	if err := w.WriteJson(&peers); err != nil {
+		log.Error("Error WriteJson: ", err.Error())
+		rest.Error(w, fmt.Sprintf("Error: %s", err.Error()), http.StatusInternalServerError)
+		return
+	}
+}
+
+// setupOverlay starts the overlay manager that programs the tunnels to the
+// nodes in dbConn.
+func setupOverlay(dbConn ucdb.Db) error {
+	m, err := uo.NewManager(uo.OVSDatapath{Bridge: u.DefaultBridge}, dbConn, uo.Config{
+		LocalIP:   net.ParseIP(os.Getenv("HOST_IP")),
+		TunnelIP:  net.ParseIP(os.Getenv("TUNNEL_IP")),
+		VNIScheme: overlayVNIScheme,
+		MTU:       overlayMTU,
+		HostPort:  "host0",
+	})
+	if err != nil {
+		return err
+	}
+	if err := m.Setup(); err != nil {
+		return err
+	}
+	overlayManager = m
+	u.SetOverlay(m)
+	go m.Run(nil)
+	return nil
+}
+
+// syncEgress periodically installs the egress gateways stored in the database,
+// and shortly after endpoints are created or removed so their fixed egress IPs
+// are applied.
+func syncEgress(dbConn ucdb.Db) {
+	sub := ub.Default().Subscribe(ub.Filter{Types: []string{ub.EndpointCreated, ub.EndpointRemoved}}, ub.DefaultBuffer)
+	defer sub.Close()
+	ticker := time.NewTicker(time.Second * time.Duration(refreshNetConfig))
+	defer ticker.Stop()
+	for {
+		if err := u.SyncEgress(dbConn); err != nil {
+			log.Error("Error while syncing egress gateways: %s", err)
+		}
+		select {
+		case <-ticker.C:
+		case <-sub.Events():
+			// Endpoints are stored right after their events.
+			time.Sleep(time.Second)
+		}
+	}
+}
+
+// nodeHeartbeat registers the given node and keeps renewing its lease.
+func nodeHeartbeat(dbConn ucdb.Db, node up.Node) {
+	ttl := time.Second * time.Duration(nodeLeaseTTL)
+	for {
+		if err := u.RenewNodeLease(dbConn, &node, ttl); err != nil {
+			log.Error("Error while renewing node's lease: %s", err)
+		}
+		time.Sleep(ttl / 3)
+	}
+}
+
+// collectDeadNodes periodically frees, in the database, the resources of nodes
+// whose lease has expired, if this node, with the given IP, is the collector.
+func collectDeadNodes(dbConn ucdb.Db, localIP string) {
+	ttl := time.Second * time.Duration(nodeLeaseTTL)
+	watcher := u.NewNodeWatcher()
+	for {
+		time.Sleep(ttl / 3)
+		if _, err := u.CollectDeadNodes(dbConn, watcher, localIP, time.Now(), ttl); err != nil {
+			log.Error("Error while collecting dead nodes: %s", err)
+		}
+	}
+}
+
+// setupEventHandlers registers on monitor the handlers of the containers'
+// lifecycle events.
+// The endpoints are added and removed in the work queue so the events of each
+// container are processed in order.
+func setupEventHandlers(monitor *ue.Monitor, dbConn ucdb.Db) {
+	monitor.Handle(func(event ue.Event) {
+		workQueue.Add(event.Container, string(event.Action), func() error {
+			return addContainerEndpoint(dbConn, event)
+		})
+	}, ue.Create, ue.Start, ue.Restart, ue.Unpause)
+	monitor.Handle(func(event ue.Event) {
+		workQueue.Add(event.Container, string(event.Action), func() error {
+			removeContainerEndpoint(dbConn, event)
+			return nil
+		})
+	}, ue.Stop, ue.Die, ue.Destroy)
+	monitor.Handle(func(event ue.Event) {
+		log.Info("Container %s renamed from %s to %s", event.Container, event.Attributes["oldName"], event.Attributes["name"])
+	}, ue.Rename)
+	monitor.Handle(func(event ue.Event) {
+		log.Info("Container %s was paused, its endpoint is kept", event.Container)
+	}, ue.Pause)
+	monitor.Handle(func(event ue.Event) {
+		log.Warning("Container %s ran out of memory", event.Container)
+	}, ue.OOM)
+}
+
+// addContainerEndpoint adds the local endpoint of the event's container unless
+// it was already added. Containers whose create event was missed get it once
+// they are started. Failures are retried by the work queue and, once it runs
+// out of attempts, the container is skipped.
+func addContainerEndpoint(dbConn ucdb.Db, event ue.Event) error {
+	log.Debug("Msg received listen only %+v", event)
+	if state := containersInCache.Add(event.Container); state == u.Configured || state == u.Failed {
+		return nil
+	}
+	log.Info("Adding endpoint for %s", event.Container)
+	if err := u.AddEndpoint(dbConn, event.Container); err != nil {
+		if attempts := containersInCache.IncFail(event.Container); attempts >= workQueue.MaxAttempts {
+			containersInCache.Set(event.Container, u.Failed)
+		}
+		return err
+	}
+	containersInCache.Set(event.Container, u.Configured)
+	return nil
+}
+
+// removeContainerEndpoint removes the local endpoint of the event's container
+// and, if the container runs on this node, frees its resources. The service
+// slot of the container is kept until it's destroyed so it can't be taken
+// while the container is stopped.
+func removeContainerEndpoint(dbConn ucdb.Db, event ue.Event) {
+	log.Debug("Msg received listen only %+v", event)
+	// Only local will be allowed to remove entries
+	local := event.From == "node:"+os.Getenv("HOSTNAME") || event.From == "self"
+	if local && event.Action == ue.Destroy {
+		if err := u.ReleaseServiceSlot(dbConn, event.Container); err != nil {
+			log.Warning("Unable to release service slot of container %s: %s", event.Container, err)
+		}
+	}
+	if containersInCache.Remove(event.Container) {
+		log.Info("Removing endpoint for %s", event.Container)
+		if local {
+			if containerIPs, err := dbConn.GetEndpoint(event.Container); err == nil {
+				for _, ip := range containerIPs.IPs {
+					dbConn.DeleteIP(ip)
 				}
-			case "start":
-			case "stop":
-				fallthrough
-			case "destroy":
-				fallthrough
-			case "die":
-				if containersInCache.Remove(event.Id) {
-					log.Info("Removing endpoint for %s", event.Id)
-					// Only local will be allowed to remove entries
-					if event.From == "node:"+os.Getenv("HOSTNAME") ||
-						event.From == "self" {
-						if containerIPs, err := dbConn.GetEndpoint(event.Id); err == nil {
-							for _, ip := range containerIPs.IPs {
-								dbConn.DeleteIP(ip)
-							}
-							u.RemoveLocalEndpoint(dbConn, event.Id)
-							dbConn.DeleteEndpoint(event.Id)
-						}
-					}
-					/*if haProxyClient, err := dbConn.GetHAProxyConfig(); err == nil {
-						haProxyClient.DeleteBackend(event.Id)
-					}*/
-					u.RemoveEndpoint(event.Id)
+				u.RemoveLocalEndpoint(dbConn, event.Container)
+				if err := u.UnpublishHostPorts(dbConn, event.Container); err != nil {
+					log.Warning("Unable to unpublish host ports of container %s: %s", event.Container, err)
 				}
+				dbConn.DeleteContainerUsage(event.Container)
+				dbConn.DeleteEndpoint(event.Container)
+				ub.Publish(ub.EndpointRemoved, event.Container, map[string]interface{}{
+					"ips": containerIPs.IPs,
+				})
 			}
-		}(*event)
+			if err := u.ReleaseIdentity(dbConn, event.Container); err != nil {
+				log.Warning("Unable to release identity of container %s: %s", event.Container, err)
+			}
+		}
+		/*if haProxyClient, err := dbConn.GetHAProxyConfig(); err == nil {
+			haProxyClient.DeleteBackend(event.Id)
+		}*/
+		u.RemoveEndpoint(event.Container)
 	}
 }
 
@@ -347,13 +962,15 @@ func updateEndpoints(dClient uc.Docker, dbConn ucdb.Db) error {
 		return err
 	}
 	for _, container := range allContainers {
-		event := d.Event{
-			Id:     container.ID,
-			Status: "create",
-			From:   "self",
-			Time:   time.Now().Unix(),
+		event := ue.Event{
+			Action:    ue.Create,
+			Container: container.ID,
+			From:      "self",
+			Time:      time.Now().Unix(),
 		}
-		listenForEvents(&event, nil, dbConn)
+		workQueue.Add(event.Container, string(event.Action), func() error {
+			return addContainerEndpoint(dbConn, event)
+		})
 	}
 	return nil
 }
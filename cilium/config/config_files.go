@@ -7,10 +7,12 @@ import (
 	"os"
 	"path/filepath"
 
+	u "github.com/cilium-team/cilium/cilium/utils"
 	uc "github.com/cilium-team/cilium/cilium/utils/comm"
 	ucdb "github.com/cilium-team/cilium/cilium/utils/comm/db"
 	upl "github.com/cilium-team/cilium/cilium/utils/plugins/loadbalancer"
 	up "github.com/cilium-team/cilium/cilium/utils/profile"
+	us "github.com/cilium-team/cilium/cilium/utils/secrets"
 
 	"github.com/cilium-team/cilium/Godeps/_workspace/src/github.com/cilium-team/yaml"
 	"github.com/cilium-team/cilium/Godeps/_workspace/src/github.com/op/go-logging"
@@ -35,6 +37,15 @@ func storePolicies(conn ucdb.Db, pf up.ProfileFile, basePath string) error {
 	for _, profile := range pf.PolicySource {
 		for i := range profile.Policies {
 			profile.Policies[i].ReadOVSConfigFiles(basePath)
+			if err := profile.Policies[i].DockerConstraints.Validate(); err != nil {
+				return fmt.Errorf("invalid policy %s: %s", profile.Policies[i].Name, err)
+			}
+			if err := profile.Policies[i].WebhookConfig.Validate(); err != nil {
+				return fmt.Errorf("invalid policy %s: %s", profile.Policies[i].Name, err)
+			}
+			if err := profile.Policies[i].IntentConfig.Config.ValidateMetadata(); err != nil {
+				return fmt.Errorf("invalid policy %s: %s", profile.Policies[i].Name, err)
+			}
 		}
 		if err := conn.PutPolicy(profile); err != nil {
 			return err
@@ -43,6 +54,92 @@ func storePolicies(conn ucdb.Db, pf up.ProfileFile, basePath string) error {
 	return nil
 }
 
+// storeNetworks stores the given networks with the IDs allocated to them.
+func storeNetworks(conn ucdb.Db, pf up.ProfileFile) error {
+	log.Debug("")
+	for _, network := range pf.Networks {
+		if _, err := u.PutNetwork(conn, network); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// storeRouters stores the given routers of the namespaces.
+func storeRouters(conn ucdb.Db, pf up.ProfileFile) error {
+	log.Debug("")
+	for _, router := range pf.Routers {
+		if err := router.Validate(); err != nil {
+			return err
+		}
+		if err := conn.PutRouter(router); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// storeEgress stores the given egress gateways.
+func storeEgress(conn ucdb.Db, pf up.ProfileFile) error {
+	log.Debug("")
+	for _, egress := range pf.Egress {
+		if err := egress.Validate(); err != nil {
+			return err
+		}
+		if err := conn.PutEgress(egress); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func storeQuotas(conn ucdb.Db, pf up.ProfileFile) error {
+	log.Debug("")
+	for _, quota := range pf.Quotas {
+		if err := quota.Validate(); err != nil {
+			return err
+		}
+		if err := conn.PutQuota(quota); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// storeSecrets encrypts the given secrets, with the node's secrets key, and
+// stores them.
+func storeSecrets(conn ucdb.Db, pf up.ProfileFile) error {
+	log.Debug("")
+	for _, secret := range pf.Secrets {
+		if err := secret.Validate(); err != nil {
+			return err
+		}
+		if secret.Plaintext != "" {
+			var err error
+			if secret, err = us.Encrypt(secret); err != nil {
+				return err
+			}
+		}
+		if err := conn.PutSecret(secret); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func storeSubscriptions(conn ucdb.Db, pf up.ProfileFile) error {
+	log.Debug("")
+	for _, subscription := range pf.Subscriptions {
+		if err := subscription.Validate(); err != nil {
+			return err
+		}
+		if err := conn.PutSubscription(subscription); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func StoreInDB(filename string) error {
 	log.Debug("")
 	conn, err := ucdb.NewConn()
@@ -121,6 +218,24 @@ func storeFileInDB(conn ucdb.Db, filename string) error {
 		if err = storePolicies(conn, pf, baseDir); err != nil {
 			return err
 		}
+		if err = storeNetworks(conn, pf); err != nil {
+			return err
+		}
+		if err = storeRouters(conn, pf); err != nil {
+			return err
+		}
+		if err = storeEgress(conn, pf); err != nil {
+			return err
+		}
+		if err = storeQuotas(conn, pf); err != nil {
+			return err
+		}
+		if err = storeSecrets(conn, pf); err != nil {
+			return err
+		}
+		if err = storeSubscriptions(conn, pf); err != nil {
+			return err
+		}
 	}
 	return nil
 }
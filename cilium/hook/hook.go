@@ -20,19 +20,20 @@ var (
 
 // GetHook is a factory of Hooks. Returns a valid hook based on the reqString
 // value. Returns "Unsupported hook type" if the reqString doesn't match to
-// any of the available hooks.
+// any of the available hooks. Once a queue is set, with SetQueue, hooks of
+// requests for existing containers run in it.
 func GetHook(reqString string) (Hook, error) {
 	switch reqString {
 	case prehook.Type:
 		preHookOnce.Do(func() {
 			preHook = prehook.NewPreHook()
 		})
-		return preHook, nil
+		return queuedHook{hook: preHook, hookType: reqString}, nil
 	case posthook.Type:
 		postHookOnce.Do(func() {
 			postHook = posthook.NewPostHook()
 		})
-		return postHook, nil
+		return queuedHook{hook: postHook, hookType: reqString}, nil
 	default:
 		return nil, errors.New("Unsupported hook type")
 	}
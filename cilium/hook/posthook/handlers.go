@@ -194,22 +194,32 @@ func (p PostHook) postHookDocker(endPoint string, pphreq PowerstripPostHookReque
 		return defaultRequest(cont)
 	}
 
+	entry := up.NewHookAuditEntry(up.AuditPostHook, createConfig.ID, policies)
 	for _, runnables := range upr.GetRunnables() {
 		runnable := runnables.GetRunnableFrom(users, policies)
 		log.Info("Loaded and merged policy for container '%s': %#v", createConfig.ID, runnable)
 		if err = runnable.DockerExec(Type, endPoint, p.dbConn, &createConfig); err != nil {
+			err = upr.AttributeDenial(err, users, policies)
+			entry.Decision, entry.Reason = up.AuditRejected, err.Error()
+			ucdb.Audit(p.dbConn, entry)
 			return &PowerstripPostHookResponse{}, err
 		}
 	}
+	entry.Decision = up.AuditAllowed
+	ucdb.Audit(p.dbConn, entry)
 
 	log.Debug("Response ClientBody Config: %+v", createConfig.Config)
 	log.Debug("Response ClientBody HostConfig: %+v", createConfig.HostConfig)
 
 	log.Info("Posthook successfully executed for container '%s'", createConfig.ID)
-	return NewPowerstripPostHookResponse(pphreq.ServerResponse.ContentType,
-			pphreq.ServerResponse.Body,
-			pphreq.ServerResponse.Code),
-		nil
+	for _, warning := range createConfig.Warnings {
+		log.Warning("Container %s: %s", createConfig.ID, warning)
+	}
+	response := NewPowerstripPostHookResponse(pphreq.ServerResponse.ContentType,
+		pphreq.ServerResponse.Body,
+		pphreq.ServerResponse.Code)
+	response.Warnings = createConfig.Warnings
+	return response, nil
 }
 
 // postHookKubernetes deals with post-hook requests that are kubernetes
@@ -251,13 +261,19 @@ func (p PostHook) postHookKubernetes(endPoint string, pphreq PowerstripPostHookR
 		return defaultRequest(cont)
 	}
 
+	entry := up.NewHookAuditEntry(up.AuditPostHook, kubernetesObjRef.Name, policies)
 	for _, runnables := range upr.GetRunnables() {
 		runnable := runnables.GetRunnableFrom(users, policies)
 		log.Info("Loaded and merged policy for kubernetesObjRef '%s': %#v", kubernetesObjRef.Name, runnable)
 		if err = runnable.KubernetesExec(Type, endPoint, p.dbConn, &kubernetesObjRef); err != nil {
+			err = upr.AttributeDenial(err, users, policies)
+			entry.Decision, entry.Reason = up.AuditRejected, err.Error()
+			ucdb.Audit(p.dbConn, entry)
 			return PowerstripPostHookResponse{}, err
 		}
 	}
+	entry.Decision = up.AuditAllowed
+	ucdb.Audit(p.dbConn, entry)
 
 	log.Debug("Response kubernetesObjRef: %+v", kubernetesObjRef)
 
@@ -8,9 +8,11 @@ import (
 	"strconv"
 	"strings"
 	"testing"
+	"time"
 
 	m "github.com/cilium-team/cilium/cilium/messages"
 	uc "github.com/cilium-team/cilium/cilium/utils/comm"
+	ucdb "github.com/cilium-team/cilium/cilium/utils/comm/db"
 	up "github.com/cilium-team/cilium/cilium/utils/profile"
 	upr "github.com/cilium-team/cilium/cilium/utils/profile/runnables"
 	uprd "github.com/cilium-team/cilium/cilium/utils/profile/runnables/docker"
@@ -134,7 +136,7 @@ func TestPostHook(t *testing.T) {
 	ph.dbConn = fdb
 	ph.dockerConn = dc
 
-	upr.Register(uprd.Name, uprd.DockerRunnable{})
+	upr.Register(uprd.Name, upr.MergeStage, uprd.DockerRunnable{})
 
 	defaultPPHR, err := ph.postHook("Default", []byte(validServerRequest))
 	if err != nil {
@@ -187,7 +189,7 @@ func TestPostHookInvalid(t *testing.T) {
 	ph.dbConn = fdb
 	ph.dockerConn = dc
 
-	upr.Register(uprd.Name, uprd.DockerRunnable{})
+	upr.Register(uprd.Name, upr.MergeStage, uprd.DockerRunnable{})
 
 	defaultPPHR, err := ph.postHook("Default", []byte(validServerRequest))
 	if err != nil {
@@ -222,6 +224,70 @@ func TestPostHookInvalid(t *testing.T) {
 	}
 }
 
+func TestPostHookEndToEnd(t *testing.T) {
+	if err := ucdb.SetDriver(ucdb.MemoryDB, ""); err != nil {
+		t.Fatal(err)
+	}
+	defer ucdb.SetDriver(ucdb.ElasticDB, "")
+	if err := ucdb.InitDb(""); err != nil {
+		t.Fatal(err)
+	}
+	conn, err := ucdb.NewConn()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := conn.PutUser("root"); err != nil {
+		t.Fatal(err)
+	}
+	policy := up.Policy{
+		Name:  "web",
+		Owner: "root",
+		Coverage: up.Coverage{
+			Labels: map[string]string{"com.docker.compose.service": "web"},
+		},
+	}
+	if err := conn.PutPolicy(up.PolicySource{Owner: "root", Policies: []up.Policy{policy}}); err != nil {
+		t.Fatal(err)
+	}
+
+	upr.Register(uprd.Name, upr.MergeStage, uprd.DockerRunnable{})
+	upr.Register(upri.Name, upr.IntentStage, upri.IntentRunnable{})
+	ph := NewPostHook()
+	// Audit entries survive InitDb.
+	start := time.Now()
+	fakeDC := &FakeDockerClient{message: jsonContainer, status: http.StatusOK}
+	ph.dockerConn = uc.Docker{Client: newMockDockerClient(fakeDC)}
+
+	req := strings.TrimPrefix(validHeaderReq, "/docker/daemon/cilium-adapter")
+	resp, err := ph.ProcessRequest("/docker/daemon/cilium-adapter", req, []byte(validServerRequest))
+	if err != nil {
+		t.Fatal("error occured while processing request", err)
+	}
+	if code := resp.(*PowerstripPostHookResponse).ModifiedServerResponse.Code; code != validCode {
+		t.Errorf("invalid ModifiedServerResponse.Code:\ngot  %d\nwant %d", code, validCode)
+	}
+
+	// The started container counts for the quotas of its policies' owners.
+	usages, err := conn.GetContainerUsages()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(usages) != 1 {
+		t.Fatalf("invalid number of container usages:\ngot  %d\nwant %d", len(usages), 1)
+	}
+	if usages[0].Container != validContainerID || !reflect.DeepEqual(usages[0].Owners, []string{"root"}) {
+		t.Errorf("invalid container usage:\ngot  %+v\nwant %s of %v", usages[0], validContainerID, []string{"root"})
+	}
+
+	entries, err := conn.GetAuditEntries(up.AuditFilter{Container: validContainerID, Since: start})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].Action != up.AuditPostHook || entries[0].Decision != up.AuditAllowed {
+		t.Errorf("invalid audit entries:\ngot  %+v\nwant one %s %s entry", entries, up.AuditPostHook, up.AuditAllowed)
+	}
+}
+
 func TestParseRequest(t *testing.T) {
 	testsBase := []struct {
 		baseAddr string
@@ -236,9 +302,10 @@ func TestParseRequest(t *testing.T) {
 	}{
 		{`/docker/daemon/cilium-adapter/v1.20/containers/48380b123e1be550f171787473a1f6683b1e3d966b2521b46d01eccfdf0e8b1f/restart?t=10`, uprd.DockerDaemonRestart},
 		{`/docker/daemon/cilium-adapter/v1.20/containers/48380b123e1be550f171787473a1f6683b1e3d966b2521b46d01eccfdf0e8b1f/start?t=10`, uprd.DockerDaemonStart},
+		{`/docker/daemon/cilium-adapter/containers/48380b123e1be550f171787473a1f6683b1e3d966b2521b46d01eccfdf0e8b1f/start`, uprd.DockerDaemonStart},
 	}
-	upr.Register(uprd.Name, uprd.DockerRunnable{})
-	upr.Register(upri.Name, upri.IntentRunnable{})
+	upr.Register(uprd.Name, upr.MergeStage, uprd.DockerRunnable{})
+	upr.Register(upri.Name, upr.IntentStage, upri.IntentRunnable{})
 	p := PostHook{
 		handlers: map[string]string{},
 	}
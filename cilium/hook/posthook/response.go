@@ -7,6 +7,9 @@ import (
 type PowerstripPostHookResponse struct {
 	m.PowerstripResponse
 	ModifiedServerResponse modifiedServerResponse
+	// Warnings aren't part of the powerstrip protocol, they are only used by
+	// the docker API proxy.
+	Warnings []string `json:",omitempty"`
 }
 
 type modifiedServerResponse struct {
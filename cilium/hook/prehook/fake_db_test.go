@@ -32,6 +32,53 @@ type FakeDB struct {
 	OnPutEndpoint                          func(up.Endpoint) error
 	OnDeleteEndpoint                       func(string) error
 	OnGetEndpoint                          func(string) (up.Endpoint, error)
+	OnGetEndpoints                         func() ([]up.Endpoint, error)
+	OnPutNode                              func(up.Node) error
+	OnDeleteNode                           func(string) error
+	OnGetNodes                             func() ([]up.Node, error)
+	OnGetPolicies                          func() ([]up.PolicySource, error)
+	OnGetIPs                               func() ([]net.IP, error)
+	OnGetDockerLinks                       func() ([]up.ContainerLinks, error)
+	OnGetDockerLinksTemp                   func() ([]up.ContainerLinks, error)
+	OnGetDockerPortBindings                func() ([]up.ContainerPortBindings, error)
+	OnGetDockerPortBindingsTemp            func() ([]up.ContainerPortBindings, error)
+	OnRestoreUser                          func(up.User) error
+	OnPutAuditEntry                        func(up.AuditEntry) error
+	OnGetAuditEntries                      func(up.AuditFilter) ([]up.AuditEntry, error)
+	OnPutEndpointRecord                    func(up.EndpointRecord) error
+	OnGetEndpointRecords                   func(up.EndpointRecordFilter) ([]up.EndpointRecord, error)
+	OnGetLatestEndpointRecord              func(up.EndpointRecordFilter) (up.EndpointRecord, bool, error)
+	OnUpdateNetworks                       func(func(*up.Networks) error) error
+	OnPutNetwork                           func(up.Network) error
+	OnDeleteNetwork                        func(string) error
+	OnGetNetwork                           func(string) (up.Network, error)
+	OnGetNetworks                          func() ([]up.Network, error)
+	OnPutRouter                            func(up.Router) error
+	OnGetRouters                           func() ([]up.Router, error)
+	OnPutEgress                            func(up.Egress) error
+	OnDeleteEgress                         func(string) error
+	OnGetEgresses                          func() ([]up.Egress, error)
+	OnPutQuota                             func(up.Quota) error
+	OnDeleteQuota                          func(string) error
+	OnGetQuotas                            func() ([]up.Quota, error)
+	OnPutHostPort                          func(up.HostPort) error
+	OnDeleteHostPort                       func(string) error
+	OnGetHostPorts                         func() ([]up.HostPort, error)
+	OnPutContainerUsage                    func(up.ContainerUsage) error
+	OnDeleteContainerUsage                 func(string) error
+	OnGetContainerUsages                   func() ([]up.ContainerUsage, error)
+	OnUpdateQuotaReservations              func(func(*up.QuotaReservations, []up.ContainerUsage) error) error
+	OnPutSecret                            func(up.Secret) error
+	OnDeleteSecret                         func(string) error
+	OnGetSecret                            func(string) (up.Secret, error)
+	OnGetSecrets                           func() ([]up.Secret, error)
+	OnPutSubscription                      func(up.Subscription) error
+	OnDeleteSubscription                   func(string) error
+	OnGetSubscriptions                     func() ([]up.Subscription, error)
+	OnUpdateServiceSlots                   func(string, func(*up.ServiceSlots) error) error
+	OnGetServiceSlots                      func() ([]up.ServiceSlots, error)
+	OnUpdateIdentities                     func(func(*up.Identities) error) error
+	OnGetIdentities                        func() (up.Identities, error)
 }
 
 func (f FakeDB) Close() {
@@ -182,3 +229,332 @@ func (f FakeDB) PutPolicy(policies up.PolicySource) error {
 	}
 	return errors.New("PutPolicy should not have been called")
 }
+
+func (f FakeDB) GetEndpoints() ([]up.Endpoint, error) {
+	if f.OnGetEndpoints != nil {
+		return f.OnGetEndpoints()
+	}
+	return nil, errors.New("GetEndpoints should not have been called")
+}
+
+func (f FakeDB) PutNode(node up.Node) error {
+	if f.OnPutNode != nil {
+		return f.OnPutNode(node)
+	}
+	return errors.New("PutNode should not have been called")
+}
+
+func (f FakeDB) DeleteNode(nodeIP string) error {
+	if f.OnDeleteNode != nil {
+		return f.OnDeleteNode(nodeIP)
+	}
+	return errors.New("DeleteNode should not have been called")
+}
+
+func (f FakeDB) GetNodes() ([]up.Node, error) {
+	if f.OnGetNodes != nil {
+		return f.OnGetNodes()
+	}
+	return nil, errors.New("GetNodes should not have been called")
+}
+
+func (f FakeDB) GetPolicies() ([]up.PolicySource, error) {
+	if f.OnGetPolicies != nil {
+		return f.OnGetPolicies()
+	}
+	return nil, errors.New("GetPolicies should not have been called")
+}
+
+func (f FakeDB) GetIPs() ([]net.IP, error) {
+	if f.OnGetIPs != nil {
+		return f.OnGetIPs()
+	}
+	return nil, errors.New("GetIPs should not have been called")
+}
+
+func (f FakeDB) GetDockerLinks() ([]up.ContainerLinks, error) {
+	if f.OnGetDockerLinks != nil {
+		return f.OnGetDockerLinks()
+	}
+	return nil, errors.New("GetDockerLinks should not have been called")
+}
+
+func (f FakeDB) GetDockerLinksTemp() ([]up.ContainerLinks, error) {
+	if f.OnGetDockerLinksTemp != nil {
+		return f.OnGetDockerLinksTemp()
+	}
+	return nil, errors.New("GetDockerLinksTemp should not have been called")
+}
+
+func (f FakeDB) GetDockerPortBindings() ([]up.ContainerPortBindings, error) {
+	if f.OnGetDockerPortBindings != nil {
+		return f.OnGetDockerPortBindings()
+	}
+	return nil, errors.New("GetDockerPortBindings should not have been called")
+}
+
+func (f FakeDB) GetDockerPortBindingsTemp() ([]up.ContainerPortBindings, error) {
+	if f.OnGetDockerPortBindingsTemp != nil {
+		return f.OnGetDockerPortBindingsTemp()
+	}
+	return nil, errors.New("GetDockerPortBindingsTemp should not have been called")
+}
+
+func (f FakeDB) RestoreUser(user up.User) error {
+	if f.OnRestoreUser != nil {
+		return f.OnRestoreUser(user)
+	}
+	return errors.New("RestoreUser should not have been called")
+}
+
+func (f FakeDB) PutAuditEntry(entry up.AuditEntry) error {
+	if f.OnPutAuditEntry != nil {
+		return f.OnPutAuditEntry(entry)
+	}
+	return errors.New("PutAuditEntry should not have been called")
+}
+
+func (f FakeDB) GetAuditEntries(filter up.AuditFilter) ([]up.AuditEntry, error) {
+	if f.OnGetAuditEntries != nil {
+		return f.OnGetAuditEntries(filter)
+	}
+	return nil, errors.New("GetAuditEntries should not have been called")
+}
+
+func (f FakeDB) PutEndpointRecord(record up.EndpointRecord) error {
+	if f.OnPutEndpointRecord != nil {
+		return f.OnPutEndpointRecord(record)
+	}
+	return errors.New("PutEndpointRecord should not have been called")
+}
+
+func (f FakeDB) GetEndpointRecords(filter up.EndpointRecordFilter) ([]up.EndpointRecord, error) {
+	if f.OnGetEndpointRecords != nil {
+		return f.OnGetEndpointRecords(filter)
+	}
+	return nil, errors.New("GetEndpointRecords should not have been called")
+}
+
+func (f FakeDB) GetLatestEndpointRecord(filter up.EndpointRecordFilter) (up.EndpointRecord, bool, error) {
+	if f.OnGetLatestEndpointRecord != nil {
+		return f.OnGetLatestEndpointRecord(filter)
+	}
+	return up.EndpointRecord{}, false, errors.New("GetLatestEndpointRecord should not have been called")
+}
+
+func (f FakeDB) UpdateNetworks(update func(*up.Networks) error) error {
+	if f.OnUpdateNetworks != nil {
+		return f.OnUpdateNetworks(update)
+	}
+	return errors.New("UpdateNetworks should not have been called")
+}
+
+func (f FakeDB) PutNetwork(network up.Network) error {
+	if f.OnPutNetwork != nil {
+		return f.OnPutNetwork(network)
+	}
+	return errors.New("PutNetwork should not have been called")
+}
+
+func (f FakeDB) DeleteNetwork(name string) error {
+	if f.OnDeleteNetwork != nil {
+		return f.OnDeleteNetwork(name)
+	}
+	return errors.New("DeleteNetwork should not have been called")
+}
+
+func (f FakeDB) GetNetwork(name string) (up.Network, error) {
+	if f.OnGetNetwork != nil {
+		return f.OnGetNetwork(name)
+	}
+	return up.Network{}, errors.New("GetNetwork should not have been called")
+}
+
+func (f FakeDB) GetNetworks() ([]up.Network, error) {
+	if f.OnGetNetworks != nil {
+		return f.OnGetNetworks()
+	}
+	return nil, errors.New("GetNetworks should not have been called")
+}
+
+func (f FakeDB) PutRouter(router up.Router) error {
+	if f.OnPutRouter != nil {
+		return f.OnPutRouter(router)
+	}
+	return errors.New("PutRouter should not have been called")
+}
+
+func (f FakeDB) GetRouters() ([]up.Router, error) {
+	if f.OnGetRouters != nil {
+		return f.OnGetRouters()
+	}
+	return nil, errors.New("GetRouters should not have been called")
+}
+
+func (f FakeDB) PutEgress(egress up.Egress) error {
+	if f.OnPutEgress != nil {
+		return f.OnPutEgress(egress)
+	}
+	return errors.New("PutEgress should not have been called")
+}
+
+func (f FakeDB) DeleteEgress(name string) error {
+	if f.OnDeleteEgress != nil {
+		return f.OnDeleteEgress(name)
+	}
+	return errors.New("DeleteEgress should not have been called")
+}
+
+func (f FakeDB) GetEgresses() ([]up.Egress, error) {
+	if f.OnGetEgresses != nil {
+		return f.OnGetEgresses()
+	}
+	return nil, errors.New("GetEgresses should not have been called")
+}
+
+func (f FakeDB) PutQuota(quota up.Quota) error {
+	if f.OnPutQuota != nil {
+		return f.OnPutQuota(quota)
+	}
+	return errors.New("PutQuota should not have been called")
+}
+
+func (f FakeDB) DeleteQuota(name string) error {
+	if f.OnDeleteQuota != nil {
+		return f.OnDeleteQuota(name)
+	}
+	return errors.New("DeleteQuota should not have been called")
+}
+
+func (f FakeDB) GetQuotas() ([]up.Quota, error) {
+	if f.OnGetQuotas != nil {
+		return f.OnGetQuotas()
+	}
+	return nil, errors.New("GetQuotas should not have been called")
+}
+
+func (f FakeDB) PutHostPort(hostPort up.HostPort) error {
+	if f.OnPutHostPort != nil {
+		return f.OnPutHostPort(hostPort)
+	}
+	return errors.New("PutHostPort should not have been called")
+}
+
+func (f FakeDB) DeleteHostPort(key string) error {
+	if f.OnDeleteHostPort != nil {
+		return f.OnDeleteHostPort(key)
+	}
+	return errors.New("DeleteHostPort should not have been called")
+}
+
+func (f FakeDB) GetHostPorts() ([]up.HostPort, error) {
+	if f.OnGetHostPorts != nil {
+		return f.OnGetHostPorts()
+	}
+	return nil, errors.New("GetHostPorts should not have been called")
+}
+
+func (f FakeDB) PutContainerUsage(usage up.ContainerUsage) error {
+	if f.OnPutContainerUsage != nil {
+		return f.OnPutContainerUsage(usage)
+	}
+	return errors.New("PutContainerUsage should not have been called")
+}
+
+func (f FakeDB) DeleteContainerUsage(containerID string) error {
+	if f.OnDeleteContainerUsage != nil {
+		return f.OnDeleteContainerUsage(containerID)
+	}
+	return errors.New("DeleteContainerUsage should not have been called")
+}
+
+func (f FakeDB) GetContainerUsages() ([]up.ContainerUsage, error) {
+	if f.OnGetContainerUsages != nil {
+		return f.OnGetContainerUsages()
+	}
+	return nil, errors.New("GetContainerUsages should not have been called")
+}
+
+func (f FakeDB) UpdateQuotaReservations(update func(*up.QuotaReservations, []up.ContainerUsage) error) error {
+	if f.OnUpdateQuotaReservations != nil {
+		return f.OnUpdateQuotaReservations(update)
+	}
+	return errors.New("UpdateQuotaReservations should not have been called")
+}
+
+func (f FakeDB) PutSecret(secret up.Secret) error {
+	if f.OnPutSecret != nil {
+		return f.OnPutSecret(secret)
+	}
+	return errors.New("PutSecret should not have been called")
+}
+
+func (f FakeDB) DeleteSecret(name string) error {
+	if f.OnDeleteSecret != nil {
+		return f.OnDeleteSecret(name)
+	}
+	return errors.New("DeleteSecret should not have been called")
+}
+
+func (f FakeDB) GetSecret(name string) (up.Secret, error) {
+	if f.OnGetSecret != nil {
+		return f.OnGetSecret(name)
+	}
+	return up.Secret{}, errors.New("GetSecret should not have been called")
+}
+
+func (f FakeDB) GetSecrets() ([]up.Secret, error) {
+	if f.OnGetSecrets != nil {
+		return f.OnGetSecrets()
+	}
+	return nil, errors.New("GetSecrets should not have been called")
+}
+
+func (f FakeDB) PutSubscription(subscription up.Subscription) error {
+	if f.OnPutSubscription != nil {
+		return f.OnPutSubscription(subscription)
+	}
+	return errors.New("PutSubscription should not have been called")
+}
+
+func (f FakeDB) DeleteSubscription(name string) error {
+	if f.OnDeleteSubscription != nil {
+		return f.OnDeleteSubscription(name)
+	}
+	return errors.New("DeleteSubscription should not have been called")
+}
+
+func (f FakeDB) GetSubscriptions() ([]up.Subscription, error) {
+	if f.OnGetSubscriptions != nil {
+		return f.OnGetSubscriptions()
+	}
+	return nil, errors.New("GetSubscriptions should not have been called")
+}
+
+func (f FakeDB) UpdateServiceSlots(service string, update func(*up.ServiceSlots) error) error {
+	if f.OnUpdateServiceSlots != nil {
+		return f.OnUpdateServiceSlots(service, update)
+	}
+	return errors.New("UpdateServiceSlots should not have been called")
+}
+
+func (f FakeDB) GetServiceSlots() ([]up.ServiceSlots, error) {
+	if f.OnGetServiceSlots != nil {
+		return f.OnGetServiceSlots()
+	}
+	return nil, errors.New("GetServiceSlots should not have been called")
+}
+
+func (f FakeDB) UpdateIdentities(update func(*up.Identities) error) error {
+	if f.OnUpdateIdentities != nil {
+		return f.OnUpdateIdentities(update)
+	}
+	return errors.New("UpdateIdentities should not have been called")
+}
+
+func (f FakeDB) GetIdentities() (up.Identities, error) {
+	if f.OnGetIdentities != nil {
+		return f.OnGetIdentities()
+	}
+	return up.Identities{}, errors.New("GetIdentities should not have been called")
+}
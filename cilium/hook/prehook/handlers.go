@@ -3,10 +3,13 @@
 package prehook
 
 import (
+	"encoding/json"
+	"fmt"
 	"regexp"
 	"strings"
 
 	m "github.com/cilium-team/cilium/cilium/messages"
+	ub "github.com/cilium-team/cilium/cilium/utils/bus"
 	ucdb "github.com/cilium-team/cilium/cilium/utils/comm/db"
 	up "github.com/cilium-team/cilium/cilium/utils/profile"
 	upr "github.com/cilium-team/cilium/cilium/utils/profile/runnables"
@@ -23,6 +26,17 @@ const (
 	Kubernetes = "Kubernetes"
 )
 
+// failOpen lets requests through, unmodified, when the policies covering
+// them can't be read.
+var failOpen bool
+
+// SetFailOpen sets whether requests are let through, unmodified, instead of
+// denied, when the policies that could deny them can't be read from the
+// database.
+func SetFailOpen(open bool) {
+	failOpen = open
+}
+
 type PreHook struct {
 	dbConn   ucdb.Db
 	handlers map[string]string
@@ -91,8 +105,9 @@ func defaultRequest(cont []byte) (m.Response, error) {
 
 // preHook takes care of preparing necessary requirements so it can call all
 // Runnables available under server/utils/profile/runnables.
-// It returns an error if it isn't possible to decode a request. All remaining
-// failures are hidden but they are logged.
+// It returns an error if it isn't possible to decode a request or, unless the
+// pre hook fails open, to get the policies that cover it, so it's denied. All
+// remaining failures are hidden but they are logged.
 func (p PreHook) preHook(endPoint string, cont []byte) (m.Response, error) {
 	log.Debug("")
 
@@ -102,21 +117,31 @@ func (p PreHook) preHook(endPoint string, cont []byte) (m.Response, error) {
 	}
 	log.Debug("PowerstripPreHookRequest %+v", pphreq)
 
+	if !strings.HasPrefix(endPoint, Docker) && !strings.HasPrefix(endPoint, Kubernetes) {
+		return defaultRequest(cont)
+	}
+
 	users, err := p.dbConn.GetUsers()
 	if err != nil {
-		// If we can't connect to DB we just sent the response without any
-		// modification but we still log it.
-		log.Error("Error: %+v", err)
-		return defaultRequest(cont)
+		return policiesUnavailable(err, cont)
 	}
 
 	if strings.HasPrefix(endPoint, Docker) {
 		return p.preHookDocker(endPoint, pphreq, users, cont)
-	} else if strings.HasPrefix(endPoint, Kubernetes) {
-		return p.preHookKubernetes(endPoint, pphreq, users, cont)
 	}
+	return p.preHookKubernetes(endPoint, pphreq, users, cont)
+}
 
-	return defaultRequest(cont)
+// policiesUnavailable denies the request in cont, since the policies that could
+// deny it couldn't be read because of err, or lets it through, unmodified, if
+// the pre hook fails open.
+func policiesUnavailable(err error, cont []byte) (m.Response, error) {
+	if failOpen {
+		log.Warning("Unable to get the policies, the request is let through: %s", err)
+		return defaultRequest(cont)
+	}
+	log.Error("Unable to get the policies, the request is denied: %s", err)
+	return PowerstripPreHookResponse{}, fmt.Errorf("unable to get the policies: %s", err)
 }
 
 // preHookDocker deals with pre-hook requests that are docker specific.
@@ -127,32 +152,43 @@ func (p PreHook) preHookDocker(endPoint string, pphreq PowerstripPreHookRequest,
 	var createConfig m.DockerCreateConfig
 	if err := pphreq.UnmarshalDockerCreateClientBody(&createConfig); err != nil {
 		log.Error("Error: %+v", err)
-		return defaultRequest(cont)
+		return PowerstripPreHookResponse{}, fmt.Errorf("invalid docker create request: %s", err)
 	}
 
 	log.Debug("ClientBody: %+v", createConfig)
 	log.Debug("ClientBody.Config: %+v", createConfig.Config)
 	log.Debug("ClientBody.HostConfig: %+v", createConfig.HostConfig)
 
-	if createConfig.Config == nil || createConfig.Config.Labels == nil {
-		log.Info("Request has empty config or empty labels.")
+	if createConfig.Config == nil {
+		log.Info("Request has empty config.")
 		return defaultRequest(cont)
 	}
 
-	policies, err := p.dbConn.GetPoliciesThatCovers(createConfig.Config.Labels)
+	// Requests without labels are still checked against the policies, and
+	// denied if they can't be retrieved, unless the pre hook fails open.
+	labels := createConfig.Config.Labels
+	if labels == nil {
+		labels = map[string]string{}
+	}
+	policies, err := p.dbConn.GetPoliciesThatCovers(labels)
 	if err != nil {
-		log.Error("Error: %+v", err)
-		return defaultRequest(cont)
+		return policiesUnavailable(err, cont)
 	}
 	if policies == nil || len(policies) == 0 {
 		log.Info("There aren't any policies for the giving labels.")
 		return defaultRequest(cont)
 	}
 
+	entry := up.NewHookAuditEntry(up.AuditPreHook, createConfig.Name, policies)
+	entry.Before = json.RawMessage(pphreq.ClientRequest.Body)
 	for _, runnables := range upr.GetRunnables() {
 		runnable := runnables.GetRunnableFrom(users, policies)
 		log.Info("Loaded and merged policy for container %s: %#v", createConfig.Name, runnable)
 		if err = runnable.DockerExec(Type, endPoint, p.dbConn, &createConfig); err != nil {
+			err = upr.AttributeDenial(err, users, policies)
+			entry.Decision, entry.Reason = up.AuditRejected, err.Error()
+			ucdb.Audit(p.dbConn, entry)
+			publishDecision(entry)
 			return PowerstripPreHookResponse{}, err
 		}
 	}
@@ -165,12 +201,20 @@ func (p PreHook) preHookDocker(endPoint string, pphreq PowerstripPreHookRequest,
 		return PowerstripPreHookResponse{}, err
 	}
 
+	entry.Decision, entry.After = up.AuditAllowed, json.RawMessage(respCreateConfig)
+	ucdb.Audit(p.dbConn, entry)
+	publishDecision(entry)
+
 	log.Info("Response created for container %s: %#v", createConfig.Name, respCreateConfig)
-	return NewPowerstripPreHookResponse(pphreq.ClientRequest.Method,
-			pphreq.ClientRequest.Request,
-			respCreateConfig,
-		),
-		nil
+	for _, warning := range createConfig.Warnings {
+		log.Warning("Container %s: %s", createConfig.Name, warning)
+	}
+	response := NewPowerstripPreHookResponse(pphreq.ClientRequest.Method,
+		pphreq.ClientRequest.Request,
+		respCreateConfig,
+	)
+	response.Warnings = createConfig.Warnings
+	return response, nil
 }
 
 // preHookKubernetes deals with pre-hook requests that are kubernetes specific.
@@ -194,8 +238,7 @@ func (p PreHook) preHookKubernetes(endPoint string, pphreq PowerstripPreHookRequ
 
 	policies, err := p.dbConn.GetPoliciesThatCovers(labels)
 	if err != nil {
-		log.Error("Error: %+v", err)
-		return defaultRequest(cont)
+		return policiesUnavailable(err, cont)
 	}
 	if policies == nil || len(policies) == 0 {
 		log.Info("There aren't any policies for the giving labels.")
@@ -211,10 +254,16 @@ func (p PreHook) preHookKubernetes(endPoint string, pphreq PowerstripPreHookRequ
 		return defaultRequest(cont)
 	}
 
+	entry := up.NewHookAuditEntry(up.AuditPreHook, kubernetesObjRef.Name, policiesKind)
+	entry.Before = json.RawMessage(pphreq.ClientRequest.Body)
 	for _, runnables := range upr.GetRunnables() {
 		runnable := runnables.GetRunnableFrom(users, policiesKind)
 		log.Info("Loaded and merged policy for kubernetesObjRef '%s': %#v", kubernetesObjRef.Name, runnable)
 		if err = runnable.KubernetesExec(Type, endPoint, p.dbConn, &kubernetesObjRef); err != nil {
+			err = upr.AttributeDenial(err, users, policiesKind)
+			entry.Decision, entry.Reason = up.AuditRejected, err.Error()
+			ucdb.Audit(p.dbConn, entry)
+			publishDecision(entry)
 			return PowerstripPreHookResponse{}, err
 		}
 	}
@@ -226,6 +275,10 @@ func (p PreHook) preHookKubernetes(endPoint string, pphreq PowerstripPreHookRequ
 		return PowerstripPreHookResponse{}, err
 	}
 
+	entry.Decision, entry.After = up.AuditAllowed, json.RawMessage(respCreateConfig)
+	ucdb.Audit(p.dbConn, entry)
+	publishDecision(entry)
+
 	log.Info("Response created for kubernetesObjRef '%s': %#v", kubernetesObjRef.Name, respCreateConfig)
 	return NewPowerstripPreHookResponse(pphreq.ClientRequest.Method,
 			pphreq.ClientRequest.Request,
@@ -233,3 +286,20 @@ func (p PreHook) preHookKubernetes(endPoint string, pphreq PowerstripPreHookRequ
 		),
 		nil
 }
+
+// publishDecision publishes the decision taken, as recorded in the given
+// audit entry, for a request.
+func publishDecision(entry up.AuditEntry) {
+	typ := ub.PolicyApplied
+	if entry.Decision == up.AuditRejected {
+		typ = ub.RequestDenied
+	}
+	data := map[string]interface{}{
+		"owners":   entry.Owners,
+		"policies": entry.Policies,
+	}
+	if entry.Reason != "" {
+		data["reason"] = entry.Reason
+	}
+	ub.Publish(typ, entry.Container, data)
+}
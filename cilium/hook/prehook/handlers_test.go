@@ -2,17 +2,28 @@ package prehook
 
 import (
 	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
 	"reflect"
 	"strconv"
 	"strings"
 	"testing"
+	"time"
 
 	m "github.com/cilium-team/cilium/cilium/messages"
+	ucdb "github.com/cilium-team/cilium/cilium/utils/comm/db"
 	up "github.com/cilium-team/cilium/cilium/utils/profile"
 	upr "github.com/cilium-team/cilium/cilium/utils/profile/runnables"
 	uprd "github.com/cilium-team/cilium/cilium/utils/profile/runnables/docker"
+	upri "github.com/cilium-team/cilium/cilium/utils/profile/runnables/intent"
 	uprk "github.com/cilium-team/cilium/cilium/utils/profile/runnables/kubernetes"
+	uprs "github.com/cilium-team/cilium/cilium/utils/profile/runnables/secrets"
+	uprw "github.com/cilium-team/cilium/cilium/utils/profile/runnables/webhook"
 	upsd "github.com/cilium-team/cilium/cilium/utils/profile/subpolicies/docker"
+	upsi "github.com/cilium-team/cilium/cilium/utils/profile/subpolicies/intent"
+	upsw "github.com/cilium-team/cilium/cilium/utils/profile/subpolicies/webhook"
+	us "github.com/cilium-team/cilium/cilium/utils/secrets"
 )
 
 var (
@@ -37,10 +48,7 @@ var (
 	validRequest = `{"Type": ` + validType + `, "PowerstripProtocolVersion": ` +
 		strconv.Itoa(validPPV) + `, "ClientRequest": {"Body": "` + validBody + `", ` +
 		`"Request": ` + validDockerRequestHeader + `, "Method": "` + validMethod + `"}}`
-	validWantBodyWoutEscQuot = `{"Hostname":"myhostname","ExposedPorts":{"53/udp":{},"80/tcp":{}},` +
-		`"Cmd":null,"Image":"fooandbar","Entrypoint":null,"Labels":{"com.docker.swarm.id":"123456"},` +
-		`"HostConfig":{"PortBindings":{"53/udp":[{"HostPort":"53"}],"80/tcp":[{"HostPort":"80"}]},` +
-		`"Dns":["1.2.3.4"],"NetworkMode":"bridge","RestartPolicy":{"Name":"no"},"LogConfig":{}}}`
+	validWantBodyWoutEscQuot             = strings.Replace(validBodyWoutEscQuot, `"Dns":["8.8.8.8","8.8.4.4"]`, `"Dns":["1.2.3.4"]`, 1)
 	validBodyWoutEscQuot                 = strings.Replace(validBody, `\"`, `"`, -1)
 	validDockerRequestHeaderWoutQuot     = strings.Replace(validDockerRequestHeader, `"`, ``, -1)
 	validKubernetesRequestHeaderWoutQuot = strings.Replace(validKubernetesRequestHeader, `"`, ``, -1)
@@ -124,7 +132,7 @@ func TestPreHook(t *testing.T) {
 	var ph PreHook
 	ph.dbConn = f
 
-	upr.Register(uprd.Name, uprd.DockerRunnable{})
+	upr.Register(uprd.Name, upr.MergeStage, uprd.DockerRunnable{})
 
 	defaultPPHR, err := ph.preHook(uprd.DockerSwarmCreate, []byte(validRequest))
 	if err != nil {
@@ -159,6 +167,152 @@ func TestPreHook(t *testing.T) {
 	}
 }
 
+func TestPreHookSecretsNotSentToWebhooks(t *testing.T) {
+	webhookCalled := false
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		webhookCalled = true
+		body, _ := ioutil.ReadAll(r.Body)
+		if strings.Contains(string(body), "s3cr3t") {
+			t.Errorf("webhook received the plaintext secret: %s", body)
+		}
+		w.Write([]byte(`{}`))
+	}))
+	defer server.Close()
+
+	if err := us.SetKey("000102030405060708090a0b0c0d0e0f101112131415161718191a1b1c1d1e1f"); err != nil {
+		t.Fatal(err)
+	}
+	f := ucdb.NewMemConn()
+	if _, err := f.PutUser("root"); err != nil {
+		t.Fatal(err)
+	}
+	secret, err := us.Encrypt(up.Secret{Name: "db/password", Owner: "root", Plaintext: "s3cr3t"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := f.PutSecret(secret); err != nil {
+		t.Fatal(err)
+	}
+	policy := up.Policy{
+		Name:  "secrets",
+		Owner: "root",
+		Coverage: up.Coverage{
+			Labels: map[string]string{"com.docker.swarm.id": "123456"},
+		},
+		DockerConfig: upsd.DockerConfig{
+			SecretEnv: map[string]string{"DB_PASSWORD": "db/password"},
+		},
+		WebhookConfig: upsw.WebhookConfig{
+			Webhooks: []upsw.Webhook{{Name: "audit", URL: server.URL}},
+		},
+	}
+	if err := f.PutPolicy(up.PolicySource{Owner: "root", Policies: []up.Policy{policy}}); err != nil {
+		t.Fatal(err)
+	}
+
+	var ph PreHook
+	ph.dbConn = f
+
+	// Registered in the reverse order, runnables must still be executed by
+	// stage.
+	upr.Register(uprs.Name, upr.SecretsStage, uprs.SecretsRunnable{})
+	upr.Register(uprw.Name, upr.WebhookStage, uprw.WebhookRunnable{})
+	upr.Register(uprd.Name, upr.MergeStage, uprd.DockerRunnable{})
+
+	resp, err := ph.preHook(uprd.DockerSwarmCreate, []byte(validRequest))
+	if err != nil {
+		t.Fatal("error occured while executing preHook", err)
+	}
+	if !webhookCalled {
+		t.Error("webhook wasn't called")
+	}
+	pphr := resp.(*PowerstripPreHookResponse)
+	if !strings.Contains(pphr.ModifiedClientRequest.Body, `"DB_PASSWORD=s3cr3t"`) {
+		t.Errorf("secret not set in the request:\n%s", pphr.ModifiedClientRequest.Body)
+	}
+}
+
+func TestPreHookEndToEnd(t *testing.T) {
+	if err := ucdb.SetDriver(ucdb.MemoryDB, ""); err != nil {
+		t.Fatal(err)
+	}
+	defer ucdb.SetDriver(ucdb.ElasticDB, "")
+	if err := ucdb.InitDb(""); err != nil {
+		t.Fatal(err)
+	}
+	conn, err := ucdb.NewConn()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := conn.PutUser("root"); err != nil {
+		t.Fatal(err)
+	}
+	intentConfig := upsi.NewIntentConfig()
+	*intentConfig.Config.MaxScale = 1
+	policy := up.Policy{
+		Name:  "web",
+		Owner: "root",
+		Coverage: up.Coverage{
+			Labels: map[string]string{"com.docker.compose.service": "web"},
+		},
+		DockerConfig: upsd.DockerConfig{
+			HostConfig: upsd.HostConfig{
+				DNS: []string{"1.2.3.4"},
+			},
+		},
+		IntentConfig: *intentConfig,
+	}
+	if err := conn.PutPolicy(up.PolicySource{Owner: "root", Policies: []up.Policy{policy}}); err != nil {
+		t.Fatal(err)
+	}
+
+	upr.Register(uprd.Name, upr.MergeStage, uprd.DockerRunnable{})
+	upr.Register(upri.Name, upr.IntentStage, upri.IntentRunnable{})
+	ph := NewPreHook()
+	// Audit entries survive InitDb.
+	start := time.Now()
+
+	req := strings.Replace(validRequest, `{\"com.docker.swarm.id\":\"123456\"}`,
+		`{\"com.docker.swarm.id\":\"123456\",\"com.docker.compose.service\":\"web\"}`, 1)
+	resp, err := ph.ProcessRequest("/docker/swarm/cilium-adapter", validDockerRequestHeaderWoutQuot, []byte(req))
+	if err != nil {
+		t.Fatal("error occured while processing request", err)
+	}
+	body := resp.(*PowerstripPreHookResponse).ModifiedClientRequest.Body
+	if !strings.Contains(body, `"Dns":["1.2.3.4"]`) {
+		t.Errorf("docker config not set in the request:\n%s", body)
+	}
+	if !strings.Contains(body, `"`+upri.SlotReservationLabel+`"`) {
+		t.Errorf("max-scale slot not reserved in the request:\n%s", body)
+	}
+
+	// The slot reserved by the first container is stored in the database so
+	// the second one is denied.
+	_, err = ph.ProcessRequest("/docker/swarm/cilium-adapter", validDockerRequestHeaderWoutQuot, []byte(req))
+	denial, ok := err.(upr.Denial)
+	if !ok {
+		t.Fatalf("invalid error:\ngot  %#v\nwant %s", err, "max-scale denial")
+	}
+	if denial.Field != "max-scale" || denial.Policy != "web" || denial.Owner != "root" {
+		t.Errorf("invalid denial:\ngot  %#v\nwant %s", denial, "max-scale denial of policy web of root")
+	}
+
+	entries, err := conn.GetAuditEntries(up.AuditFilter{Owner: "root", Since: start})
+	if err != nil {
+		t.Fatal(err)
+	}
+	decisions := []string{}
+	for _, entry := range entries {
+		if entry.Action == up.AuditPreHook {
+			decisions = append(decisions, entry.Decision)
+		}
+	}
+	want := []string{up.AuditAllowed, up.AuditRejected}
+	if !reflect.DeepEqual(decisions, want) {
+		t.Errorf("invalid audited decisions:\ngot  %v\nwant %v", decisions, want)
+	}
+}
+
 func TestPreHookInvalid(t *testing.T) {
 	f := FakeDB{}
 	f.OnGetUsers = func() ([]up.User, error) {
@@ -168,7 +322,19 @@ func TestPreHookInvalid(t *testing.T) {
 	var ph PreHook
 	ph.dbConn = f
 
-	upr.Register(uprd.Name, uprd.DockerRunnable{})
+	upr.Register(uprd.Name, upr.MergeStage, uprd.DockerRunnable{})
+
+	if _, err := ph.preHook(uprd.DockerSwarmCreate, []byte(validRequest)); err == nil {
+		t.Error("request was let through without its policies")
+	}
+	SetFailOpen(true)
+	resp, err := ph.preHook(uprd.DockerSwarmCreate, []byte(validRequest))
+	SetFailOpen(false)
+	if err != nil {
+		t.Errorf("request was denied without its policies by a fail-open pre hook: %s", err)
+	} else if body := resp.(*PowerstripPreHookResponse).ModifiedClientRequest.Body; body != validBodyWoutEscQuot {
+		t.Errorf("request modified without its policies:\ngot  %s\nwant %s", body, validBodyWoutEscQuot)
+	}
 
 	defaultPPHR, err := ph.preHook("Default", []byte(validRequest))
 	if err != nil {
@@ -213,8 +379,8 @@ func TestParseRequest(t *testing.T) {
 		{`/something`, "Default"},
 	}
 
-	upr.Register(uprd.Name, uprd.DockerRunnable{})
-	upr.Register(uprk.Name, uprk.KubernetesRunnable{})
+	upr.Register(uprd.Name, upr.MergeStage, uprd.DockerRunnable{})
+	upr.Register(uprk.Name, upr.MergeStage, uprk.KubernetesRunnable{})
 	p := PreHook{
 		handlers: map[string]string{},
 	}
@@ -230,6 +396,11 @@ func TestParseRequest(t *testing.T) {
 			t.Errorf("invalid parsed request:\ngot  %s\nwant %s", got, tt.want)
 		}
 	}
+	for _, tt := range dockerTests {
+		if got := p.parseRequest(tt.baseAddr, "/containers/create?name=hello-world"); got != tt.want {
+			t.Errorf("invalid parsed unversioned request:\ngot  %s\nwant %s", got, tt.want)
+		}
+	}
 	for _, tt := range dockerTests {
 		if got := p.parseRequest(tt.baseAddr, invalidDockerRequestHeader); got != "Default" {
 			t.Errorf("invalid parsed request:\ngot  %s\nwant %s", got, "Default")
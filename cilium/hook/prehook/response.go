@@ -7,6 +7,9 @@ import (
 type PowerstripPreHookResponse struct {
 	m.PowerstripResponse
 	ModifiedClientRequest modifiedClientRequest
+	// Warnings aren't part of the powerstrip protocol, they are only used by
+	// the docker API proxy.
+	Warnings []string `json:",omitempty"`
 }
 
 type modifiedClientRequest struct {
@@ -0,0 +1,69 @@
+package server
+
+import (
+	"regexp"
+	"sync"
+
+	m "github.com/cilium-team/cilium/cilium/messages"
+	uw "github.com/cilium-team/cilium/cilium/utils/workqueue"
+
+	"github.com/cilium-team/cilium/Godeps/_workspace/src/github.com/op/go-logging"
+)
+
+var (
+	log = logging.MustGetLogger("cilium")
+
+	queueMutex sync.RWMutex
+	queue      *uw.Queue
+	resolveID  func(string) (string, error)
+
+	containerRegexp = regexp.MustCompile(`/containers/([^/?]+)/`)
+	fullIDRegexp    = regexp.MustCompile(`^[[:xdigit:]]{64}$`)
+)
+
+// SetQueue sets the work queue where the hooks of requests for existing
+// containers run, serialized with the other work for the same container. The
+// containers, referred to by name or ID prefix in the requests, are keyed by
+// their full ID, as given by resolve, like the containers' events.
+func SetQueue(q *uw.Queue, resolve func(string) (string, error)) {
+	queueMutex.Lock()
+	defer queueMutex.Unlock()
+	queue, resolveID = q, resolve
+}
+
+func getQueue() (*uw.Queue, func(string) (string, error)) {
+	queueMutex.RLock()
+	defer queueMutex.RUnlock()
+	return queue, resolveID
+}
+
+// queuedHook runs its hook in the work queue, keyed by the container of the
+// request.
+type queuedHook struct {
+	hook     Hook
+	hookType string
+}
+
+func (qh queuedHook) ProcessRequest(baseAddr string, req string, cont []byte) (m.Response, error) {
+	q, resolve := getQueue()
+	match := containerRegexp.FindStringSubmatch(req)
+	if q == nil || match == nil {
+		return qh.hook.ProcessRequest(baseAddr, req, cont)
+	}
+	container := match[1]
+	if resolve != nil && !fullIDRegexp.MatchString(container) {
+		// Requests for containers that can't be resolved fail in docker,
+		// they are keyed by what the client sent.
+		if id, err := resolve(container); err != nil {
+			log.Warning("Unable to resolve the ID of container %s: %s", container, err)
+		} else {
+			container = id
+		}
+	}
+	var response m.Response
+	err := q.Do(container, qh.hookType, func() (err error) {
+		response, err = qh.hook.ProcessRequest(baseAddr, req, cont)
+		return err
+	})
+	return response, err
+}
@@ -0,0 +1,80 @@
+package server
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	m "github.com/cilium-team/cilium/cilium/messages"
+	uw "github.com/cilium-team/cilium/cilium/utils/workqueue"
+)
+
+type recordingHook struct {
+	order *[]string
+}
+
+func (rh recordingHook) ProcessRequest(baseAddr string, req string, cont []byte) (m.Response, error) {
+	*rh.order = append(*rh.order, req)
+	return nil, nil
+}
+
+func TestQueuedHook(t *testing.T) {
+	q := uw.NewQueue(2, 10)
+	SetQueue(q, nil)
+	defer SetQueue(nil, nil)
+	order := []string{}
+	hook := queuedHook{hook: recordingHook{order: &order}, hookType: "pre-hook"}
+
+	q.Add("abc", "die", func() error {
+		time.Sleep(5 * time.Millisecond)
+		order = append(order, "die")
+		return nil
+	})
+	if _, err := hook.ProcessRequest("", "/v1.21/containers/abc/start", nil); err != nil {
+		t.Fatalf("error while processing request: %s", err)
+	}
+	if _, err := hook.ProcessRequest("", "/v1.21/containers/create", nil); err != nil {
+		t.Fatalf("error while processing request: %s", err)
+	}
+	want := []string{"die", "/v1.21/containers/abc/start", "/v1.21/containers/create"}
+	if !reflect.DeepEqual(order, want) {
+		t.Errorf("invalid order:\ngot  %v\nwant %v", order, want)
+	}
+	q.Stop()
+}
+
+func TestQueuedHookResolvesContainer(t *testing.T) {
+	const id = "6b27a943823d0f735346861bbce6e24acdaf435edb259748be556300d1c361f3"
+	q := uw.NewQueue(2, 10)
+	SetQueue(q, func(container string) (string, error) {
+		if container != "web" && container != id[:12] {
+			return "", fmt.Errorf("no such container: %s", container)
+		}
+		return id, nil
+	})
+	defer SetQueue(nil, nil)
+	order := []string{}
+	hook := queuedHook{hook: recordingHook{order: &order}, hookType: "post-hook"}
+
+	// Requests by name or ID prefix wait for the events of the full ID.
+	for _, container := range []string{"web", id[:12], id} {
+		q.Add(id, "die", func() error {
+			time.Sleep(5 * time.Millisecond)
+			order = append(order, "die")
+			return nil
+		})
+		request := "/v1.21/containers/" + container + "/start"
+		if _, err := hook.ProcessRequest("", request, nil); err != nil {
+			t.Fatalf("error while processing request: %s", err)
+		}
+		if want := []string{"die", request}; !reflect.DeepEqual(order, want) {
+			t.Errorf("invalid order:\ngot  %v\nwant %v", order, want)
+		}
+		order = order[:0]
+	}
+	if _, err := hook.ProcessRequest("", "/v1.21/containers/unknown/start", nil); err != nil {
+		t.Errorf("error while processing request of unknown container: %s", err)
+	}
+	q.Stop()
+}
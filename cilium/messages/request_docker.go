@@ -2,6 +2,7 @@ package messages
 
 import (
 	"encoding/json"
+	"fmt"
 	"net/url"
 
 	"github.com/cilium-team/cilium/Godeps/_workspace/src/github.com/cilium-team/mergo"
@@ -14,6 +15,16 @@ type DockerCreateConfig struct {
 	ID         string        `json:"-" yaml:"-"`
 	State      *d.State      `json:"-" yaml:"-"`
 	HostConfig *d.HostConfig `json:"HostConfig,omitempty" yaml:"HostConfig,omitempty"`
+	// APIVersion is the docker API version of the request where the
+	// DockerCreateConfig was unmarshalled from.
+	APIVersion DockerAPIVersion `json:"-" yaml:"-"`
+	// Warnings are the non-fatal messages, for the client, added while
+	// processing the DockerCreateConfig.
+	Warnings []string `json:"-" yaml:"-"`
+	// originalBody and decodedBody are the client body and its encoding from
+	// the go-dockerclient structures right after being unmarshalled.
+	originalBody []byte
+	decodedBody  []byte
 }
 
 // NewDockerCreateConfigFromDockerContainer creates a CreateConfig from the
@@ -74,6 +85,11 @@ func (cc *DockerCreateConfig) MergeWithOverwrite(other DockerCreateConfig) {
 	}
 }
 
+// Warn adds a warning, for the client, to the receiver's DockerCreateConfig.
+func (cc *DockerCreateConfig) Warn(format string, a ...interface{}) {
+	cc.Warnings = append(cc.Warnings, fmt.Sprintf(format, a...))
+}
+
 // UnmarshalDockerCreateClientBody unmarshals the PowerstripRequest into a
 // DockerCreateConfig.
 func (p PowerstripRequest) UnmarshalDockerCreateClientBody(cc *DockerCreateConfig) error {
@@ -90,17 +106,29 @@ func (p PowerstripRequest) UnmarshalDockerCreateClientBody(cc *DockerCreateConfi
 	}
 	// The "/" is because docker inserts them as well on the container's names
 	cc.Name = "/" + urlreq.Query().Get("name")
+	cc.APIVersion = ParseDockerAPIVersion(p.ClientRequest.Request)
 
+	decodedBody, err := json.Marshal(cc)
+	if err != nil {
+		return err
+	}
+	cc.originalBody = []byte(p.ClientRequest.Body)
+	cc.decodedBody = decodedBody
 	return nil
 }
 
 // Marshal2JSONStr returns on a json string format of the given
-// DockerCreateConfig.
+// DockerCreateConfig. If it was unmarshalled from a client body, that body is
+// returned with only the fields modified since then patched, so the fields
+// that go-dockerclient doesn't know about aren't lost.
 func (cc *DockerCreateConfig) Marshal2JSONStr() (string, error) {
 	bytes, err := json.Marshal(cc)
 	if err != nil {
 		return "", err
 	}
+	if cc.originalBody != nil {
+		return patchDockerCreateBody(cc.APIVersion, cc.originalBody, cc.decodedBody, bytes)
+	}
 	return string(bytes), nil
 }
 
@@ -0,0 +1,277 @@
+package messages
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+const hostConfigField = "HostConfig"
+
+// DockerAPIVersion is the version of the docker remote API used by a request.
+// The zero value means the request didn't specify any version, in which case
+// docker uses its latest version.
+type DockerAPIVersion struct {
+	Major int
+	Minor int
+}
+
+var dockerAPIVersionPrefix = regexp.MustCompile(`^/v([0-9]+)\.([0-9]+)/`)
+
+// ParseDockerAPIVersion returns the docker API version from the /vX.Y/ prefix
+// of the given request.
+func ParseDockerAPIVersion(request string) DockerAPIVersion {
+	if urlreq, err := url.ParseRequestURI(request); err == nil {
+		request = urlreq.Path
+	}
+	match := dockerAPIVersionPrefix.FindStringSubmatch(request)
+	if match == nil {
+		return DockerAPIVersion{}
+	}
+	major, _ := strconv.Atoi(match[1])
+	minor, _ := strconv.Atoi(match[2])
+	return DockerAPIVersion{Major: major, Minor: minor}
+}
+
+// AtLeast returns true if the receiver's version is equal or newer than the
+// given one. Unspecified versions are newer than all versions.
+func (v DockerAPIVersion) AtLeast(major, minor int) bool {
+	if v == (DockerAPIVersion{}) {
+		return true
+	}
+	return v.Major > major || (v.Major == major && v.Minor >= minor)
+}
+
+func (v DockerAPIVersion) String() string {
+	if v == (DockerAPIVersion{}) {
+		return "latest"
+	}
+	return fmt.Sprintf("%d.%d", v.Major, v.Minor)
+}
+
+// relocatedFields are the fields of the create body that were moved, in the
+// given docker API version, from the container's config to its host config.
+var relocatedFields = []struct {
+	config     string
+	hostConfig string
+	major      int
+	minor      int
+}{
+	{"Dns", "Dns", 1, 10},
+	{"Memory", "Memory", 1, 18},
+	{"MemorySwap", "MemorySwap", 1, 18},
+	{"CpuShares", "CpuShares", 1, 18},
+	{"Cpuset", "CpusetCpus", 1, 18},
+}
+
+// jsonField is a field of a json object with its value as it was encoded.
+type jsonField struct {
+	Key   string
+	Value json.RawMessage
+}
+
+// jsonObject is a json object that keeps the order and the encoding of its
+// fields' values.
+type jsonObject []jsonField
+
+// parseJSONObject parses the given json object. Empty and null documents are
+// parsed as empty objects.
+func parseJSONObject(data []byte) (jsonObject, error) {
+	obj := jsonObject{}
+	if trimmed := bytes.TrimSpace(data); len(trimmed) == 0 || bytes.Equal(trimmed, []byte("null")) {
+		return obj, nil
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	if tok, err := dec.Token(); err != nil {
+		return nil, err
+	} else if tok != json.Delim('{') {
+		return nil, fmt.Errorf("invalid json object, got %v", tok)
+	}
+	for dec.More() {
+		tok, err := dec.Token()
+		if err != nil {
+			return nil, err
+		}
+		key, ok := tok.(string)
+		if !ok {
+			return nil, fmt.Errorf("invalid json object key %v", tok)
+		}
+		var value json.RawMessage
+		if err := dec.Decode(&value); err != nil {
+			return nil, err
+		}
+		obj = append(obj, jsonField{Key: key, Value: value})
+	}
+	if _, err := dec.Token(); err != nil {
+		return nil, err
+	}
+	return obj, nil
+}
+
+// MarshalJSON encodes the receiver's jsonObject keeping its fields' order and
+// values untouched.
+func (o jsonObject) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for i, field := range o {
+		if i != 0 {
+			buf.WriteByte(',')
+		}
+		key, err := json.Marshal(field.Key)
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(key)
+		buf.WriteByte(':')
+		buf.Write(field.Value)
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+// index returns the position of the given key in the receiver's jsonObject or
+// -1 if it doesn't exist. Keys are matched case-insensitively, like docker
+// does.
+func (o jsonObject) index(key string) int {
+	for i, field := range o {
+		if strings.EqualFold(field.Key, key) {
+			return i
+		}
+	}
+	return -1
+}
+
+// get returns the value of the given key.
+func (o jsonObject) get(key string) json.RawMessage {
+	if i := o.index(key); i != -1 {
+		return o[i].Value
+	}
+	return nil
+}
+
+// set replaces the value of the given key, keeping its position and its
+// original spelling, or appends it if it doesn't exist. A nil value removes
+// the key.
+func (o *jsonObject) set(key string, value json.RawMessage) {
+	i := o.index(key)
+	switch {
+	case value == nil && i != -1:
+		*o = append((*o)[:i], (*o)[i+1:]...)
+	case value == nil:
+	case i != -1:
+		(*o)[i].Value = value
+	default:
+		*o = append(*o, jsonField{Key: key, Value: value})
+	}
+}
+
+// diff returns the fields that changed from before to after. Removed fields
+// have a nil value. The skip field is ignored, as well as new empty objects,
+// which go-dockerclient always encodes for its struct fields.
+func diff(before, after jsonObject, skip string) jsonObject {
+	changes := jsonObject{}
+	for _, field := range after {
+		if field.Key == skip {
+			continue
+		}
+		old := before.get(field.Key)
+		if old == nil && bytes.Equal(field.Value, []byte("{}")) {
+			continue
+		}
+		if old == nil || !bytes.Equal(old, field.Value) {
+			changes = append(changes, field)
+		}
+	}
+	for _, field := range before {
+		if field.Key != skip && after.index(field.Key) == -1 {
+			changes = append(changes, jsonField{Key: field.Key})
+		}
+	}
+	return changes
+}
+
+// relocate moves the changes of the fields that docker has moved between the
+// container's config and its host config to the place where the given API
+// version expects them.
+func relocate(version DockerAPIVersion, config, hostConfig jsonObject) (jsonObject, jsonObject) {
+	for _, r := range relocatedFields {
+		inHostConfig := version.AtLeast(r.major, r.minor)
+		if i := config.index(r.config); i != -1 && inHostConfig && config[i].Value != nil {
+			if hostConfig.index(r.hostConfig) == -1 {
+				hostConfig.set(r.hostConfig, config[i].Value)
+			}
+			config = append(config[:i], config[i+1:]...)
+		}
+		if i := hostConfig.index(r.hostConfig); i != -1 && !inHostConfig && hostConfig[i].Value != nil {
+			if config.index(r.config) == -1 {
+				config.set(r.config, hostConfig[i].Value)
+			}
+			hostConfig = append(hostConfig[:i], hostConfig[i+1:]...)
+		}
+	}
+	return config, hostConfig
+}
+
+// apply applies the given changes to the receiver's jsonObject.
+func (o *jsonObject) apply(changes jsonObject) {
+	for _, change := range changes {
+		o.set(change.Key, change.Value)
+	}
+}
+
+// patchDockerCreateBody returns the original create body with the fields that
+// changed from the decoded to the modified create body, both encoded from the
+// go-dockerclient structures. The fields unknown to those structures are kept
+// untouched.
+func patchDockerCreateBody(version DockerAPIVersion, original, decoded, modified []byte) (string, error) {
+	body, err := parseJSONObject(original)
+	if err != nil {
+		return "", err
+	}
+	before, err := parseJSONObject(decoded)
+	if err != nil {
+		return "", err
+	}
+	after, err := parseJSONObject(modified)
+	if err != nil {
+		return "", err
+	}
+	hcBefore, err := parseJSONObject(before.get(hostConfigField))
+	if err != nil {
+		return "", err
+	}
+	hcAfter, err := parseJSONObject(after.get(hostConfigField))
+	if err != nil {
+		return "", err
+	}
+
+	changes, hcChanges := relocate(version,
+		diff(before, after, hostConfigField),
+		diff(hcBefore, hcAfter, ""))
+	if len(changes) == 0 && len(hcChanges) == 0 {
+		return string(original), nil
+	}
+
+	body.apply(changes)
+	if len(hcChanges) != 0 {
+		hostConfig, err := parseJSONObject(body.get(hostConfigField))
+		if err != nil {
+			return "", err
+		}
+		hostConfig.apply(hcChanges)
+		hcBody, err := hostConfig.MarshalJSON()
+		if err != nil {
+			return "", err
+		}
+		body.set(hostConfigField, hcBody)
+	}
+	data, err := body.MarshalJSON()
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
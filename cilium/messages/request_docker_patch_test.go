@@ -0,0 +1,104 @@
+package messages
+
+import (
+	"testing"
+
+	d "github.com/cilium-team/cilium/Godeps/_workspace/src/github.com/fsouza/go-dockerclient"
+)
+
+var validUnknownFieldsBody = `{"Image":"fooandbar","Labels":{"app":"web"},` +
+	`"Healthcheck":{"Test":["CMD","true"],"Interval":1000000000},` +
+	`"HostConfig":{"Memory":0,"NanoCpus":500000000,"Dns":["8.8.8.8"]},` +
+	`"NetworkingConfig":{"EndpointsConfig":{"net1":{"Aliases":["web"]}}}}`
+
+func unmarshalTestCreateBody(t *testing.T, request, body string) DockerCreateConfig {
+	var powerStripReq PowerstripRequest
+	powerStripReq.ClientRequest = ClientRequest{Method: "POST", Request: request, Body: body}
+	var cc DockerCreateConfig
+	if err := powerStripReq.UnmarshalDockerCreateClientBody(&cc); err != nil {
+		t.Fatal("invalid request:", err)
+	}
+	return cc
+}
+
+func TestDockerCreateConfigKeepsUnknownFields(t *testing.T) {
+	cc := unmarshalTestCreateBody(t, "/v1.24/containers/create?name=web", validUnknownFieldsBody)
+	str, err := cc.Marshal2JSONStr()
+	if err != nil {
+		t.Fatal("invalid CreateConfig:", err)
+	}
+	if str != validUnknownFieldsBody {
+		t.Errorf("invalid unmodified body:\ngot  %s\nwant %s", str, validUnknownFieldsBody)
+	}
+
+	cc.Labels["cilium"] = "yes"
+	cc.HostConfig.DNS = []string{"1.2.3.4"}
+	cc.HostConfig.NetworkMode = "cilium"
+	str, err = cc.Marshal2JSONStr()
+	if err != nil {
+		t.Fatal("invalid CreateConfig:", err)
+	}
+	want := `{"Image":"fooandbar","Labels":{"app":"web","cilium":"yes"},` +
+		`"Healthcheck":{"Test":["CMD","true"],"Interval":1000000000},` +
+		`"HostConfig":{"Memory":0,"NanoCpus":500000000,"Dns":["1.2.3.4"],"NetworkMode":"cilium"},` +
+		`"NetworkingConfig":{"EndpointsConfig":{"net1":{"Aliases":["web"]}}}}`
+	if str != want {
+		t.Errorf("invalid patched body:\ngot  %s\nwant %s", str, want)
+	}
+}
+
+func TestDockerCreateConfigRemovedFields(t *testing.T) {
+	cc := unmarshalTestCreateBody(t, "/v1.24/containers/create", `{"image":"fooandbar","Labels":{"app":"web"},"Unknown":1}`)
+	cc.Labels = nil
+	cc.Image = "bar"
+	str, err := cc.Marshal2JSONStr()
+	if err != nil {
+		t.Fatal("invalid CreateConfig:", err)
+	}
+	if want := `{"image":"bar","Unknown":1}`; str != want {
+		t.Errorf("invalid patched body:\ngot  %s\nwant %s", str, want)
+	}
+}
+
+func TestDockerCreateConfigRelocatedFields(t *testing.T) {
+	tests := []struct {
+		request string
+		want    string
+	}{
+		{"/v1.9/containers/create", `{"Image":"fooandbar","Memory":1024,"Dns":["1.2.3.4"]}`},
+		{"/v1.17/containers/create", `{"Image":"fooandbar","Memory":1024,"HostConfig":{"Dns":["1.2.3.4"]}}`},
+		{"/v1.20/containers/create", `{"Image":"fooandbar","HostConfig":{"Dns":["1.2.3.4"],"Memory":1024}}`},
+		{"/containers/create", `{"Image":"fooandbar","HostConfig":{"Dns":["1.2.3.4"],"Memory":1024}}`},
+	}
+	for _, tt := range tests {
+		cc := unmarshalTestCreateBody(t, tt.request, `{"Image":"fooandbar"}`)
+		cc.Memory = 1024
+		cc.HostConfig = &d.HostConfig{DNS: []string{"1.2.3.4"}}
+		str, err := cc.Marshal2JSONStr()
+		if err != nil {
+			t.Fatal("invalid CreateConfig:", err)
+		}
+		if str != tt.want {
+			t.Errorf("invalid patched body for %s:\ngot  %s\nwant %s", tt.request, str, tt.want)
+		}
+	}
+}
+
+func TestParseDockerAPIVersion(t *testing.T) {
+	tests := []struct {
+		request string
+		want    DockerAPIVersion
+	}{
+		{"/v1.20/containers/create?name=foo", DockerAPIVersion{1, 20}},
+		{"/containers/create", DockerAPIVersion{}},
+		{"/v1/containers/create", DockerAPIVersion{}},
+	}
+	for _, tt := range tests {
+		if got := ParseDockerAPIVersion(tt.request); got != tt.want {
+			t.Errorf("invalid version for %s:\ngot  %s\nwant %s", tt.request, got, tt.want)
+		}
+	}
+	if !(DockerAPIVersion{1, 18}).AtLeast(1, 18) || (DockerAPIVersion{1, 17}).AtLeast(1, 18) || !(DockerAPIVersion{}).AtLeast(1, 18) {
+		t.Errorf("invalid version comparison")
+	}
+}
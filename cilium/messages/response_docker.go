@@ -0,0 +1,27 @@
+package messages
+
+import (
+	"encoding/json"
+)
+
+const (
+	// DockerWarningHeader is the HTTP header where the warnings of a request
+	// are returned to docker clients.
+	DockerWarningHeader = "X-Cilium-Warning"
+)
+
+// DockerError returns the content type and the body of an error response
+// with the given message as docker returns them in the given API version.
+// Since API 1.24 errors are json objects, before they were plain text.
+func DockerError(version DockerAPIVersion, message string) (string, string) {
+	if !version.AtLeast(1, 24) {
+		return "text/plain; charset=utf-8", message + "\n"
+	}
+	data, err := json.Marshal(struct {
+		Message string `json:"message"`
+	}{message})
+	if err != nil {
+		return "text/plain; charset=utf-8", message + "\n"
+	}
+	return "application/json", string(data)
+}
@@ -0,0 +1,23 @@
+package messages
+
+import (
+	"testing"
+)
+
+func TestDockerError(t *testing.T) {
+	tests := []struct {
+		version     DockerAPIVersion
+		contentType string
+		body        string
+	}{
+		{DockerAPIVersion{1, 20}, "text/plain; charset=utf-8", "denied\n"},
+		{DockerAPIVersion{1, 24}, "application/json", `{"message":"denied"}`},
+		{DockerAPIVersion{}, "application/json", `{"message":"denied"}`},
+	}
+	for _, tt := range tests {
+		contentType, body := DockerError(tt.version, "denied")
+		if contentType != tt.contentType || body != tt.body {
+			t.Errorf("invalid error for version %s:\ngot  %s %q\nwant %s %q", tt.version, contentType, body, tt.contentType, tt.body)
+		}
+	}
+}
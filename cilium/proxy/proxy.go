@@ -0,0 +1,509 @@
+// Package proxy implements a Docker API proxy that runs cilium's pre and post
+// hooks, in-process, on the requests cilium is interested in and streams all
+// remaining requests, unmodified, to the docker daemon.
+package proxy
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	h "github.com/cilium-team/cilium/cilium/hook"
+	"github.com/cilium-team/cilium/cilium/hook/posthook"
+	"github.com/cilium-team/cilium/cilium/hook/prehook"
+	m "github.com/cilium-team/cilium/cilium/messages"
+	upr "github.com/cilium-team/cilium/cilium/utils/profile/runnables"
+
+	"github.com/cilium-team/cilium/Godeps/_workspace/src/github.com/op/go-logging"
+)
+
+var log = logging.MustGetLogger("cilium")
+
+// flushInterval is how often the responses streamed from the docker daemon,
+// e.g. logs, events and stats, are flushed to the clients.
+const flushInterval = 100 * time.Millisecond
+
+// Route is a docker API endpoint that should go through the pre and/or post
+// hooks.
+type Route struct {
+	Method string
+	Path   *regexp.Regexp
+	Pre    bool
+	Post   bool
+}
+
+var (
+	// DockerDaemonRoutes are the routes hooked when proxying a docker daemon.
+	DockerDaemonRoutes = []Route{
+		{"POST", regexp.MustCompile(`^(/[^/]+)?/containers/create$`), true, false},
+		{"POST", regexp.MustCompile(`^(/[^/]+)?/containers/[^/]+/(start|restart)$`), false, true},
+	}
+	// DockerSwarmRoutes are the routes hooked when proxying a swarm master.
+	DockerSwarmRoutes = []Route{
+		{"POST", regexp.MustCompile(`^(/[^/]+)?/containers/create$`), true, false},
+	}
+
+	// hijackedPaths are the endpoints where docker takes over the connection
+	// to stream stdin, stdout and stderr in both directions.
+	hijackedPaths = []*regexp.Regexp{
+		regexp.MustCompile(`^(/[^/]+)?/containers/[^/]+/attach$`),
+		regexp.MustCompile(`^(/[^/]+)?/exec/[^/]+/start$`),
+	}
+)
+
+// Proxy is an http.Handler that forwards docker API requests to a docker
+// daemon. Requests matching one of its routes are buffered and processed by
+// the hooks, all other requests are streamed without buffering.
+type Proxy struct {
+	baseAddr  string
+	routes    []Route
+	host      string
+	dial      func() (net.Conn, error)
+	transport *http.Transport
+	stream    *httputil.ReverseProxy
+	getHook   func(string) (h.Hook, error)
+	rollback  func(m.ClientRequest)
+}
+
+// NewProxy returns a Proxy to the docker daemon at the given endpoint, in the
+// DOCKER_HOST format. The baseAddr is the address used by the hooks to match
+// their handlers, the same one that was used by the powerstrip adapters. Like
+// the docker client, tcp endpoints are reached over TLS, with the certificates
+// in DOCKER_CERT_PATH, if it's set.
+func NewProxy(baseAddr, endpoint string, routes []Route) (*Proxy, error) {
+	network, addr, err := parseEndpoint(endpoint)
+	if err != nil {
+		return nil, err
+	}
+	p := &Proxy{
+		baseAddr: baseAddr,
+		routes:   routes,
+		host:     addr,
+		dial: func() (net.Conn, error) {
+			return net.Dial(network, addr)
+		},
+		getHook: h.GetHook,
+	}
+	if certPath := os.Getenv("DOCKER_CERT_PATH"); network == "tcp" && certPath != "" {
+		tlsConfig, err := loadTLSConfig(certPath)
+		if err != nil {
+			return nil, err
+		}
+		if tlsConfig.ServerName, _, err = net.SplitHostPort(addr); err != nil {
+			return nil, err
+		}
+		p.dial = func() (net.Conn, error) {
+			return tls.Dial(network, addr, tlsConfig)
+		}
+	}
+	if network == "unix" {
+		// Any host will do, it's only used in the request's headers.
+		p.host = "docker"
+	}
+	p.transport = &http.Transport{
+		Dial: func(string, string) (net.Conn, error) {
+			return p.dial()
+		},
+		DisableCompression: true,
+	}
+	p.stream = &httputil.ReverseProxy{
+		Director: func(req *http.Request) {
+			req.URL.Scheme = "http"
+			req.URL.Host = p.host
+		},
+		Transport:     badGatewayTransport{p.transport},
+		FlushInterval: flushInterval,
+	}
+	return p, nil
+}
+
+// SetRollback sets the function called with the requests, as modified by the
+// pre hook, that the docker daemon failed, so the resources reserved by the
+// pre hook can be freed right away.
+func (p *Proxy) SetRollback(rollback func(m.ClientRequest)) {
+	p.rollback = rollback
+}
+
+// badGatewayTransport is an http.RoundTripper that replies with a 502 Bad
+// Gateway response when the request can't be sent to the docker daemon.
+type badGatewayTransport struct {
+	http.RoundTripper
+}
+
+func (t badGatewayTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.RoundTripper.RoundTrip(req)
+	if err == nil {
+		return resp, nil
+	}
+	log.Error("Error while proxying %s %s: %s", req.Method, req.URL, err)
+	return &http.Response{
+		Status:     http.StatusText(http.StatusBadGateway),
+		StatusCode: http.StatusBadGateway,
+		Proto:      "HTTP/1.1",
+		ProtoMajor: 1,
+		ProtoMinor: 1,
+		Header:     http.Header{"Content-Type": {"text/plain; charset=utf-8"}},
+		Body:       ioutil.NopCloser(bytes.NewReader([]byte(err.Error()))),
+		Request:    req,
+	}, nil
+}
+
+// loadTLSConfig returns the TLS configuration with the CA, ca.pem, the
+// certificate, cert.pem, and the key, key.pem, in the given directory, as the
+// docker client and daemon expect them.
+func loadTLSConfig(certPath string) (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(filepath.Join(certPath, "cert.pem"), filepath.Join(certPath, "key.pem"))
+	if err != nil {
+		return nil, fmt.Errorf("unable to load the certificate from %s: %s", certPath, err)
+	}
+	ca, err := ioutil.ReadFile(filepath.Join(certPath, "ca.pem"))
+	if err != nil {
+		return nil, fmt.Errorf("unable to load the CA from %s: %s", certPath, err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(ca) {
+		return nil, fmt.Errorf("invalid CA in %s", certPath)
+	}
+	return &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		RootCAs:      pool,
+		ClientCAs:    pool,
+		MinVersion:   tls.VersionTLS12,
+	}, nil
+}
+
+// parseEndpoint returns the network and address of the given endpoint, in the
+// DOCKER_HOST format, e.g. unix:///var/run/docker.sock or tcp://1.2.3.4:2375.
+func parseEndpoint(endpoint string) (string, string, error) {
+	switch {
+	case strings.HasPrefix(endpoint, "unix://"):
+		return "unix", strings.TrimPrefix(endpoint, "unix://"), nil
+	case strings.HasPrefix(endpoint, "tcp://"):
+		return "tcp", strings.TrimPrefix(endpoint, "tcp://"), nil
+	default:
+		return "", "", fmt.Errorf("invalid endpoint %q, it should start with unix:// or tcp://", endpoint)
+	}
+}
+
+// ListenAndServe listens on the given address, in the DOCKER_HOST format, and
+// serves the docker API through the receiver's Proxy. Serving the docker API
+// is as good as root access to the node so tcp addresses are only served over
+// TLS, with the certificates in the given certPath, and to clients with a
+// certificate signed by its CA. Stale unix sockets are removed before
+// listening.
+func (p *Proxy) ListenAndServe(addr, certPath string) error {
+	network, address, err := parseEndpoint(addr)
+	if err != nil {
+		return err
+	}
+	if network == "unix" {
+		if err := removeStaleSocket(address); err != nil {
+			return err
+		}
+	}
+	l, err := net.Listen(network, address)
+	if err != nil {
+		return err
+	}
+	if network == "tcp" {
+		if certPath == "" {
+			l.Close()
+			return fmt.Errorf("%s can't be served without TLS certificates", addr)
+		}
+		tlsConfig, err := loadTLSConfig(certPath)
+		if err != nil {
+			l.Close()
+			return err
+		}
+		tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+		l = tls.NewListener(l, tlsConfig)
+	}
+	log.Info("Docker API proxy listening on %s", addr)
+	return http.Serve(l, p)
+}
+
+// removeStaleSocket removes the unix socket at the given path if no one is
+// listening on it. Any other file is left in place.
+func removeStaleSocket(path string) error {
+	fi, err := os.Lstat(path)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	if fi.Mode()&os.ModeSocket == 0 {
+		return fmt.Errorf("%s already exists and it isn't a socket", path)
+	}
+	if conn, err := net.Dial("unix", path); err == nil {
+		conn.Close()
+		return fmt.Errorf("%s is already in use", path)
+	}
+	return os.Remove(path)
+}
+
+// ServeHTTP forwards the given request to the docker daemon.
+func (p *Proxy) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	log.Debug("%s %s", req.Method, req.URL)
+	if route, ok := p.matchRoute(req); ok {
+		p.serveHooked(w, req, route)
+	} else if isHijacked(req) {
+		p.serveHijacked(w, req)
+	} else {
+		p.stream.ServeHTTP(w, req)
+	}
+}
+
+// matchRoute returns the receiver's route that matches the given request.
+func (p *Proxy) matchRoute(req *http.Request) (Route, bool) {
+	for _, route := range p.routes {
+		if route.Method == req.Method && route.Path.MatchString(req.URL.Path) {
+			return route, true
+		}
+	}
+	return Route{}, false
+}
+
+// isHijacked returns true if docker will take over the connection of the
+// given request.
+func isHijacked(req *http.Request) bool {
+	if req.Header.Get("Upgrade") != "" {
+		return true
+	}
+	for _, path := range hijackedPaths {
+		if path.MatchString(req.URL.Path) {
+			return true
+		}
+	}
+	return false
+}
+
+// serveHijacked sends the given request to the docker daemon and copies, in
+// both directions, everything sent afterwards through the client's and the
+// daemon's connections.
+func (p *Proxy) serveHijacked(w http.ResponseWriter, req *http.Request) {
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		http.Error(w, "Connection can't be hijacked", http.StatusInternalServerError)
+		return
+	}
+	upConn, err := p.dial()
+	if err != nil {
+		log.Error("Error while connecting to docker: %s", err)
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	defer upConn.Close()
+	req.Host = p.host
+	if err := req.Write(upConn); err != nil {
+		log.Error("Error while sending request to docker: %s", err)
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	clientConn, clientBuf, err := hijacker.Hijack()
+	if err != nil {
+		log.Error("Error while hijacking connection: %s", err)
+		return
+	}
+	defer clientConn.Close()
+
+	go func() {
+		io.Copy(upConn, clientBuf)
+		closeWrite(upConn)
+	}()
+	io.Copy(clientConn, upConn)
+}
+
+// closeWrite shuts down the writing side of the given connection, if
+// supported, so the other end knows no more data will be sent.
+func closeWrite(conn net.Conn) {
+	if cw, ok := conn.(interface {
+		CloseWrite() error
+	}); ok {
+		cw.CloseWrite()
+	}
+}
+
+// serveHooked processes the given request through the route's pre hook,
+// forwards it to the docker daemon and processes the daemon's response
+// through the route's post hook.
+func (p *Proxy) serveHooked(w http.ResponseWriter, req *http.Request, route Route) {
+	version := m.ParseDockerAPIVersion(req.URL.Path)
+	body, err := ioutil.ReadAll(req.Body)
+	req.Body.Close()
+	if err != nil {
+		writeError(w, version, http.StatusBadRequest, err)
+		return
+	}
+	clientReq := m.ClientRequest{
+		Method:  req.Method,
+		Request: req.URL.RequestURI(),
+		Body:    string(body),
+	}
+	var warnings []string
+	if route.Pre {
+		if clientReq, warnings, err = p.preHook(clientReq); err != nil {
+			log.Warning("Pre hook: %s", err)
+			writeError(w, version, http.StatusInternalServerError, err)
+			return
+		}
+	}
+
+	upReq, err := http.NewRequest(clientReq.Method, "http://"+p.host+clientReq.Request, strings.NewReader(clientReq.Body))
+	if err != nil {
+		writeError(w, version, http.StatusInternalServerError, err)
+		return
+	}
+	for k, v := range req.Header {
+		if k != "Content-Length" {
+			upReq.Header[k] = v
+		}
+	}
+	resp, err := p.transport.RoundTrip(upReq)
+	if err != nil {
+		log.Error("Error while sending request to docker: %s", err)
+		p.rollbackPreHook(route, clientReq)
+		writeError(w, version, http.StatusBadGateway, err)
+		return
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		p.rollbackPreHook(route, clientReq)
+	}
+
+	// Post hooks only make sense for successful requests.
+	if !route.Post || resp.StatusCode < 200 || resp.StatusCode > 299 {
+		copyHeader(w.Header(), resp.Header)
+		addWarnings(w.Header(), warnings)
+		w.WriteHeader(resp.StatusCode)
+		io.Copy(w, resp.Body)
+		return
+	}
+
+	respBody, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		writeError(w, version, http.StatusBadGateway, err)
+		return
+	}
+	serverResp := m.ServerResponse{
+		ContentType: resp.Header.Get("Content-Type"),
+		Body:        string(respBody),
+		Code:        resp.StatusCode,
+	}
+	serverResp, postWarnings, err := p.postHook(clientReq, serverResp)
+	if err != nil {
+		log.Warning("Post hook: %s", err)
+		writeError(w, version, http.StatusInternalServerError, err)
+		return
+	}
+	copyHeader(w.Header(), resp.Header)
+	addWarnings(w.Header(), append(warnings, postWarnings...))
+	w.Header().Del("Content-Length")
+	if serverResp.ContentType != "" {
+		w.Header().Set("Content-Type", serverResp.ContentType)
+	}
+	w.WriteHeader(serverResp.Code)
+	io.WriteString(w, serverResp.Body)
+}
+
+// rollbackPreHook calls the receiver's rollback, if any, with the given
+// client request if it went through the route's pre hook.
+func (p *Proxy) rollbackPreHook(route Route, clientReq m.ClientRequest) {
+	if route.Pre && p.rollback != nil {
+		p.rollback(clientReq)
+	}
+}
+
+// writeError writes the given error as docker does in the given API version.
+// Denials are written with their own status code, all other errors with the
+// given code.
+func writeError(w http.ResponseWriter, version m.DockerAPIVersion, code int, err error) {
+	if denial, ok := err.(upr.Denial); ok {
+		code = denial.StatusCode()
+	}
+	contentType, body := m.DockerError(version, err.Error())
+	w.Header().Set("Content-Type", contentType)
+	w.WriteHeader(code)
+	io.WriteString(w, body)
+}
+
+// addWarnings adds the given warnings to the given headers.
+func addWarnings(header http.Header, warnings []string) {
+	for _, warning := range warnings {
+		header.Add(m.DockerWarningHeader, warning)
+	}
+}
+
+// copyHeader copies all headers from src to dst.
+func copyHeader(dst, src http.Header) {
+	for k, v := range src {
+		dst[k] = v
+	}
+}
+
+// preHook runs the pre hook on the given client request and returns the
+// modified client request and the warnings for the client.
+func (p *Proxy) preHook(clientReq m.ClientRequest) (m.ClientRequest, []string, error) {
+	var pphreq prehook.PowerstripPreHookRequest
+	pphreq.PowerstripProtocolVersion = m.PowerstripProtocolVersion
+	pphreq.Type = prehook.Type
+	pphreq.ClientRequest = clientReq
+	response, err := p.processRequest(prehook.Type, clientReq.Request, pphreq)
+	if err != nil {
+		return m.ClientRequest{}, nil, err
+	}
+	pphresp, ok := response.GetPowerstripHookResponse().(prehook.PowerstripPreHookResponse)
+	if !ok {
+		return m.ClientRequest{}, nil, fmt.Errorf("unexpected pre hook response %+v", response)
+	}
+	return pphresp.ModifiedClientRequest.ClientRequest, pphresp.Warnings, nil
+}
+
+// postHook runs the post hook on the given client request and server response
+// and returns the modified server response and the warnings for the client.
+func (p *Proxy) postHook(clientReq m.ClientRequest, serverResp m.ServerResponse) (m.ServerResponse, []string, error) {
+	var pphreq posthook.PowerstripPostHookRequest
+	pphreq.PowerstripProtocolVersion = m.PowerstripProtocolVersion
+	pphreq.Type = posthook.Type
+	pphreq.ClientRequest = clientReq
+	pphreq.ServerResponse = serverResp
+	response, err := p.processRequest(posthook.Type, clientReq.Request, pphreq)
+	if err != nil {
+		return m.ServerResponse{}, nil, err
+	}
+	pphresp, ok := response.GetPowerstripHookResponse().(posthook.PowerstripPostHookResponse)
+	if !ok {
+		return m.ServerResponse{}, nil, fmt.Errorf("unexpected post hook response %+v", response)
+	}
+	modified := pphresp.ModifiedServerResponse
+	return m.ServerResponse{
+		ContentType: modified.ContentType,
+		Body:        modified.Body,
+		Code:        modified.Code,
+	}, pphresp.Warnings, nil
+}
+
+// processRequest encodes the given powerstrip request and processes it with
+// the hook of the given type.
+func (p *Proxy) processRequest(hookType, request string, pwReq interface{}) (m.Response, error) {
+	hook, err := p.getHook(hookType)
+	if err != nil {
+		return nil, err
+	}
+	content, err := json.Marshal(pwReq)
+	if err != nil {
+		return nil, err
+	}
+	return hook.ProcessRequest(p.baseAddr, request, content)
+}
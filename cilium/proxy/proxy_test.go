@@ -0,0 +1,448 @@
+package proxy
+
+import (
+	"bufio"
+	"errors"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	h "github.com/cilium-team/cilium/cilium/hook"
+	"github.com/cilium-team/cilium/cilium/hook/posthook"
+	"github.com/cilium-team/cilium/cilium/hook/prehook"
+	m "github.com/cilium-team/cilium/cilium/messages"
+	upr "github.com/cilium-team/cilium/cilium/utils/profile/runnables"
+)
+
+const (
+	testBaseAddr    = "/docker/daemon/cilium-adapter"
+	testContainerID = "6b27a943823d0f735346861bbce6e24acdaf435edb259748be556300d1c361f3"
+)
+
+// fakeDockerDaemon is a docker daemon listening on a unix socket that records
+// the bodies of the create requests it receives.
+type fakeDockerDaemon struct {
+	endpoint    string
+	createBody  chan string
+	logsRelease chan struct{}
+	listener    net.Listener
+	dir         string
+}
+
+func newFakeDockerDaemon(t *testing.T) *fakeDockerDaemon {
+	dir, err := ioutil.TempDir("", "cilium-proxy-test")
+	if err != nil {
+		t.Fatalf("error while creating temporary directory: %s", err)
+	}
+	sock := filepath.Join(dir, "docker.sock")
+	l, err := net.Listen("unix", sock)
+	if err != nil {
+		t.Fatalf("error while listening on %s: %s", sock, err)
+	}
+	f := &fakeDockerDaemon{
+		endpoint:    "unix://" + sock,
+		createBody:  make(chan string, 1),
+		logsRelease: make(chan struct{}),
+		dir:         dir,
+	}
+	mux := http.NewServeMux()
+	create := func(w http.ResponseWriter, req *http.Request) {
+		body, _ := ioutil.ReadAll(req.Body)
+		f.createBody <- string(body)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte(`{"Id":"` + testContainerID + `"}`))
+	}
+	mux.HandleFunc("/v1.20/containers/create", create)
+	mux.HandleFunc("/containers/create", create)
+	mux.HandleFunc("/v1.21/containers/create", func(w http.ResponseWriter, req *http.Request) {
+		http.Error(w, "No such image: busybox", http.StatusNotFound)
+	})
+	mux.HandleFunc("/v1.20/containers/json", func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`[{"Id":"` + testContainerID + `"}]`))
+	})
+	mux.HandleFunc("/v1.20/containers/"+testContainerID+"/start", func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		w.WriteHeader(http.StatusNoContent)
+	})
+	mux.HandleFunc("/v1.20/containers/"+testContainerID+"/logs", func(w http.ResponseWriter, req *http.Request) {
+		w.Write([]byte("first line\n"))
+		w.(http.Flusher).Flush()
+		<-f.logsRelease
+		w.Write([]byte("second line\n"))
+	})
+	mux.HandleFunc("/v1.20/containers/"+testContainerID+"/attach", func(w http.ResponseWriter, req *http.Request) {
+		conn, buf, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		conn.Write([]byte("HTTP/1.1 200 OK\r\nContent-Type: application/vnd.docker.raw-stream\r\n\r\n"))
+		// Echo stdin until it's closed.
+		line, _ := buf.ReadString('\n')
+		conn.Write([]byte("echo: " + line))
+	})
+	f.listener = l
+	go http.Serve(l, mux)
+	return f
+}
+
+func (f *fakeDockerDaemon) Close() {
+	f.listener.Close()
+	os.RemoveAll(f.dir)
+}
+
+// fakeHook is a hook that adds a label to all created containers and replaces
+// the body of the responses of started containers.
+type fakeHook struct {
+	typ      string
+	err      error
+	warnings []string
+}
+
+func (f fakeHook) ProcessRequest(baseAddr string, req string, cont []byte) (m.Response, error) {
+	if f.err != nil {
+		return nil, f.err
+	}
+	if f.typ == prehook.Type {
+		var pphreq prehook.PowerstripPreHookRequest
+		if err := m.DecodeRequest(cont, &pphreq); err != nil {
+			return nil, err
+		}
+		body := strings.Replace(pphreq.ClientRequest.Body, `"Labels":{}`, `"Labels":{"cilium":"yes"}`, 1)
+		response := prehook.NewPowerstripPreHookResponse(pphreq.ClientRequest.Method, pphreq.ClientRequest.Request, body)
+		response.Warnings = f.warnings
+		return response, nil
+	}
+	var pphreq posthook.PowerstripPostHookRequest
+	if err := m.DecodeRequest(cont, &pphreq); err != nil {
+		return nil, err
+	}
+	return posthook.NewPowerstripPostHookResponse("application/json", `{"started":"`+baseAddr+pphreq.ClientRequest.Request+`"}`, http.StatusOK), nil
+}
+
+func newTestProxy(t *testing.T, daemon *fakeDockerDaemon, hookErr error, warnings ...string) *httptest.Server {
+	p, err := NewProxy(testBaseAddr, daemon.endpoint, DockerDaemonRoutes)
+	if err != nil {
+		t.Fatalf("error while creating proxy: %s", err)
+	}
+	p.getHook = func(typ string) (h.Hook, error) {
+		return fakeHook{typ: typ, err: hookErr, warnings: warnings}, nil
+	}
+	return httptest.NewServer(p)
+}
+
+func TestProxyPreHook(t *testing.T) {
+	daemon := newFakeDockerDaemon(t)
+	defer daemon.Close()
+	proxy := newTestProxy(t, daemon, nil)
+	defer proxy.Close()
+
+	resp, err := http.Post(proxy.URL+"/v1.20/containers/create", "application/json", strings.NewReader(`{"Image":"busybox","Labels":{}}`))
+	if err != nil {
+		t.Fatalf("error while creating container: %s", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated {
+		t.Errorf("invalid status code:\ngot  %d\nwant %d", resp.StatusCode, http.StatusCreated)
+	}
+	want := `{"Image":"busybox","Labels":{"cilium":"yes"}}`
+	if got := <-daemon.createBody; got != want {
+		t.Errorf("invalid create body received by docker:\ngot  %s\nwant %s", got, want)
+	}
+}
+
+func TestProxyPreHookUnversioned(t *testing.T) {
+	daemon := newFakeDockerDaemon(t)
+	defer daemon.Close()
+	proxy := newTestProxy(t, daemon, nil)
+	defer proxy.Close()
+
+	resp, err := http.Post(proxy.URL+"/containers/create", "application/json", strings.NewReader(`{"Image":"busybox","Labels":{}}`))
+	if err != nil {
+		t.Fatalf("error while creating container: %s", err)
+	}
+	defer resp.Body.Close()
+	want := `{"Image":"busybox","Labels":{"cilium":"yes"}}`
+	if got := <-daemon.createBody; got != want {
+		t.Errorf("invalid create body received by docker:\ngot  %s\nwant %s", got, want)
+	}
+}
+
+func TestProxyRollback(t *testing.T) {
+	daemon := newFakeDockerDaemon(t)
+	defer daemon.Close()
+	p, err := NewProxy(testBaseAddr, daemon.endpoint, DockerDaemonRoutes)
+	if err != nil {
+		t.Fatalf("error while creating proxy: %s", err)
+	}
+	p.getHook = func(typ string) (h.Hook, error) {
+		return fakeHook{typ: typ}, nil
+	}
+	rolledBack := []string{}
+	p.SetRollback(func(clientReq m.ClientRequest) {
+		rolledBack = append(rolledBack, clientReq.Body)
+	})
+	proxy := httptest.NewServer(p)
+	defer proxy.Close()
+
+	tests := []struct {
+		request string
+		code    int
+		want    []string
+	}{
+		{"/v1.20/containers/create", http.StatusCreated, []string{}},
+		{"/v1.21/containers/create", http.StatusNotFound, []string{`{"Image":"busybox","Labels":{"cilium":"yes"}}`}},
+	}
+	for _, tt := range tests {
+		rolledBack = []string{}
+		resp, err := http.Post(proxy.URL+tt.request, "application/json", strings.NewReader(`{"Image":"busybox","Labels":{}}`))
+		if err != nil {
+			t.Fatalf("error while creating container: %s", err)
+		}
+		resp.Body.Close()
+		if tt.code == http.StatusCreated {
+			<-daemon.createBody
+		}
+		if resp.StatusCode != tt.code {
+			t.Errorf("invalid status code for %s:\ngot  %d\nwant %d", tt.request, resp.StatusCode, tt.code)
+		}
+		if !reflect.DeepEqual(rolledBack, tt.want) {
+			t.Errorf("invalid requests rolled back for %s:\ngot  %s\nwant %s", tt.request, rolledBack, tt.want)
+		}
+	}
+}
+
+func TestProxyPostHook(t *testing.T) {
+	daemon := newFakeDockerDaemon(t)
+	defer daemon.Close()
+	proxy := newTestProxy(t, daemon, nil)
+	defer proxy.Close()
+
+	request := "/v1.20/containers/" + testContainerID + "/start"
+	resp, err := http.Post(proxy.URL+request, "application/json", nil)
+	if err != nil {
+		t.Fatalf("error while starting container: %s", err)
+	}
+	defer resp.Body.Close()
+	body, _ := ioutil.ReadAll(resp.Body)
+	if want := `{"started":"` + testBaseAddr + request + `"}`; string(body) != want {
+		t.Errorf("invalid response body:\ngot  %s\nwant %s", body, want)
+	}
+	if want := "application/json"; resp.Header.Get("Content-Type") != want {
+		t.Errorf("invalid content type:\ngot  %s\nwant %s", resp.Header.Get("Content-Type"), want)
+	}
+}
+
+func TestProxyHookError(t *testing.T) {
+	daemon := newFakeDockerDaemon(t)
+	defer daemon.Close()
+	proxy := newTestProxy(t, daemon, errors.New("denied"))
+	defer proxy.Close()
+
+	resp, err := http.Post(proxy.URL+"/v1.20/containers/create", "application/json", strings.NewReader(`{}`))
+	if err != nil {
+		t.Fatalf("error while creating container: %s", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusInternalServerError {
+		t.Errorf("invalid status code:\ngot  %d\nwant %d", resp.StatusCode, http.StatusInternalServerError)
+	}
+	select {
+	case body := <-daemon.createBody:
+		t.Errorf("docker received a denied request: %s", body)
+	default:
+	}
+}
+
+func TestProxyDenial(t *testing.T) {
+	daemon := newFakeDockerDaemon(t)
+	defer daemon.Close()
+	denial := upr.Denial{Policy: "web", Owner: "root", Reason: "too many containers", Code: http.StatusConflict}
+	proxy := newTestProxy(t, daemon, denial)
+	defer proxy.Close()
+
+	tests := []struct {
+		request string
+		body    string
+	}{
+		{"/v1.20/containers/create", denial.Error() + "\n"},
+		{"/v1.24/containers/create", `{"message":"` + denial.Error() + `"}`},
+	}
+	for _, tt := range tests {
+		resp, err := http.Post(proxy.URL+tt.request, "application/json", strings.NewReader(`{}`))
+		if err != nil {
+			t.Fatalf("error while creating container: %s", err)
+		}
+		body, _ := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusConflict {
+			t.Errorf("invalid status code:\ngot  %d\nwant %d", resp.StatusCode, http.StatusConflict)
+		}
+		if string(body) != tt.body {
+			t.Errorf("invalid response body for %s:\ngot  %s\nwant %s", tt.request, body, tt.body)
+		}
+	}
+}
+
+func TestProxyWarnings(t *testing.T) {
+	daemon := newFakeDockerDaemon(t)
+	defer daemon.Close()
+	proxy := newTestProxy(t, daemon, nil, "first", "second")
+	defer proxy.Close()
+
+	resp, err := http.Post(proxy.URL+"/v1.20/containers/create", "application/json", strings.NewReader(`{}`))
+	if err != nil {
+		t.Fatalf("error while creating container: %s", err)
+	}
+	defer resp.Body.Close()
+	<-daemon.createBody
+	got := resp.Header[http.CanonicalHeaderKey(m.DockerWarningHeader)]
+	if want := []string{"first", "second"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("invalid warnings:\ngot  %s\nwant %s", got, want)
+	}
+}
+
+func TestProxyPassthrough(t *testing.T) {
+	daemon := newFakeDockerDaemon(t)
+	defer daemon.Close()
+	proxy := newTestProxy(t, daemon, errors.New("hooks should not run"))
+	defer proxy.Close()
+
+	resp, err := http.Get(proxy.URL + "/v1.20/containers/json")
+	if err != nil {
+		t.Fatalf("error while listing containers: %s", err)
+	}
+	defer resp.Body.Close()
+	body, _ := ioutil.ReadAll(resp.Body)
+	if want := `[{"Id":"` + testContainerID + `"}]`; string(body) != want {
+		t.Errorf("invalid response body:\ngot  %s\nwant %s", body, want)
+	}
+}
+
+func TestProxyStreaming(t *testing.T) {
+	daemon := newFakeDockerDaemon(t)
+	defer daemon.Close()
+	proxy := newTestProxy(t, daemon, nil)
+	defer proxy.Close()
+
+	resp, err := http.Get(proxy.URL + "/v1.20/containers/" + testContainerID + "/logs")
+	if err != nil {
+		t.Fatalf("error while getting logs: %s", err)
+	}
+	defer resp.Body.Close()
+	r := bufio.NewReader(resp.Body)
+	// The first line must arrive while docker is still writing the logs.
+	if line, err := r.ReadString('\n'); err != nil || line != "first line\n" {
+		t.Errorf("invalid first line:\ngot  %q (%v)\nwant %q", line, err, "first line\n")
+	}
+	close(daemon.logsRelease)
+	if line, err := r.ReadString('\n'); err != nil || line != "second line\n" {
+		t.Errorf("invalid second line:\ngot  %q (%v)\nwant %q", line, err, "second line\n")
+	}
+}
+
+func TestProxyHijack(t *testing.T) {
+	daemon := newFakeDockerDaemon(t)
+	defer daemon.Close()
+	proxy := newTestProxy(t, daemon, nil)
+	defer proxy.Close()
+
+	conn, err := net.Dial("tcp", strings.TrimPrefix(proxy.URL, "http://"))
+	if err != nil {
+		t.Fatalf("error while connecting to proxy: %s", err)
+	}
+	defer conn.Close()
+	conn.Write([]byte("POST /v1.20/containers/" + testContainerID + "/attach?stdin=1&stream=1 HTTP/1.1\r\nHost: docker\r\nContent-Length: 0\r\n\r\n"))
+	r := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(r, nil)
+	if err != nil {
+		t.Fatalf("error while reading attach response: %s", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("invalid status code:\ngot  %d\nwant %d", resp.StatusCode, http.StatusOK)
+	}
+	conn.Write([]byte("hello\n"))
+	if line, err := r.ReadString('\n'); err != nil || line != "echo: hello\n" {
+		t.Errorf("invalid attached output:\ngot  %q (%v)\nwant %q", line, err, "echo: hello\n")
+	}
+}
+
+func TestParseEndpoint(t *testing.T) {
+	tests := []struct {
+		endpoint string
+		network  string
+		addr     string
+		err      bool
+	}{
+		{"unix:///var/run/docker.sock", "unix", "/var/run/docker.sock", false},
+		{"tcp://127.0.0.1:2375", "tcp", "127.0.0.1:2375", false},
+		{"127.0.0.1:2375", "", "", true},
+	}
+	for _, tt := range tests {
+		network, addr, err := parseEndpoint(tt.endpoint)
+		if network != tt.network || addr != tt.addr || (err != nil) != tt.err {
+			t.Errorf("invalid endpoint %s:\ngot  %s %s %v\nwant %s %s %t", tt.endpoint, network, addr, err, tt.network, tt.addr, tt.err)
+		}
+	}
+}
+
+func TestProxyBadGateway(t *testing.T) {
+	daemon := newFakeDockerDaemon(t)
+	proxy := newTestProxy(t, daemon, nil)
+	defer proxy.Close()
+	daemon.Close()
+
+	resp, err := http.Get(proxy.URL + "/v1.20/containers/json")
+	if err != nil {
+		t.Fatalf("error while listing containers: %s", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadGateway {
+		t.Errorf("invalid status code:\ngot  %d\nwant %d", resp.StatusCode, http.StatusBadGateway)
+	}
+}
+
+func TestListenAndServe(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cilium-proxy-test")
+	if err != nil {
+		t.Fatalf("error while creating temporary directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	p, err := NewProxy(testBaseAddr, "unix://"+filepath.Join(dir, "docker.sock"), DockerDaemonRoutes)
+	if err != nil {
+		t.Fatalf("error while creating proxy: %s", err)
+	}
+
+	file := filepath.Join(dir, "file")
+	if err := ioutil.WriteFile(file, []byte("data"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.ListenAndServe("unix://"+file, ""); err == nil {
+		t.Error("proxy listened on an existing file")
+	}
+	if data, err := ioutil.ReadFile(file); err != nil || string(data) != "data" {
+		t.Errorf("existing file was modified: %q %v", data, err)
+	}
+
+	sock := filepath.Join(dir, "in-use.sock")
+	l, err := net.Listen("unix", sock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	if err := p.ListenAndServe("unix://"+sock, ""); err == nil {
+		t.Error("proxy listened on a socket in use")
+	}
+
+	if err := p.ListenAndServe("tcp://127.0.0.1:0", ""); err == nil {
+		t.Error("proxy served a tcp address without TLS")
+	}
+}
@@ -0,0 +1,119 @@
+package utils
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"sync"
+
+	upsi "github.com/cilium-team/cilium/cilium/utils/profile/subpolicies/intent"
+)
+
+const (
+	// DefaultBridge is the bridge, created by backend/setup.sh, of the
+	// endpoints whose intent doesn't request one.
+	DefaultBridge = "lxc-br0"
+	// TunnelPort is the VXLAN UDP port of the default bridge's tunnel, the
+	// other bridges use the following ports in the order they are managed.
+	TunnelPort = 4789
+)
+
+var (
+	bridgeName = regexp.MustCompile(`^[a-zA-Z0-9_.-]{1,15}$`)
+
+	// bridges are the bridges managed by cilium, DefaultBridge is the first.
+	bridges = []string{DefaultBridge}
+	// readyBridges are the bridges that exist on this node.
+	readyBridges = map[string]bool{DefaultBridge: true}
+	bridgesMutex sync.Mutex
+)
+
+// SetBridges sets the bridges, besides DefaultBridge, where intents can attach
+// endpoints. All nodes must manage the same bridges in the same order so they
+// use the same tunnel ports.
+func SetBridges(names []string) error {
+	managed := []string{DefaultBridge}
+	for _, name := range names {
+		if name == "" || name == DefaultBridge {
+			continue
+		}
+		if !bridgeName.MatchString(name) {
+			return fmt.Errorf("invalid bridge name %q", name)
+		}
+		for _, other := range managed {
+			if other == name {
+				return fmt.Errorf("bridge %s is duplicated", name)
+			}
+		}
+		managed = append(managed, name)
+	}
+	bridgesMutex.Lock()
+	defer bridgesMutex.Unlock()
+	bridges = managed
+	return nil
+}
+
+// Bridges returns the bridges managed by cilium, the first is DefaultBridge.
+func Bridges() []string {
+	bridgesMutex.Lock()
+	defer bridgesMutex.Unlock()
+	return append([]string{}, bridges...)
+}
+
+// IsManagedBridge returns true if the bridge with the given name is managed by
+// cilium.
+func IsManagedBridge(name string) bool {
+	return bridgeIndex(name) != -1
+}
+
+func bridgeIndex(name string) int {
+	bridgesMutex.Lock()
+	defer bridgesMutex.Unlock()
+	for i, bridge := range bridges {
+		if bridge == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// BridgeOf returns the bridge requested by netConf, DefaultBridge if it doesn't
+// request one.
+func BridgeOf(netConf upsi.NetConf) string {
+	if netConf.Br == nil || *netConf.Br == "" {
+		return DefaultBridge
+	}
+	return *netConf.Br
+}
+
+// EnsureBridge creates, with the same pipeline as DefaultBridge, the managed
+// bridge with the given name unless it already exists. Each bridge has its own
+// tunnel port, 'vx<index>', on the UDP port TunnelPort+index. The script is
+// set under 'ADD_BRIDGE' environment variable.
+func EnsureBridge(name string) error {
+	index := bridgeIndex(name)
+	if index == -1 {
+		return fmt.Errorf("bridge %s isn't managed by cilium", name)
+	}
+	bridgesMutex.Lock()
+	defer bridgesMutex.Unlock()
+	if readyBridges[name] {
+		return nil
+	}
+	addBridgeCmd := fmt.Sprintf("%s %s vx%d %d", os.Getenv("ADD_BRIDGE"), name, index, TunnelPort+index)
+	if _, err := execShCommand(addBridgeCmd); err != nil {
+		return fmt.Errorf("unable to create bridge %s: %s", name, err)
+	}
+	log.Info("Bridge %s is ready", name)
+	readyBridges[name] = true
+	return nil
+}
+
+// bridgeEnv returns the environment, to prefix the backend scripts with, that
+// makes them act on the given bridge instead of DefaultBridge.
+func bridgeEnv(bridge string) string {
+	if bridge == "" || bridge == DefaultBridge {
+		return ""
+	}
+	return "BRIDGE=" + bridge + " "
+}
@@ -0,0 +1,68 @@
+package utils
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"testing"
+
+	upsi "github.com/cilium-team/cilium/cilium/utils/profile/subpolicies/intent"
+)
+
+func TestSetBridges(t *testing.T) {
+	defer SetBridges(nil)
+	if err := SetBridges([]string{"tenant-a", "", DefaultBridge, "tenant-b"}); err != nil {
+		t.Fatalf("error while setting bridges: %s", err)
+	}
+	if got, want := Bridges(), []string{DefaultBridge, "tenant-a", "tenant-b"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("invalid bridges:\ngot  %v\nwant %v", got, want)
+	}
+	for _, invalid := range [][]string{{"tenant-a", "tenant-a"}, {"br with spaces"}, {"a-bridge-name-too-long"}} {
+		if err := SetBridges(invalid); err == nil {
+			t.Errorf("invalid bridges %v were set", invalid)
+		}
+	}
+
+	br := ""
+	if got := BridgeOf(upsi.NetConf{Br: &br}); got != DefaultBridge {
+		t.Errorf("invalid bridge of net-conf without br:\ngot  %s\nwant %s", got, DefaultBridge)
+	}
+}
+
+func TestEnsureBridge(t *testing.T) {
+	defer SetBridges(nil)
+	if err := SetBridges([]string{"tenant-a", "tenant-b"}); err != nil {
+		t.Fatal(err)
+	}
+	os.Setenv("ADD_BRIDGE", "/opt/backend/add-bridge.sh")
+	cmds := []string{}
+	execShCommand = func(strCmd string) ([]byte, error) {
+		cmds = append(cmds, strCmd)
+		return nil, nil
+	}
+	for _, bridge := range []string{DefaultBridge, "tenant-b", "tenant-b"} {
+		if err := EnsureBridge(bridge); err != nil {
+			t.Errorf("error while ensuring bridge %s: %s", bridge, err)
+		}
+	}
+	if want := []string{"/opt/backend/add-bridge.sh tenant-b vx2 4791"}; !reflect.DeepEqual(cmds, want) {
+		t.Errorf("invalid commands:\ngot  %v\nwant %v", cmds, want)
+	}
+	if err := EnsureBridge("tenant-c"); err == nil {
+		t.Errorf("bridge not managed by cilium was created")
+	}
+
+	// RemoveEndpoint removes the endpoint from every bridge.
+	cmds = []string{}
+	os.Setenv("REMOVE_ENDPOINT", "/opt/backend/remove-endpoint.sh")
+	if err := RemoveEndpoint("1234"); err != nil {
+		t.Fatalf("error while removing endpoint: %s", err)
+	}
+	want := []string{"/opt/backend/remove-endpoint.sh 1234"}
+	for _, bridge := range []string{"tenant-a", "tenant-b"} {
+		want = append(want, fmt.Sprintf("BRIDGE=%s /opt/backend/remove-endpoint.sh 1234", bridge))
+	}
+	if !reflect.DeepEqual(cmds, want) {
+		t.Errorf("invalid commands:\ngot  %v\nwant %v", cmds, want)
+	}
+}
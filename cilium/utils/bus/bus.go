@@ -0,0 +1,173 @@
+// Package bus publishes cilium's events, e.g. IP allocations or denied
+// requests, to the subscribers of a Bus: event streams served over HTTP and
+// outbound webhooks.
+package bus
+
+import (
+	"encoding/json"
+	"os"
+	"sync"
+	"time"
+
+	us "github.com/cilium-team/cilium/cilium/utils/secrets"
+
+	"github.com/cilium-team/cilium/Godeps/_workspace/src/github.com/op/go-logging"
+)
+
+// Event types.
+const (
+	IPAllocated         = "ip-allocated"
+	EndpointCreated     = "endpoint-created"
+	EndpointRemoved     = "endpoint-removed"
+	DNSEntryAdded       = "dns-entry-added"
+	LoadBalancerUpdated = "load-balancer-updated"
+	HostPortsPublished  = "host-ports-published"
+	HostPortConflict    = "host-port-conflict"
+	PolicyApplied       = "policy-applied"
+	RequestDenied       = "request-denied"
+)
+
+// DefaultBuffer is the number of events a subscriber can fall behind before
+// events are dropped for it.
+const DefaultBuffer = 256
+
+var (
+	log = logging.MustGetLogger("cilium")
+
+	defaultBus = NewBus()
+)
+
+// Event is something done by cilium.
+type Event struct {
+	// ID increases with every event published in the same bus.
+	ID   uint64    `json:"id"`
+	Type string    `json:"type"`
+	Time time.Time `json:"time"`
+	// Node is the IP of the node that published the event.
+	Node      string                 `json:"node,omitempty"`
+	Container string                 `json:"container,omitempty"`
+	Data      map[string]interface{} `json:"data,omitempty"`
+}
+
+// marshalEvent returns the json representation of the given event, sent to
+// the subscribers, with the values of secrets redacted.
+func marshalEvent(event Event) ([]byte, error) {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return nil, err
+	}
+	return []byte(us.RedactString(string(data))), nil
+}
+
+// Filter selects events. Empty fields select all events.
+type Filter struct {
+	Types     []string
+	Container string
+}
+
+// Matches returns true if the given event is selected by the receiver's
+// Filter.
+func (f Filter) Matches(event Event) bool {
+	if f.Container != "" && f.Container != event.Container {
+		return false
+	}
+	if len(f.Types) == 0 {
+		return true
+	}
+	for _, typ := range f.Types {
+		if typ == event.Type {
+			return true
+		}
+	}
+	return false
+}
+
+// Subscription receives the events of a Bus selected by its filter.
+type Subscription struct {
+	c       chan Event
+	filter  Filter
+	bus     *Bus
+	dropped uint64
+}
+
+// Events returns the channel where the subscription's events are sent. It's
+// closed once the subscription is closed.
+func (s *Subscription) Events() <-chan Event {
+	return s.c
+}
+
+// Dropped returns the number of events dropped because the subscriber wasn't
+// keeping up.
+func (s *Subscription) Dropped() uint64 {
+	s.bus.mutex.RLock()
+	defer s.bus.mutex.RUnlock()
+	return s.dropped
+}
+
+// Close stops sending events to the subscription.
+func (s *Subscription) Close() {
+	s.bus.mutex.Lock()
+	defer s.bus.mutex.Unlock()
+	if _, ok := s.bus.subscriptions[s]; ok {
+		delete(s.bus.subscriptions, s)
+		close(s.c)
+	}
+}
+
+// Bus sends the events published in it to its subscriptions.
+type Bus struct {
+	mutex         sync.RWMutex
+	lastID        uint64
+	subscriptions map[*Subscription]bool
+}
+
+func NewBus() *Bus {
+	return &Bus{subscriptions: map[*Subscription]bool{}}
+}
+
+// Subscribe returns a subscription to the events selected by filter that can
+// fall behind up to buffer events.
+func (b *Bus) Subscribe(filter Filter, buffer int) *Subscription {
+	s := &Subscription{c: make(chan Event, buffer), filter: filter, bus: b}
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.subscriptions[s] = true
+	return s
+}
+
+// Publish sends the given event to all subscriptions that select it. Publish
+// never blocks, events are dropped for subscriptions that fall behind.
+func (b *Bus) Publish(event Event) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.lastID++
+	event.ID = b.lastID
+	if event.Time.IsZero() {
+		event.Time = time.Now().UTC()
+	}
+	if event.Node == "" {
+		event.Node = os.Getenv("HOST_IP")
+	}
+	for s := range b.subscriptions {
+		if !s.filter.Matches(event) {
+			continue
+		}
+		select {
+		case s.c <- event:
+		default:
+			s.dropped++
+			log.Debug("Dropped event %d for a subscriber falling behind", event.ID)
+		}
+	}
+}
+
+// Default returns the bus where Publish publishes events.
+func Default() *Bus {
+	return defaultBus
+}
+
+// Publish publishes an event of the given type, about the given container,
+// in the default bus.
+func Publish(typ, container string, data map[string]interface{}) {
+	defaultBus.Publish(Event{Type: typ, Container: container, Data: data})
+}
@@ -0,0 +1,209 @@
+package bus
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	up "github.com/cilium-team/cilium/cilium/utils/profile"
+	us "github.com/cilium-team/cilium/cilium/utils/secrets"
+)
+
+func TestBusPublish(t *testing.T) {
+	b := NewBus()
+	all := b.Subscribe(Filter{}, 10)
+	denied := b.Subscribe(Filter{Types: []string{RequestDenied}}, 10)
+	container := b.Subscribe(Filter{Container: "1"}, 1)
+
+	b.Publish(Event{Type: IPAllocated, Container: "1"})
+	b.Publish(Event{Type: RequestDenied, Container: "2"})
+	b.Publish(Event{Type: EndpointCreated, Container: "1"})
+
+	var got []string
+	for len(all.Events()) > 0 {
+		event := <-all.Events()
+		got = append(got, fmt.Sprintf("%d %s %s", event.ID, event.Type, event.Container))
+		if event.Time.IsZero() {
+			t.Errorf("event %d published without time", event.ID)
+		}
+	}
+	want := []string{"1 ip-allocated 1", "2 request-denied 2", "3 endpoint-created 1"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("invalid events:\ngot  %v\nwant %v", got, want)
+	}
+	if event := <-denied.Events(); event.ID != 2 || len(denied.Events()) != 0 {
+		t.Errorf("invalid events selected by type: %+v", event)
+	}
+	if event := <-container.Events(); event.ID != 1 {
+		t.Errorf("invalid event selected by container:\ngot  %d\nwant %d", event.ID, 1)
+	}
+	if container.Dropped() != 1 {
+		t.Errorf("invalid number of dropped events:\ngot  %d\nwant %d", container.Dropped(), 1)
+	}
+
+	all.Close()
+	if _, ok := <-all.Events(); ok {
+		t.Errorf("events of a closed subscription weren't closed")
+	}
+	b.Publish(Event{Type: IPAllocated})
+}
+
+func TestMarshalEventRedacted(t *testing.T) {
+	us.Redact("s3cr3t")
+	data, err := marshalEvent(Event{Type: RequestDenied, Data: map[string]interface{}{"reason": "env A=s3cr3t"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(data), "s3cr3t") {
+		t.Errorf("event has the plaintext secret: %s", data)
+	}
+}
+
+func TestHandlerSSE(t *testing.T) {
+	b := NewBus()
+	server := httptest.NewServer(NewHandler(b))
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "?type=" + IPAllocated + "," + EndpointRemoved)
+	if err != nil {
+		t.Fatalf("error while streaming events: %s", err)
+	}
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Errorf("invalid content type:\ngot  %s\nwant %s", ct, "text/event-stream")
+	}
+	waitSubscribers(t, b, 1)
+	b.Publish(Event{Type: PolicyApplied, Container: "1"})
+	b.Publish(Event{Type: IPAllocated, Container: "1", Data: map[string]interface{}{"ip": "10.0.0.1"}})
+
+	reader := bufio.NewReader(resp.Body)
+	var lines []string
+	for len(lines) < 3 {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			t.Fatalf("error while reading events: %s", err)
+		}
+		lines = append(lines, strings.TrimSpace(line))
+	}
+	if lines[0] != "id: 2" || lines[1] != "event: "+IPAllocated {
+		t.Errorf("invalid event headers: %v", lines[:2])
+	}
+	var event Event
+	if err := json.Unmarshal([]byte(strings.TrimPrefix(lines[2], "data: ")), &event); err != nil {
+		t.Fatalf("error while decoding event %q: %s", lines[2], err)
+	}
+	if event.Container != "1" || event.Data["ip"] != "10.0.0.1" {
+		t.Errorf("invalid event data: %+v", event)
+	}
+}
+
+func TestHandlerWebsocket(t *testing.T) {
+	b := NewBus()
+	server := httptest.NewServer(NewHandler(b))
+	defer server.Close()
+
+	req, _ := http.NewRequest("GET", server.URL+"?container=1", nil)
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Upgrade", "websocket")
+	req.Header.Set("Sec-WebSocket-Version", "13")
+	req.Header.Set("Sec-WebSocket-Key", "dGhlIHNhbXBsZSBub25jZQ==")
+	resp, err := http.DefaultTransport.RoundTrip(req)
+	if err != nil {
+		t.Fatalf("error while opening websocket: %s", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		t.Fatalf("invalid status code:\ngot  %d\nwant %d", resp.StatusCode, http.StatusSwitchingProtocols)
+	}
+	// Example of the websocket's RFC.
+	if accept := resp.Header.Get("Sec-WebSocket-Accept"); accept != "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=" {
+		t.Errorf("invalid accept:\ngot  %s\nwant %s", accept, "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=")
+	}
+	waitSubscribers(t, b, 1)
+	b.Publish(Event{Type: EndpointCreated, Container: "2"})
+	b.Publish(Event{Type: EndpointCreated, Container: "1"})
+
+	opcode, payload, err := readFrame(bufio.NewReader(resp.Body))
+	if err != nil {
+		t.Fatalf("error while reading frame: %s", err)
+	}
+	var event Event
+	if err := json.Unmarshal(payload, &event); err != nil {
+		t.Fatalf("error while decoding event %q: %s", payload, err)
+	}
+	if opcode != opText || event.ID != 2 || event.Container != "1" {
+		t.Errorf("invalid frame %d: %+v", opcode, event)
+	}
+}
+
+// waitSubscribers waits until the given bus has n subscriptions.
+func waitSubscribers(t *testing.T, b *Bus, n int) {
+	for i := 0; i < 500; i++ {
+		b.mutex.RLock()
+		subscriptions := len(b.subscriptions)
+		b.mutex.RUnlock()
+		if subscriptions == n {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("timeout while waiting for %d subscribers", n)
+}
+
+type fakeSubscriptions []up.Subscription
+
+func (fs fakeSubscriptions) GetSubscriptions() ([]up.Subscription, error) {
+	return fs, nil
+}
+
+func TestDispatcherRetries(t *testing.T) {
+	var (
+		mutex    sync.Mutex
+		attempts int
+		got      []string
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		if attempts++; attempts == 1 {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		body, _ := ioutil.ReadAll(r.Body)
+		var event Event
+		json.Unmarshal(body, &event)
+		got = append(got, r.Header.Get(EventTypeHeader)+" "+event.Container)
+	}))
+	defer server.Close()
+
+	d := NewDispatcher(fakeSubscriptions{
+		{Name: "denials", URL: server.URL, Types: []string{RequestDenied}},
+		{Name: "unreachable", URL: "http://127.0.0.1:1", Timeout: "100ms"},
+	}, NewBus())
+	d.Queue().Backoff = time.Millisecond
+	if err := d.Load(); err != nil {
+		t.Fatalf("error while loading subscriptions: %s", err)
+	}
+	d.Dispatch(Event{ID: 1, Type: IPAllocated, Container: "1"})
+	d.Dispatch(Event{ID: 2, Type: RequestDenied, Container: "2"})
+	d.Queue().Stop()
+
+	if want := []string{RequestDenied + " 2"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("invalid posted events:\ngot  %v\nwant %v", got, want)
+	}
+	if attempts != 2 {
+		t.Errorf("invalid number of attempts:\ngot  %d\nwant %d", attempts, 2)
+	}
+	deadLetters := d.DeadLetters()
+	if len(deadLetters) != 2 || deadLetters[0].Key != "unreachable" {
+		t.Errorf("invalid dead letters: %+v", deadLetters)
+	}
+}
@@ -0,0 +1,241 @@
+package bus
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// KeepAliveInterval is the time between the keep alives sent to
+	// streams without events.
+	KeepAliveInterval = 30 * time.Second
+
+	websocketGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+	opText  = 0x1
+	opClose = 0x8
+	opPing  = 0x9
+	opPong  = 0xa
+)
+
+type handler struct {
+	bus *Bus
+}
+
+// NewHandler returns a handler that streams the events of the given bus, as
+// server-sent events or, if the client asks for it, through a websocket.
+// Events can be filtered by the "type", comma separated, and "container"
+// query parameters.
+func NewHandler(bus *Bus) http.Handler {
+	return handler{bus: bus}
+}
+
+// filterFrom returns the filter set by the query of the given request.
+func filterFrom(req *http.Request) Filter {
+	filter := Filter{Container: req.URL.Query().Get("container")}
+	for _, types := range req.URL.Query()["type"] {
+		for _, typ := range strings.Split(types, ",") {
+			if typ = strings.TrimSpace(typ); typ != "" {
+				filter.Types = append(filter.Types, typ)
+			}
+		}
+	}
+	return filter
+}
+
+func (h handler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if strings.EqualFold(req.Header.Get("Upgrade"), "websocket") {
+		h.serveWebsocket(w, req)
+		return
+	}
+	h.serveSSE(w, req)
+}
+
+// serveSSE streams events as server-sent events.
+func (h handler) serveSSE(w http.ResponseWriter, req *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming isn't supported", http.StatusInternalServerError)
+		return
+	}
+	// Without a CloseNotifier, a closed client is noticed on the next write.
+	var closed <-chan bool
+	if notifier, ok := w.(http.CloseNotifier); ok {
+		closed = notifier.CloseNotify()
+	}
+	sub := h.bus.Subscribe(filterFrom(req), DefaultBuffer)
+	defer sub.Close()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+	keepAlive := time.NewTicker(KeepAliveInterval)
+	defer keepAlive.Stop()
+	for {
+		select {
+		case <-closed:
+			return
+		case <-keepAlive.C:
+			if _, err := io.WriteString(w, ": keep-alive\n\n"); err != nil {
+				return
+			}
+		case event := <-sub.Events():
+			data, err := marshalEvent(event)
+			if err != nil {
+				log.Error("Unable to marshal event %d: %s", event.ID, err)
+				continue
+			}
+			if _, err := fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", event.ID, event.Type, data); err != nil {
+				return
+			}
+		}
+		flusher.Flush()
+	}
+}
+
+// serveWebsocket streams events, one per text message, through a websocket.
+// Messages sent by the client are ignored.
+func (h handler) serveWebsocket(w http.ResponseWriter, req *http.Request) {
+	key := req.Header.Get("Sec-WebSocket-Key")
+	if key == "" {
+		http.Error(w, "missing Sec-WebSocket-Key", http.StatusBadRequest)
+		return
+	}
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		http.Error(w, "websockets aren't supported", http.StatusInternalServerError)
+		return
+	}
+	conn, rw, err := hijacker.Hijack()
+	if err != nil {
+		log.Error("Unable to hijack events connection: %s", err)
+		return
+	}
+	defer conn.Close()
+	sub := h.bus.Subscribe(filterFrom(req), DefaultBuffer)
+	defer sub.Close()
+
+	accept := sha1.Sum([]byte(key + websocketGUID))
+	fmt.Fprintf(rw, "HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n"+
+		"Sec-WebSocket-Accept: %s\r\n\r\n", base64.StdEncoding.EncodeToString(accept[:]))
+	if err := rw.Flush(); err != nil {
+		return
+	}
+
+	var writeMutex sync.Mutex
+	write := func(opcode byte, payload []byte) error {
+		writeMutex.Lock()
+		defer writeMutex.Unlock()
+		if err := writeFrame(rw.Writer, opcode, payload); err != nil {
+			return err
+		}
+		return rw.Flush()
+	}
+	closed := make(chan struct{})
+	go func() {
+		defer close(closed)
+		for {
+			opcode, payload, err := readFrame(rw.Reader)
+			if err != nil || opcode == opClose {
+				write(opClose, nil)
+				return
+			}
+			if opcode == opPing {
+				write(opPong, payload)
+			}
+		}
+	}()
+	keepAlive := time.NewTicker(KeepAliveInterval)
+	defer keepAlive.Stop()
+	for {
+		select {
+		case <-closed:
+			return
+		case <-keepAlive.C:
+			if err := write(opPing, nil); err != nil {
+				return
+			}
+		case event := <-sub.Events():
+			data, err := marshalEvent(event)
+			if err != nil {
+				log.Error("Unable to marshal event %d: %s", event.ID, err)
+				continue
+			}
+			if err := write(opText, data); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// writeFrame writes an unmasked, final, websocket frame.
+func writeFrame(w io.Writer, opcode byte, payload []byte) error {
+	header := []byte{0x80 | opcode}
+	switch length := len(payload); {
+	case length < 126:
+		header = append(header, byte(length))
+	case length <= 0xffff:
+		header = append(header, 126, 0, 0)
+		binary.BigEndian.PutUint16(header[2:], uint16(length))
+	default:
+		header = append(header, 127, 0, 0, 0, 0, 0, 0, 0, 0)
+		binary.BigEndian.PutUint64(header[2:], uint64(length))
+	}
+	if _, err := w.Write(header); err != nil {
+		return err
+	}
+	_, err := w.Write(payload)
+	return err
+}
+
+// readFrame reads a websocket frame and returns its opcode and unmasked
+// payload.
+func readFrame(r *bufio.Reader) (byte, []byte, error) {
+	header := make([]byte, 2)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return 0, nil, err
+	}
+	opcode, masked, length := header[0]&0x0f, header[1]&0x80 != 0, uint64(header[1]&0x7f)
+	switch length {
+	case 126:
+		ext := make([]byte, 2)
+		if _, err := io.ReadFull(r, ext); err != nil {
+			return 0, nil, err
+		}
+		length = uint64(binary.BigEndian.Uint16(ext))
+	case 127:
+		ext := make([]byte, 8)
+		if _, err := io.ReadFull(r, ext); err != nil {
+			return 0, nil, err
+		}
+		length = binary.BigEndian.Uint64(ext)
+	}
+	// Clients only send control frames, and close the stream, so big
+	// frames aren't expected.
+	if length > 1<<20 {
+		return 0, nil, fmt.Errorf("websocket frame of %d bytes is too big", length)
+	}
+	mask := make([]byte, 4)
+	if masked {
+		if _, err := io.ReadFull(r, mask); err != nil {
+			return 0, nil, err
+		}
+	}
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return 0, nil, err
+	}
+	for i := range payload {
+		payload[i] ^= mask[i%4]
+	}
+	return opcode, payload, nil
+}
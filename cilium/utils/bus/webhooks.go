@@ -0,0 +1,145 @@
+package bus
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sync"
+	"time"
+
+	up "github.com/cilium-team/cilium/cilium/utils/profile"
+	uw "github.com/cilium-team/cilium/cilium/utils/workqueue"
+)
+
+const (
+	// DefaultRefresh is the time between reloads of the subscriptions.
+	DefaultRefresh = 30 * time.Second
+
+	// EventTypeHeader is the header, set on posted events, with the
+	// event's type.
+	EventTypeHeader = "X-Cilium-Event"
+)
+
+// Subscriptions returns the outbound webhook subscriptions.
+type Subscriptions interface {
+	GetSubscriptions() ([]up.Subscription, error)
+}
+
+// Dispatcher posts the events of a Bus to the subscriptions stored in the
+// database. Events are posted, in order, by subscription and retried with
+// the backoff of the dispatcher's queue.
+type Dispatcher struct {
+	Refresh time.Duration
+
+	store Subscriptions
+	bus   *Bus
+	queue *uw.Queue
+
+	mutex         sync.RWMutex
+	subscriptions []up.Subscription
+}
+
+// NewDispatcher returns a Dispatcher that posts the events of bus to the
+// subscriptions of store.
+func NewDispatcher(store Subscriptions, bus *Bus) *Dispatcher {
+	return &Dispatcher{
+		Refresh: DefaultRefresh,
+		store:   store,
+		bus:     bus,
+		queue:   uw.NewQueue(uw.DefaultWorkers, uw.DefaultCapacity),
+	}
+}
+
+// Queue returns the queue where the dispatcher's posts are run.
+func (d *Dispatcher) Queue() *uw.Queue {
+	return d.queue
+}
+
+// DeadLetters returns the posts that failed all their attempts.
+func (d *Dispatcher) DeadLetters() []uw.DeadLetter {
+	return d.queue.DeadLetters()
+}
+
+// Load reloads the subscriptions from the dispatcher's store.
+func (d *Dispatcher) Load() error {
+	subscriptions, err := d.store.GetSubscriptions()
+	if err != nil {
+		return err
+	}
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	d.subscriptions = subscriptions
+	return nil
+}
+
+// Run posts the bus' events until stop is closed, the subscriptions are
+// reloaded every Refresh.
+func (d *Dispatcher) Run(stop <-chan struct{}) {
+	if err := d.Load(); err != nil {
+		log.Warning("Unable to load event subscriptions: %s", err)
+	}
+	sub := d.bus.Subscribe(Filter{}, DefaultBuffer)
+	defer sub.Close()
+	refresh := time.NewTicker(d.Refresh)
+	defer refresh.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-refresh.C:
+			if err := d.Load(); err != nil {
+				log.Warning("Unable to reload event subscriptions: %s", err)
+			}
+		case event := <-sub.Events():
+			d.Dispatch(event)
+		}
+	}
+}
+
+// Dispatch queues the post of the given event to every subscription that
+// wants it.
+func (d *Dispatcher) Dispatch(event Event) {
+	d.mutex.RLock()
+	defer d.mutex.RUnlock()
+	for _, subscription := range d.subscriptions {
+		if !subscription.Wants(event.Type) {
+			continue
+		}
+		subscription := subscription
+		d.queue.Add(subscription.Name, fmt.Sprintf("%s %d", event.Type, event.ID), func() error {
+			return post(subscription, event)
+		})
+	}
+}
+
+// post posts the given event to the given subscription. Responses without a
+// 2xx status code are errors.
+func post(subscription up.Subscription, event Event) error {
+	data, err := marshalEvent(event)
+	if err != nil {
+		return err
+	}
+	timeout, err := subscription.GetTimeout()
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest("POST", subscription.URL, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(EventTypeHeader, event.Type)
+	client := http.Client{Timeout: timeout}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, resp.Body)
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("subscription %s replied with status %d", subscription.Name, resp.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,183 @@
+package db
+
+import (
+	"encoding/json"
+	"net"
+	"os"
+	"time"
+
+	up "github.com/cilium-team/cilium/cilium/utils/profile"
+	us "github.com/cilium-team/cilium/cilium/utils/secrets"
+)
+
+var (
+	auditActor = "cilium"
+	auditNode  = os.Getenv("HOST_IP")
+)
+
+// SetAuditIdentity sets the actor and node recorded in all audit entries
+// written by this process.
+func SetAuditIdentity(actor, node string) {
+	auditActor = actor
+	auditNode = node
+}
+
+// Audit stores the given entry in the audit table of conn. The timestamp,
+// actor and node are filled, if empty, with the current time and with this
+// process' identity. Failures are only logged so they don't interrupt the
+// operation being audited.
+func Audit(conn Db, entry up.AuditEntry) {
+	if entry.Timestamp.IsZero() {
+		entry.Timestamp = time.Now().UTC()
+	}
+	if entry.Actor == "" {
+		entry.Actor = auditActor
+	}
+	if entry.Node == "" {
+		entry.Node = auditNode
+	}
+	// Requests may have the values of secrets set by policies.
+	if entry.Before != nil {
+		entry.Before = json.RawMessage(us.RedactString(string(entry.Before)))
+	}
+	if entry.After != nil {
+		entry.After = json.RawMessage(us.RedactString(string(entry.After)))
+	}
+	if err := conn.PutAuditEntry(entry); err != nil {
+		log.Warning("Unable to store audit entry %+v: %s", entry, err)
+	}
+}
+
+// toRawJSON returns the json representation of v or nil if it can't be
+// marshalled.
+func toRawJSON(v interface{}) json.RawMessage {
+	data, err := json.Marshal(v)
+	if err != nil {
+		log.Warning("Unable to marshal %+v: %s", v, err)
+		return nil
+	}
+	return data
+}
+
+// AuditedConn is a Db that records, in the audit table, all successful
+// mutations of users, policies, endpoints and IPs.
+type AuditedConn struct {
+	Db
+}
+
+// NewAuditedConn returns conn wrapped in an AuditedConn.
+func NewAuditedConn(conn Db) AuditedConn {
+	return AuditedConn{Db: conn}
+}
+
+func (c AuditedConn) PutUser(userName string) (bool, error) {
+	isNewUser, err := c.Db.PutUser(userName)
+	if err == nil && isNewUser {
+		Audit(c.Db, up.AuditEntry{
+			Action: up.AuditPutUser,
+			Owners: []string{userName},
+			After:  toRawJSON(up.User{Name: userName}),
+		})
+	}
+	return isNewUser, err
+}
+
+func (c AuditedConn) RestoreUser(user up.User) error {
+	if err := c.Db.RestoreUser(user); err != nil {
+		return err
+	}
+	Audit(c.Db, up.AuditEntry{
+		Action: up.AuditPutUser,
+		Owners: []string{user.Name},
+		After:  toRawJSON(user),
+	})
+	return nil
+}
+
+func (c AuditedConn) PutPolicy(policies up.PolicySource) error {
+	before := map[string]up.Policy{}
+	if oldPolicies, err := c.Db.GetPolicies(); err != nil {
+		log.Warning("Unable to get policies before storing them: %s", err)
+	} else {
+		for _, oldPolicySource := range oldPolicies {
+			for _, policy := range oldPolicySource.Policies {
+				before[policy.Name] = policy
+			}
+		}
+	}
+	if err := c.Db.PutPolicy(policies); err != nil {
+		return err
+	}
+	for _, policy := range policies.Policies {
+		entry := up.AuditEntry{
+			Action:   up.AuditPutPolicy,
+			Owners:   []string{policies.Owner},
+			Policies: []string{policy.Name},
+			After:    toRawJSON(policy),
+		}
+		if oldPolicy, ok := before[policy.Name]; ok {
+			entry.Before = toRawJSON(oldPolicy)
+		}
+		Audit(c.Db, entry)
+	}
+	return nil
+}
+
+// getEndpoint returns the stored endpoint of the given container or nil if it
+// doesn't exist.
+func (c AuditedConn) getEndpoint(containerID string) json.RawMessage {
+	endpoint, err := c.Db.GetEndpoint(containerID)
+	if err != nil || endpoint.Container == "" {
+		return nil
+	}
+	return toRawJSON(endpoint)
+}
+
+func (c AuditedConn) PutEndpoint(endpoint up.Endpoint) error {
+	before := c.getEndpoint(endpoint.Container)
+	if err := c.Db.PutEndpoint(endpoint); err != nil {
+		return err
+	}
+	Audit(c.Db, up.AuditEntry{
+		Action:    up.AuditPutEndpoint,
+		Container: endpoint.Container,
+		Before:    before,
+		After:     toRawJSON(endpoint),
+	})
+	return nil
+}
+
+func (c AuditedConn) DeleteEndpoint(containerID string) error {
+	before := c.getEndpoint(containerID)
+	if err := c.Db.DeleteEndpoint(containerID); err != nil {
+		return err
+	}
+	Audit(c.Db, up.AuditEntry{
+		Action:    up.AuditDeleteEndpoint,
+		Container: containerID,
+		Before:    before,
+	})
+	return nil
+}
+
+func (c AuditedConn) PutIP(ip net.IP) error {
+	if err := c.Db.PutIP(ip); err != nil {
+		return err
+	}
+	Audit(c.Db, up.AuditEntry{
+		Action: up.AuditPutIP,
+		After:  toRawJSON(map[string]string{"ip": ip.String()}),
+	})
+	return nil
+}
+
+func (c AuditedConn) DeleteIP(ip net.IP) error {
+	if err := c.Db.DeleteIP(ip); err != nil {
+		return err
+	}
+	Audit(c.Db, up.AuditEntry{
+		Action: up.AuditDeleteIP,
+		Before: toRawJSON(map[string]string{"ip": ip.String()}),
+	})
+	return nil
+}
@@ -0,0 +1,103 @@
+package db
+
+import (
+	"encoding/json"
+	"net"
+	"reflect"
+	"testing"
+
+	up "github.com/cilium-team/cilium/cilium/utils/profile"
+	us "github.com/cilium-team/cilium/cilium/utils/secrets"
+)
+
+func TestAuditedConn(t *testing.T) {
+	SetAuditIdentity("alice", "192.168.50.10")
+	defer SetAuditIdentity("cilium", "")
+	mc := NewMemConn()
+	c := NewAuditedConn(mc)
+
+	if _, err := c.PutUser("root"); err != nil {
+		t.Fatal(err)
+	}
+	// Existing users aren't audited.
+	if _, err := c.PutUser("root"); err != nil {
+		t.Fatal(err)
+	}
+	policySource := up.PolicySource{Owner: "root", Policies: []up.Policy{{Name: "web"}}}
+	if err := c.PutPolicy(policySource); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.PutPolicy(policySource); err != nil {
+		t.Fatal(err)
+	}
+	ip := net.ParseIP("f00d::1")
+	if err := c.PutIP(ip); err != nil {
+		t.Fatal(err)
+	}
+	// Failed mutations aren't audited.
+	if err := c.PutIP(ip); err != ErrIPInUse {
+		t.Fatalf("invalid error:\ngot  %v\nwant %v", err, ErrIPInUse)
+	}
+	endpoint := up.Endpoint{Container: "1234", IPs: up.IPs{ip}}
+	if err := c.PutEndpoint(endpoint); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.DeleteEndpoint(endpoint.Container); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.DeleteIP(ip); err != nil {
+		t.Fatal(err)
+	}
+
+	entries, err := mc.GetAuditEntries(up.AuditFilter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	actions := []string{}
+	for _, entry := range entries {
+		actions = append(actions, entry.Action)
+		if entry.Actor != "alice" || entry.Node != "192.168.50.10" || entry.Timestamp.IsZero() {
+			t.Errorf("invalid identity of entry %+v", entry)
+		}
+	}
+	want := []string{up.AuditPutUser, up.AuditPutPolicy, up.AuditPutPolicy, up.AuditPutIP,
+		up.AuditPutEndpoint, up.AuditDeleteEndpoint, up.AuditDeleteIP}
+	if !reflect.DeepEqual(actions, want) {
+		t.Fatalf("invalid audited actions:\ngot  %s\nwant %s", actions, want)
+	}
+	if entries[1].Before != nil || entries[2].Before == nil {
+		t.Errorf("invalid before values of policies:\ngot  %s and %s", entries[1].Before, entries[2].Before)
+	}
+
+	entries, err = mc.GetAuditEntries(up.AuditFilter{Container: endpoint.Container})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 {
+		t.Errorf("invalid number of entries of container %s:\ngot  %d\nwant %d", endpoint.Container, len(entries), 2)
+	}
+	entries, err = mc.GetAuditEntries(up.AuditFilter{Owner: "root"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 3 {
+		t.Errorf("invalid number of entries of owner %s:\ngot  %d\nwant %d", "root", len(entries), 3)
+	}
+}
+
+func TestAuditRedactsSecrets(t *testing.T) {
+	us.Redact("s3cr3t")
+	c := NewMemConn()
+	Audit(c, up.AuditEntry{
+		Action: up.AuditPreHook,
+		Before: json.RawMessage(`{"Env":["A=plain"]}`),
+		After:  json.RawMessage(`{"Env":["A=s3cr3t"]}`),
+	})
+	entries, err := c.GetAuditEntries(up.AuditFilter{})
+	if err != nil || len(entries) != 1 {
+		t.Fatalf("invalid audit entries:\ngot  %+v, %v\nwant 1 entry", entries, err)
+	}
+	if got, want := string(entries[0].After), `{"Env":["A=`+us.Redacted+`"]}`; got != want {
+		t.Errorf("invalid after:\ngot  %s\nwant %s", got, want)
+	}
+}
@@ -0,0 +1,597 @@
+package db
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"time"
+
+	uc "github.com/cilium-team/cilium/cilium/utils/comm"
+	upl "github.com/cilium-team/cilium/cilium/utils/plugins/loadbalancer"
+	up "github.com/cilium-team/cilium/cilium/utils/profile"
+)
+
+const (
+	// ArchiveVersion is the version of the archive format written by Export.
+	ArchiveVersion = 1
+
+	ImportMerge   = "merge"
+	ImportReplace = "replace"
+	ImportCheck   = "check"
+)
+
+// Archive holds the full state of a cluster in a format that doesn't depend
+// on the database used to store it.
+type Archive struct {
+	Version                int                        `json:"version"`
+	CreatedAt              time.Time                  `json:"created-at"`
+	Users                  []up.User                  `json:"users,omitempty"`
+	Policies               []up.PolicySource          `json:"policies,omitempty"`
+	DNSConfig              *uc.DNSClient              `json:"dns-config,omitempty"`
+	HAProxyConfig          *upl.HAProxyClient         `json:"haproxy-config,omitempty"`
+	Endpoints              []up.Endpoint              `json:"endpoints,omitempty"`
+	IPs                    []net.IP                   `json:"ips-in-use,omitempty"`
+	Nodes                  []up.Node                  `json:"nodes,omitempty"`
+	DockerLinks            []up.ContainerLinks        `json:"docker-links,omitempty"`
+	DockerLinksTemp        []up.ContainerLinks        `json:"docker-links-temp,omitempty"`
+	DockerPortBindings     []up.ContainerPortBindings `json:"docker-port-bindings,omitempty"`
+	DockerPortBindingsTemp []up.ContainerPortBindings `json:"docker-port-bindings-temp,omitempty"`
+	Networks               []up.Network               `json:"networks,omitempty"`
+	Identities             []up.Identity              `json:"identities,omitempty"`
+	Routers                []up.Router                `json:"routers,omitempty"`
+	Egress                 []up.Egress                `json:"egress,omitempty"`
+	Quotas                 []up.Quota                 `json:"quotas,omitempty"`
+	ContainerUsages        []up.ContainerUsage        `json:"container-usages,omitempty"`
+	HostPorts              []up.HostPort              `json:"host-ports,omitempty"`
+	ServiceSlots           []up.ServiceSlots          `json:"service-slots,omitempty"`
+	// Secrets are exported encrypted, they can only be used in clusters
+	// with the same secrets key.
+	Secrets       []up.Secret       `json:"secrets,omitempty"`
+	Subscriptions []up.Subscription `json:"subscriptions,omitempty"`
+}
+
+// Export reads all tables from the given database into an Archive.
+func Export(conn Db) (Archive, error) {
+	var (
+		a   = Archive{Version: ArchiveVersion, CreatedAt: time.Now().UTC()}
+		err error
+	)
+	if a.Users, err = conn.GetUsers(); err != nil {
+		return a, err
+	}
+	up.OrderUsersByAscendingID(a.Users)
+	if a.Policies, err = conn.GetPolicies(); err != nil {
+		return a, err
+	}
+	dnsConfig, err := conn.GetDNSConfig()
+	if err != nil {
+		return a, err
+	}
+	if dnsConfig.IP != "" {
+		a.DNSConfig = &dnsConfig
+	}
+	haProxyConfig, err := conn.GetHAProxyConfig()
+	if err != nil {
+		return a, err
+	}
+	if haProxyConfig.IP != "" {
+		a.HAProxyConfig = &haProxyConfig
+	}
+	if a.Endpoints, err = conn.GetEndpoints(); err != nil {
+		return a, err
+	}
+	if a.IPs, err = conn.GetIPs(); err != nil {
+		return a, err
+	}
+	if a.Nodes, err = conn.GetNodes(); err != nil {
+		return a, err
+	}
+	if a.DockerLinks, err = conn.GetDockerLinks(); err != nil {
+		return a, err
+	}
+	if a.DockerLinksTemp, err = conn.GetDockerLinksTemp(); err != nil {
+		return a, err
+	}
+	if a.DockerPortBindings, err = conn.GetDockerPortBindings(); err != nil {
+		return a, err
+	}
+	if a.DockerPortBindingsTemp, err = conn.GetDockerPortBindingsTemp(); err != nil {
+		return a, err
+	}
+	if a.Networks, err = conn.GetNetworks(); err != nil {
+		return a, err
+	}
+	identities, err := conn.GetIdentities()
+	if err != nil {
+		return a, err
+	}
+	a.Identities = identities.Identities
+	if a.Routers, err = conn.GetRouters(); err != nil {
+		return a, err
+	}
+	if a.Egress, err = conn.GetEgresses(); err != nil {
+		return a, err
+	}
+	if a.Quotas, err = conn.GetQuotas(); err != nil {
+		return a, err
+	}
+	if a.ContainerUsages, err = conn.GetContainerUsages(); err != nil {
+		return a, err
+	}
+	if a.HostPorts, err = conn.GetHostPorts(); err != nil {
+		return a, err
+	}
+	if a.ServiceSlots, err = conn.GetServiceSlots(); err != nil {
+		return a, err
+	}
+	if a.Secrets, err = conn.GetSecrets(); err != nil {
+		return a, err
+	}
+	if a.Subscriptions, err = conn.GetSubscriptions(); err != nil {
+		return a, err
+	}
+	return a, nil
+}
+
+// Import writes all entries of the given Archive into the given database.
+// Entries already present in the database with the same key are overwritten,
+// except networks, identities and service slots, which are merged with the
+// database's ones. Networks and identities fail the import if they conflict
+// while the database's service slots, e.g. live reservations, are kept over
+// the archive's ones. Nothing is written if the archive conflicts with the
+// database, see Archive.Conflicts.
+func Import(conn Db, a Archive) error {
+	errs, err := a.Conflicts(conn)
+	if err != nil {
+		return err
+	}
+	if len(errs) != 0 {
+		return fmt.Errorf("archive conflicts with the database: %s", joinErrors(errs))
+	}
+	ips, err := conn.GetIPs()
+	if err != nil {
+		return err
+	}
+	ipsInUse := map[string]bool{}
+	for _, ip := range ips {
+		ipsInUse[ip.String()] = true
+	}
+	// Users keep their IDs so they keep their priority.
+	for _, user := range a.Users {
+		if err := conn.RestoreUser(user); err != nil {
+			return err
+		}
+	}
+	for _, policySource := range a.Policies {
+		if err := conn.PutPolicy(policySource); err != nil {
+			return err
+		}
+	}
+	if a.DNSConfig != nil {
+		if err := conn.PutDNSConfig(*a.DNSConfig); err != nil {
+			return err
+		}
+	}
+	if a.HAProxyConfig != nil {
+		if err := conn.PutHAProxyConfig(*a.HAProxyConfig); err != nil {
+			return err
+		}
+	}
+	for _, ip := range a.IPs {
+		// IPs already in use belong to the same endpoints, see Conflicts.
+		if ipsInUse[ip.String()] {
+			continue
+		}
+		if err := conn.PutIP(ip); err != nil {
+			return fmt.Errorf("unable to mark IP %s as in use: %s", ip, err)
+		}
+	}
+	for _, endpoint := range a.Endpoints {
+		if err := conn.PutEndpoint(endpoint); err != nil {
+			return err
+		}
+	}
+	for _, node := range a.Nodes {
+		if err := conn.PutNode(node); err != nil {
+			return err
+		}
+	}
+	for _, links := range a.DockerLinks {
+		if err := conn.PutDockerLinksOfContainer(links); err != nil {
+			return err
+		}
+	}
+	for _, links := range a.DockerLinksTemp {
+		if err := conn.PutDockerLinksOfContainerTemp(links); err != nil {
+			return err
+		}
+	}
+	for _, portBindings := range a.DockerPortBindings {
+		if err := conn.PutDockerPortBindingsOfContainer(portBindings); err != nil {
+			return err
+		}
+	}
+	for _, portBindings := range a.DockerPortBindingsTemp {
+		if err := conn.PutDockerPortBindingsOfContainerTemp(portBindings); err != nil {
+			return err
+		}
+	}
+	if len(a.Networks) != 0 {
+		if err := conn.UpdateNetworks(func(networks *up.Networks) error {
+			for _, network := range a.Networks {
+				if err := networks.Merge(network); err != nil {
+					return err
+				}
+			}
+			return nil
+		}); err != nil {
+			return err
+		}
+	}
+	if len(a.Identities) != 0 {
+		if err := conn.UpdateIdentities(func(identities *up.Identities) error {
+			for _, identity := range a.Identities {
+				if err := identities.Merge(identity); err != nil {
+					return err
+				}
+			}
+			return nil
+		}); err != nil {
+			return err
+		}
+	}
+	for _, router := range a.Routers {
+		if err := conn.PutRouter(router); err != nil {
+			return err
+		}
+	}
+	for _, egress := range a.Egress {
+		if err := conn.PutEgress(egress); err != nil {
+			return err
+		}
+	}
+	for _, quota := range a.Quotas {
+		if err := conn.PutQuota(quota); err != nil {
+			return err
+		}
+	}
+	for _, usage := range a.ContainerUsages {
+		if err := conn.PutContainerUsage(usage); err != nil {
+			return err
+		}
+	}
+	for _, hostPort := range a.HostPorts {
+		if err := conn.PutHostPort(hostPort); err != nil {
+			return err
+		}
+	}
+	for _, slots := range a.ServiceSlots {
+		slots := slots
+		if err := conn.UpdateServiceSlots(slots.Service, func(ss *up.ServiceSlots) error {
+			ss.Merge(slots)
+			return nil
+		}); err != nil {
+			return err
+		}
+	}
+	for _, secret := range a.Secrets {
+		if err := conn.PutSecret(secret); err != nil {
+			return err
+		}
+	}
+	for _, subscription := range a.Subscriptions {
+		if err := conn.PutSubscription(subscription); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Check verifies the consistency of the receiver's Archive and returns all
+// inconsistencies found.
+func (a Archive) Check() []error {
+	errs := []error{}
+	if a.Version < 1 || a.Version > ArchiveVersion {
+		errs = append(errs, fmt.Errorf("unsupported archive version %d, we support up to %d", a.Version, ArchiveVersion))
+	}
+
+	userNames := map[string]bool{}
+	userIDs := map[int]bool{}
+	for _, user := range a.Users {
+		if userNames[user.Name] {
+			errs = append(errs, fmt.Errorf("user %q is duplicated", user.Name))
+		}
+		if userIDs[user.ID] {
+			errs = append(errs, fmt.Errorf("user ID %d is duplicated", user.ID))
+		}
+		userNames[user.Name] = true
+		userIDs[user.ID] = true
+	}
+
+	policyNames := map[string]string{}
+	for _, policySource := range a.Policies {
+		if !userNames[policySource.Owner] {
+			errs = append(errs, fmt.Errorf("owner %q of policies doesn't exist", policySource.Owner))
+		}
+		for _, policy := range policySource.Policies {
+			if owner, ok := policyNames[policy.Name]; ok {
+				errs = append(errs, fmt.Errorf("policy %q of owner %q is already defined by owner %q",
+					policy.Name, policySource.Owner, owner))
+			}
+			policyNames[policy.Name] = policySource.Owner
+		}
+	}
+
+	for i, network := range a.Networks {
+		if err := network.Validate(); err != nil {
+			errs = append(errs, err)
+		}
+		for _, other := range a.Networks[:i] {
+			if other.Name == network.Name {
+				errs = append(errs, fmt.Errorf("network %q is duplicated", network.Name))
+			} else if other.BD == network.BD {
+				errs = append(errs, fmt.Errorf("networks %s and %s have the same BD %d", other.Name, network.Name, network.BD))
+			} else if subnet := network.Overlaps(other); subnet != "" {
+				errs = append(errs, fmt.Errorf("subnet %s of network %s overlaps with network %s", subnet, network.Name, other.Name))
+			}
+		}
+	}
+
+	for i, router := range a.Routers {
+		if err := router.Validate(); err != nil {
+			errs = append(errs, err)
+		}
+		for _, other := range a.Routers[:i] {
+			if other.Namespace == router.Namespace {
+				errs = append(errs, fmt.Errorf("router of namespace %d is duplicated", router.Namespace))
+			}
+		}
+	}
+
+	for i, egress := range a.Egress {
+		if err := egress.Validate(); err != nil {
+			errs = append(errs, err)
+		}
+		for _, other := range a.Egress[:i] {
+			if other.Name == egress.Name {
+				errs = append(errs, fmt.Errorf("egress %q is duplicated", egress.Name))
+			}
+		}
+	}
+
+	for _, quota := range a.Quotas {
+		if err := quota.Validate(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	for _, secret := range a.Secrets {
+		if err := secret.Validate(); err != nil {
+			errs = append(errs, err)
+		} else if secret.Plaintext != "" {
+			errs = append(errs, fmt.Errorf("secret %s isn't encrypted", secret.Name))
+		}
+		if !userNames[secret.Owner] {
+			errs = append(errs, fmt.Errorf("owner %q of secret %s doesn't exist", secret.Owner, secret.Name))
+		}
+	}
+
+	for _, subscription := range a.Subscriptions {
+		if err := subscription.Validate(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	ipsInUse := map[string]bool{}
+	for _, ip := range a.IPs {
+		ipsInUse[ip.String()] = true
+	}
+	ipsOwners := map[string]string{}
+	for _, endpoint := range a.Endpoints {
+		if len(endpoint.IPs) != len(endpoint.MACs) {
+			errs = append(errs, fmt.Errorf("endpoint %s has %d IPs but %d MACs",
+				endpoint.Container, len(endpoint.IPs), len(endpoint.MACs)))
+		}
+		for _, ip := range endpoint.IPs {
+			if !ipsInUse[ip.String()] {
+				errs = append(errs, fmt.Errorf("IP %s of endpoint %s isn't marked as in use",
+					ip, endpoint.Container))
+			}
+			if owner, ok := ipsOwners[ip.String()]; ok {
+				errs = append(errs, fmt.Errorf("IP %s is used by endpoints %s and %s",
+					ip, owner, endpoint.Container))
+			}
+			ipsOwners[ip.String()] = endpoint.Container
+		}
+	}
+	for ip := range ipsInUse {
+		if _, ok := ipsOwners[ip]; !ok {
+			log.Warning("IP %s is in use but it doesn't belong to any endpoint", ip)
+		}
+	}
+	return errs
+}
+
+// Conflicts compares the receiver's Archive with the state of the given
+// database and returns all entries that can't be merged into it: users,
+// IPs, endpoints and host ports owned by someone else in the database, and
+// networks and identities that conflict with the database's ones.
+func (a Archive) Conflicts(conn Db) ([]error, error) {
+	errs := []error{}
+
+	users, err := conn.GetUsers()
+	if err != nil {
+		return nil, err
+	}
+	userNames := map[string]int{}
+	userIDs := map[int]string{}
+	for _, user := range users {
+		userNames[user.Name] = user.ID
+		userIDs[user.ID] = user.Name
+	}
+	for _, user := range a.Users {
+		if id, ok := userNames[user.Name]; ok && id != user.ID {
+			errs = append(errs, fmt.Errorf("user %q has ID %d in the database", user.Name, id))
+		} else if name, ok := userIDs[user.ID]; ok && name != user.Name {
+			errs = append(errs, fmt.Errorf("user ID %d of %q belongs to %q in the database", user.ID, user.Name, name))
+		}
+	}
+
+	endpoints, err := conn.GetEndpoints()
+	if err != nil {
+		return nil, err
+	}
+	ipsOwners := map[string]string{}
+	endpointsIPs := map[string]up.IPs{}
+	for _, endpoint := range endpoints {
+		for _, ip := range endpoint.IPs {
+			ipsOwners[ip.String()] = endpoint.Container
+		}
+		endpointsIPs[endpoint.Container] = endpoint.IPs
+	}
+	archiveOwners := map[string]string{}
+	for _, endpoint := range a.Endpoints {
+		for _, ip := range endpoint.IPs {
+			archiveOwners[ip.String()] = endpoint.Container
+		}
+		if ips, ok := endpointsIPs[endpoint.Container]; ok && fmt.Sprint(ips) != fmt.Sprint(endpoint.IPs) {
+			errs = append(errs, fmt.Errorf("endpoint %s has IPs %s in the database", endpoint.Container, ips))
+		}
+	}
+	ips, err := conn.GetIPs()
+	if err != nil {
+		return nil, err
+	}
+	ipsInUse := map[string]bool{}
+	for _, ip := range ips {
+		ipsInUse[ip.String()] = true
+	}
+	for _, ip := range a.IPs {
+		if !ipsInUse[ip.String()] {
+			continue
+		}
+		owner, archiveOwner := ipsOwners[ip.String()], archiveOwners[ip.String()]
+		if owner == "" {
+			owner = "no endpoint"
+		}
+		if archiveOwner == "" {
+			archiveOwner = "no endpoint"
+		}
+		if owner != archiveOwner {
+			errs = append(errs, fmt.Errorf("IP %s of %s is already in use by %s in the database", ip, archiveOwner, owner))
+		}
+	}
+
+	hostPorts, err := conn.GetHostPorts()
+	if err != nil {
+		return nil, err
+	}
+	hostPortsOwners := map[string]string{}
+	for _, hostPort := range hostPorts {
+		hostPortsOwners[hostPort.Key()] = hostPort.Container
+	}
+	for _, hostPort := range a.HostPorts {
+		if owner, ok := hostPortsOwners[hostPort.Key()]; ok && owner != hostPort.Container {
+			errs = append(errs, fmt.Errorf("host port %s of %s is already used by %s in the database",
+				hostPort.Key(), hostPort.Container, owner))
+		}
+	}
+
+	networks, err := conn.GetNetworks()
+	if err != nil {
+		return nil, err
+	}
+	merged := up.Networks{Networks: networks}
+	for _, network := range a.Networks {
+		if err := merged.Merge(network); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	identities, err := conn.GetIdentities()
+	if err != nil {
+		return nil, err
+	}
+	for _, identity := range a.Identities {
+		if err := identities.Merge(identity); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errs, nil
+}
+
+func joinErrors(errs []error) string {
+	errStrs := []string{}
+	for _, err := range errs {
+		errStrs = append(errStrs, err.Error())
+	}
+	return strings.Join(errStrs, "; ")
+}
+
+// WriteArchive writes the given Archive into w.
+func WriteArchive(w io.Writer, a Archive) error {
+	data, err := json.MarshalIndent(a, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(data)
+	return err
+}
+
+// ReadArchive reads an Archive from r.
+func ReadArchive(r io.Reader) (Archive, error) {
+	var a Archive
+	if err := json.NewDecoder(r).Decode(&a); err != nil {
+		return a, err
+	}
+	return a, nil
+}
+
+// ExportDb writes the full state of the database into w.
+func ExportDb(w io.Writer) error {
+	conn, err := NewConn()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	a, err := Export(conn)
+	if err != nil {
+		return err
+	}
+	return WriteArchive(w, a)
+}
+
+// ImportDb reads an archive from r and, if it's consistent, stores it in the
+// database according the given mode:
+// ImportMerge - entries are added to the ones already in the database, if
+// they don't conflict with them.
+// ImportReplace - the database is deleted before storing the entries.
+// ImportCheck - the archive is only checked for consistency.
+func ImportDb(dbType string, r io.Reader, mode string) error {
+	a, err := ReadArchive(r)
+	if err != nil {
+		return err
+	}
+	if errs := a.Check(); len(errs) != 0 {
+		return fmt.Errorf("archive is inconsistent: %s", joinErrors(errs))
+	}
+	switch mode {
+	case ImportCheck:
+		return nil
+	case ImportReplace:
+		if err := InitDb(dbType); err != nil {
+			return err
+		}
+	case ImportMerge:
+	default:
+		return fmt.Errorf("unknown import mode %q", mode)
+	}
+	conn, err := NewConn()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	return Import(conn, a)
+}
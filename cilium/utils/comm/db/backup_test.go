@@ -0,0 +1,271 @@
+package db
+
+import (
+	"bytes"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+
+	uc "github.com/cilium-team/cilium/cilium/utils/comm"
+	up "github.com/cilium-team/cilium/cilium/utils/profile"
+)
+
+func newTestArchive() Archive {
+	return Archive{
+		Version:   ArchiveVersion,
+		CreatedAt: time.Date(2015, 10, 1, 12, 0, 0, 0, time.UTC),
+		Users:     []up.User{{ID: 0, Name: "root"}, {ID: 1, Name: "foo"}},
+		Policies: []up.PolicySource{
+			{Owner: "root", Policies: []up.Policy{{Name: "root-policy", Owner: "root"}}},
+			{Owner: "foo", Policies: []up.Policy{{Name: "foo-policy", Owner: "foo"}}},
+		},
+		DNSConfig: &uc.DNSClient{IP: "192.168.50.1", Port: "80"},
+		Endpoints: []up.Endpoint{
+			{
+				Container: "1234",
+				IPs:       up.IPs{net.ParseIP("f00d::1")},
+				MACs:      up.MACs{"02:00:00:00:00:01"},
+				Node:      "192.168.50.10",
+			},
+		},
+		IPs:   []net.IP{net.ParseIP("f00d::1")},
+		Nodes: []up.Node{{Name: "node1", IP: "192.168.50.10"}},
+		Networks: []up.Network{
+			{Name: "foo-net", Owner: "foo", Subnets: []string{"10.1.0.0/16"}, Isolation: up.IsolationNetwork, BD: 2, Namespace: 2},
+		},
+		Quotas: []up.Quota{{Name: "foo-quota", Owner: "foo", MaxContainers: 10}},
+		ContainerUsages: []up.ContainerUsage{
+			{Container: "1234", Owners: []string{"foo"}, IPs: up.IPs{net.ParseIP("f00d::1")}},
+		},
+		ServiceSlots: []up.ServiceSlots{
+			{Service: "web", Slots: []up.Slot{{Reservation: "5678", Container: "1234"}}},
+		},
+		Secrets:       []up.Secret{{Name: "db/password", Owner: "foo", Ciphertext: "c2VjcmV0"}},
+		Subscriptions: []up.Subscription{{Name: "audit", URL: "https://audit.example.com/cilium"}},
+	}
+}
+
+func TestArchiveCheck(t *testing.T) {
+	a := newTestArchive()
+	if errs := a.Check(); len(errs) != 0 {
+		t.Errorf("invalid errors:\ngot  %s\nwant %s", errs, []error{})
+	}
+
+	a = newTestArchive()
+	a.Version = ArchiveVersion + 1
+	if errs := a.Check(); len(errs) != 1 {
+		t.Errorf("invalid number of errors for an unsupported version:\ngot  %d\nwant %d", len(errs), 1)
+	}
+
+	a = newTestArchive()
+	a.Users = append(a.Users, up.User{ID: 1, Name: "foo"})
+	if errs := a.Check(); len(errs) != 2 {
+		t.Errorf("invalid number of errors for a duplicated user:\ngot  %d\nwant %d", len(errs), 2)
+	}
+
+	a = newTestArchive()
+	a.Policies = append(a.Policies, up.PolicySource{Owner: "bar",
+		Policies: []up.Policy{{Name: "foo-policy", Owner: "bar"}}})
+	if errs := a.Check(); len(errs) != 2 {
+		t.Errorf("invalid number of errors for an unknown owner:\ngot  %d\nwant %d", len(errs), 2)
+	}
+
+	a = newTestArchive()
+	a.Quotas = append(a.Quotas, up.Quota{Name: "bar-quota"})
+	if errs := a.Check(); len(errs) != 1 {
+		t.Errorf("invalid number of errors for a quota without selector:\ngot  %d\nwant %d", len(errs), 1)
+	}
+
+	a = newTestArchive()
+	a.Secrets = append(a.Secrets, up.Secret{Name: "db/user", Owner: "bar", Plaintext: "foo"})
+	if errs := a.Check(); len(errs) != 2 {
+		t.Errorf("invalid number of errors for a plain text secret of an unknown owner:\ngot  %d\nwant %d", len(errs), 2)
+	}
+
+	a = newTestArchive()
+	a.Subscriptions = append(a.Subscriptions, up.Subscription{Name: "bad", URL: "ftp://example.com", Timeout: "soon"})
+	if errs := a.Check(); len(errs) != 1 {
+		t.Errorf("invalid number of errors for an invalid subscription:\ngot  %d\nwant %d", len(errs), 1)
+	}
+
+	a = newTestArchive()
+	a.Networks = append(a.Networks, up.Network{Name: "bar-net", Owner: "foo", Subnets: []string{"10.1.2.0/24"}, BD: 3, Namespace: 3})
+	if errs := a.Check(); len(errs) != 1 {
+		t.Errorf("invalid number of errors for overlapping networks:\ngot  %d\nwant %d", len(errs), 1)
+	}
+
+	a = newTestArchive()
+	a.IPs = []net.IP{}
+	if errs := a.Check(); len(errs) != 1 {
+		t.Errorf("invalid number of errors for an IP not in use:\ngot  %d\nwant %d", len(errs), 1)
+	}
+
+	a = newTestArchive()
+	a.Endpoints = append(a.Endpoints, up.Endpoint{Container: "5678", IPs: up.IPs{net.ParseIP("f00d::1")}})
+	if errs := a.Check(); len(errs) != 2 {
+		t.Errorf("invalid number of errors for a shared IP:\ngot  %d\nwant %d", len(errs), 2)
+	}
+}
+
+func TestArchiveReadWrite(t *testing.T) {
+	want := newTestArchive()
+	var buf bytes.Buffer
+	if err := WriteArchive(&buf, want); err != nil {
+		t.Fatalf("error while writing archive: %s", err)
+	}
+	got, err := ReadArchive(&buf)
+	if err != nil {
+		t.Fatalf("error while reading archive: %s", err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("invalid archive:\ngot  %+v\nwant %+v", got, want)
+	}
+}
+
+func TestImportMergeIdentities(t *testing.T) {
+	web := map[string]string{"com.intent.service": "web"}
+	db := map[string]string{"com.intent.service": "db"}
+	c := NewMemConn()
+	if err := c.UpdateIdentities(func(identities *up.Identities) error {
+		identities.Identities = []up.Identity{{ID: up.FirstIdentityID, Labels: web, Endpoints: []string{"1"}}}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	a := Archive{Version: ArchiveVersion, Identities: []up.Identity{
+		{ID: up.FirstIdentityID + 1, Labels: db, Endpoints: []string{"2"}},
+	}}
+	if err := Import(c, a); err != nil {
+		t.Fatalf("error while importing archive: %s", err)
+	}
+	want := []up.Identity{
+		{ID: up.FirstIdentityID, Labels: web, Endpoints: []string{"1"}},
+		{ID: up.FirstIdentityID + 1, Labels: db, Endpoints: []string{"2"}},
+	}
+	got, err := c.GetIdentities()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got.Identities, want) {
+		t.Errorf("invalid identities:\ngot  %+v\nwant %+v", got.Identities, want)
+	}
+
+	// Running endpoints keep their identities.
+	a.Identities = []up.Identity{{ID: up.FirstIdentityID, Labels: db, Endpoints: []string{"3"}}}
+	if err := Import(c, a); err == nil {
+		t.Error("identity with a conflicting ID was imported")
+	}
+	if got, _ := c.GetIdentities(); !reflect.DeepEqual(got.Identities, want) {
+		t.Errorf("invalid identities after conflict:\ngot  %+v\nwant %+v", got.Identities, want)
+	}
+}
+
+func TestImportMergeServiceSlots(t *testing.T) {
+	c := NewMemConn()
+	if err := c.UpdateServiceSlots("web", func(ss *up.ServiceSlots) error {
+		ss.Slots = []up.Slot{{Reservation: "a", Container: "1"}, {Reservation: "b"}}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	a := Archive{Version: ArchiveVersion, ServiceSlots: []up.ServiceSlots{{
+		Service: "web",
+		Slots:   []up.Slot{{Reservation: "a"}, {Reservation: "c", Container: "1"}, {Reservation: "d", Container: "2"}},
+	}}}
+	if err := Import(c, a); err != nil {
+		t.Fatalf("error while importing archive: %s", err)
+	}
+	want := []up.ServiceSlots{{
+		Service: "web",
+		Slots:   []up.Slot{{Reservation: "a", Container: "1"}, {Reservation: "b"}, {Reservation: "d", Container: "2"}},
+	}}
+	got, err := c.GetServiceSlots()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("invalid service slots:\ngot  %+v\nwant %+v", got, want)
+	}
+}
+
+func TestImportMergeNetworks(t *testing.T) {
+	c := NewMemConn()
+	web := up.Network{Name: "web", Owner: "foo", Subnets: []string{"10.1.0.0/24"}, Isolation: up.IsolationNetwork, BD: 2, Namespace: 2}
+	if err := c.PutNetwork(web); err != nil {
+		t.Fatal(err)
+	}
+
+	db := up.Network{Name: "db", Owner: "foo", Subnets: []string{"10.1.1.0/24"}, Isolation: up.IsolationNetwork, BD: 3, Namespace: 3}
+	a := Archive{Version: ArchiveVersion, Networks: []up.Network{web, db}}
+	if err := Import(c, a); err != nil {
+		t.Fatalf("error while importing archive: %s", err)
+	}
+	want := []up.Network{db, web}
+	got, err := c.GetNetworks()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("invalid networks:\ngot  %+v\nwant %+v", got, want)
+	}
+
+	changed := web
+	changed.Subnets = []string{"10.2.0.0/24"}
+	overlapping := up.Network{Name: "other", Owner: "bar", Subnets: []string{"10.1.0.128/25"}, BD: 4, Namespace: 4}
+	sameBD := up.Network{Name: "other", Owner: "bar", Subnets: []string{"10.3.0.0/24"}, BD: 2, Namespace: 4}
+	sameNamespace := up.Network{Name: "other", Owner: "bar", Subnets: []string{"10.3.0.0/24"}, BD: 4, Namespace: 2}
+	for _, network := range []up.Network{changed, overlapping, sameBD, sameNamespace} {
+		a.Networks = []up.Network{network}
+		if err := Import(c, a); err == nil {
+			t.Errorf("conflicting network %+v was imported", network)
+		}
+	}
+	if got, _ := c.GetNetworks(); !reflect.DeepEqual(got, want) {
+		t.Errorf("invalid networks after conflicts:\ngot  %+v\nwant %+v", got, want)
+	}
+}
+
+func TestImportMergeConflicts(t *testing.T) {
+	c := NewMemConn()
+	if err := Import(c, newTestArchive()); err != nil {
+		t.Fatalf("error while importing archive: %s", err)
+	}
+	// Merging the same archive again doesn't conflict.
+	if err := Import(c, newTestArchive()); err != nil {
+		t.Fatalf("error while importing the same archive: %s", err)
+	}
+
+	a := Archive{Version: ArchiveVersion, Users: []up.User{{ID: 2, Name: "bar"}}}
+	a.IPs = []net.IP{net.ParseIP("f00d::1")}
+	a.Endpoints = []up.Endpoint{{
+		Container: "5678",
+		IPs:       up.IPs{net.ParseIP("f00d::1")},
+		MACs:      up.MACs{"02:00:00:00:00:02"},
+	}}
+	if errs, err := a.Conflicts(c); err != nil {
+		t.Fatal(err)
+	} else if len(errs) != 1 {
+		t.Errorf("invalid number of conflicts:\ngot  %d (%s)\nwant %d", len(errs), errs, 1)
+	}
+	if err := Import(c, a); err == nil {
+		t.Error("endpoint with an IP in use was imported")
+	}
+	// Nothing is written when the archive conflicts.
+	if users, _ := c.GetUsers(); len(users) != 2 {
+		t.Errorf("invalid number of users after conflict:\ngot  %d\nwant %d", len(users), 2)
+	}
+
+	a = Archive{Version: ArchiveVersion,
+		Users:     []up.User{{ID: 1, Name: "bar"}},
+		Endpoints: []up.Endpoint{{Container: "1234", IPs: up.IPs{net.ParseIP("f00d::2")}, MACs: up.MACs{"02:00:00:00:00:01"}}},
+		IPs:       []net.IP{net.ParseIP("f00d::2")},
+	}
+	if errs, err := a.Conflicts(c); err != nil {
+		t.Fatal(err)
+	} else if len(errs) != 2 {
+		t.Errorf("invalid number of conflicts:\ngot  %d (%s)\nwant %d", len(errs), errs, 2)
+	}
+}
@@ -1,6 +1,8 @@
 package db
 
 import (
+	"errors"
+	"fmt"
 	"net"
 
 	uc "github.com/cilium-team/cilium/cilium/utils/comm"
@@ -12,50 +14,136 @@ import (
 
 var log = logging.MustGetLogger("cilium")
 
-const defaultDB = "elastic"
+const (
+	defaultDB = ElasticDB
+	ElasticDB = "elastic"
+	MemoryDB  = "memory"
+)
+
+var (
+	ErrIPInUse = errors.New("IP already in use")
+	// ErrHostPortInUse is returned when a host port is already published
+	// for another container.
+	ErrHostPortInUse = errors.New("host port already in use")
+
+	driver = defaultDB
+	memDb  = NewMemConn()
+)
 
 const (
+	TNAudit                  = "audit"
 	TNDNSconfig              = "dnsconfig"
+	TNEgress                 = "egress"
+	TNHostPorts              = "hostports"
 	TNEndpoint               = "endpoint"
+	TNEndpointHistory        = "endpointhistory"
 	TNHAProxyconfig          = "haproxyconfig"
+	TNIdentities             = "identities"
 	TNIPsinUse               = "ipsinuse"
 	TNLinksConfig            = "dockerlinks"
 	TNLinksConfigTemp        = "dockerlinkstemp"
+	TNNetworks               = "networks"
+	TNNodes                  = "nodes"
 	TNPolicySource           = "policies"
 	TNPortBindingsConfig     = "dockerportbindings"
 	TNPortBindingsConfigTemp = "dockerportbindingstemp"
+	TNQuotas                 = "quotas"
+	TNQuotaReservations      = "quotareservations"
+	TNQuotaUsage             = "quotausage"
+	TNRouters                = "routers"
+	TNSchema                 = "schema"
+	TNSecrets                = "secrets"
+	TNServiceSlots           = "serviceslots"
+	TNSubscriptions          = "subscriptions"
 	TNUsers                  = "users"
 )
 
-func InitDb(dbType string) error {
+// SetDriver selects the database used by NewConn, InitDb and FlushConfig when
+// no database type is given. For MemoryDB, all connections share the same
+// in-memory database which is persisted in file, if file is not empty.
+func SetDriver(dbType, file string) error {
 	switch dbType {
-	case "elastic":
-		return InitElasticDb()
+	case ElasticDB:
+	case MemoryDB:
+		if file != "" {
+			c, err := NewMemConnFile(file)
+			if err != nil {
+				return err
+			}
+			memDb = c
+		}
+	default:
+		return fmt.Errorf("unknown database type %q", dbType)
+	}
+	driver = dbType
+	return nil
+}
+
+func driverOf(dbType string) string {
+	if dbType == "" {
+		return driver
+	}
+	return dbType
+}
+
+func InitDb(dbType string) error {
+	switch driverOf(dbType) {
+	case MemoryDB:
+		return memDb.Flush(append(configTables, stateTables...)...)
 	default:
 		return InitElasticDb()
 	}
 }
 
 func FlushConfig(dbType string) error {
-	switch dbType {
-	case "elastic":
-		return ElasticFlushConfig()
+	switch driverOf(dbType) {
+	case MemoryDB:
+		return memDb.Flush(configTables...)
 	default:
 		return ElasticFlushConfig()
 	}
 }
 
 func NewConn() (Db, error) {
-	return NewElasticConn()
+	switch driver {
+	case MemoryDB:
+		return NewAuditedConn(NewHistoryConn(memDb)), nil
+	default:
+		c, err := NewElasticConn()
+		if err != nil {
+			return nil, err
+		}
+		return NewAuditedConn(NewHistoryConn(c)), nil
+	}
 }
 
 func NewConnTo(driver, ip, port string) (Db, error) {
 	switch driver {
-	case "elastic":
-		return NewElasticConnTo(ip, port)
+	case MemoryDB:
+		return NewAuditedConn(NewHistoryConn(memDb)), nil
 	default:
-		return NewElasticConnTo(ip, port)
+		c, err := NewElasticConnTo(ip, port)
+		if err != nil {
+			return nil, err
+		}
+		return NewAuditedConn(NewHistoryConn(c)), nil
+	}
+}
+
+// checkRestoredUser returns true if the given user is already stored in
+// users or an error if another user in users has its name or ID.
+func checkRestoredUser(user up.User, users []up.User) (bool, error) {
+	for _, other := range users {
+		switch {
+		case other == user:
+			return true, nil
+		case other.Name == user.Name:
+			return false, fmt.Errorf("user %q already exists with ID %d", user.Name, other.ID)
+		case other.ID == user.ID:
+			return false, fmt.Errorf("user ID %d is already used by user %q", user.ID, other.Name)
+		}
 	}
+	return false, nil
 }
 
 type Db interface {
@@ -64,6 +152,7 @@ type Db interface {
 	GetDockerLinksOfContainer(string) (up.ContainerLinks, error)
 	GetDockerLinksOfContainerTemp(string) (up.ContainerLinks, error)
 	GetPoliciesThatCovers(map[string]string) ([]up.PolicySource, error)
+	GetPolicies() ([]up.PolicySource, error)
 	GetUsers() ([]up.User, error)
 	PutDNSConfig(uc.DNSClient) error
 	PutDockerLinksOfContainer(up.ContainerLinks) error
@@ -72,14 +161,89 @@ type Db interface {
 	PutDockerPortBindingsOfContainer(up.ContainerPortBindings) error
 	GetDockerPortBindingsOfContainerTemp(string) (up.ContainerPortBindings, error)
 	GetDockerPortBindingsOfContainer(string) (up.ContainerPortBindings, error)
+	GetDockerLinks() ([]up.ContainerLinks, error)
+	GetDockerLinksTemp() ([]up.ContainerLinks, error)
+	GetDockerPortBindings() ([]up.ContainerPortBindings, error)
+	GetDockerPortBindingsTemp() ([]up.ContainerPortBindings, error)
 	PutUser(userName string) (bool, error)
+	// RestoreUser stores the given user with its ID, unlike PutUser, so it
+	// keeps its priority. Returns an error if another user has the same
+	// name or ID.
+	RestoreUser(up.User) error
 	PutPolicy(up.PolicySource) error
 	PutHAProxyConfig(upl.HAProxyClient) error
 	GetHAProxyConfig() (upl.HAProxyClient, error)
 
 	PutIP(net.IP) error
 	DeleteIP(net.IP) error
+	GetIPs() ([]net.IP, error)
 	PutEndpoint(up.Endpoint) error
 	DeleteEndpoint(string) error
 	GetEndpoint(string) (up.Endpoint, error)
+	GetEndpoints() ([]up.Endpoint, error)
+
+	PutNode(up.Node) error
+	DeleteNode(string) error
+	GetNodes() ([]up.Node, error)
+
+	PutAuditEntry(up.AuditEntry) error
+	GetAuditEntries(up.AuditFilter) ([]up.AuditEntry, error)
+
+	PutEndpointRecord(up.EndpointRecord) error
+	// GetEndpointRecords returns the selected records sorted by timestamp.
+	GetEndpointRecords(up.EndpointRecordFilter) ([]up.EndpointRecord, error)
+	// GetLatestEndpointRecord returns the latest selected record, false if
+	// none is selected.
+	GetLatestEndpointRecord(up.EndpointRecordFilter) (up.EndpointRecord, bool, error)
+
+	// UpdateNetworks atomically reads all networks, applies update to them
+	// and stores the result, like UpdateIdentities.
+	UpdateNetworks(func(*up.Networks) error) error
+	PutNetwork(up.Network) error
+	DeleteNetwork(string) error
+	GetNetwork(string) (up.Network, error)
+	GetNetworks() ([]up.Network, error)
+
+	PutRouter(up.Router) error
+	GetRouters() ([]up.Router, error)
+
+	PutEgress(up.Egress) error
+	DeleteEgress(string) error
+	GetEgresses() ([]up.Egress, error)
+
+	PutQuota(up.Quota) error
+	DeleteQuota(string) error
+	GetQuotas() ([]up.Quota, error)
+	PutHostPort(up.HostPort) error
+	DeleteHostPort(string) error
+	GetHostPorts() ([]up.HostPort, error)
+
+	PutContainerUsage(up.ContainerUsage) error
+	DeleteContainerUsage(string) error
+	GetContainerUsages() ([]up.ContainerUsage, error)
+	// UpdateQuotaReservations atomically reads the quota reservations,
+	// applies update to them, along with the usages of the containers
+	// read afterwards, and stores the result, like UpdateServiceSlots.
+	UpdateQuotaReservations(func(*up.QuotaReservations, []up.ContainerUsage) error) error
+
+	PutSecret(up.Secret) error
+	DeleteSecret(string) error
+	GetSecret(string) (up.Secret, error)
+	GetSecrets() ([]up.Secret, error)
+
+	PutSubscription(up.Subscription) error
+	DeleteSubscription(string) error
+	GetSubscriptions() ([]up.Subscription, error)
+
+	// UpdateServiceSlots atomically reads the slots of the given service,
+	// applies update to them and stores the result. If update returns an
+	// error nothing is stored. update may be called more than once if the
+	// slots are modified concurrently.
+	UpdateServiceSlots(string, func(*up.ServiceSlots) error) error
+	GetServiceSlots() ([]up.ServiceSlots, error)
+
+	// UpdateIdentities atomically reads all identities, applies update to
+	// them and stores the result, like UpdateServiceSlots.
+	UpdateIdentities(func(*up.Identities) error) error
+	GetIdentities() (up.Identities, error)
 }
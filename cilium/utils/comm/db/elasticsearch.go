@@ -1,14 +1,14 @@
 package db
 
 import (
-	"errors"
+	"fmt"
 	l "log"
 	"net"
+	"net/http"
 	"net/url"
 	"os"
 	"reflect"
 	"strconv"
-	"strings"
 	"sync"
 	"time"
 
@@ -28,15 +28,28 @@ const (
 	elasticDefaultIP   = "127.0.0.1"
 	IndexConfig        = "cilium-configs"
 	IndexState         = "cilium-state"
-	logNameTimeFormat  = time.RFC3339
+	// IndexAudit has the audit entries, they are kept when the database is
+	// deleted or replaced by an import.
+	IndexAudit = "cilium-audit"
+	// IndexHistory has the endpoint history, it's kept when the database is
+	// deleted or replaced by an import.
+	IndexHistory = "cilium-history"
+	// IndexSchema has the schema version of the documents stored in the
+	// remaining indexes.
+	IndexSchema       = "cilium-schema"
+	logNameTimeFormat = time.RFC3339
+	// maxSearchResults is the maximum number of hits returned on queries,
+	// all entries of a table are listed with scan.
+	maxSearchResults = 10000
+	// maxUpdateRetries is the number of times a read-modify-write of a
+	// document is retried when the document is modified concurrently.
+	maxUpdateRetries = 10
 )
 
 var (
-	ec          EConn
-	clientInit  sync.Once
-	Indexes     = []string{IndexConfig, IndexState}
-	quotedots   = strings.NewReplacer(`/`, `//`, `.`, `/dot`)
-	unquotedots = strings.NewReplacer(`/dot`, `.`, `//`, `/`)
+	ec         EConn
+	clientInit sync.Once
+	Indexes    = []string{IndexConfig, IndexState, IndexAudit, IndexHistory}
 )
 
 func InitElasticDb() error {
@@ -47,19 +60,20 @@ func InitElasticDb() error {
 	defer c.Close()
 
 	for _, index := range Indexes {
-		if exists, err := c.IndexExists(index).Do(); err != nil {
-			return err
-		} else if exists {
-			if _, err := c.DeleteIndex(index).Do(); err != nil {
+		if index == IndexAudit || index == IndexHistory {
+			// The audit and history indexes are only created, if
+			// missing, so their entries outlive the database.
+			if err := c.reindex(index, nil); err != nil {
 				return err
 			}
+			continue
 		}
-		if _, err = c.CreateIndex(index).Do(); err != nil {
+		if err := c.recreateIndex(index); err != nil {
 			return err
 		}
 	}
 
-	return nil
+	return c.resetSchemaVersion()
 }
 
 func ElasticFlushConfig() error {
@@ -68,18 +82,7 @@ func ElasticFlushConfig() error {
 		return err
 	}
 	defer c.Close()
-	if exists, err := c.IndexExists(IndexConfig).Do(); err != nil {
-		return err
-	} else if exists {
-		if _, err := c.DeleteIndex(IndexConfig).Do(); err != nil {
-			return err
-		}
-	}
-	if _, err = c.CreateIndex(IndexConfig).Do(); err != nil {
-		return err
-	}
-
-	return nil
+	return c.recreateIndex(IndexConfig)
 }
 
 func NewElasticConn() (EConn, error) {
@@ -129,6 +132,7 @@ func NewElasticConnTo(ip, port string) (EConn, error) {
 		)
 		if err == nil {
 			l.Printf("Success!\n")
+			err = ec.Migrate()
 		} else {
 			l.Printf("Error %+v\n", err)
 		}
@@ -153,23 +157,17 @@ func (c EConn) Close() {
 
 func (c EConn) GetUsers() ([]up.User, error) {
 	log.Debug("")
-	searchResult, err := c.Search().Index(IndexConfig).Type(TNUsers).Do()
+	sources, err := c.searchAll(IndexConfig, TNUsers)
 	if err != nil {
 		return nil, err
 	}
 	users := []up.User{}
-	for _, item := range searchResult.Each(reflect.TypeOf(up.User{})) {
-		if u, ok := item.(up.User); ok {
-			uStr, err := u.Value()
-			if err != nil {
-				return nil, err
-			}
-			u = up.User{}
-			if err := u.Scan(unquotedots.Replace(uStr)); err != nil {
-				return nil, err
-			}
-			users = append(users, u)
+	for _, source := range sources {
+		var user up.User
+		if err := user.Scan(source); err != nil {
+			return nil, err
 		}
+		users = append(users, user)
 	}
 	return users, nil
 }
@@ -183,14 +181,6 @@ func (c EConn) GetDNSConfig() (uc.DNSClient, error) {
 	}
 	for _, item := range searchResult.Each(reflect.TypeOf(dnsConfig)) {
 		if dnsConfig, ok := item.(uc.DNSClient); ok {
-			dStr, err := dnsConfig.Value()
-			if err != nil {
-				return dnsConfig, err
-			}
-			dnsConfig = uc.DNSClient{}
-			if err := dnsConfig.Scan(unquotedots.Replace(dStr)); err != nil {
-				return dnsConfig, err
-			}
 			return dnsConfig, nil
 		}
 	}
@@ -206,14 +196,6 @@ func (c EConn) GetHAProxyConfig() (upl.HAProxyClient, error) {
 	}
 	for _, item := range searchResult.Each(reflect.TypeOf(hAProxyClient)) {
 		if hAProxyClient, ok := item.(upl.HAProxyClient); ok {
-			hStr, err := hAProxyClient.Value()
-			if err != nil {
-				return hAProxyClient, err
-			}
-			hAProxyClient = upl.HAProxyClient{}
-			if err := hAProxyClient.Scan(unquotedots.Replace(hStr)); err != nil {
-				return hAProxyClient, err
-			}
 			return hAProxyClient, nil
 		}
 	}
@@ -228,8 +210,8 @@ func (c EConn) GetDockerLinksOfContainerTemp(containerName string) (up.Container
 		return linksConfig, err
 	}
 	if getResult.Found {
-		unquotedSource := unquotedots.Replace(string(*getResult.Source))
-		if err := linksConfig.Scan(unquotedSource); err != nil {
+		source := string(*getResult.Source)
+		if err := linksConfig.Scan(source); err != nil {
 			return linksConfig, err
 		}
 	}
@@ -244,8 +226,8 @@ func (c EConn) GetDockerLinksOfContainer(containerID string) (up.ContainerLinks,
 		return linksConfig, err
 	}
 	if getResult.Found {
-		unquotedSource := unquotedots.Replace(string(*getResult.Source))
-		if err := linksConfig.Scan(unquotedSource); err != nil {
+		source := string(*getResult.Source)
+		if err := linksConfig.Scan(source); err != nil {
 			return linksConfig, err
 		}
 	}
@@ -260,8 +242,8 @@ func (c EConn) GetEndpoint(containerID string) (up.Endpoint, error) {
 		return ipConfig, err
 	}
 	if getResult.Found {
-		unquotedSource := unquotedots.Replace(string(*getResult.Source))
-		if err := ipConfig.Scan(unquotedSource); err != nil {
+		source := string(*getResult.Source)
+		if err := ipConfig.Scan(source); err != nil {
 			return ipConfig, err
 		}
 	}
@@ -276,8 +258,8 @@ func (c EConn) GetDockerPortBindingsOfContainerTemp(containerID string) (up.Cont
 		return portBindings, err
 	}
 	if getResult.Found {
-		unquotedSource := unquotedots.Replace(string(*getResult.Source))
-		if err := portBindings.Scan(unquotedSource); err != nil {
+		source := string(*getResult.Source)
+		if err := portBindings.Scan(source); err != nil {
 			return portBindings, err
 		}
 	}
@@ -292,8 +274,8 @@ func (c EConn) GetDockerPortBindingsOfContainer(containerID string) (up.Containe
 		return portBindings, err
 	}
 	if getResult.Found {
-		unquotedSource := unquotedots.Replace(string(*getResult.Source))
-		if err := portBindings.Scan(unquotedSource); err != nil {
+		source := string(*getResult.Source)
+		if err := portBindings.Scan(source); err != nil {
 			return portBindings, err
 		}
 	}
@@ -315,7 +297,6 @@ func (c EConn) PutUser(userName string) (bool, error) {
 		if err != nil {
 			return false, err
 		}
-		usrStr = quotedots.Replace(usrStr)
 		if _, err := c.Index().Index(IndexConfig).Type(TNUsers).Refresh(true).
 			Id(id).BodyString(usrStr).Do(); err != nil {
 			return isNewUser, err
@@ -324,6 +305,25 @@ func (c EConn) PutUser(userName string) (bool, error) {
 	return isNewUser, nil
 }
 
+func (c EConn) RestoreUser(user up.User) error {
+	log.Debug("user: %+v", user)
+	users, err := c.GetUsers()
+	if err != nil {
+		return err
+	}
+	if stored, err := checkRestoredUser(user, users); err != nil || stored {
+		return err
+	}
+	id := url.QueryEscape(strconv.Itoa(user.ID))
+	usrStr, err := user.Value()
+	if err != nil {
+		return err
+	}
+	_, err = c.Index().Index(IndexConfig).Type(TNUsers).Refresh(true).
+		Id(id).BodyString(usrStr).Do()
+	return err
+}
+
 func (c EConn) PutDNSConfig(dnsConfig uc.DNSClient) error {
 	log.Debug("")
 	id := url.QueryEscape(TNDNSconfig)
@@ -331,7 +331,6 @@ func (c EConn) PutDNSConfig(dnsConfig uc.DNSClient) error {
 	if err != nil {
 		return err
 	}
-	dnsConfigStr = quotedots.Replace(dnsConfigStr)
 	if _, err := c.Index().Index(IndexConfig).Type(TNDNSconfig).Refresh(true).
 		Id(id).BodyString(dnsConfigStr).Do(); err != nil {
 		return err
@@ -346,7 +345,6 @@ func (c EConn) PutHAProxyConfig(haProxyClient upl.HAProxyClient) error {
 	if err != nil {
 		return err
 	}
-	haProxyClientStr = quotedots.Replace(haProxyClientStr)
 	if _, err := c.Index().Index(IndexConfig).Type(TNHAProxyconfig).Refresh(true).
 		Id(id).BodyString(haProxyClientStr).Do(); err != nil {
 		return err
@@ -361,7 +359,6 @@ func (c EConn) PutDockerLinksOfContainer(containerLinks up.ContainerLinks) error
 	if err != nil {
 		return err
 	}
-	containerLinksStr = quotedots.Replace(containerLinksStr)
 	if _, err := c.Index().Index(IndexState).Type(TNLinksConfig).Refresh(true).
 		Id(id).BodyString(containerLinksStr).Do(); err != nil {
 		return err
@@ -376,7 +373,6 @@ func (c EConn) PutDockerLinksOfContainerTemp(containerLinks up.ContainerLinks) e
 	if err != nil {
 		return err
 	}
-	containerLinksStr = quotedots.Replace(containerLinksStr)
 	if _, err := c.Index().Index(IndexState).Type(TNLinksConfigTemp).Refresh(true).
 		Id(id).BodyString(containerLinksStr).Do(); err != nil {
 		return err
@@ -391,7 +387,6 @@ func (c EConn) PutDockerPortBindingsOfContainerTemp(portBindings up.ContainerPor
 	if err != nil {
 		return err
 	}
-	portBindingsStr = quotedots.Replace(portBindingsStr)
 	if _, err := c.Index().Index(IndexState).Type(TNPortBindingsConfigTemp).Refresh(true).
 		Id(id).BodyString(portBindingsStr).Do(); err != nil {
 		return err
@@ -406,7 +401,6 @@ func (c EConn) PutDockerPortBindingsOfContainer(portBindings up.ContainerPortBin
 	if err != nil {
 		return err
 	}
-	portBindingsStr = quotedots.Replace(portBindingsStr)
 	if _, err := c.Index().Index(IndexState).Type(TNPortBindingsConfig).Refresh(true).
 		Id(id).BodyJson(portBindingsStr).Do(); err != nil {
 		return err
@@ -423,14 +417,13 @@ func (c EConn) PutIP(ip net.IP) error {
 	if err != nil {
 		return err
 	}
-	dbIPStr = quotedots.Replace(dbIPStr)
 	result, err := c.Index().Index(IndexState).Type(TNIPsinUse).Refresh(true).
 		Id(id).BodyString(dbIPStr).Do()
 	if err != nil {
 		return err
 	}
 	if !result.Created {
-		return errors.New("IP already in use")
+		return ErrIPInUse
 	}
 	return nil
 }
@@ -452,7 +445,6 @@ func (c EConn) PutEndpoint(endpoint up.Endpoint) error {
 	if err != nil {
 		return err
 	}
-	endpointStr = quotedots.Replace(endpointStr)
 	if _, err := c.Index().Index(IndexState).Type(TNEndpoint).Refresh(true).
 		Id(id).BodyJson(endpointStr).Do(); err != nil {
 		return err
@@ -470,18 +462,237 @@ func (c EConn) DeleteEndpoint(containerID string) error {
 	return nil
 }
 
-func (c EConn) GetPoliciesThatCovers(labels map[string]string) ([]up.PolicySource, error) {
+func (c EConn) GetEndpoints() ([]up.Endpoint, error) {
+	log.Debug("")
+	sources, err := c.searchAll(IndexState, TNEndpoint)
+	if err != nil {
+		return nil, err
+	}
+	endpoints := []up.Endpoint{}
+	for _, source := range sources {
+		var endpoint up.Endpoint
+		if err := endpoint.Scan(source); err != nil {
+			return nil, err
+		}
+		endpoints = append(endpoints, endpoint)
+	}
+	return endpoints, nil
+}
+
+func (c EConn) PutNode(node up.Node) error {
+	log.Debug("Node %+v", node)
+	id := url.QueryEscape(node.IP)
+	nodeStr, err := node.Value()
+	if err != nil {
+		return err
+	}
+	if _, err := c.Index().Index(IndexState).Type(TNNodes).Refresh(true).
+		Id(id).BodyString(nodeStr).Do(); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (c EConn) DeleteNode(nodeIP string) error {
+	log.Debug("nodeIP %+v", nodeIP)
+	id := url.QueryEscape(nodeIP)
+	if _, err := c.Delete().Index(IndexState).Type(TNNodes).Refresh(true).
+		Id(id).Do(); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (c EConn) GetNodes() ([]up.Node, error) {
+	log.Debug("")
+	sources, err := c.searchAll(IndexState, TNNodes)
+	if err != nil {
+		return nil, err
+	}
+	nodes := []up.Node{}
+	for _, source := range sources {
+		var node up.Node
+		if err := node.Scan(source); err != nil {
+			return nil, err
+		}
+		nodes = append(nodes, node)
+	}
+	return nodes, nil
+}
+
+// scan calls f with every document of the given types stored in the given
+// index.
+func (c EConn) scan(index string, types []string, f func(*elastic.SearchHit) error) error {
+	return scroll(c.Scan(index).Type(types...), f)
+}
+
+// scanSorted calls f with every document of the given type stored in the
+// given index and selected by the given query, in ascending order of the
+// given field.
+func (c EConn) scanSorted(index, typ string, query elastic.Query, field string, f func(*elastic.SearchHit) error) error {
+	return scroll(c.Scan(index).Type(typ).Query(query).Sort(field, true), f)
+}
+
+// scroll calls f with every document returned by the given scan.
+func scroll(scan *elastic.ScanService, f func(*elastic.SearchHit) error) error {
+	cursor, err := scan.Size(migrationBatchSize).Do()
+	if err != nil {
+		return err
+	}
+	// Sorted scans return their first documents with the cursor.
+	searchResult := cursor.Results
+	for {
+		if searchResult.Hits != nil {
+			for _, hit := range searchResult.Hits.Hits {
+				if err := f(hit); err != nil {
+					return err
+				}
+			}
+		}
+		searchResult, err = cursor.Next()
+		if err == elastic.EOS {
+			return nil
+		} else if err != nil {
+			return err
+		}
+	}
+}
+
+// searchAll returns the source of all documents of the given type
+// stored in the given index.
+func (c EConn) searchAll(index, typ string) ([]string, error) {
+	sources := []string{}
+	err := c.scan(index, []string{typ}, func(hit *elastic.SearchHit) error {
+		sources = append(sources, string(*hit.Source))
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return sources, nil
+}
+
+func (c EConn) GetIPs() ([]net.IP, error) {
+	log.Debug("")
+	sources, err := c.searchAll(IndexState, TNIPsinUse)
+	if err != nil {
+		return nil, err
+	}
+	ips := []net.IP{}
+	for _, source := range sources {
+		var dbIP up.IP
+		if err := dbIP.Scan(source); err != nil {
+			return nil, err
+		}
+		ips = append(ips, net.IP(dbIP.IPAddress))
+	}
+	return ips, nil
+}
+
+func (c EConn) getContainerLinks(typ string) ([]up.ContainerLinks, error) {
+	sources, err := c.searchAll(IndexState, typ)
+	if err != nil {
+		return nil, err
+	}
+	containersLinks := []up.ContainerLinks{}
+	for _, source := range sources {
+		var containerLinks up.ContainerLinks
+		if err := containerLinks.Scan(source); err != nil {
+			return nil, err
+		}
+		containersLinks = append(containersLinks, containerLinks)
+	}
+	return containersLinks, nil
+}
+
+func (c EConn) GetDockerLinks() ([]up.ContainerLinks, error) {
+	log.Debug("")
+	return c.getContainerLinks(TNLinksConfig)
+}
+
+func (c EConn) GetDockerLinksTemp() ([]up.ContainerLinks, error) {
 	log.Debug("")
+	return c.getContainerLinks(TNLinksConfigTemp)
+}
+
+func (c EConn) getContainerPortBindings(typ string) ([]up.ContainerPortBindings, error) {
+	sources, err := c.searchAll(IndexState, typ)
+	if err != nil {
+		return nil, err
+	}
+	containersPortBindings := []up.ContainerPortBindings{}
+	for _, source := range sources {
+		var portBindings up.ContainerPortBindings
+		if err := portBindings.Scan(source); err != nil {
+			return nil, err
+		}
+		containersPortBindings = append(containersPortBindings, portBindings)
+	}
+	return containersPortBindings, nil
+}
+
+func (c EConn) GetDockerPortBindings() ([]up.ContainerPortBindings, error) {
+	log.Debug("")
+	return c.getContainerPortBindings(TNPortBindingsConfig)
+}
+
+func (c EConn) GetDockerPortBindingsTemp() ([]up.ContainerPortBindings, error) {
+	log.Debug("")
+	return c.getContainerPortBindings(TNPortBindingsConfigTemp)
+}
+
+func (c EConn) GetPolicies() ([]up.PolicySource, error) {
+	log.Debug("")
+	sources, err := c.searchAll(IndexConfig, TNPolicySource)
+	if err != nil {
+		return nil, err
+	}
 	policiesMap := make(map[string]*up.PolicySource)
-	searchResult, err := c.Search().Index(IndexConfig).Type(TNPolicySource).Do()
+	owners := []string{}
+	for _, source := range sources {
+		var dbPolicy up.Policy
+		if err := dbPolicy.Scan(source); err != nil {
+			return nil, err
+		}
+		owner := dbPolicy.Owner
+		if _, ok := policiesMap[owner]; !ok {
+			policiesMap[owner] = &up.PolicySource{Owner: owner}
+			owners = append(owners, owner)
+		}
+		policiesMap[owner].Policies = append(policiesMap[owner].Policies, dbPolicy)
+	}
+	policies := []up.PolicySource{}
+	for _, owner := range owners {
+		policies = append(policies, *policiesMap[owner])
+	}
+	return policies, nil
+}
+
+func (c EConn) GetPoliciesThatCovers(labels map[string]string) ([]up.PolicySource, error) {
+	log.Debug("")
+	var policies []up.PolicySource
+	if len(labels) == 0 {
+		return policies, nil
+	}
+	// Only policies with, at least, one coverage label key present in labels
+	// might cover them, the values are matched afterwards since they are
+	// regular expressions.
+	keys := []interface{}{}
+	for key := range labels {
+		keys = append(keys, key)
+	}
+	query := elastic.NewNestedQuery("coverage-labels",
+		elastic.NewTermsQuery("coverage-labels.key", keys...))
+	searchResult, err := c.Search().Index(IndexConfig).Type(TNPolicySource).
+		Query(query).Size(maxSearchResults).Do()
 	if err != nil {
 		return nil, err
 	}
+	policiesMap := make(map[string]*up.PolicySource)
 	if searchResult.Hits != nil {
 		for _, hit := range searchResult.Hits.Hits {
 			var dbPolicy up.Policy
-			unquotedHit := unquotedots.Replace(string(*hit.Source))
-			if err := dbPolicy.Scan(unquotedHit); err != nil {
+			if err := dbPolicy.Scan(string(*hit.Source)); err != nil {
 				return nil, err
 			}
 			if dbPolicy.Coverage.Covers(labels) {
@@ -493,7 +704,6 @@ func (c EConn) GetPoliciesThatCovers(labels map[string]string) ([]up.PolicySourc
 			}
 		}
 	}
-	var policies []up.PolicySource
 	for _, v := range policiesMap {
 		policies = append(policies, *v)
 	}
@@ -510,11 +720,10 @@ func (c EConn) PutPolicy(policies up.PolicySource) error {
 		// automatically do that for them.
 		policy.KubernetesConfig.ConvertBodyObjTo(&policy.KubernetesConfig.ObjectReference)
 		id := url.QueryEscape(policy.Name)
-		policyStr, err := policy.Value()
+		policyStr, err := newPolicyDoc(policy).Value()
 		if err != nil {
 			return err
 		}
-		policyStr = quotedots.Replace(policyStr)
 		if _, err := c.Index().Index(IndexConfig).Type(TNPolicySource).Refresh(true).
 			Id(id).BodyString(policyStr).Do(); err != nil {
 			return err
@@ -522,3 +731,701 @@ func (c EConn) PutPolicy(policies up.PolicySource) error {
 	}
 	return nil
 }
+
+func (c EConn) PutAuditEntry(entry up.AuditEntry) error {
+	log.Debug("AuditEntry %+v", entry)
+	entryStr, err := entry.Value()
+	if err != nil {
+		return err
+	}
+	// Entries are never modified so Elasticsearch generates their ids.
+	if _, err := c.Index().Index(IndexAudit).Type(TNAudit).Refresh(true).
+		BodyString(entryStr).Do(); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (c EConn) GetAuditEntries(filter up.AuditFilter) ([]up.AuditEntry, error) {
+	log.Debug("filter %+v", filter)
+	query := elastic.NewBoolQuery()
+	if filter.Container != "" {
+		query = query.Filter(elastic.NewTermQuery("container", filter.Container))
+	}
+	if filter.Owner != "" {
+		query = query.Filter(elastic.NewTermQuery("owners", filter.Owner))
+	}
+	if !filter.Since.IsZero() || !filter.Until.IsZero() {
+		timeRange := elastic.NewRangeQuery("timestamp")
+		if !filter.Since.IsZero() {
+			timeRange = timeRange.Gte(filter.Since)
+		}
+		if !filter.Until.IsZero() {
+			timeRange = timeRange.Lte(filter.Until)
+		}
+		query = query.Filter(timeRange)
+	}
+	entries := []up.AuditEntry{}
+	err := c.scanSorted(IndexAudit, TNAudit, query, "timestamp", func(hit *elastic.SearchHit) error {
+		var entry up.AuditEntry
+		if err := entry.Scan(string(*hit.Source)); err != nil {
+			return err
+		}
+		entries = append(entries, entry)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+func (c EConn) PutEndpointRecord(record up.EndpointRecord) error {
+	log.Debug("EndpointRecord %+v", record)
+	recordStr, err := record.Value()
+	if err != nil {
+		return err
+	}
+	// Records are never modified so Elasticsearch generates their ids.
+	if _, err := c.Index().Index(IndexHistory).Type(TNEndpointHistory).Refresh(true).
+		BodyString(recordStr).Do(); err != nil {
+		return err
+	}
+	return nil
+}
+
+// endpointRecordsQuery returns the query selecting the endpoint records
+// selected by the given filter.
+func endpointRecordsQuery(filter up.EndpointRecordFilter) elastic.Query {
+	query := elastic.NewBoolQuery()
+	if filter.Container != "" {
+		query = query.Filter(elastic.NewTermQuery("container", filter.Container))
+	}
+	if filter.IP != nil {
+		query = query.Filter(elastic.NewTermQuery("ips", filter.IP.String()))
+	}
+	if !filter.Since.IsZero() || !filter.Until.IsZero() {
+		timeRange := elastic.NewRangeQuery("timestamp")
+		if !filter.Since.IsZero() {
+			timeRange = timeRange.Gte(filter.Since)
+		}
+		if !filter.Until.IsZero() {
+			timeRange = timeRange.Lte(filter.Until)
+		}
+		query = query.Filter(timeRange)
+	}
+	return query
+}
+
+func (c EConn) GetEndpointRecords(filter up.EndpointRecordFilter) ([]up.EndpointRecord, error) {
+	log.Debug("filter %+v", filter)
+	records := []up.EndpointRecord{}
+	err := c.scanSorted(IndexHistory, TNEndpointHistory, endpointRecordsQuery(filter), "timestamp", func(hit *elastic.SearchHit) error {
+		var record up.EndpointRecord
+		if err := record.Scan(string(*hit.Source)); err != nil {
+			return err
+		}
+		records = append(records, record)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return records, nil
+}
+
+func (c EConn) GetLatestEndpointRecord(filter up.EndpointRecordFilter) (up.EndpointRecord, bool, error) {
+	log.Debug("filter %+v", filter)
+	// A release and an assignment recorded by the same PutEndpoint may have
+	// the same timestamp, the assignment goes first.
+	searchResult, err := c.Search().Index(IndexHistory).Type(TNEndpointHistory).Query(endpointRecordsQuery(filter)).
+		Sort("timestamp", false).Sort("action", true).Size(1).Do()
+	if err != nil {
+		return up.EndpointRecord{}, false, err
+	}
+	var record up.EndpointRecord
+	if searchResult.Hits == nil || len(searchResult.Hits.Hits) == 0 {
+		return record, false, nil
+	}
+	if err := record.Scan(string(*searchResult.Hits.Hits[0].Source)); err != nil {
+		return record, false, err
+	}
+	return record, true, nil
+}
+
+// UpdateNetworks stores all networks in a single document so they are
+// allocated atomically across the cluster.
+func (c EConn) UpdateNetworks(update func(*up.Networks) error) error {
+	log.Debug("")
+	for i := 0; i < maxUpdateRetries; i++ {
+		networks := up.Networks{}
+		getResult, err := c.Get().Index(IndexState).Type(TNNetworks).Id(TNNetworks).Do()
+		if err != nil && !elastic.IsNotFound(err) {
+			return err
+		}
+		found := err == nil && getResult.Found
+		if found {
+			if err := networks.Scan(string(*getResult.Source)); err != nil {
+				return err
+			}
+		}
+		if err := update(&networks); err != nil {
+			return err
+		}
+		networksStr, err := networks.Value()
+		if err != nil {
+			return err
+		}
+		// The version of the document read makes the write fail if another
+		// node modified it in the meantime.
+		index := c.Index().Index(IndexState).Type(TNNetworks).Refresh(true).
+			Id(TNNetworks).BodyString(networksStr)
+		if found {
+			index = index.Version(*getResult.Version)
+		} else {
+			index = index.OpType("create")
+		}
+		if _, err = index.Do(); !isConflict(err) {
+			return err
+		}
+		log.Debug("Networks were modified concurrently, retrying")
+	}
+	return fmt.Errorf("networks were modified concurrently %d times", maxUpdateRetries)
+}
+
+func (c EConn) PutNetwork(network up.Network) error {
+	log.Debug("Network %+v", network)
+	return c.UpdateNetworks(func(networks *up.Networks) error {
+		networks.Put(network)
+		return nil
+	})
+}
+
+func (c EConn) DeleteNetwork(name string) error {
+	log.Debug("name %+v", name)
+	return c.UpdateNetworks(func(networks *up.Networks) error {
+		networks.Delete(name)
+		return nil
+	})
+}
+
+func (c EConn) GetNetwork(name string) (up.Network, error) {
+	log.Debug("name %+v", name)
+	networks, err := c.getNetworks()
+	if err != nil {
+		return up.Network{}, err
+	}
+	network, _ := networks.Get(name)
+	return network, nil
+}
+
+func (c EConn) GetNetworks() ([]up.Network, error) {
+	log.Debug("")
+	networks, err := c.getNetworks()
+	if err != nil {
+		return nil, err
+	}
+	if networks.Networks == nil {
+		return []up.Network{}, nil
+	}
+	return networks.Networks, nil
+}
+
+// getNetworks returns the document with all networks.
+func (c EConn) getNetworks() (up.Networks, error) {
+	networks := up.Networks{}
+	getResult, err := c.Get().Index(IndexState).Type(TNNetworks).Id(TNNetworks).Do()
+	if elastic.IsNotFound(err) {
+		return networks, nil
+	} else if err != nil {
+		return networks, err
+	}
+	if getResult.Found {
+		if err := networks.Scan(string(*getResult.Source)); err != nil {
+			return networks, err
+		}
+	}
+	return networks, nil
+}
+
+func (c EConn) PutRouter(router up.Router) error {
+	log.Debug("Router %+v", router)
+	routerStr, err := router.Value()
+	if err != nil {
+		return err
+	}
+	if _, err := c.Index().Index(IndexConfig).Type(TNRouters).Refresh(true).
+		Id(strconv.Itoa(router.Namespace)).BodyString(routerStr).Do(); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (c EConn) GetRouters() ([]up.Router, error) {
+	log.Debug("")
+	sources, err := c.searchAll(IndexConfig, TNRouters)
+	if err != nil {
+		return nil, err
+	}
+	routers := []up.Router{}
+	for _, source := range sources {
+		var router up.Router
+		if err := router.Scan(source); err != nil {
+			return nil, err
+		}
+		routers = append(routers, router)
+	}
+	return routers, nil
+}
+
+func (c EConn) PutEgress(egress up.Egress) error {
+	log.Debug("Egress %+v", egress)
+	id := url.QueryEscape(egress.Name)
+	egressStr, err := egress.Value()
+	if err != nil {
+		return err
+	}
+	if _, err := c.Index().Index(IndexConfig).Type(TNEgress).Refresh(true).
+		Id(id).BodyString(egressStr).Do(); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (c EConn) DeleteEgress(name string) error {
+	log.Debug("name %+v", name)
+	id := url.QueryEscape(name)
+	if _, err := c.Delete().Index(IndexConfig).Type(TNEgress).Refresh(true).
+		Id(id).Do(); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (c EConn) GetEgresses() ([]up.Egress, error) {
+	log.Debug("")
+	sources, err := c.searchAll(IndexConfig, TNEgress)
+	if err != nil {
+		return nil, err
+	}
+	egresses := []up.Egress{}
+	for _, source := range sources {
+		var egress up.Egress
+		if err := egress.Scan(source); err != nil {
+			return nil, err
+		}
+		egresses = append(egresses, egress)
+	}
+	return egresses, nil
+}
+
+func (c EConn) PutQuota(quota up.Quota) error {
+	log.Debug("Quota %+v", quota)
+	id := url.QueryEscape(quota.Name)
+	quotaStr, err := quota.Value()
+	if err != nil {
+		return err
+	}
+	if _, err := c.Index().Index(IndexConfig).Type(TNQuotas).Refresh(true).
+		Id(id).BodyString(quotaStr).Do(); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (c EConn) DeleteQuota(name string) error {
+	log.Debug("name %+v", name)
+	id := url.QueryEscape(name)
+	if _, err := c.Delete().Index(IndexConfig).Type(TNQuotas).Refresh(true).
+		Id(id).Do(); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (c EConn) GetQuotas() ([]up.Quota, error) {
+	log.Debug("")
+	sources, err := c.searchAll(IndexConfig, TNQuotas)
+	if err != nil {
+		return nil, err
+	}
+	quotas := []up.Quota{}
+	for _, source := range sources {
+		var quota up.Quota
+		if err := quota.Scan(source); err != nil {
+			return nil, err
+		}
+		quotas = append(quotas, quota)
+	}
+	return quotas, nil
+}
+
+func (c EConn) PutHostPort(hostPort up.HostPort) error {
+	log.Debug("HostPort %+v", hostPort)
+	id := url.QueryEscape(hostPort.Key())
+	hostPortStr, err := hostPort.Value()
+	if err != nil {
+		return err
+	}
+	// The host port is only created if no other container published it,
+	// the container publishing it again keeps it.
+	_, err = c.Index().Index(IndexState).Type(TNHostPorts).Refresh(true).
+		Id(id).BodyString(hostPortStr).OpType("create").Do()
+	if !isConflict(err) {
+		return err
+	}
+	getResult, err := c.Get().Index(IndexState).Type(TNHostPorts).Id(id).Do()
+	if err != nil {
+		return err
+	}
+	var other up.HostPort
+	if getResult.Found {
+		if err := other.Scan(string(*getResult.Source)); err != nil {
+			return err
+		}
+	}
+	if other.Container != hostPort.Container {
+		return ErrHostPortInUse
+	}
+	return nil
+}
+
+func (c EConn) DeleteHostPort(key string) error {
+	log.Debug("key %+v", key)
+	id := url.QueryEscape(key)
+	if _, err := c.Delete().Index(IndexState).Type(TNHostPorts).Refresh(true).
+		Id(id).Do(); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (c EConn) GetHostPorts() ([]up.HostPort, error) {
+	log.Debug("")
+	sources, err := c.searchAll(IndexState, TNHostPorts)
+	if err != nil {
+		return nil, err
+	}
+	hostPorts := []up.HostPort{}
+	for _, source := range sources {
+		var hostPort up.HostPort
+		if err := hostPort.Scan(source); err != nil {
+			return nil, err
+		}
+		hostPorts = append(hostPorts, hostPort)
+	}
+	return hostPorts, nil
+}
+
+func (c EConn) PutContainerUsage(usage up.ContainerUsage) error {
+	log.Debug("ContainerUsage %+v", usage)
+	id := url.QueryEscape(usage.Container)
+	usageStr, err := usage.Value()
+	if err != nil {
+		return err
+	}
+	if _, err := c.Index().Index(IndexState).Type(TNQuotaUsage).Refresh(true).
+		Id(id).BodyString(usageStr).Do(); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (c EConn) DeleteContainerUsage(containerID string) error {
+	log.Debug("containerID %+v", containerID)
+	id := url.QueryEscape(containerID)
+	// Containers that weren't covered by any policy don't have usage.
+	if _, err := c.Delete().Index(IndexState).Type(TNQuotaUsage).Refresh(true).
+		Id(id).Do(); err != nil && !elastic.IsNotFound(err) {
+		return err
+	}
+	return nil
+}
+
+func (c EConn) GetContainerUsages() ([]up.ContainerUsage, error) {
+	log.Debug("")
+	sources, err := c.searchAll(IndexState, TNQuotaUsage)
+	if err != nil {
+		return nil, err
+	}
+	usages := []up.ContainerUsage{}
+	for _, source := range sources {
+		var usage up.ContainerUsage
+		if err := usage.Scan(source); err != nil {
+			return nil, err
+		}
+		usages = append(usages, usage)
+	}
+	return usages, nil
+}
+
+func (c EConn) UpdateQuotaReservations(update func(*up.QuotaReservations, []up.ContainerUsage) error) error {
+	log.Debug("")
+	for i := 0; i < maxUpdateRetries; i++ {
+		reservations := up.QuotaReservations{}
+		getResult, err := c.Get().Index(IndexState).Type(TNQuotaReservations).Id(TNQuotaReservations).Do()
+		if err != nil && !elastic.IsNotFound(err) {
+			return err
+		}
+		found := err == nil && getResult.Found
+		if found {
+			if err := reservations.Scan(string(*getResult.Source)); err != nil {
+				return err
+			}
+		}
+		// The usages are read after the reservations, and a reservation is
+		// released after the usage of its container is stored, so a
+		// container is always counted at least once.
+		usages, err := c.GetContainerUsages()
+		if err != nil {
+			return err
+		}
+		if err := update(&reservations, usages); err != nil {
+			return err
+		}
+		reservationsStr, err := reservations.Value()
+		if err != nil {
+			return err
+		}
+		// The version of the document read makes the write fail if another
+		// node modified it in the meantime.
+		index := c.Index().Index(IndexState).Type(TNQuotaReservations).Refresh(true).
+			Id(TNQuotaReservations).BodyString(reservationsStr)
+		if found {
+			index = index.Version(*getResult.Version)
+		} else {
+			index = index.OpType("create")
+		}
+		if _, err = index.Do(); !isConflict(err) {
+			return err
+		}
+		log.Debug("Quota reservations were modified concurrently, retrying")
+	}
+	return fmt.Errorf("quota reservations were modified concurrently %d times", maxUpdateRetries)
+}
+
+// isConflict returns true if the given error indicates that a document was
+// modified since it was read.
+func isConflict(err error) bool {
+	e, ok := err.(*elastic.Error)
+	return ok && e.Status == http.StatusConflict
+}
+
+func (c EConn) UpdateServiceSlots(service string, update func(*up.ServiceSlots) error) error {
+	log.Debug("service %+v", service)
+	id := url.QueryEscape(service)
+	for i := 0; i < maxUpdateRetries; i++ {
+		slots := up.ServiceSlots{Service: service}
+		getResult, err := c.Get().Index(IndexState).Type(TNServiceSlots).Id(id).Do()
+		if err != nil && !elastic.IsNotFound(err) {
+			return err
+		}
+		found := err == nil && getResult.Found
+		if found {
+			if err := slots.Scan(string(*getResult.Source)); err != nil {
+				return err
+			}
+		}
+		if err := update(&slots); err != nil {
+			return err
+		}
+		// The version of the document read makes the write fail if another
+		// node modified it in the meantime.
+		if len(slots.Slots) == 0 {
+			if !found {
+				return nil
+			}
+			_, err = c.Delete().Index(IndexState).Type(TNServiceSlots).Refresh(true).
+				Id(id).Version(*getResult.Version).Do()
+		} else {
+			slotsStr, err := slots.Value()
+			if err != nil {
+				return err
+			}
+			index := c.Index().Index(IndexState).Type(TNServiceSlots).Refresh(true).
+				Id(id).BodyString(slotsStr)
+			if found {
+				index = index.Version(*getResult.Version)
+			} else {
+				index = index.OpType("create")
+			}
+			_, err = index.Do()
+		}
+		if !isConflict(err) {
+			return err
+		}
+		log.Debug("Slots of service %s were modified concurrently, retrying", service)
+	}
+	return fmt.Errorf("slots of service %s were modified concurrently %d times", service, maxUpdateRetries)
+}
+
+func (c EConn) GetServiceSlots() ([]up.ServiceSlots, error) {
+	log.Debug("")
+	sources, err := c.searchAll(IndexState, TNServiceSlots)
+	if err != nil {
+		return nil, err
+	}
+	services := []up.ServiceSlots{}
+	for _, source := range sources {
+		var slots up.ServiceSlots
+		if err := slots.Scan(source); err != nil {
+			return nil, err
+		}
+		services = append(services, slots)
+	}
+	return services, nil
+}
+
+func (c EConn) UpdateIdentities(update func(*up.Identities) error) error {
+	log.Debug("")
+	for i := 0; i < maxUpdateRetries; i++ {
+		identities := up.Identities{}
+		getResult, err := c.Get().Index(IndexState).Type(TNIdentities).Id(TNIdentities).Do()
+		if err != nil && !elastic.IsNotFound(err) {
+			return err
+		}
+		found := err == nil && getResult.Found
+		if found {
+			if err := identities.Scan(string(*getResult.Source)); err != nil {
+				return err
+			}
+		}
+		if err := update(&identities); err != nil {
+			return err
+		}
+		identitiesStr, err := identities.Value()
+		if err != nil {
+			return err
+		}
+		// The version of the document read makes the write fail if another
+		// node modified it in the meantime.
+		index := c.Index().Index(IndexState).Type(TNIdentities).Refresh(true).
+			Id(TNIdentities).BodyString(identitiesStr)
+		if found {
+			index = index.Version(*getResult.Version)
+		} else {
+			index = index.OpType("create")
+		}
+		if _, err = index.Do(); !isConflict(err) {
+			return err
+		}
+		log.Debug("Identities were modified concurrently, retrying")
+	}
+	return fmt.Errorf("identities were modified concurrently %d times", maxUpdateRetries)
+}
+
+func (c EConn) GetIdentities() (up.Identities, error) {
+	log.Debug("")
+	identities := up.Identities{}
+	getResult, err := c.Get().Index(IndexState).Type(TNIdentities).Id(TNIdentities).Do()
+	if elastic.IsNotFound(err) {
+		return identities, nil
+	} else if err != nil {
+		return identities, err
+	}
+	if getResult.Found {
+		if err := identities.Scan(string(*getResult.Source)); err != nil {
+			return identities, err
+		}
+	}
+	return identities, nil
+}
+
+func (c EConn) PutSecret(secret up.Secret) error {
+	log.Debug("Secret %s of %s", secret.Name, secret.Owner)
+	id := url.QueryEscape(secret.Name)
+	secretStr, err := secret.Value()
+	if err != nil {
+		return err
+	}
+	if _, err := c.Index().Index(IndexConfig).Type(TNSecrets).Refresh(true).
+		Id(id).BodyString(secretStr).Do(); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (c EConn) DeleteSecret(name string) error {
+	log.Debug("name %+v", name)
+	id := url.QueryEscape(name)
+	if _, err := c.Delete().Index(IndexConfig).Type(TNSecrets).Refresh(true).
+		Id(id).Do(); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (c EConn) GetSecret(name string) (up.Secret, error) {
+	log.Debug("name %+v", name)
+	var secret up.Secret
+	getResult, err := c.Get().Index(IndexConfig).Type(TNSecrets).Id(url.QueryEscape(name)).Do()
+	if elastic.IsNotFound(err) {
+		return secret, nil
+	} else if err != nil {
+		return secret, err
+	}
+	if getResult.Found {
+		if err := secret.Scan(string(*getResult.Source)); err != nil {
+			return secret, err
+		}
+	}
+	return secret, nil
+}
+
+func (c EConn) GetSecrets() ([]up.Secret, error) {
+	log.Debug("")
+	sources, err := c.searchAll(IndexConfig, TNSecrets)
+	if err != nil {
+		return nil, err
+	}
+	secrets := []up.Secret{}
+	for _, source := range sources {
+		var secret up.Secret
+		if err := secret.Scan(source); err != nil {
+			return nil, err
+		}
+		secrets = append(secrets, secret)
+	}
+	return secrets, nil
+}
+
+func (c EConn) PutSubscription(subscription up.Subscription) error {
+	log.Debug("Subscription %+v", subscription)
+	id := url.QueryEscape(subscription.Name)
+	subscriptionStr, err := subscription.Value()
+	if err != nil {
+		return err
+	}
+	if _, err := c.Index().Index(IndexConfig).Type(TNSubscriptions).Refresh(true).
+		Id(id).BodyString(subscriptionStr).Do(); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (c EConn) DeleteSubscription(name string) error {
+	log.Debug("name %+v", name)
+	id := url.QueryEscape(name)
+	if _, err := c.Delete().Index(IndexConfig).Type(TNSubscriptions).Refresh(true).
+		Id(id).Do(); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (c EConn) GetSubscriptions() ([]up.Subscription, error) {
+	log.Debug("")
+	sources, err := c.searchAll(IndexConfig, TNSubscriptions)
+	if err != nil {
+		return nil, err
+	}
+	subscriptions := []up.Subscription{}
+	for _, source := range sources {
+		var subscription up.Subscription
+		if err := subscription.Scan(source); err != nil {
+			return nil, err
+		}
+		subscriptions = append(subscriptions, subscription)
+	}
+	return subscriptions, nil
+}
@@ -0,0 +1,825 @@
+package db
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	up "github.com/cilium-team/cilium/cilium/utils/profile"
+
+	"github.com/cilium-team/cilium/Godeps/_workspace/src/gopkg.in/olivere/elastic.v3"
+)
+
+// SchemaVersion is the version of the format of the documents stored in
+// Elasticsearch. Every time the format of a stored document changes, this
+// version must be increased and a migration, that converts the documents from
+// the previous version, must be added to migrations.
+const SchemaVersion = 18
+
+const (
+	schemaVersionID = "version"
+	// migrationTimeout is how long a node waits for the migration of the
+	// database by another node before giving up.
+	migrationTimeout = 10 * time.Minute
+	// migrationPollInterval is how often a node checks if the migration of
+	// the database by another node has finished.
+	migrationPollInterval = 2 * time.Second
+	// migrationBatchSize is the number of documents read and written at once
+	// while copying an index.
+	migrationBatchSize = 500
+	// auditIndexVersion is the schema version since the audit entries are
+	// stored in IndexAudit instead of IndexState.
+	auditIndexVersion = 16
+	// historyIndexVersion is the schema version since the endpoint history
+	// is stored in IndexHistory instead of IndexState.
+	historyIndexVersion = 17
+	// networksDocVersion is the schema version since all networks are stored
+	// in a single document instead of a document each.
+	networksDocVersion = 18
+)
+
+// schemaVersion is the document, stored in IndexSchema, with the schema
+// version of the database. It's also the lock of its migrations, only the
+// node set in MigratedBy migrates the database.
+type schemaVersion struct {
+	Version    int    `json:"version"`
+	MigratedBy string `json:"migrated-by,omitempty"`
+}
+
+// Value marshals the receiver schemaVersion into a json string.
+func (sv schemaVersion) Value() (string, error) {
+	data, err := json.Marshal(sv)
+	return string(data), err
+}
+
+// Scan unmarshals the input into the receiver schemaVersion.
+func (sv *schemaVersion) Scan(input string) error {
+	return json.Unmarshal([]byte(input), sv)
+}
+
+type properties map[string]interface{}
+
+var (
+	notAnalyzedString = properties{"type": "string", "index": "not_analyzed"}
+	integer           = properties{"type": "integer"}
+	date              = properties{"type": "date"}
+	// disabledObject is used for objects with user defined keys, like labels,
+	// that are kept in the document's source but they are not indexed, this
+	// way keys with dots don't create new fields in the mapping.
+	disabledObject = properties{"type": "object", "enabled": false}
+)
+
+// newMapping returns a type mapping with the given properties. Fields not
+// present in properties are stored but not indexed so new fields added to a
+// document don't change its mapping.
+func newMapping(props properties) properties {
+	return properties{"dynamic": false, "properties": props}
+}
+
+// mappings has the mapping of each type for each index.
+var mappings = map[string]map[string]properties{
+	IndexSchema: {
+		TNSchema: newMapping(properties{
+			"version":     integer,
+			"migrated-by": notAnalyzedString,
+		}),
+	},
+	IndexConfig: {
+		TNDNSconfig: newMapping(properties{
+			"ip":   notAnalyzedString,
+			"port": notAnalyzedString,
+		}),
+		TNHAProxyconfig: newMapping(properties{
+			"ip":   notAnalyzedString,
+			"port": notAnalyzedString,
+		}),
+		TNPolicySource: newMapping(properties{
+			"name":              notAnalyzedString,
+			"owner":             notAnalyzedString,
+			"coverage":          disabledObject,
+			"docker-config":     disabledObject,
+			"intent-config":     disabledObject,
+			"kubernetes-config": disabledObject,
+			"coverage-labels": properties{
+				"type": "nested",
+				"properties": properties{
+					"key":   notAnalyzedString,
+					"value": notAnalyzedString,
+				},
+			},
+		}),
+		TNQuotas: newMapping(properties{
+			"name":   notAnalyzedString,
+			"owner":  notAnalyzedString,
+			"labels": disabledObject,
+		}),
+		TNEgress: newMapping(properties{
+			"name":         notAnalyzedString,
+			"network":      notAnalyzedString,
+			"namespace":    integer,
+			"subnets":      notAnalyzedString,
+			"destinations": notAnalyzedString,
+			"uplink":       notAnalyzedString,
+			"owner-ips":    disabledObject,
+		}),
+		TNRouters: newMapping(properties{
+			"namespace": integer,
+			"gateways":  disabledObject,
+			"routes":    disabledObject,
+		}),
+		TNSecrets: newMapping(properties{
+			"name":       notAnalyzedString,
+			"owner":      notAnalyzedString,
+			"readers":    notAnalyzedString,
+			"ciphertext": properties{"type": "string", "index": "no"},
+		}),
+		TNSubscriptions: newMapping(properties{
+			"name":    notAnalyzedString,
+			"url":     notAnalyzedString,
+			"types":   notAnalyzedString,
+			"timeout": notAnalyzedString,
+		}),
+		TNUsers: newMapping(properties{
+			"ID":   integer,
+			"Name": notAnalyzedString,
+		}),
+	},
+	IndexAudit: {
+		TNAudit: newMapping(properties{
+			"timestamp": date,
+			"actor":     notAnalyzedString,
+			"node":      notAnalyzedString,
+			"action":    notAnalyzedString,
+			"container": notAnalyzedString,
+			"owners":    notAnalyzedString,
+			"policies":  notAnalyzedString,
+			"decision":  notAnalyzedString,
+			"reason":    properties{"type": "string"},
+			"before":    disabledObject,
+			"after":     disabledObject,
+		}),
+	},
+	IndexHistory: {
+		TNEndpointHistory: newMapping(properties{
+			"timestamp": date,
+			"action":    notAnalyzedString,
+			"container": notAnalyzedString,
+			"name":      notAnalyzedString,
+			"labels":    disabledObject,
+			"node":      notAnalyzedString,
+			"ips":       notAnalyzedString,
+			"macs":      notAnalyzedString,
+			"group":     integer,
+		}),
+	},
+	IndexState: {
+		TNEndpoint: newMapping(properties{
+			"container": notAnalyzedString,
+			"name":      notAnalyzedString,
+			"labels":    disabledObject,
+			"ips":       notAnalyzedString,
+			"macs":      notAnalyzedString,
+			"node":      notAnalyzedString,
+			"interface": notAnalyzedString,
+			"bridge":    notAnalyzedString,
+			"group":     integer,
+			"bd":        integer,
+			"namespace": integer,
+			"network":   notAnalyzedString,
+			"service":   notAnalyzedString,
+			"domains":   notAnalyzedString,
+		}),
+		TNHostPorts: newMapping(properties{
+			"host-ip":   notAnalyzedString,
+			"host-port": integer,
+			"protocol":  notAnalyzedString,
+			"node":      notAnalyzedString,
+			"container": notAnalyzedString,
+			"ip":        notAnalyzedString,
+			"port":      integer,
+		}),
+		TNIPsinUse: newMapping(properties{
+			"IPAddress": notAnalyzedString,
+		}),
+		TNLinksConfig: newMapping(properties{
+			"container": notAnalyzedString,
+			"links":     notAnalyzedString,
+		}),
+		TNLinksConfigTemp: newMapping(properties{
+			"container": notAnalyzedString,
+			"links":     notAnalyzedString,
+		}),
+		TNNodes: newMapping(properties{
+			"name":             notAnalyzedString,
+			"ip":               notAnalyzedString,
+			"tunnel-ip":        notAnalyzedString,
+			"capabilities":     notAnalyzedString,
+			"lease-expiration": date,
+		}),
+		TNPortBindingsConfig: newMapping(properties{
+			"container":     notAnalyzedString,
+			"port-bindings": disabledObject,
+		}),
+		TNPortBindingsConfigTemp: newMapping(properties{
+			"container":     notAnalyzedString,
+			"port-bindings": disabledObject,
+		}),
+		TNIdentities: newMapping(properties{
+			"identities": disabledObject,
+		}),
+		TNNetworks: newMapping(properties{
+			"networks": disabledObject,
+		}),
+		TNQuotaReservations: newMapping(properties{
+			"reservations": disabledObject,
+		}),
+		TNQuotaUsage: newMapping(properties{
+			"container": notAnalyzedString,
+			"owners":    notAnalyzedString,
+			"labels":    disabledObject,
+			"ips":       notAnalyzedString,
+		}),
+		TNServiceSlots: newMapping(properties{
+			"service": notAnalyzedString,
+			"slots":   disabledObject,
+		}),
+	},
+}
+
+type labelDoc struct {
+	Key   string `json:"key"`
+	Value string `json:"value"`
+}
+
+// policyDoc is the document stored for each Policy. Besides the Policy itself,
+// it has the coverage labels as a list of key-value pairs so they can be
+// queried.
+type policyDoc struct {
+	up.Policy
+	CoverageLabels []labelDoc `json:"coverage-labels,omitempty"`
+}
+
+// Value marshals the receiver policyDoc into a json string.
+func (pd policyDoc) Value() (string, error) {
+	data, err := json.Marshal(pd)
+	return string(data), err
+}
+
+// newPolicyDoc returns the policyDoc of the given Policy.
+func newPolicyDoc(policy up.Policy) policyDoc {
+	keys := []string{}
+	for key := range policy.Coverage.Labels {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	doc := policyDoc{Policy: policy}
+	for _, key := range keys {
+		doc.CoverageLabels = append(doc.CoverageLabels,
+			labelDoc{Key: key, Value: policy.Coverage.Labels[key]})
+	}
+	return doc
+}
+
+type migration struct {
+	// version is the schema version after the migration is applied.
+	version     int
+	description string
+	// transform, if set, converts the source of a document of the given
+	// type from the previous schema version.
+	transform func(typ, source string) (string, error)
+}
+
+// migrations must be ordered by ascending version. Version 1 is the schema
+// used before schema versions were stored in the database. Every migration
+// copies the documents to new indexes, created with the mappings of
+// SchemaVersion, so migrations that only add fields or types don't need a
+// transform.
+var migrations = []migration{
+	{
+		version:     2,
+		description: "store documents with explicit mappings and without quoted dots",
+		transform:   unquoteLegacyDots,
+	},
+	{
+		version:     3,
+		description: "add audit table",
+	},
+	{
+		version:     4,
+		description: "add quotas and quota usage tables",
+	},
+	{
+		version:     5,
+		description: "add service slots table",
+	},
+	{
+		version:     6,
+		description: "add secrets table",
+	},
+	{
+		version:     7,
+		description: "add event subscriptions table",
+	},
+	{
+		version:     8,
+		description: "add endpoint history table and endpoints' names and labels",
+	},
+	{
+		version:     9,
+		description: "add networks table and endpoints' networks",
+	},
+	{
+		version:     10,
+		description: "add identities table",
+	},
+	{
+		version:     11,
+		description: "add endpoints' bridges",
+	},
+	{
+		version:     12,
+		description: "add routers table",
+	},
+	{
+		version:     13,
+		description: "add egress table",
+	},
+	{
+		version:     14,
+		description: "add host ports table",
+	},
+	{
+		version:     15,
+		description: "add quota reservations table",
+	},
+	{
+		version:     auditIndexVersion,
+		description: "move audit entries to their own index",
+	},
+	{
+		version:     historyIndexVersion,
+		description: "move endpoint history to its own index",
+	},
+	{
+		version:     networksDocVersion,
+		description: "store all networks in a single document",
+	},
+}
+
+// physicalIndex returns the name of the index, behind the given alias, with
+// the documents of the given schema version.
+func physicalIndex(alias string, version int) string {
+	return fmt.Sprintf("%s-v%d", alias, version)
+}
+
+// createIndex creates the index, with the mappings of SchemaVersion, behind
+// the given alias.
+func (c EConn) createIndex(alias string) error {
+	_, err := c.CreateIndex(physicalIndex(alias, SchemaVersion)).BodyJson(properties{
+		"mappings": mappings[alias],
+		"aliases":  properties{alias: properties{}},
+	}).Do()
+	return err
+}
+
+// recreateIndex deletes the indexes behind the given alias and creates an
+// empty one.
+func (c EConn) recreateIndex(alias string) error {
+	indexes, err := c.aliasedIndexes(alias)
+	if err != nil {
+		return err
+	}
+	if target := physicalIndex(alias, SchemaVersion); !containsString(indexes, target) {
+		indexes = append(indexes, target)
+	}
+	for _, index := range indexes {
+		if err := c.deleteIndex(index); err != nil {
+			return err
+		}
+	}
+	return c.createIndex(alias)
+}
+
+// aliasedIndexes returns the indexes behind the given alias. Databases created
+// before the indexes had aliases have an index with the alias' name.
+func (c EConn) aliasedIndexes(alias string) ([]string, error) {
+	result, err := c.Aliases().Index(alias).Do()
+	if elastic.IsNotFound(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	indexes := []string{}
+	for index := range result.Indices {
+		indexes = append(indexes, index)
+	}
+	sort.Strings(indexes)
+	return indexes, nil
+}
+
+// deleteIndex deletes the given index if it exists.
+func (c EConn) deleteIndex(index string) error {
+	if exists, err := c.IndexExists(index).Do(); err != nil || !exists {
+		return err
+	}
+	_, err := c.DeleteIndex(index).Do()
+	return err
+}
+
+// reindex copies, with the given transforms applied, the documents behind the
+// given alias to a new index with the mappings of SchemaVersion and swaps the
+// alias to it. The documents are never deleted before they are copied so an
+// interrupted reindex is resumed by running it again.
+func (c EConn) reindex(alias string, transforms []func(typ, source string) (string, error)) error {
+	target := physicalIndex(alias, SchemaVersion)
+	current, err := c.aliasedIndexes(alias)
+	if err != nil {
+		return err
+	}
+	targetExists, err := c.IndexExists(target).Do()
+	if err != nil {
+		return err
+	}
+	switch {
+	case len(current) == 1 && current[0] == target:
+		return nil
+	case len(current) == 0 && targetExists:
+		// The previous index was deleted, after being copied, by an
+		// interrupted reindex.
+		_, err := c.Alias().Add(target, alias).Do()
+		return err
+	case len(current) == 0:
+		return c.createIndex(alias)
+	}
+
+	// A previous reindex may have been interrupted while copying.
+	if err := c.deleteIndex(target); err != nil {
+		return err
+	}
+	if _, err := c.CreateIndex(target).BodyJson(properties{"mappings": mappings[alias]}).Do(); err != nil {
+		return err
+	}
+	types := []string{}
+	for typ := range mappings[alias] {
+		types = append(types, typ)
+	}
+	sort.Strings(types)
+	copied, err := c.copyDocuments(alias, target, types, transforms)
+	if err != nil {
+		return err
+	}
+	if _, err := c.Refresh(target).Do(); err != nil {
+		return err
+	}
+	if count, err := c.Count(target).Do(); err != nil {
+		return err
+	} else if count != copied {
+		return fmt.Errorf("index %s has %d documents, %d were copied", target, count, copied)
+	}
+
+	if len(current) == 1 && current[0] == alias {
+		// An alias can't have the name of an existing index.
+		if _, err := c.DeleteIndex(alias).Do(); err != nil {
+			return err
+		}
+		_, err := c.Alias().Add(target, alias).Do()
+		return err
+	}
+	swap := c.Alias()
+	for _, index := range current {
+		swap = swap.Remove(index, alias)
+	}
+	if _, err := swap.Add(target, alias).Do(); err != nil {
+		return err
+	}
+	for _, index := range current {
+		if err := c.deleteIndex(index); err != nil {
+			log.Warning("Unable to delete index %s: %s", index, err)
+		}
+	}
+	return nil
+}
+
+// copyDocuments copies, with the given transforms applied, the documents of
+// the given types from the given index to the target index. It returns the
+// number of documents copied.
+func (c EConn) copyDocuments(index, target string, types []string, transforms []func(typ, source string) (string, error)) (int64, error) {
+	copied := int64(0)
+	bulk := c.Bulk()
+	commit := func() error {
+		if bulk.NumberOfActions() == 0 {
+			return nil
+		}
+		resp, err := bulk.Do()
+		if err != nil {
+			return err
+		}
+		if failed := resp.Failed(); len(failed) != 0 {
+			return fmt.Errorf("unable to copy document %s/%s: %+v", failed[0].Type, failed[0].Id, failed[0].Error)
+		}
+		return nil
+	}
+	err := c.scan(index, types, func(hit *elastic.SearchHit) error {
+		source := string(*hit.Source)
+		for _, transform := range transforms {
+			var err error
+			if source, err = transform(hit.Type, source); err != nil {
+				return err
+			}
+		}
+		bulk.Add(elastic.NewBulkIndexRequest().Index(target).Type(hit.Type).Id(hit.Id).
+			Doc(json.RawMessage(source)))
+		copied++
+		if bulk.NumberOfActions() >= migrationBatchSize {
+			return commit()
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return copied, commit()
+}
+
+// getSchemaVersion returns the schema version document and its version, nil
+// if it isn't stored yet. Databases created before IndexSchema existed have
+// their schema version, 1 if it isn't stored, in IndexState. The schema
+// version is 0 if there isn't any database created.
+func (c EConn) getSchemaVersion() (schemaVersion, *int64, error) {
+	var sv schemaVersion
+	getResult, err := c.Get().Index(IndexSchema).Type(TNSchema).Id(schemaVersionID).Do()
+	if err == nil && getResult.Found {
+		err := sv.Scan(string(*getResult.Source))
+		return sv, getResult.Version, err
+	} else if err != nil && !elastic.IsNotFound(err) {
+		return sv, nil, err
+	}
+
+	exists := false
+	for _, index := range Indexes {
+		indexExists, err := c.IndexExists(index).Do()
+		if err != nil {
+			return sv, nil, err
+		}
+		exists = exists || indexExists
+	}
+	if !exists {
+		return sv, nil, nil
+	}
+	getResult, err = c.Get().Index(IndexState).Type(TNSchema).Id(schemaVersionID).Do()
+	if elastic.IsNotFound(err) || (err == nil && !getResult.Found) {
+		return schemaVersion{Version: 1}, nil, nil
+	} else if err != nil {
+		return sv, nil, err
+	}
+	err = sv.Scan(string(*getResult.Source))
+	return schemaVersion{Version: sv.Version}, nil, err
+}
+
+// putSchemaVersion stores the given schema version document. If version is
+// not nil, the document is only stored if it wasn't modified since it had
+// that version, otherwise it's only stored if it doesn't exist. Returns the
+// version of the stored document.
+func (c EConn) putSchemaVersion(sv schemaVersion, version *int64) (int64, error) {
+	if err := c.createSchemaIndex(); err != nil {
+		return 0, err
+	}
+	svStr, err := sv.Value()
+	if err != nil {
+		return 0, err
+	}
+	index := c.Index().Index(IndexSchema).Type(TNSchema).Refresh(true).
+		Id(schemaVersionID).BodyString(svStr)
+	if version != nil {
+		index = index.Version(*version)
+	} else {
+		index = index.OpType("create")
+	}
+	resp, err := index.Do()
+	if err != nil {
+		return 0, err
+	}
+	return int64(resp.Version), nil
+}
+
+// createSchemaIndex creates IndexSchema if it doesn't exist.
+func (c EConn) createSchemaIndex() error {
+	if exists, err := c.IndexExists(IndexSchema).Do(); err != nil || exists {
+		return err
+	}
+	_, err := c.CreateIndex(IndexSchema).BodyJson(properties{"mappings": mappings[IndexSchema]}).Do()
+	if err != nil {
+		// Other nodes may have created it in the meantime.
+		if exists, _ := c.IndexExists(IndexSchema).Do(); exists {
+			return nil
+		}
+	}
+	return err
+}
+
+// resetSchemaVersion stores SchemaVersion, regardless of the stored one, for
+// a database that was just created.
+func (c EConn) resetSchemaVersion() error {
+	_, version, err := c.getSchemaVersion()
+	if err != nil {
+		return err
+	}
+	if version == nil {
+		_, err = c.putSchemaVersion(schemaVersion{Version: SchemaVersion}, nil)
+		return err
+	}
+	_, err = c.putSchemaVersion(schemaVersion{Version: SchemaVersion}, version)
+	return err
+}
+
+// Migrate upgrades all documents stored in the database to SchemaVersion. If
+// there isn't any database created, it creates one. Only one node migrates
+// the database, the one that sets itself in the schema version document, the
+// remaining ones wait for it to finish.
+func (c EConn) Migrate() error {
+	log.Debug("")
+	node, err := os.Hostname()
+	if err != nil {
+		return err
+	}
+	deadline := time.Now().Add(migrationTimeout)
+	for {
+		sv, version, err := c.getSchemaVersion()
+		if err != nil {
+			return err
+		}
+		if sv.Version > SchemaVersion {
+			return fmt.Errorf("database schema version %d is newer than the supported version %d",
+				sv.Version, SchemaVersion)
+		}
+		// Databases without the schema version document are migrated, even
+		// if they have SchemaVersion, so their indexes get aliases.
+		if version != nil && sv.Version == SchemaVersion && sv.MigratedBy == "" {
+			return nil
+		}
+		// A node migrating the database that was restarted resumes its
+		// migration.
+		if sv.MigratedBy != "" && sv.MigratedBy != node {
+			if time.Now().After(deadline) {
+				return fmt.Errorf("database is being migrated by %s for more than %s, if it's no "+
+					"longer running remove the document %s/%s/%s", sv.MigratedBy, migrationTimeout,
+					IndexSchema, TNSchema, schemaVersionID)
+			}
+			log.Info("Waiting for %s to migrate the database", sv.MigratedBy)
+			time.Sleep(migrationPollInterval)
+			continue
+		}
+		lockVersion, err := c.putSchemaVersion(schemaVersion{Version: sv.Version, MigratedBy: node}, version)
+		if isConflict(err) {
+			continue
+		} else if err != nil {
+			return err
+		}
+		if err := c.migrate(sv.Version); err != nil {
+			if _, unlockErr := c.putSchemaVersion(schemaVersion{Version: sv.Version}, &lockVersion); unlockErr != nil {
+				log.Error("Unable to unlock the database migration: %s", unlockErr)
+			}
+			return err
+		}
+		_, err = c.putSchemaVersion(schemaVersion{Version: SchemaVersion}, &lockVersion)
+		return err
+	}
+}
+
+// migrate copies the documents of the given schema version to indexes with
+// the mappings of SchemaVersion, applying the transforms of all migrations
+// since that version.
+func (c EConn) migrate(version int) error {
+	if version == 0 {
+		log.Info("Creating database with schema version %d", SchemaVersion)
+	}
+	transforms := []func(typ, source string) (string, error){}
+	for _, m := range migrations {
+		if m.version <= version || version == 0 {
+			continue
+		}
+		log.Info("Migrating database to schema version %d: %s", m.version, m.description)
+		if m.transform != nil {
+			transforms = append(transforms, m.transform)
+		}
+	}
+	if version != 0 && version < auditIndexVersion {
+		if err := c.moveTable(IndexAudit, TNAudit, transforms); err != nil {
+			return fmt.Errorf("migration of the audit entries to index %s failed: %s", IndexAudit, err)
+		}
+	}
+	if version != 0 && version < historyIndexVersion {
+		if err := c.moveTable(IndexHistory, TNEndpointHistory, transforms); err != nil {
+			return fmt.Errorf("migration of the endpoint history to index %s failed: %s", IndexHistory, err)
+		}
+	}
+	for _, index := range Indexes {
+		if err := c.reindex(index, transforms); err != nil {
+			return fmt.Errorf("migration of index %s to schema version %d failed: %s", index, SchemaVersion, err)
+		}
+	}
+	if version != 0 && version < networksDocVersion {
+		if err := c.mergeNetworks(); err != nil {
+			return fmt.Errorf("migration of the networks to a single document failed: %s", err)
+		}
+	}
+	return nil
+}
+
+// mergeNetworks moves the networks stored in a document each, before
+// networksDocVersion, to the document with all networks. The documents of the
+// networks are only deleted once they are merged so an interrupted migration
+// merges them again.
+func (c EConn) mergeNetworks() error {
+	networks := up.Networks{}
+	merged := []string{}
+	err := c.scan(IndexState, []string{TNNetworks}, func(hit *elastic.SearchHit) error {
+		if hit.Id == TNNetworks {
+			var stored up.Networks
+			if err := stored.Scan(string(*hit.Source)); err != nil {
+				return err
+			}
+			for _, network := range stored.Networks {
+				if _, ok := networks.Get(network.Name); !ok {
+					networks.Put(network)
+				}
+			}
+			return nil
+		}
+		var network up.Network
+		if err := network.Scan(string(*hit.Source)); err != nil {
+			return err
+		}
+		networks.Put(network)
+		merged = append(merged, hit.Id)
+		return nil
+	})
+	if err != nil || len(merged) == 0 {
+		return err
+	}
+	networksStr, err := networks.Value()
+	if err != nil {
+		return err
+	}
+	if _, err := c.Index().Index(IndexState).Type(TNNetworks).Refresh(true).
+		Id(TNNetworks).BodyString(networksStr).Do(); err != nil {
+		return err
+	}
+	for _, id := range merged {
+		if _, err := c.Delete().Index(IndexState).Type(TNNetworks).Refresh(true).
+			Id(id).Do(); err != nil && !elastic.IsNotFound(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// moveTable copies, with the given transforms applied, the documents of the
+// given table stored in IndexState to the given index. It must run before
+// IndexState is reindexed, which drops them, so an interrupted migration
+// copies them again.
+func (c EConn) moveTable(alias, table string, transforms []func(typ, source string) (string, error)) error {
+	if err := c.reindex(alias, transforms); err != nil {
+		return err
+	}
+	if current, err := c.aliasedIndexes(IndexState); err != nil || len(current) == 0 {
+		return err
+	}
+	target := physicalIndex(alias, SchemaVersion)
+	if _, err := c.copyDocuments(IndexState, target, []string{table}, transforms); err != nil {
+		return err
+	}
+	_, err := c.Refresh(target).Do()
+	return err
+}
+
+// legacyUnquotedots reverts the replacement, done to every document in schema
+// version 1, of dots in keys so they weren't interpreted as object paths by
+// Elasticsearch.
+var legacyUnquotedots = strings.NewReplacer(`/dot`, `.`, `//`, `/`)
+
+// unquoteLegacyDots removes the quoted dots from the given document and adds
+// the coverage labels to policies.
+func unquoteLegacyDots(typ, source string) (string, error) {
+	source = legacyUnquotedots.Replace(source)
+	if typ != TNPolicySource {
+		return source, nil
+	}
+	var policy up.Policy
+	if err := policy.Scan(source); err != nil {
+		return "", err
+	}
+	return newPolicyDoc(policy).Value()
+}
+
+func containsString(list []string, s string) bool {
+	for _, elem := range list {
+		if elem == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,103 @@
+package db
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	up "github.com/cilium-team/cilium/cilium/utils/profile"
+)
+
+func TestMappingsCoverAllTables(t *testing.T) {
+	tables := append(append(append([]string{}, configTables...), stateTables...), keptTables...)
+	for _, table := range tables {
+		found := 0
+		for _, index := range Indexes {
+			if _, ok := mappings[index][table]; ok {
+				found++
+			}
+		}
+		if found != 1 {
+			t.Errorf("invalid number of mappings for table %s:\ngot  %d\nwant %d", table, found, 1)
+		}
+	}
+}
+
+func TestMigrationsOrder(t *testing.T) {
+	lastVersion := 1
+	for _, m := range migrations {
+		if m.version != lastVersion+1 {
+			t.Errorf("invalid migration version:\ngot  %d\nwant %d", m.version, lastVersion+1)
+		}
+		lastVersion = m.version
+	}
+	if lastVersion != SchemaVersion {
+		t.Errorf("invalid last migration version:\ngot  %d\nwant %d", lastVersion, SchemaVersion)
+	}
+}
+
+func TestPolicyDoc(t *testing.T) {
+	policy := up.Policy{
+		Name:  "foo",
+		Owner: "root",
+		Coverage: up.Coverage{
+			Labels: map[string]string{"com.docker.swarm.id": "123", "app": "web"},
+		},
+	}
+	doc := newPolicyDoc(policy)
+	want := []labelDoc{{Key: "app", Value: "web"}, {Key: "com.docker.swarm.id", Value: "123"}}
+	if !reflect.DeepEqual(doc.CoverageLabels, want) {
+		t.Errorf("invalid coverage labels:\ngot  %+v\nwant %+v", doc.CoverageLabels, want)
+	}
+
+	docStr, err := doc.Value()
+	if err != nil {
+		t.Fatalf("error while marshalling policy document: %s", err)
+	}
+	var got up.Policy
+	if err := got.Scan(docStr); err != nil {
+		t.Fatalf("error while unmarshalling policy document: %s", err)
+	}
+	if !reflect.DeepEqual(got.Coverage, policy.Coverage) {
+		t.Errorf("invalid coverage:\ngot  %+v\nwant %+v", got.Coverage, policy.Coverage)
+	}
+}
+
+func TestLegacyUnquotedots(t *testing.T) {
+	legacy := `{"labels":{"com/dotdocker/dotswarm/dotid":"a//b"}}`
+	want := `{"labels":{"com.docker.swarm.id":"a/b"}}`
+	if got := legacyUnquotedots.Replace(legacy); got != want {
+		t.Errorf("invalid unquoted document:\ngot  %s\nwant %s", got, want)
+	}
+}
+
+func TestUnquoteLegacyDots(t *testing.T) {
+	legacy := `{"name":"foo","owner":"root","coverage":{"labels":{"com/dotdocker/dotswarm/dotid":"123"}}}`
+	got, err := unquoteLegacyDots(TNPolicySource, legacy)
+	if err != nil {
+		t.Fatalf("error while transforming policy: %s", err)
+	}
+	var doc policyDoc
+	if err := json.Unmarshal([]byte(got), &doc); err != nil {
+		t.Fatalf("error while unmarshalling policy document: %s", err)
+	}
+	want := []labelDoc{{Key: "com.docker.swarm.id", Value: "123"}}
+	if !reflect.DeepEqual(doc.CoverageLabels, want) {
+		t.Errorf("invalid coverage labels:\ngot  %+v\nwant %+v", doc.CoverageLabels, want)
+	}
+
+	if got, _ := unquoteLegacyDots(TNUsers, `{"name":"a//b"}`); got != `{"name":"a/b"}` {
+		t.Errorf("invalid unquoted document:\ngot  %s\nwant %s", got, `{"name":"a/b"}`)
+	}
+}
+
+func TestPhysicalIndex(t *testing.T) {
+	if got, want := physicalIndex(IndexState, 14), "cilium-state-v14"; got != want {
+		t.Errorf("invalid physical index:\ngot  %s\nwant %s", got, want)
+	}
+	for _, index := range Indexes {
+		if _, ok := mappings[index]; !ok {
+			t.Errorf("index %s doesn't have mappings", index)
+		}
+	}
+}
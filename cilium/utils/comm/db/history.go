@@ -0,0 +1,88 @@
+package db
+
+import (
+	"net"
+	"time"
+
+	up "github.com/cilium-team/cilium/cilium/utils/profile"
+)
+
+// recordEndpoint stores, in the endpoint history of conn, the given action on
+// the given endpoint. Failures are only logged so they don't interrupt the
+// operation being recorded.
+func recordEndpoint(conn Db, action string, endpoint up.Endpoint) {
+	record := up.NewEndpointRecord(action, endpoint)
+	record.Timestamp = time.Now().UTC()
+	if err := conn.PutEndpointRecord(record); err != nil {
+		log.Warning("Unable to store endpoint record %+v: %s", record, err)
+	}
+}
+
+// sameIPs returns true if a and b have the same IPs in the same order.
+func sameIPs(a, b up.IPs) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if !a[i].Equal(b[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// HistoryConn is a Db that records, in the endpoint history, every assignment
+// and release of the IPs of an endpoint.
+type HistoryConn struct {
+	Db
+}
+
+// NewHistoryConn returns conn wrapped in a HistoryConn.
+func NewHistoryConn(conn Db) HistoryConn {
+	return HistoryConn{Db: conn}
+}
+
+// PutEndpoint records the assignment of the endpoint's IPs unless it already
+// had them, if it had others their release is recorded first.
+func (c HistoryConn) PutEndpoint(endpoint up.Endpoint) error {
+	before, err := c.Db.GetEndpoint(endpoint.Container)
+	if err != nil {
+		before = up.Endpoint{}
+	}
+	if err := c.Db.PutEndpoint(endpoint); err != nil {
+		return err
+	}
+	if before.Container != "" && sameIPs(before.IPs, endpoint.IPs) {
+		return nil
+	}
+	if before.Container != "" {
+		recordEndpoint(c.Db, up.EndpointReleased, before)
+	}
+	recordEndpoint(c.Db, up.EndpointAssigned, endpoint)
+	return nil
+}
+
+func (c HistoryConn) DeleteEndpoint(containerID string) error {
+	before, err := c.Db.GetEndpoint(containerID)
+	if err != nil {
+		before = up.Endpoint{}
+	}
+	if err := c.Db.DeleteEndpoint(containerID); err != nil {
+		return err
+	}
+	if before.Container != "" {
+		recordEndpoint(c.Db, up.EndpointReleased, before)
+	}
+	return nil
+}
+
+// GetIPHolder returns the record of the endpoint that had the given IP at the
+// given time, false if none had it.
+func GetIPHolder(conn Db, ip net.IP, t time.Time) (up.EndpointRecord, bool, error) {
+	last, found, err := conn.GetLatestEndpointRecord(up.EndpointRecordFilter{IP: ip, Until: t})
+	if err != nil || !found {
+		return up.EndpointRecord{}, false, err
+	}
+	record, found := up.IPHolderAt([]up.EndpointRecord{last}, ip, t)
+	return record, found, nil
+}
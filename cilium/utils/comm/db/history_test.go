@@ -0,0 +1,91 @@
+package db
+
+import (
+	"net"
+	"reflect"
+	"testing"
+	"time"
+
+	up "github.com/cilium-team/cilium/cilium/utils/profile"
+)
+
+func TestHistoryConn(t *testing.T) {
+	mc := NewMemConn()
+	c := NewHistoryConn(mc)
+
+	ip1, ip2 := net.ParseIP("f00d::1"), net.ParseIP("f00d::2")
+	endpoint := up.Endpoint{Container: "1234", Name: "web", Labels: map[string]string{"app": "web"},
+		IPs: up.IPs{ip1}, Node: "192.168.50.10"}
+	if err := c.PutEndpoint(endpoint); err != nil {
+		t.Fatal(err)
+	}
+	// Updates that keep the IPs aren't recorded.
+	endpoint.Domains = []string{"web"}
+	if err := c.PutEndpoint(endpoint); err != nil {
+		t.Fatal(err)
+	}
+	endpoint.IPs = up.IPs{ip2}
+	if err := c.PutEndpoint(endpoint); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.DeleteEndpoint(endpoint.Container); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.DeleteEndpoint("unknown"); err != nil {
+		t.Fatal(err)
+	}
+
+	records, err := mc.GetEndpointRecords(up.EndpointRecordFilter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := []string{}
+	for _, record := range records {
+		got = append(got, record.Action+" "+record.IPs[0].String())
+		if record.Name != "web" || record.Labels["app"] != "web" || record.Timestamp.IsZero() {
+			t.Errorf("invalid record %+v", record)
+		}
+	}
+	want := []string{"assigned f00d::1", "released f00d::1", "assigned f00d::2", "released f00d::2"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("invalid records:\ngot  %v\nwant %v", got, want)
+	}
+
+	record, found, err := GetIPHolder(mc, ip1, records[0].Timestamp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !found || record.Container != "1234" {
+		t.Errorf("invalid holder of %s: %+v", ip1, record)
+	}
+	if _, found, _ := GetIPHolder(mc, ip2, time.Now()); found {
+		t.Errorf("released %s still has a holder", ip2)
+	}
+}
+
+func TestGetIPHolderOfReusedIP(t *testing.T) {
+	mc := NewMemConn()
+	c := NewHistoryConn(mc)
+
+	ip := net.ParseIP("f00d::1")
+	containers := []string{"1", "2", "3"}
+	for _, container := range containers {
+		if err := c.PutEndpoint(up.Endpoint{Container: container, IPs: up.IPs{ip}}); err != nil {
+			t.Fatal(err)
+		}
+		if err := c.DeleteEndpoint(container); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := c.PutEndpoint(up.Endpoint{Container: "4", IPs: up.IPs{ip}}); err != nil {
+		t.Fatal(err)
+	}
+
+	record, found, err := GetIPHolder(mc, ip, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !found || record.Container != "4" {
+		t.Errorf("invalid holder of %s:\ngot  %+v\nwant container %s", ip, record, "4")
+	}
+}
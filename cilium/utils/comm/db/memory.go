@@ -0,0 +1,1093 @@
+package db
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+
+	uc "github.com/cilium-team/cilium/cilium/utils/comm"
+	upl "github.com/cilium-team/cilium/cilium/utils/plugins/loadbalancer"
+	up "github.com/cilium-team/cilium/cilium/utils/profile"
+)
+
+// MemConn is an in-memory database, safe for concurrent use. Each table keeps
+// the json representation of its entries so no entry is shared between the
+// database and its callers. If a file is set, every modification is appended
+// to it, before being applied, and the database is replayed from it on
+// creation. The file is compacted once most of its operations are stale.
+type MemConn struct {
+	mutex   sync.RWMutex
+	file    string
+	journal *os.File
+	// size and ops are the size and the number of operations of the
+	// journal.
+	size   int64
+	ops    int
+	tables map[string]map[string]string
+}
+
+// memOp is a modification of a MemConn as written into its file.
+type memOp struct {
+	Table string `json:"table,omitempty"`
+	ID    string `json:"id,omitempty"`
+	// Value is the new value of the entry, nil if it is deleted.
+	Value *string `json:"value,omitempty"`
+	// Flush are the tables to empty.
+	Flush []string `json:"flush,omitempty"`
+}
+
+const (
+	// minCompactionOps is the number of operations the file must have before
+	// being compacted.
+	minCompactionOps = 1000
+)
+
+var (
+	configTables = []string{TNDNSconfig, TNEgress, TNHAProxyconfig, TNPolicySource, TNQuotas, TNRouters, TNSecrets, TNSubscriptions, TNUsers}
+	stateTables  = []string{TNEndpoint, TNHostPorts, TNIdentities, TNIPsinUse, TNLinksConfig, TNLinksConfigTemp,
+		TNNetworks, TNNodes, TNPortBindingsConfig, TNPortBindingsConfigTemp, TNQuotaReservations, TNQuotaUsage, TNServiceSlots}
+	// keptTables are never flushed so the audit entries and the endpoint
+	// history outlive the database.
+	keptTables = []string{TNAudit, TNEndpointHistory}
+)
+
+type valuer interface {
+	Value() (string, error)
+}
+
+type scanner interface {
+	Scan(string) error
+}
+
+// NewMemConn returns an empty in-memory database.
+func NewMemConn() *MemConn {
+	return &MemConn{tables: map[string]map[string]string{}}
+}
+
+// NewMemConnFile returns an in-memory database persisted in the given file. If
+// the file already exists, the database is initialized with its contents.
+func NewMemConnFile(file string) (*MemConn, error) {
+	log.Debug("file: %+v", file)
+	c := NewMemConn()
+	c.file = file
+	f, err := os.Open(file)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	if err == nil {
+		err = c.replay(f)
+		f.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+	// The file is rewritten so a torn last operation doesn't precede the
+	// next ones.
+	if err := c.compact(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// replay applies all operations read from r. A last operation without a
+// newline was being written when the database stopped so it is ignored.
+func (c *MemConn) replay(r io.Reader) error {
+	br := bufio.NewReader(r)
+	for {
+		line, err := br.ReadBytes('\n')
+		if err == io.EOF {
+			if len(line) != 0 {
+				log.Warning("Ignoring incomplete operation at the end of %s", c.file)
+			}
+			return nil
+		} else if err != nil {
+			return err
+		}
+		var op memOp
+		if err := json.Unmarshal(line, &op); err != nil {
+			return fmt.Errorf("invalid operation in %s: %s", c.file, err)
+		}
+		c.applyOp(op)
+	}
+}
+
+// apply writes the given operations into the receiver's file, if any, and
+// then applies them. Nothing is applied if they can't be written. Must be
+// called with the receiver's mutex locked.
+func (c *MemConn) apply(ops ...memOp) error {
+	if c.journal != nil {
+		var buf bytes.Buffer
+		enc := json.NewEncoder(&buf)
+		for _, op := range ops {
+			if err := enc.Encode(op); err != nil {
+				return err
+			}
+		}
+		if n, err := c.journal.Write(buf.Bytes()); err != nil {
+			if n != 0 {
+				c.journal.Truncate(c.size)
+			}
+			return err
+		}
+		c.size += int64(buf.Len())
+		c.ops += len(ops)
+	}
+	for _, op := range ops {
+		c.applyOp(op)
+	}
+	if c.journal != nil && c.ops > minCompactionOps && c.ops > 2*c.count() {
+		if err := c.compact(); err != nil {
+			// The operations are already stored, only the file keeps
+			// growing until the next compaction.
+			log.Error("Error while compacting %s: %s", c.file, err)
+		}
+	}
+	return nil
+}
+
+func (c *MemConn) applyOp(op memOp) {
+	for _, table := range op.Flush {
+		delete(c.tables, table)
+	}
+	if op.Table == "" {
+		return
+	}
+	if op.Value == nil {
+		delete(c.tables[op.Table], op.ID)
+		return
+	}
+	if _, ok := c.tables[op.Table]; !ok {
+		c.tables[op.Table] = map[string]string{}
+	}
+	c.tables[op.Table][op.ID] = *op.Value
+}
+
+// count returns the number of entries of the receiver.
+func (c *MemConn) count() int {
+	n := 0
+	for _, entries := range c.tables {
+		n += len(entries)
+	}
+	return n
+}
+
+// compact replaces the receiver's file with one that only stores its current
+// entries. Must be called with the receiver's mutex locked.
+func (c *MemConn) compact() error {
+	tmpFile := c.file + ".tmp"
+	f, err := os.OpenFile(tmpFile, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	enc := json.NewEncoder(w)
+	for table, entries := range c.tables {
+		for id, value := range entries {
+			value := value
+			if err := enc.Encode(memOp{Table: table, ID: id, Value: &value}); err != nil {
+				f.Close()
+				return err
+			}
+		}
+	}
+	if err := w.Flush(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	f.Close()
+	if err := os.Rename(tmpFile, c.file); err != nil {
+		return err
+	}
+	journal, err := os.OpenFile(c.file, os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	info, err := journal.Stat()
+	if err != nil {
+		journal.Close()
+		return err
+	}
+	if c.journal != nil {
+		c.journal.Close()
+	}
+	c.journal, c.size, c.ops = journal, info.Size(), c.count()
+	return nil
+}
+
+// put stores v with the given id in table. Returns true if the id was already
+// present in table. Must be called with the receiver's mutex locked.
+func (c *MemConn) put(table, id string, v valuer) (bool, error) {
+	op, err := putOp(table, id, v)
+	if err != nil {
+		return false, err
+	}
+	_, exists := c.tables[table][id]
+	return exists, c.apply(op)
+}
+
+func putOp(table, id string, v valuer) (memOp, error) {
+	vStr, err := v.Value()
+	if err != nil {
+		return memOp{}, err
+	}
+	return memOp{Table: table, ID: id, Value: &vStr}, nil
+}
+
+// get scans the entry with the given id from table into s. Must be called with
+// the receiver's mutex locked.
+func (c *MemConn) get(table, id string, s scanner) error {
+	if vStr, ok := c.tables[table][id]; ok {
+		return s.Scan(vStr)
+	}
+	return nil
+}
+
+// delete removes the entry with the given id from table. Must be called with
+// the receiver's mutex locked.
+func (c *MemConn) delete(table, id string) error {
+	return c.apply(memOp{Table: table, ID: id})
+}
+
+// list returns all entries of table sorted by their ids. Must be called with
+// the receiver's mutex locked.
+func (c *MemConn) list(table string) []string {
+	ids := []string{}
+	for id := range c.tables[table] {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	entries := []string{}
+	for _, id := range ids {
+		entries = append(entries, c.tables[table][id])
+	}
+	return entries
+}
+
+// Flush removes all entries from the given tables.
+func (c *MemConn) Flush(tables ...string) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.apply(memOp{Flush: tables})
+}
+
+func (c *MemConn) Close() {
+}
+
+func (c *MemConn) getUsers() ([]up.User, error) {
+	users := []up.User{}
+	for _, entry := range c.list(TNUsers) {
+		var user up.User
+		if err := user.Scan(entry); err != nil {
+			return nil, err
+		}
+		users = append(users, user)
+	}
+	return users, nil
+}
+
+func (c *MemConn) GetUsers() ([]up.User, error) {
+	log.Debug("")
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	return c.getUsers()
+}
+
+func (c *MemConn) PutUser(userName string) (bool, error) {
+	log.Debug("userName: %+v", userName)
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	users, err := c.getUsers()
+	if err != nil {
+		return false, err
+	}
+	up.OrderUsersByAscendingID(users)
+	userID, isNewUser := up.GetUserID(userName, users)
+	if isNewUser {
+		usr := up.User{ID: userID, Name: userName}
+		if _, err := c.put(TNUsers, strconv.Itoa(userID), usr); err != nil {
+			return isNewUser, err
+		}
+	}
+	return isNewUser, nil
+}
+
+func (c *MemConn) RestoreUser(user up.User) error {
+	log.Debug("user: %+v", user)
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	users, err := c.getUsers()
+	if err != nil {
+		return err
+	}
+	if stored, err := checkRestoredUser(user, users); err != nil || stored {
+		return err
+	}
+	_, err = c.put(TNUsers, strconv.Itoa(user.ID), user)
+	return err
+}
+
+func (c *MemConn) GetDNSConfig() (uc.DNSClient, error) {
+	log.Debug("")
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	var dnsConfig uc.DNSClient
+	err := c.get(TNDNSconfig, TNDNSconfig, &dnsConfig)
+	return dnsConfig, err
+}
+
+func (c *MemConn) PutDNSConfig(dnsConfig uc.DNSClient) error {
+	log.Debug("")
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	_, err := c.put(TNDNSconfig, TNDNSconfig, dnsConfig)
+	return err
+}
+
+func (c *MemConn) GetHAProxyConfig() (upl.HAProxyClient, error) {
+	log.Debug("")
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	var haProxyClient upl.HAProxyClient
+	err := c.get(TNHAProxyconfig, TNHAProxyconfig, &haProxyClient)
+	return haProxyClient, err
+}
+
+func (c *MemConn) PutHAProxyConfig(haProxyClient upl.HAProxyClient) error {
+	log.Debug("")
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	_, err := c.put(TNHAProxyconfig, TNHAProxyconfig, haProxyClient)
+	return err
+}
+
+func (c *MemConn) getContainerLinks(typ, containerID string) (up.ContainerLinks, error) {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	var linksConfig up.ContainerLinks
+	err := c.get(typ, containerID, &linksConfig)
+	return linksConfig, err
+}
+
+func (c *MemConn) listContainerLinks(typ string) ([]up.ContainerLinks, error) {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	containersLinks := []up.ContainerLinks{}
+	for _, entry := range c.list(typ) {
+		var containerLinks up.ContainerLinks
+		if err := containerLinks.Scan(entry); err != nil {
+			return nil, err
+		}
+		containersLinks = append(containersLinks, containerLinks)
+	}
+	return containersLinks, nil
+}
+
+func (c *MemConn) putContainerLinks(typ string, containerLinks up.ContainerLinks) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	_, err := c.put(typ, containerLinks.Container, containerLinks)
+	return err
+}
+
+func (c *MemConn) GetDockerLinksOfContainer(containerID string) (up.ContainerLinks, error) {
+	log.Debug("")
+	return c.getContainerLinks(TNLinksConfig, containerID)
+}
+
+func (c *MemConn) GetDockerLinksOfContainerTemp(containerName string) (up.ContainerLinks, error) {
+	log.Debug("")
+	return c.getContainerLinks(TNLinksConfigTemp, containerName)
+}
+
+func (c *MemConn) GetDockerLinks() ([]up.ContainerLinks, error) {
+	log.Debug("")
+	return c.listContainerLinks(TNLinksConfig)
+}
+
+func (c *MemConn) GetDockerLinksTemp() ([]up.ContainerLinks, error) {
+	log.Debug("")
+	return c.listContainerLinks(TNLinksConfigTemp)
+}
+
+func (c *MemConn) PutDockerLinksOfContainer(containerLinks up.ContainerLinks) error {
+	log.Debug("")
+	return c.putContainerLinks(TNLinksConfig, containerLinks)
+}
+
+func (c *MemConn) PutDockerLinksOfContainerTemp(containerLinks up.ContainerLinks) error {
+	log.Debug("")
+	return c.putContainerLinks(TNLinksConfigTemp, containerLinks)
+}
+
+func (c *MemConn) getContainerPortBindings(typ, containerID string) (up.ContainerPortBindings, error) {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	var portBindings up.ContainerPortBindings
+	err := c.get(typ, containerID, &portBindings)
+	return portBindings, err
+}
+
+func (c *MemConn) listContainerPortBindings(typ string) ([]up.ContainerPortBindings, error) {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	containersPortBindings := []up.ContainerPortBindings{}
+	for _, entry := range c.list(typ) {
+		var portBindings up.ContainerPortBindings
+		if err := portBindings.Scan(entry); err != nil {
+			return nil, err
+		}
+		containersPortBindings = append(containersPortBindings, portBindings)
+	}
+	return containersPortBindings, nil
+}
+
+func (c *MemConn) putContainerPortBindings(typ string, portBindings up.ContainerPortBindings) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	_, err := c.put(typ, portBindings.Container, portBindings)
+	return err
+}
+
+func (c *MemConn) GetDockerPortBindingsOfContainer(containerID string) (up.ContainerPortBindings, error) {
+	log.Debug("")
+	return c.getContainerPortBindings(TNPortBindingsConfig, containerID)
+}
+
+func (c *MemConn) GetDockerPortBindingsOfContainerTemp(containerID string) (up.ContainerPortBindings, error) {
+	log.Debug("")
+	return c.getContainerPortBindings(TNPortBindingsConfigTemp, containerID)
+}
+
+func (c *MemConn) GetDockerPortBindings() ([]up.ContainerPortBindings, error) {
+	log.Debug("")
+	return c.listContainerPortBindings(TNPortBindingsConfig)
+}
+
+func (c *MemConn) GetDockerPortBindingsTemp() ([]up.ContainerPortBindings, error) {
+	log.Debug("")
+	return c.listContainerPortBindings(TNPortBindingsConfigTemp)
+}
+
+func (c *MemConn) PutDockerPortBindingsOfContainer(portBindings up.ContainerPortBindings) error {
+	log.Debug("")
+	return c.putContainerPortBindings(TNPortBindingsConfig, portBindings)
+}
+
+func (c *MemConn) PutDockerPortBindingsOfContainerTemp(portBindings up.ContainerPortBindings) error {
+	log.Debug("")
+	return c.putContainerPortBindings(TNPortBindingsConfigTemp, portBindings)
+}
+
+func (c *MemConn) PutPolicy(policies up.PolicySource) error {
+	log.Debug("policies %+v\n", policies)
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	for _, policy := range policies.Policies {
+		policy.Owner = url.QueryEscape(policies.Owner)
+		// Same as the Elasticsearch implementation, Kubernetes policies have
+		// their ObjectReference automatically filled from the BodyObj.
+		policy.KubernetesConfig.ConvertBodyObjTo(&policy.KubernetesConfig.ObjectReference)
+		if _, err := c.put(TNPolicySource, policy.Name, policy); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// getPolicies returns all policies, that satisfy filter, grouped by owner in
+// the order each owner was first seen.
+func (c *MemConn) getPolicies(filter func(up.Policy) bool) ([]up.PolicySource, error) {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	policiesMap := make(map[string]*up.PolicySource)
+	owners := []string{}
+	for _, entry := range c.list(TNPolicySource) {
+		var dbPolicy up.Policy
+		if err := dbPolicy.Scan(entry); err != nil {
+			return nil, err
+		}
+		if !filter(dbPolicy) {
+			continue
+		}
+		owner := dbPolicy.Owner
+		if _, ok := policiesMap[owner]; !ok {
+			policiesMap[owner] = &up.PolicySource{Owner: owner}
+			owners = append(owners, owner)
+		}
+		policiesMap[owner].Policies = append(policiesMap[owner].Policies, dbPolicy)
+	}
+	policies := []up.PolicySource{}
+	for _, owner := range owners {
+		policies = append(policies, *policiesMap[owner])
+	}
+	return policies, nil
+}
+
+func (c *MemConn) GetPolicies() ([]up.PolicySource, error) {
+	log.Debug("")
+	return c.getPolicies(func(up.Policy) bool { return true })
+}
+
+func (c *MemConn) GetPoliciesThatCovers(labels map[string]string) ([]up.PolicySource, error) {
+	log.Debug("")
+	return c.getPolicies(func(policy up.Policy) bool {
+		return policy.Coverage.Covers(labels)
+	})
+}
+
+func (c *MemConn) PutIP(ip net.IP) error {
+	log.Debug("ipStr %+v", ip.String())
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	exists, err := c.put(TNIPsinUse, ip.String(), up.IP{IPAddress: up.IPAddress(ip)})
+	if err != nil {
+		return err
+	}
+	if exists {
+		return ErrIPInUse
+	}
+	return nil
+}
+
+func (c *MemConn) DeleteIP(ip net.IP) error {
+	log.Debug("ipStr %+v", ip.String())
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.delete(TNIPsinUse, ip.String())
+}
+
+func (c *MemConn) GetIPs() ([]net.IP, error) {
+	log.Debug("")
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	ips := []net.IP{}
+	for _, entry := range c.list(TNIPsinUse) {
+		var dbIP up.IP
+		if err := dbIP.Scan(entry); err != nil {
+			return nil, err
+		}
+		ips = append(ips, net.IP(dbIP.IPAddress))
+	}
+	return ips, nil
+}
+
+func (c *MemConn) PutEndpoint(endpoint up.Endpoint) error {
+	log.Debug("Endpoint %+v\n", endpoint)
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	_, err := c.put(TNEndpoint, endpoint.Container, endpoint)
+	return err
+}
+
+func (c *MemConn) DeleteEndpoint(containerID string) error {
+	log.Debug("containerID %+v\n", containerID)
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.delete(TNEndpoint, containerID)
+}
+
+func (c *MemConn) GetEndpoint(containerID string) (up.Endpoint, error) {
+	log.Debug("")
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	var endpoint up.Endpoint
+	err := c.get(TNEndpoint, containerID, &endpoint)
+	return endpoint, err
+}
+
+func (c *MemConn) GetEndpoints() ([]up.Endpoint, error) {
+	log.Debug("")
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	endpoints := []up.Endpoint{}
+	for _, entry := range c.list(TNEndpoint) {
+		var endpoint up.Endpoint
+		if err := endpoint.Scan(entry); err != nil {
+			return nil, err
+		}
+		endpoints = append(endpoints, endpoint)
+	}
+	return endpoints, nil
+}
+
+func (c *MemConn) PutNode(node up.Node) error {
+	log.Debug("Node %+v", node)
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	_, err := c.put(TNNodes, node.IP, node)
+	return err
+}
+
+func (c *MemConn) DeleteNode(nodeIP string) error {
+	log.Debug("nodeIP %+v", nodeIP)
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.delete(TNNodes, nodeIP)
+}
+
+func (c *MemConn) GetNodes() ([]up.Node, error) {
+	log.Debug("")
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	nodes := []up.Node{}
+	for _, entry := range c.list(TNNodes) {
+		var node up.Node
+		if err := node.Scan(entry); err != nil {
+			return nil, err
+		}
+		nodes = append(nodes, node)
+	}
+	return nodes, nil
+}
+
+func (c *MemConn) PutAuditEntry(entry up.AuditEntry) error {
+	log.Debug("AuditEntry %+v", entry)
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	// Entries are never removed so their ids keep the insertion order.
+	id := fmt.Sprintf("%020d", len(c.tables[TNAudit]))
+	_, err := c.put(TNAudit, id, entry)
+	return err
+}
+
+func (c *MemConn) GetAuditEntries(filter up.AuditFilter) ([]up.AuditEntry, error) {
+	log.Debug("filter %+v", filter)
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	entries := []up.AuditEntry{}
+	for _, source := range c.list(TNAudit) {
+		var entry up.AuditEntry
+		if err := entry.Scan(source); err != nil {
+			return nil, err
+		}
+		if filter.Matches(entry) {
+			entries = append(entries, entry)
+		}
+	}
+	return entries, nil
+}
+
+func (c *MemConn) PutEndpointRecord(record up.EndpointRecord) error {
+	log.Debug("EndpointRecord %+v", record)
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	// Records are never removed so their ids keep the insertion order.
+	id := fmt.Sprintf("%020d", len(c.tables[TNEndpointHistory]))
+	_, err := c.put(TNEndpointHistory, id, record)
+	return err
+}
+
+func (c *MemConn) GetEndpointRecords(filter up.EndpointRecordFilter) ([]up.EndpointRecord, error) {
+	log.Debug("filter %+v", filter)
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	records := []up.EndpointRecord{}
+	for _, source := range c.list(TNEndpointHistory) {
+		var record up.EndpointRecord
+		if err := record.Scan(source); err != nil {
+			return nil, err
+		}
+		if filter.Matches(record) {
+			records = append(records, record)
+		}
+	}
+	sort.Stable(endpointRecordsByTime(records))
+	return records, nil
+}
+
+func (c *MemConn) GetLatestEndpointRecord(filter up.EndpointRecordFilter) (up.EndpointRecord, bool, error) {
+	records, err := c.GetEndpointRecords(filter)
+	if err != nil || len(records) == 0 {
+		return up.EndpointRecord{}, false, err
+	}
+	return records[len(records)-1], true, nil
+}
+
+// endpointRecordsByTime sorts endpoint records by timestamp.
+type endpointRecordsByTime []up.EndpointRecord
+
+func (r endpointRecordsByTime) Len() int           { return len(r) }
+func (r endpointRecordsByTime) Swap(i, j int)      { r[i], r[j] = r[j], r[i] }
+func (r endpointRecordsByTime) Less(i, j int) bool { return r[i].Timestamp.Before(r[j].Timestamp) }
+
+func (c *MemConn) UpdateNetworks(update func(*up.Networks) error) error {
+	log.Debug("")
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	networks := up.Networks{}
+	for _, entry := range c.list(TNNetworks) {
+		var network up.Network
+		if err := network.Scan(entry); err != nil {
+			return err
+		}
+		networks.Networks = append(networks.Networks, network)
+	}
+	before := append([]up.Network{}, networks.Networks...)
+	if err := update(&networks); err != nil {
+		return err
+	}
+	ops := []memOp{}
+	for _, network := range before {
+		if _, ok := networks.Get(network.Name); !ok {
+			ops = append(ops, memOp{Table: TNNetworks, ID: network.Name})
+		}
+	}
+	for _, network := range networks.Networks {
+		op, err := putOp(TNNetworks, network.Name, network)
+		if err != nil {
+			return err
+		}
+		ops = append(ops, op)
+	}
+	return c.apply(ops...)
+}
+
+func (c *MemConn) PutNetwork(network up.Network) error {
+	log.Debug("Network %+v", network)
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	_, err := c.put(TNNetworks, network.Name, network)
+	return err
+}
+
+func (c *MemConn) DeleteNetwork(name string) error {
+	log.Debug("name %+v", name)
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.delete(TNNetworks, name)
+}
+
+func (c *MemConn) GetNetwork(name string) (up.Network, error) {
+	log.Debug("name %+v", name)
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	var network up.Network
+	err := c.get(TNNetworks, name, &network)
+	return network, err
+}
+
+func (c *MemConn) GetNetworks() ([]up.Network, error) {
+	log.Debug("")
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	networks := []up.Network{}
+	for _, entry := range c.list(TNNetworks) {
+		var network up.Network
+		if err := network.Scan(entry); err != nil {
+			return nil, err
+		}
+		networks = append(networks, network)
+	}
+	return networks, nil
+}
+
+func (c *MemConn) PutRouter(router up.Router) error {
+	log.Debug("Router %+v", router)
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	_, err := c.put(TNRouters, strconv.Itoa(router.Namespace), router)
+	return err
+}
+
+func (c *MemConn) GetRouters() ([]up.Router, error) {
+	log.Debug("")
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	routers := []up.Router{}
+	for _, entry := range c.list(TNRouters) {
+		var router up.Router
+		if err := router.Scan(entry); err != nil {
+			return nil, err
+		}
+		routers = append(routers, router)
+	}
+	return routers, nil
+}
+
+func (c *MemConn) PutEgress(egress up.Egress) error {
+	log.Debug("Egress %+v", egress)
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	_, err := c.put(TNEgress, egress.Name, egress)
+	return err
+}
+
+func (c *MemConn) DeleteEgress(name string) error {
+	log.Debug("name %+v", name)
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.delete(TNEgress, name)
+}
+
+func (c *MemConn) GetEgresses() ([]up.Egress, error) {
+	log.Debug("")
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	egresses := []up.Egress{}
+	for _, entry := range c.list(TNEgress) {
+		var egress up.Egress
+		if err := egress.Scan(entry); err != nil {
+			return nil, err
+		}
+		egresses = append(egresses, egress)
+	}
+	return egresses, nil
+}
+
+func (c *MemConn) PutQuota(quota up.Quota) error {
+	log.Debug("Quota %+v", quota)
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	_, err := c.put(TNQuotas, quota.Name, quota)
+	return err
+}
+
+func (c *MemConn) DeleteQuota(name string) error {
+	log.Debug("name %+v", name)
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.delete(TNQuotas, name)
+}
+
+func (c *MemConn) GetQuotas() ([]up.Quota, error) {
+	log.Debug("")
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	quotas := []up.Quota{}
+	for _, entry := range c.list(TNQuotas) {
+		var quota up.Quota
+		if err := quota.Scan(entry); err != nil {
+			return nil, err
+		}
+		quotas = append(quotas, quota)
+	}
+	return quotas, nil
+}
+
+func (c *MemConn) PutHostPort(hostPort up.HostPort) error {
+	log.Debug("HostPort %+v", hostPort)
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	var other up.HostPort
+	if err := c.get(TNHostPorts, hostPort.Key(), &other); err != nil {
+		return err
+	}
+	if other.Container != "" && other.Container != hostPort.Container {
+		return ErrHostPortInUse
+	}
+	_, err := c.put(TNHostPorts, hostPort.Key(), hostPort)
+	return err
+}
+
+func (c *MemConn) DeleteHostPort(key string) error {
+	log.Debug("key %+v", key)
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.delete(TNHostPorts, key)
+}
+
+func (c *MemConn) GetHostPorts() ([]up.HostPort, error) {
+	log.Debug("")
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	hostPorts := []up.HostPort{}
+	for _, entry := range c.list(TNHostPorts) {
+		var hostPort up.HostPort
+		if err := hostPort.Scan(entry); err != nil {
+			return nil, err
+		}
+		hostPorts = append(hostPorts, hostPort)
+	}
+	return hostPorts, nil
+}
+
+func (c *MemConn) PutContainerUsage(usage up.ContainerUsage) error {
+	log.Debug("ContainerUsage %+v", usage)
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	_, err := c.put(TNQuotaUsage, usage.Container, usage)
+	return err
+}
+
+func (c *MemConn) DeleteContainerUsage(containerID string) error {
+	log.Debug("containerID %+v", containerID)
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.delete(TNQuotaUsage, containerID)
+}
+
+func (c *MemConn) GetContainerUsages() ([]up.ContainerUsage, error) {
+	log.Debug("")
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	usages := []up.ContainerUsage{}
+	for _, entry := range c.list(TNQuotaUsage) {
+		var usage up.ContainerUsage
+		if err := usage.Scan(entry); err != nil {
+			return nil, err
+		}
+		usages = append(usages, usage)
+	}
+	return usages, nil
+}
+
+func (c *MemConn) UpdateQuotaReservations(update func(*up.QuotaReservations, []up.ContainerUsage) error) error {
+	log.Debug("")
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	reservations := up.QuotaReservations{}
+	if err := c.get(TNQuotaReservations, TNQuotaReservations, &reservations); err != nil {
+		return err
+	}
+	usages := []up.ContainerUsage{}
+	for _, entry := range c.list(TNQuotaUsage) {
+		var usage up.ContainerUsage
+		if err := usage.Scan(entry); err != nil {
+			return err
+		}
+		usages = append(usages, usage)
+	}
+	if err := update(&reservations, usages); err != nil {
+		return err
+	}
+	_, err := c.put(TNQuotaReservations, TNQuotaReservations, reservations)
+	return err
+}
+
+func (c *MemConn) UpdateServiceSlots(service string, update func(*up.ServiceSlots) error) error {
+	log.Debug("service %+v", service)
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	slots := up.ServiceSlots{Service: service}
+	if err := c.get(TNServiceSlots, service, &slots); err != nil {
+		return err
+	}
+	if err := update(&slots); err != nil {
+		return err
+	}
+	if len(slots.Slots) == 0 {
+		return c.delete(TNServiceSlots, service)
+	}
+	_, err := c.put(TNServiceSlots, service, slots)
+	return err
+}
+
+func (c *MemConn) GetServiceSlots() ([]up.ServiceSlots, error) {
+	log.Debug("")
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	services := []up.ServiceSlots{}
+	for _, entry := range c.list(TNServiceSlots) {
+		var slots up.ServiceSlots
+		if err := slots.Scan(entry); err != nil {
+			return nil, err
+		}
+		services = append(services, slots)
+	}
+	return services, nil
+}
+
+func (c *MemConn) UpdateIdentities(update func(*up.Identities) error) error {
+	log.Debug("")
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	identities := up.Identities{}
+	if err := c.get(TNIdentities, TNIdentities, &identities); err != nil {
+		return err
+	}
+	if err := update(&identities); err != nil {
+		return err
+	}
+	_, err := c.put(TNIdentities, TNIdentities, identities)
+	return err
+}
+
+func (c *MemConn) GetIdentities() (up.Identities, error) {
+	log.Debug("")
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	identities := up.Identities{}
+	err := c.get(TNIdentities, TNIdentities, &identities)
+	return identities, err
+}
+
+func (c *MemConn) PutSecret(secret up.Secret) error {
+	log.Debug("Secret %s of %s", secret.Name, secret.Owner)
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	_, err := c.put(TNSecrets, secret.Name, secret)
+	return err
+}
+
+func (c *MemConn) DeleteSecret(name string) error {
+	log.Debug("name %+v", name)
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.delete(TNSecrets, name)
+}
+
+func (c *MemConn) GetSecret(name string) (up.Secret, error) {
+	log.Debug("name %+v", name)
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	var secret up.Secret
+	err := c.get(TNSecrets, name, &secret)
+	return secret, err
+}
+
+func (c *MemConn) GetSecrets() ([]up.Secret, error) {
+	log.Debug("")
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	secrets := []up.Secret{}
+	for _, entry := range c.list(TNSecrets) {
+		var secret up.Secret
+		if err := secret.Scan(entry); err != nil {
+			return nil, err
+		}
+		secrets = append(secrets, secret)
+	}
+	return secrets, nil
+}
+
+func (c *MemConn) PutSubscription(subscription up.Subscription) error {
+	log.Debug("Subscription %+v", subscription)
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	_, err := c.put(TNSubscriptions, subscription.Name, subscription)
+	return err
+}
+
+func (c *MemConn) DeleteSubscription(name string) error {
+	log.Debug("name %+v", name)
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.delete(TNSubscriptions, name)
+}
+
+func (c *MemConn) GetSubscriptions() ([]up.Subscription, error) {
+	log.Debug("")
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	subscriptions := []up.Subscription{}
+	for _, entry := range c.list(TNSubscriptions) {
+		var subscription up.Subscription
+		if err := subscription.Scan(entry); err != nil {
+			return nil, err
+		}
+		subscriptions = append(subscriptions, subscription)
+	}
+	return subscriptions, nil
+}
@@ -0,0 +1,418 @@
+package db
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	uc "github.com/cilium-team/cilium/cilium/utils/comm"
+	up "github.com/cilium-team/cilium/cilium/utils/profile"
+)
+
+func TestMemConnUsers(t *testing.T) {
+	c := NewMemConn()
+	for _, name := range []string{"root", "foo", "root"} {
+		if _, err := c.PutUser(name); err != nil {
+			t.Fatalf("error while putting user %s: %s", name, err)
+		}
+	}
+	users, err := c.GetUsers()
+	if err != nil {
+		t.Fatalf("error while getting users: %s", err)
+	}
+	up.OrderUsersByAscendingID(users)
+	if want := []up.User{{ID: 1, Name: "root"}, {ID: 2, Name: "foo"}}; !reflect.DeepEqual(users, want) {
+		t.Errorf("invalid users:\ngot  %+v\nwant %+v", users, want)
+	}
+}
+
+func TestMemConnRestoreUser(t *testing.T) {
+	c := NewMemConn()
+	for _, user := range []up.User{{ID: 5, Name: "foo"}, {ID: 5, Name: "foo"}} {
+		if err := c.RestoreUser(user); err != nil {
+			t.Fatalf("error while restoring user %+v: %s", user, err)
+		}
+	}
+	if err := c.RestoreUser(up.User{ID: 6, Name: "foo"}); err == nil {
+		t.Errorf("restored a user with the name of another user")
+	}
+	if err := c.RestoreUser(up.User{ID: 5, Name: "bar"}); err == nil {
+		t.Errorf("restored a user with the ID of another user")
+	}
+	if _, err := c.PutUser("bar"); err != nil {
+		t.Fatalf("error while putting user bar: %s", err)
+	}
+	users, err := c.GetUsers()
+	if err != nil {
+		t.Fatalf("error while getting users: %s", err)
+	}
+	up.OrderUsersByAscendingID(users)
+	if want := []up.User{{ID: 5, Name: "foo"}, {ID: 6, Name: "bar"}}; !reflect.DeepEqual(users, want) {
+		t.Errorf("invalid users:\ngot  %+v\nwant %+v", users, want)
+	}
+}
+
+func TestMemConnIPs(t *testing.T) {
+	c := NewMemConn()
+	ip := net.ParseIP("f00d::1")
+	if err := c.PutIP(ip); err != nil {
+		t.Fatalf("error while putting IP: %s", err)
+	}
+	if err := c.PutIP(ip); err != ErrIPInUse {
+		t.Errorf("invalid error:\ngot  %v\nwant %v", err, ErrIPInUse)
+	}
+	if err := c.DeleteIP(ip); err != nil {
+		t.Fatalf("error while deleting IP: %s", err)
+	}
+	if err := c.PutIP(ip); err != nil {
+		t.Errorf("error while putting a freed IP: %s", err)
+	}
+}
+
+func TestMemConnHostPorts(t *testing.T) {
+	c := NewMemConn()
+	hostPort := up.HostPort{HostIP: "192.168.50.10", HostPort: 8080, Protocol: "tcp", Container: "a", IP: "10.1.0.5", Port: 80}
+	if err := c.PutHostPort(hostPort); err != nil {
+		t.Fatalf("error while putting host port: %s", err)
+	}
+	// The same container can publish it again, other containers can't.
+	if err := c.PutHostPort(hostPort); err != nil {
+		t.Errorf("error while putting host port again: %s", err)
+	}
+	other := hostPort
+	other.Container = "b"
+	if err := c.PutHostPort(other); err != ErrHostPortInUse {
+		t.Errorf("invalid error:\ngot  %v\nwant %v", err, ErrHostPortInUse)
+	}
+	if err := c.DeleteHostPort(hostPort.Key()); err != nil {
+		t.Fatalf("error while deleting host port: %s", err)
+	}
+	if err := c.PutHostPort(other); err != nil {
+		t.Errorf("error while putting a freed host port: %s", err)
+	}
+}
+
+func TestMemConnConcurrentIPs(t *testing.T) {
+	c := NewMemConn()
+	ip := net.ParseIP("f00d::1")
+	var (
+		wg    sync.WaitGroup
+		mutex sync.Mutex
+		won   int
+	)
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := c.PutIP(ip); err == nil {
+				mutex.Lock()
+				won++
+				mutex.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	if won != 1 {
+		t.Errorf("invalid number of successful reservations:\ngot  %d\nwant %d", won, 1)
+	}
+}
+
+func TestMemConnPoliciesThatCovers(t *testing.T) {
+	c := NewMemConn()
+	policySource := up.PolicySource{
+		Owner: "root",
+		Policies: []up.Policy{
+			{Name: "web", Coverage: up.Coverage{Labels: map[string]string{"app": "web"}}},
+			{Name: "db", Coverage: up.Coverage{Labels: map[string]string{"app": "db"}}},
+		},
+	}
+	if err := c.PutPolicy(policySource); err != nil {
+		t.Fatalf("error while putting policies: %s", err)
+	}
+	got, err := c.GetPoliciesThatCovers(map[string]string{"app": "web"})
+	if err != nil {
+		t.Fatalf("error while getting policies: %s", err)
+	}
+	if len(got) != 1 || len(got[0].Policies) != 1 || got[0].Policies[0].Name != "web" {
+		t.Errorf("invalid policies:\ngot  %+v\nwant only policy %s", got, "web")
+	}
+}
+
+func TestMemConnFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cilium-memdb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "db.json")
+
+	c, err := NewMemConnFile(file)
+	if err != nil {
+		t.Fatalf("error while creating database: %s", err)
+	}
+	dnsConfig := uc.DNSClient{IP: "192.168.50.1", Port: "80"}
+	if err := c.PutDNSConfig(dnsConfig); err != nil {
+		t.Fatalf("error while putting DNS config: %s", err)
+	}
+
+	c, err = NewMemConnFile(file)
+	if err != nil {
+		t.Fatalf("error while reopening database: %s", err)
+	}
+	got, err := c.GetDNSConfig()
+	if err != nil {
+		t.Fatalf("error while getting DNS config: %s", err)
+	}
+	if got != dnsConfig {
+		t.Errorf("invalid DNS config:\ngot  %+v\nwant %+v", got, dnsConfig)
+	}
+}
+
+func TestMemConnFileReplay(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cilium-memdb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "db.json")
+
+	c, err := NewMemConnFile(file)
+	if err != nil {
+		t.Fatalf("error while creating database: %s", err)
+	}
+	for i := 0; i < 3*minCompactionOps; i++ {
+		if err := c.PutNode(up.Node{Name: "node", IP: strconv.Itoa(i % 10)}); err != nil {
+			t.Fatalf("error while putting node: %s", err)
+		}
+	}
+	if err := c.DeleteNode("0"); err != nil {
+		t.Fatalf("error while deleting node: %s", err)
+	}
+	if err := c.PutDNSConfig(uc.DNSClient{IP: "192.168.50.1", Port: "80"}); err != nil {
+		t.Fatalf("error while putting DNS config: %s", err)
+	}
+	if err := c.Flush(configTables...); err != nil {
+		t.Fatalf("error while flushing config: %s", err)
+	}
+	if c.ops > minCompactionOps+1 {
+		t.Errorf("file wasn't compacted: %d operations", c.ops)
+	}
+
+	// An operation torn by a crash is ignored.
+	f, err := os.OpenFile(file, os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString(`{"table":"nodes","id":"1"`)
+	f.Close()
+
+	reopened, err := NewMemConnFile(file)
+	if err != nil {
+		t.Fatalf("error while reopening database: %s", err)
+	}
+	if !reflect.DeepEqual(reopened.tables, c.tables) {
+		t.Errorf("invalid tables:\ngot  %v\nwant %v", reopened.tables, c.tables)
+	}
+}
+
+func TestMemConnFileWriteError(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cilium-memdb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	c, err := NewMemConnFile(filepath.Join(dir, "db.json"))
+	if err != nil {
+		t.Fatalf("error while creating database: %s", err)
+	}
+	c.journal.Close()
+	if err := c.PutDNSConfig(uc.DNSClient{IP: "192.168.50.1", Port: "80"}); err == nil {
+		t.Fatal("DNS config was put without being stored")
+	}
+	if got, err := c.GetDNSConfig(); err != nil || got.IP != "" {
+		t.Errorf("DNS config changed without being stored: %+v, %v", got, err)
+	}
+}
+
+func TestMemConnExportImport(t *testing.T) {
+	c1 := NewMemConn()
+	if err := Import(c1, newTestArchive()); err != nil {
+		t.Fatalf("error while importing archive: %s", err)
+	}
+	want, err := Export(c1)
+	if err != nil {
+		t.Fatalf("error while exporting archive: %s", err)
+	}
+	if errs := want.Check(); len(errs) != 0 {
+		t.Errorf("invalid errors:\ngot  %s\nwant %s", errs, []error{})
+	}
+	if users := newTestArchive().Users; !reflect.DeepEqual(want.Users, users) {
+		t.Errorf("invalid users:\ngot  %+v\nwant %+v", want.Users, users)
+	}
+
+	c2 := NewMemConn()
+	if err := Import(c2, want); err != nil {
+		t.Fatalf("error while importing archive: %s", err)
+	}
+	got, err := Export(c2)
+	if err != nil {
+		t.Fatalf("error while exporting archive: %s", err)
+	}
+	got.CreatedAt = want.CreatedAt
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("invalid archive:\ngot  %+v\nwant %+v", got, want)
+	}
+}
+
+func TestMemConnQuotas(t *testing.T) {
+	c := NewMemConn()
+	quota := up.Quota{Name: "web", Owner: "foo", MaxMemory: 1 << 30}
+	if err := c.PutQuota(quota); err != nil {
+		t.Fatalf("error while storing quota: %s", err)
+	}
+	usage := up.ContainerUsage{Container: "1234", Owners: []string{"foo"}, Memory: 1 << 20}
+	if err := c.PutContainerUsage(usage); err != nil {
+		t.Fatalf("error while storing container usage: %s", err)
+	}
+	quotas, err := c.GetQuotas()
+	if err != nil || !reflect.DeepEqual(quotas, []up.Quota{quota}) {
+		t.Errorf("invalid quotas:\ngot  %+v, %v\nwant %+v", quotas, err, []up.Quota{quota})
+	}
+	usages, err := c.GetContainerUsages()
+	if err != nil || !reflect.DeepEqual(usages, []up.ContainerUsage{usage}) {
+		t.Errorf("invalid container usages:\ngot  %+v, %v\nwant %+v", usages, err, []up.ContainerUsage{usage})
+	}
+
+	c.DeleteQuota(quota.Name)
+	c.DeleteContainerUsage(usage.Container)
+	if quotas, _ := c.GetQuotas(); len(quotas) != 0 {
+		t.Errorf("invalid quotas after deletion:\ngot  %+v\nwant %+v", quotas, []up.Quota{})
+	}
+	if usages, _ := c.GetContainerUsages(); len(usages) != 0 {
+		t.Errorf("invalid container usages after deletion:\ngot  %+v\nwant %+v", usages, []up.ContainerUsage{})
+	}
+}
+
+func TestMemConnUpdateServiceSlotsConcurrently(t *testing.T) {
+	c := NewMemConn()
+	const maxScale, creates = 3, 20
+	expiration := time.Now().Add(time.Minute)
+	var (
+		wg       sync.WaitGroup
+		mutex    sync.Mutex
+		reserved int
+	)
+	for i := 0; i < creates; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			ok := false
+			if err := c.UpdateServiceSlots("web", func(ss *up.ServiceSlots) error {
+				ok = ss.Reserve(strconv.Itoa(i), maxScale, expiration)
+				return nil
+			}); err != nil {
+				t.Errorf("error while reserving slot %d: %s", i, err)
+			}
+			if ok {
+				mutex.Lock()
+				reserved++
+				mutex.Unlock()
+			}
+		}(i)
+	}
+	wg.Wait()
+	if reserved != maxScale {
+		t.Errorf("invalid number of reservations:\ngot  %d\nwant %d", reserved, maxScale)
+	}
+	services, err := c.GetServiceSlots()
+	if err != nil || len(services) != 1 || len(services[0].Slots) != maxScale {
+		t.Errorf("invalid service slots:\ngot  %+v, %v\nwant %d slots of service web", services, err, maxScale)
+	}
+
+	errUpdate := fmt.Errorf("update failed")
+	if err := c.UpdateServiceSlots("web", func(ss *up.ServiceSlots) error {
+		ss.Slots = nil
+		return errUpdate
+	}); err != errUpdate {
+		t.Errorf("invalid error:\ngot  %v\nwant %v", err, errUpdate)
+	}
+	if services, _ := c.GetServiceSlots(); len(services) != 1 || len(services[0].Slots) != maxScale {
+		t.Errorf("slots modified by a failed update: %+v", services)
+	}
+
+	c.UpdateServiceSlots("web", func(ss *up.ServiceSlots) error {
+		ss.Slots = nil
+		return nil
+	})
+	if services, _ := c.GetServiceSlots(); len(services) != 0 {
+		t.Errorf("invalid service slots after releasing all of them:\ngot  %+v\nwant %+v", services, []up.ServiceSlots{})
+	}
+}
+
+func TestMemConnSecrets(t *testing.T) {
+	c := NewMemConn()
+	secret := up.Secret{Name: "db/prod/password", Owner: "foo", Readers: []string{"bar"}, Ciphertext: "c2VjcmV0"}
+	if err := c.PutSecret(secret); err != nil {
+		t.Fatalf("error while storing secret: %s", err)
+	}
+	if got, err := c.GetSecret(secret.Name); err != nil || !reflect.DeepEqual(got, secret) {
+		t.Errorf("invalid secret:\ngot  %+v, %v\nwant %+v", got, err, secret)
+	}
+	if got, err := c.GetSecret("db/dev/password"); err != nil || got.Name != "" {
+		t.Errorf("invalid missing secret:\ngot  %+v, %v\nwant %+v", got, err, up.Secret{})
+	}
+	c.DeleteSecret(secret.Name)
+	if secrets, _ := c.GetSecrets(); len(secrets) != 0 {
+		t.Errorf("invalid secrets after deletion:\ngot  %+v\nwant %+v", secrets, []up.Secret{})
+	}
+}
+
+func TestMemConnSubscriptions(t *testing.T) {
+	c := NewMemConn()
+	subscription := up.Subscription{Name: "audit", URL: "https://audit.example.com/cilium", Types: []string{"request-denied"}}
+	if err := c.PutSubscription(subscription); err != nil {
+		t.Fatalf("error while putting subscription: %s", err)
+	}
+	if got, err := c.GetSubscriptions(); err != nil || !reflect.DeepEqual(got, []up.Subscription{subscription}) {
+		t.Errorf("invalid subscriptions:\ngot  %+v, %v\nwant %+v", got, err, []up.Subscription{subscription})
+	}
+	c.DeleteSubscription(subscription.Name)
+	if subscriptions, _ := c.GetSubscriptions(); len(subscriptions) != 0 {
+		t.Errorf("invalid subscriptions after deletion:\ngot  %+v\nwant %+v", subscriptions, []up.Subscription{})
+	}
+}
+
+func TestMemConnInitKeepsAuditAndHistory(t *testing.T) {
+	c := NewMemConn()
+	if err := c.PutAuditEntry(up.AuditEntry{Action: up.AuditPutUser}); err != nil {
+		t.Fatalf("error while putting audit entry: %s", err)
+	}
+	if err := c.PutEndpointRecord(up.EndpointRecord{Action: up.EndpointAssigned}); err != nil {
+		t.Fatalf("error while putting endpoint record: %s", err)
+	}
+	if err := c.Flush(append(configTables, stateTables...)...); err != nil {
+		t.Fatalf("error while flushing: %s", err)
+	}
+	entries, err := c.GetAuditEntries(up.AuditFilter{})
+	if err != nil {
+		t.Fatalf("error while getting audit entries: %s", err)
+	}
+	if len(entries) != 1 {
+		t.Errorf("invalid number of audit entries:\ngot  %d\nwant %d", len(entries), 1)
+	}
+	records, err := c.GetEndpointRecords(up.EndpointRecordFilter{})
+	if err != nil {
+		t.Fatalf("error while getting endpoint records: %s", err)
+	}
+	if len(records) != 1 {
+		t.Errorf("invalid number of endpoint records:\ngot  %d\nwant %d", len(records), 1)
+	}
+}
@@ -47,15 +47,31 @@ func (dc *DNSClient) SendToDNS(domains, ips []string) error {
 	}
 	for _, domain := range domains {
 		addrReq := "http://" + dc.IP + ":" + dc.Port + "/domain/" + domain
-		if err := sendRequest(addrReq, cDbytes); err != nil {
+		if err := sendRequest("PUT", addrReq, cDbytes); err != nil {
 			return err
 		}
 	}
 	return nil
 }
 
-func sendRequest(addrReq string, cDbytes []byte) error {
-	request, err := http.NewRequest("PUT", addrReq, bytes.NewBuffer(cDbytes))
+// DeleteFromDNS removes the given ips from each one of the given domains.
+func (dc *DNSClient) DeleteFromDNS(domains, ips []string) error {
+	ipsJSON := domainsList{Ips: ips}
+	cDbytes, err := json.Marshal(ipsJSON)
+	if err != nil {
+		return err
+	}
+	for _, domain := range domains {
+		addrReq := "http://" + dc.IP + ":" + dc.Port + "/domain/" + domain
+		if err := sendRequest("DELETE", addrReq, cDbytes); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func sendRequest(method, addrReq string, cDbytes []byte) error {
+	request, err := http.NewRequest(method, addrReq, bytes.NewBuffer(cDbytes))
 	if err != nil {
 		log.Debug("request %+v", request)
 		return err
@@ -8,7 +8,6 @@ import (
 
 	d "github.com/cilium-team/cilium/Godeps/_workspace/src/github.com/fsouza/go-dockerclient"
 	"github.com/cilium-team/cilium/Godeps/_workspace/src/github.com/op/go-logging"
-	dsamalba "github.com/cilium-team/cilium/Godeps/_workspace/src/github.com/samalba/dockerclient"
 )
 
 var log = logging.MustGetLogger("cilium")
@@ -21,11 +20,17 @@ type Docker struct {
 	*d.Client
 }
 
-func NewDockerClient() (cli Docker, err error) {
-	endpoint := os.Getenv("DOCKER_HOST")
-	if endpoint == "" {
-		endpoint = defaultEndpoint
+// DockerEndpoint returns the docker daemon endpoint set in DOCKER_HOST or the
+// default unix socket if it isn't set.
+func DockerEndpoint() string {
+	if endpoint := os.Getenv("DOCKER_HOST"); endpoint != "" {
+		return endpoint
 	}
+	return defaultEndpoint
+}
+
+func NewDockerClient() (cli Docker, err error) {
+	endpoint := DockerEndpoint()
 	path := os.Getenv("DOCKER_CERT_PATH")
 	if path != "" {
 		ca := fmt.Sprintf("%s/ca.pem", path)
@@ -38,24 +43,6 @@ func NewDockerClient() (cli Docker, err error) {
 	return
 }
 
-func NewDockerClientSamalba() (cli *dsamalba.DockerClient, err error) {
-	endpoint := os.Getenv("DOCKER_HOST")
-	if endpoint == "" {
-		endpoint = defaultEndpoint
-	}
-	path := os.Getenv("DOCKER_CERT_PATH")
-	if path != "" {
-		//		ca := fmt.Sprintf("%s/ca.pem", path)
-		//		cert := fmt.Sprintf("%s/cert.pem", path)
-		//		key := fmt.Sprintf("%s/key.pem", path)
-		log.Warning("DOCKER_CERT_PATH not available yet.")
-		cli, err = dsamalba.NewDockerClient(endpoint, nil)
-	} else {
-		cli, err = dsamalba.NewDockerClient(endpoint, nil)
-	}
-	return
-}
-
 func SplitLink(link string) (container, alias string) {
 	split := strings.Split(link, ":")
 	switch len(split) {
@@ -0,0 +1,189 @@
+package utils
+
+import (
+	"fmt"
+	"hash/fnv"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+
+	ucdb "github.com/cilium-team/cilium/cilium/utils/comm/db"
+	up "github.com/cilium-team/cilium/cilium/utils/profile"
+)
+
+// egressCookieFlag marks the cookies of the flows of the egress gateways.
+// Endpoints' cookies have, at most, 56 bits, the overlay peers' ones the 57th
+// bit and the routers' ones the 58th bit so they never collide with them.
+const egressCookieFlag = uint64(1) << 58
+
+var (
+	// installedEgress are the egress gateways installed on this node, by
+	// name.
+	installedEgress      = map[string]egressInstall{}
+	installedEgressMutex sync.Mutex
+)
+
+// egressInstall is the command that installed an egress gateway and the
+// subnets routed through its host port.
+type egressInstall struct {
+	command string
+	subnets []string
+}
+
+// egressCookie returns the cookie of the flows of the egress gateway with the
+// given name.
+func egressCookie(name string) uint64 {
+	h := fnv.New32a()
+	h.Write([]byte(name))
+	return egressCookieFlag | uint64(h.Sum32())
+}
+
+// SyncEgress installs, on DefaultBridge, the egress gateways stored in the
+// database and removes the ones that no longer are. Each gateway is installed
+// again if it, its namespace's router, or the fixed egress IPs of the local
+// endpoints changed. The scripts are set under 'ADD_EGRESS' and
+// 'REMOVE_EGRESS' environment variables.
+func SyncEgress(dbConn ucdb.Db) error {
+	egresses, err := dbConn.GetEgresses()
+	if err != nil {
+		return err
+	}
+	networks, err := dbConn.GetNetworks()
+	if err != nil {
+		return err
+	}
+	routers, err := dbConn.GetRouters()
+	if err != nil {
+		return err
+	}
+	endpoints, err := dbConn.GetEndpoints()
+	if err != nil {
+		return err
+	}
+
+	installs := map[string]egressInstall{}
+	routed := map[string]bool{}
+	for _, egress := range egresses {
+		egress, err := egress.Resolve(networks)
+		if err != nil {
+			log.Warning("Skipping egress gateway: %s", err)
+			continue
+		}
+		snats, err := egressSNATs(dbConn, egress, endpoints)
+		if err != nil {
+			return err
+		}
+		router, _ := up.RouterOf(routers, egress.Namespace)
+		for _, subnet := range egress.Subnets {
+			routed[subnet] = true
+		}
+		addEgressCmd := fmt.Sprintf("%s %s %#x %d '%s' '%s' '%s' '%s' '%s'", os.Getenv("ADD_EGRESS"),
+			egress.Name, egressCookie(egress.Name), egress.Namespace, strings.Join(egress.Subnets, " "),
+			strings.Join(egress.Destinations, " "), strings.Join(router.MACs(nil), ","), egress.Uplink,
+			strings.Join(snats, " "))
+		installs[egress.Name] = egressInstall{command: addEgressCmd, subnets: egress.Subnets}
+	}
+
+	installedEgressMutex.Lock()
+	defer installedEgressMutex.Unlock()
+	for name, installed := range installedEgress {
+		if _, ok := installs[name]; ok {
+			continue
+		}
+		// Subnets still routed by other gateways keep their routes.
+		subnets := []string{}
+		for _, subnet := range installed.subnets {
+			if !routed[subnet] {
+				subnets = append(subnets, subnet)
+			}
+		}
+		removeEgressCmd := fmt.Sprintf("%s %#x/-1 %s '%s'", os.Getenv("REMOVE_EGRESS"),
+			egressCookie(name), name, strings.Join(subnets, " "))
+		if _, err := execShCommand(removeEgressCmd); err != nil {
+			return fmt.Errorf("unable to remove egress gateway %s: %s", name, err)
+		}
+		log.Info("Egress gateway %s is removed", name)
+		delete(installedEgress, name)
+	}
+	for name, install := range installs {
+		if installedEgress[name].command == install.command {
+			continue
+		}
+		if _, err := execShCommand(install.command); err != nil {
+			return fmt.Errorf("unable to install egress gateway %s: %s", name, err)
+		}
+		log.Info("Egress gateway %s is installed", name)
+		installedEgress[name] = install
+	}
+	return nil
+}
+
+// egressSNATs returns the source and fixed egress IPs, 'SRC,IP', of the local
+// endpoints, from the given ones, that use the given egress gateway and whose
+// owners have a fixed egress IP.
+func egressSNATs(dbConn ucdb.Db, egress up.Egress, endpoints []up.Endpoint) ([]string, error) {
+	snats := []string{}
+	if len(egress.OwnerIPs) == 0 {
+		return snats, nil
+	}
+	for _, endpoint := range endpoints {
+		if endpoint.Node != os.Getenv("HOST_IP") || endpoint.Namespace != egress.Namespace ||
+			(endpoint.Bridge != "" && endpoint.Bridge != DefaultBridge) {
+			continue
+		}
+		policies, err := dbConn.GetPoliciesThatCovers(endpoint.Labels)
+		if err != nil {
+			return nil, err
+		}
+		owners := []string{}
+		for _, policy := range policies {
+			owners = append(owners, policy.Owner)
+		}
+		egressIP := egress.IPOf(owners)
+		if egressIP == "" {
+			continue
+		}
+		for _, ip := range endpoint.IPs {
+			if ip.To4() != nil && egress.Contains(ip) {
+				snats = append(snats, ip.String()+","+egressIP)
+			}
+		}
+	}
+	sort.Strings(snats)
+	return snats, nil
+}
+
+// FlushEgress removes, from this node, the flows and the NAT rules of all
+// egress gateways, even the ones installed before cilium restarted. Their
+// routes are left in place, SyncEgress replaces them.
+func FlushEgress() error {
+	installedEgressMutex.Lock()
+	defer installedEgressMutex.Unlock()
+	removeEgressCmd := fmt.Sprintf("%s %#x/%#x ''", os.Getenv("REMOVE_EGRESS"), egressCookieFlag, egressCookieFlag)
+	if _, err := execShCommand(removeEgressCmd); err != nil {
+		return fmt.Errorf("unable to remove egress gateways: %s", err)
+	}
+	installedEgress = map[string]egressInstall{}
+	return nil
+}
+
+// EgressesOf returns the egress gateways, resolved with their networks, of the
+// given namespace.
+func EgressesOf(dbConn ucdb.Db, namespace int) ([]up.Egress, error) {
+	egresses, err := dbConn.GetEgresses()
+	if err != nil {
+		return nil, err
+	}
+	networks, err := dbConn.GetNetworks()
+	if err != nil {
+		return nil, err
+	}
+	of := []up.Egress{}
+	for _, egress := range egresses {
+		if egress, err := egress.Resolve(networks); err == nil && egress.Namespace == namespace {
+			of = append(of, egress)
+		}
+	}
+	return of, nil
+}
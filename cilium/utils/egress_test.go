@@ -0,0 +1,70 @@
+package utils
+
+import (
+	"net"
+	"os"
+	"reflect"
+	"testing"
+
+	ucdb "github.com/cilium-team/cilium/cilium/utils/comm/db"
+	up "github.com/cilium-team/cilium/cilium/utils/profile"
+)
+
+func TestSyncEgress(t *testing.T) {
+	fdb := ucdb.NewMemConn()
+	if err := fdb.PutNetwork(up.Network{Name: "web", Subnets: []string{"10.1.0.0/24"}, Namespace: 2}); err != nil {
+		t.Fatal(err)
+	}
+	if err := fdb.PutPolicy(up.PolicySource{Owner: "alice", Policies: []up.Policy{
+		{Name: "web", Owner: "alice", Coverage: up.Coverage{Labels: map[string]string{"app": "web"}}},
+	}}); err != nil {
+		t.Fatal(err)
+	}
+	os.Setenv("HOST_IP", "192.168.50.10")
+	endpoints := []up.Endpoint{
+		{Container: "a", Labels: map[string]string{"app": "web"}, IPs: up.IPs{net.ParseIP("10.1.0.5")}, Node: "192.168.50.10", Namespace: 2},
+		{Container: "b", Labels: map[string]string{"app": "web"}, IPs: up.IPs{net.ParseIP("10.1.0.6")}, Node: "192.168.50.11", Namespace: 2},
+		{Container: "c", Labels: map[string]string{"app": "db"}, IPs: up.IPs{net.ParseIP("10.1.0.7")}, Node: "192.168.50.10", Namespace: 2},
+	}
+	for _, endpoint := range endpoints {
+		if err := fdb.PutEndpoint(endpoint); err != nil {
+			t.Fatal(err)
+		}
+	}
+	egress := up.Egress{Name: "web", Network: "web", Uplink: "eth0",
+		OwnerIPs: []up.OwnerIP{{Owner: "alice", IP: "192.168.50.100"}}}
+	if err := fdb.PutEgress(egress); err != nil {
+		t.Fatal(err)
+	}
+	os.Setenv("ADD_EGRESS", "/opt/backend/add-egress.sh")
+	os.Setenv("REMOVE_EGRESS", "/opt/backend/remove-egress.sh")
+	cmds := []string{}
+	execShCommand = func(strCmd string) ([]byte, error) {
+		cmds = append(cmds, strCmd)
+		return nil, nil
+	}
+
+	// Only local endpoints of the owner get the fixed egress IP and the
+	// gateway is only installed again when it changes.
+	for i := 0; i < 2; i++ {
+		if err := SyncEgress(fdb); err != nil {
+			t.Fatalf("error while syncing egress gateways: %s", err)
+		}
+	}
+	want := []string{"/opt/backend/add-egress.sh web 0x40000000fd9bc91 2 '10.1.0.0/24' '0.0.0.0/0' " +
+		"'dd:dd:dd:dd:dd:dd' 'eth0' '10.1.0.5,192.168.50.100'"}
+	if !reflect.DeepEqual(cmds, want) {
+		t.Errorf("invalid commands:\ngot  %v\nwant %v", cmds, want)
+	}
+
+	if err := fdb.DeleteEgress("web"); err != nil {
+		t.Fatal(err)
+	}
+	if err := SyncEgress(fdb); err != nil {
+		t.Fatalf("error while syncing egress gateways: %s", err)
+	}
+	want = append(want, "/opt/backend/remove-egress.sh 0x40000000fd9bc91/-1 web '10.1.0.0/24'")
+	if !reflect.DeepEqual(cmds, want) {
+		t.Errorf("invalid commands after removal:\ngot  %v\nwant %v", cmds, want)
+	}
+}
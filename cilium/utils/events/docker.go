@@ -0,0 +1,112 @@
+package events
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+
+	uc "github.com/cilium-team/cilium/cilium/utils/comm"
+)
+
+// dockerEvent is an event as sent by the docker daemon. Newer daemons send
+// the type, action and actor besides the older status, id and from fields.
+type dockerEvent struct {
+	Status   string `json:"status"`
+	ID       string `json:"id"`
+	From     string `json:"from"`
+	Time     int64  `json:"time"`
+	TimeNano int64  `json:"timeNano"`
+	Type     string `json:"Type"`
+	Action   string `json:"Action"`
+	Actor    struct {
+		ID         string            `json:"ID"`
+		Attributes map[string]string `json:"Attributes"`
+	} `json:"Actor"`
+}
+
+type dockerSource struct {
+	cli uc.Docker
+}
+
+// NewDockerSource returns a Source of the container events of the daemon of
+// the given client.
+func NewDockerSource(cli uc.Docker) Source {
+	return dockerSource{cli: cli}
+}
+
+func (ds dockerSource) Events(since int64) (Stream, error) {
+	endpoint, err := url.Parse(ds.cli.Endpoint())
+	if err != nil {
+		return nil, err
+	}
+	httpClient := ds.cli.HTTPClient
+	if endpoint.Scheme == "unix" {
+		socket := endpoint.Path
+		httpClient = &http.Client{
+			Transport: &http.Transport{
+				Dial: func(network, addr string) (net.Conn, error) {
+					return ds.cli.Dialer.Dial("unix", socket)
+				},
+			},
+		}
+		endpoint = &url.URL{Scheme: "http", Host: "unix.sock"}
+	} else if endpoint.Scheme == "tcp" {
+		endpoint.Scheme = "http"
+		if ds.cli.TLSConfig != nil {
+			endpoint.Scheme = "https"
+		}
+	}
+	query := url.Values{}
+	if since != 0 {
+		query.Set("since", fmt.Sprint(since))
+	}
+	resp, err := httpClient.Get(strings.TrimRight(endpoint.String(), "/") + "/events?" + query.Encode())
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("docker events returned status %s", resp.Status)
+	}
+	return &dockerStream{body: resp.Body, decoder: json.NewDecoder(resp.Body)}, nil
+}
+
+type dockerStream struct {
+	body    io.ReadCloser
+	decoder *json.Decoder
+}
+
+func (ds *dockerStream) Next() (Event, error) {
+	for {
+		var de dockerEvent
+		if err := ds.decoder.Decode(&de); err != nil {
+			return Event{}, err
+		}
+		if de.Type != "" && de.Type != "container" {
+			continue
+		}
+		event := Event{
+			Action:     Action(de.Status),
+			Container:  de.ID,
+			From:       de.From,
+			Time:       de.Time,
+			TimeNano:   de.TimeNano,
+			Attributes: de.Actor.Attributes,
+		}
+		if event.Action == "" {
+			event.Action = Action(de.Action)
+		}
+		if event.Container == "" {
+			event.Container = de.Actor.ID
+		}
+		return event, nil
+	}
+}
+
+func (ds *dockerStream) Close() error {
+	return ds.body.Close()
+}
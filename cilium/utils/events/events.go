@@ -0,0 +1,203 @@
+// Package events monitors the lifecycle events of docker containers. A Monitor
+// keeps a single stream of events open, reconnecting with backoff and resuming
+// from the last event seen when it's interrupted, and dispatches each event to
+// the handlers registered for its action.
+package events
+
+import (
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/cilium-team/cilium/Godeps/_workspace/src/github.com/op/go-logging"
+)
+
+// Action is the lifecycle action of a container event.
+type Action string
+
+const (
+	Create  Action = "create"
+	Start   Action = "start"
+	Restart Action = "restart"
+	Rename  Action = "rename"
+	Pause   Action = "pause"
+	Unpause Action = "unpause"
+	OOM     Action = "oom"
+	Stop    Action = "stop"
+	Die     Action = "die"
+	Destroy Action = "destroy"
+)
+
+const (
+	// DefaultBackoff and DefaultMaxBackoff are the initial and maximum
+	// times a Monitor waits before reconnecting.
+	DefaultBackoff    = 500 * time.Millisecond
+	DefaultMaxBackoff = 30 * time.Second
+)
+
+var log = logging.MustGetLogger("cilium")
+
+// Event is a lifecycle event of a container.
+type Event struct {
+	Action    Action
+	Container string
+	// From is the image of the container or, for events made up by
+	// cilium, "self".
+	From string
+	// Time is the unix time of the event, in seconds, and TimeNano in
+	// nanoseconds if the daemon reports it.
+	Time     int64
+	TimeNano int64
+	// Attributes are the attributes of the container, for example "name"
+	// and, on rename, "oldName".
+	Attributes map[string]string
+}
+
+// key identifies an event between the ones with the same Time.
+func (e Event) key() string {
+	return string(e.Action) + "/" + e.Container + "/" + strconv.FormatInt(e.TimeNano, 10)
+}
+
+// Handler handles an event. Handlers are called, one at a time, in the order
+// events are received so a blocked handler holds back the following events.
+type Handler func(Event)
+
+// Stream is an open stream of events.
+type Stream interface {
+	// Next blocks until the next event is received. It returns an error
+	// once the stream is interrupted or closed.
+	Next() (Event, error)
+	Close() error
+}
+
+// Source opens streams of events.
+type Source interface {
+	// Events returns a stream with the events since the given unix time,
+	// in seconds, and the ones happening from now on.
+	Events(since int64) (Stream, error)
+}
+
+// Monitor dispatches the events of a Source to the handlers registered for
+// their action.
+type Monitor struct {
+	// Backoff and MaxBackoff are the initial and maximum times to wait
+	// before reconnecting to the source, the time doubles on each failed
+	// attempt.
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+
+	source   Source
+	mutex    sync.RWMutex
+	handlers map[Action][]Handler
+	lastSeen int64
+	// seen are the keys of the events, with Time equal to lastSeen,
+	// already dispatched. They are replayed when the stream is resumed.
+	seen map[string]bool
+}
+
+// NewMonitor returns a Monitor of the events of source since the given unix
+// time.
+func NewMonitor(source Source, since int64) *Monitor {
+	return &Monitor{
+		Backoff:    DefaultBackoff,
+		MaxBackoff: DefaultMaxBackoff,
+		source:     source,
+		handlers:   map[Action][]Handler{},
+		lastSeen:   since,
+		seen:       map[string]bool{},
+	}
+}
+
+// Handle registers handler for the events with any of the given actions.
+func (mon *Monitor) Handle(handler Handler, actions ...Action) {
+	mon.mutex.Lock()
+	defer mon.mutex.Unlock()
+	for _, action := range actions {
+		mon.handlers[action] = append(mon.handlers[action], handler)
+	}
+}
+
+// LastSeen returns the unix time of the last event dispatched.
+func (mon *Monitor) LastSeen() int64 {
+	mon.mutex.RLock()
+	defer mon.mutex.RUnlock()
+	return mon.lastSeen
+}
+
+// Run dispatches the events of the monitor's source until stop is closed.
+// Whenever the stream is interrupted, Run reconnects and resumes it from the
+// last event seen.
+func (mon *Monitor) Run(stop <-chan struct{}) {
+	backoff := mon.Backoff
+	for {
+		if stream, err := mon.source.Events(mon.LastSeen()); err != nil {
+			log.Warning("Unable to stream docker events: %s", err)
+		} else {
+			log.Info("Streaming docker events since %d", mon.LastSeen())
+			// Only streams that worked for a while reset the backoff,
+			// otherwise a daemon closing them right away would be
+			// flooded with reconnections.
+			if dispatched, err := mon.consume(stream, stop); dispatched > 0 {
+				backoff = mon.Backoff
+			} else if err != nil {
+				log.Warning("Docker events stream interrupted: %s", err)
+			}
+		}
+		select {
+		case <-stop:
+			return
+		case <-time.After(backoff):
+		}
+		if backoff *= 2; backoff > mon.MaxBackoff {
+			backoff = mon.MaxBackoff
+		}
+	}
+}
+
+// consume dispatches the events of stream until it's interrupted, or stop is
+// closed, and returns the number of events dispatched.
+func (mon *Monitor) consume(stream Stream, stop <-chan struct{}) (int, error) {
+	done := make(chan struct{})
+	defer close(done)
+	defer stream.Close()
+	go func() {
+		select {
+		case <-stop:
+			stream.Close()
+		case <-done:
+		}
+	}()
+	dispatched := 0
+	for {
+		event, err := stream.Next()
+		if err != nil {
+			return dispatched, err
+		}
+		if mon.dispatch(event) {
+			dispatched++
+		}
+	}
+}
+
+// dispatch calls the handlers of the given event, and returns true, unless it
+// was already dispatched before the stream was resumed.
+func (mon *Monitor) dispatch(event Event) bool {
+	mon.mutex.Lock()
+	if event.Time < mon.lastSeen || mon.seen[event.key()] {
+		mon.mutex.Unlock()
+		log.Debug("Skipping replayed event %+v", event)
+		return false
+	}
+	if event.Time > mon.lastSeen {
+		mon.lastSeen = event.Time
+		mon.seen = map[string]bool{}
+	}
+	mon.seen[event.key()] = true
+	handlers := mon.handlers[event.Action]
+	mon.mutex.Unlock()
+	log.Debug("Dispatching event %+v", event)
+	for _, handler := range handlers {
+		handler(event)
+	}
+	return true
+}
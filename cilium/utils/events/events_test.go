@@ -0,0 +1,177 @@
+package events
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	uc "github.com/cilium-team/cilium/cilium/utils/comm"
+
+	d "github.com/cilium-team/cilium/Godeps/_workspace/src/github.com/fsouza/go-dockerclient"
+)
+
+// fakeStream sends its events and then fails as if the daemon disconnected.
+type fakeStream struct {
+	events []Event
+}
+
+func (fs *fakeStream) Next() (Event, error) {
+	if len(fs.events) == 0 {
+		return Event{}, fmt.Errorf("connection reset by peer")
+	}
+	event := fs.events[0]
+	fs.events = fs.events[1:]
+	return event, nil
+}
+
+func (fs *fakeStream) Close() error {
+	return nil
+}
+
+// blockingStream blocks until it's closed.
+type blockingStream struct {
+	closed chan struct{}
+	once   sync.Once
+}
+
+func (bs *blockingStream) Next() (Event, error) {
+	<-bs.closed
+	return Event{}, fmt.Errorf("stream closed")
+}
+
+func (bs *blockingStream) Close() error {
+	bs.once.Do(func() { close(bs.closed) })
+	return nil
+}
+
+// fakeSource returns, on each connection, the next of its streams, or
+// errors, and records the since of each connection. Once it runs out of them
+// it returns blocking streams.
+type fakeSource struct {
+	mutex    sync.Mutex
+	streams  []interface{}
+	since    []int64
+	blocking chan struct{}
+}
+
+func (fs *fakeSource) Events(since int64) (Stream, error) {
+	fs.mutex.Lock()
+	defer fs.mutex.Unlock()
+	fs.since = append(fs.since, since)
+	if len(fs.streams) == 0 {
+		fs.blocking <- struct{}{}
+		return &blockingStream{closed: make(chan struct{})}, nil
+	}
+	next := fs.streams[0]
+	fs.streams = fs.streams[1:]
+	if err, ok := next.(error); ok {
+		return nil, err
+	}
+	return &fakeStream{events: next.([]Event)}, nil
+}
+
+func TestMonitorResumesAfterDisconnect(t *testing.T) {
+	source := &fakeSource{streams: []interface{}{
+		[]Event{
+			{Action: Create, Container: "1", Time: 100, TimeNano: 100001},
+			{Action: Start, Container: "1", Time: 101, TimeNano: 101001},
+		},
+		fmt.Errorf("connection refused"),
+		[]Event{
+			// Replayed since the stream resumes from the second of
+			// the last event seen.
+			{Action: Start, Container: "1", Time: 101, TimeNano: 101001},
+			{Action: Create, Container: "2", Time: 101, TimeNano: 101002},
+			{Action: Rename, Container: "2", Time: 102, TimeNano: 102001, Attributes: map[string]string{"name": "web"}},
+			{Action: OOM, Container: "1", Time: 103, TimeNano: 103001},
+			{Action: Die, Container: "1", Time: 103, TimeNano: 103002},
+		},
+	}, blocking: make(chan struct{}, 1)}
+	mon := NewMonitor(source, 99)
+	mon.Backoff = time.Millisecond
+
+	var (
+		mutex sync.Mutex
+		got   []string
+	)
+	mon.Handle(func(e Event) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		got = append(got, string(e.Action)+" "+e.Container)
+	}, Create, Start, Rename, OOM, Die)
+
+	stop := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		mon.Run(stop)
+		close(stopped)
+	}()
+	select {
+	case <-source.blocking:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("timeout while waiting for the monitor to resume")
+	}
+	close(stop)
+	select {
+	case <-stopped:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("monitor didn't stop")
+	}
+
+	want := []string{"create 1", "start 1", "create 2", "rename 2", "oom 1", "die 1"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("invalid events:\ngot  %v\nwant %v", got, want)
+	}
+	if want := []int64{99, 101, 101, 103}; !reflect.DeepEqual(source.since, want) {
+		t.Errorf("invalid since:\ngot  %v\nwant %v", source.since, want)
+	}
+	if mon.LastSeen() != 103 {
+		t.Errorf("invalid last seen:\ngot  %d\nwant %d", mon.LastSeen(), 103)
+	}
+}
+
+func TestDockerSource(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/events" || r.URL.Query().Get("since") != "100" {
+			http.Error(w, "unexpected request "+r.URL.String(), http.StatusBadRequest)
+			return
+		}
+		fmt.Fprint(w, `{"status":"create","id":"1","from":"busybox","time":100}`)
+		fmt.Fprint(w, `{"Type":"network","Action":"connect","Actor":{"ID":"n1"},"time":101}`)
+		fmt.Fprint(w, `{"status":"rename","id":"1","from":"busybox","Type":"container","Action":"rename",`+
+			`"Actor":{"ID":"1","Attributes":{"name":"web","oldName":"/db"}},"time":102,"timeNano":102000000005}`)
+	}))
+	defer server.Close()
+	client, err := d.NewClient(strings.Replace(server.URL, "http://", "tcp://", 1))
+	if err != nil {
+		t.Fatalf("error while creating docker client: %s", err)
+	}
+
+	stream, err := NewDockerSource(uc.Docker{Client: client}).Events(100)
+	if err != nil {
+		t.Fatalf("error while streaming events: %s", err)
+	}
+	defer stream.Close()
+	want := []Event{
+		{Action: Create, Container: "1", From: "busybox", Time: 100},
+		{Action: Rename, Container: "1", From: "busybox", Time: 102, TimeNano: 102000000005,
+			Attributes: map[string]string{"name": "web", "oldName": "/db"}},
+	}
+	for _, wantEvent := range want {
+		event, err := stream.Next()
+		if err != nil {
+			t.Fatalf("error while receiving event: %s", err)
+		}
+		if !reflect.DeepEqual(event, wantEvent) {
+			t.Errorf("invalid event:\ngot  %+v\nwant %+v", event, wantEvent)
+		}
+	}
+	if _, err := stream.Next(); err == nil {
+		t.Errorf("stream wasn't interrupted after the daemon disconnected")
+	}
+}
@@ -0,0 +1,95 @@
+package utils
+
+import (
+	"fmt"
+	"hash/fnv"
+	"os"
+
+	ucdb "github.com/cilium-team/cilium/cilium/utils/comm/db"
+	up "github.com/cilium-team/cilium/cilium/utils/profile"
+)
+
+// hostPortCookieFlag marks the cookies of the flows of the published host
+// ports. Endpoints' cookies have, at most, 56 bits, the overlay peers', the
+// routers' and the egress gateways' ones the 57th, 58th and 59th bits so they
+// never collide with them.
+const hostPortCookieFlag = uint64(1) << 59
+
+// hostPortCookie returns the cookie of the flows of the given host port.
+func hostPortCookie(hostPort up.HostPort) uint64 {
+	h := fnv.New32a()
+	h.Write([]byte(hostPort.Key()))
+	return hostPortCookieFlag | uint64(h.Sum32())
+}
+
+// PublishHostPorts reserves, in the whole cluster, and publishes, on this
+// node's DefaultBridge, the given host ports of an endpoint of the given
+// namespace. routerMAC is the MAC of the endpoint's gateway, the host reaches
+// the endpoint through it. Returns the host ports that weren't published
+// because other containers already did. The script is set under
+// 'ADD_HOSTPORT' environment variable.
+func PublishHostPorts(dbConn ucdb.Db, hostPorts []up.HostPort, namespace int, routerMAC string) ([]up.HostPort, error) {
+	conflicts := []up.HostPort{}
+	for _, hostPort := range hostPorts {
+		if err := dbConn.PutHostPort(hostPort); err == ucdb.ErrHostPortInUse {
+			conflicts = append(conflicts, hostPort)
+			continue
+		} else if err != nil {
+			return conflicts, err
+		}
+		addHostPortCmd := fmt.Sprintf("%s %#x %d %s %d %s %s %d %s", os.Getenv("ADD_HOSTPORT"),
+			hostPortCookie(hostPort), namespace, hostPort.HostIP, hostPort.HostPort, hostPort.Protocol,
+			hostPort.IP, hostPort.Port, routerMAC)
+		if _, err := execShCommand(addHostPortCmd); err != nil {
+			dbConn.DeleteHostPort(hostPort.Key())
+			return conflicts, fmt.Errorf("unable to publish host port %s: %s", hostPort.Key(), err)
+		}
+		log.Info("Host port %s is published to %s:%d", hostPort.Key(), hostPort.IP, hostPort.Port)
+	}
+	return conflicts, nil
+}
+
+// UnpublishHostPorts removes, from this node, the host ports published for the
+// container with the given ID and releases them. The script is set under
+// 'REMOVE_HOSTPORT' environment variable.
+func UnpublishHostPorts(dbConn ucdb.Db, containerID string) error {
+	hostPorts, err := dbConn.GetHostPorts()
+	if err != nil {
+		return err
+	}
+	var lastErr error
+	for _, hostPort := range hostPorts {
+		if hostPort.Container != containerID || hostPort.Node != os.Getenv("HOST_IP") {
+			continue
+		}
+		removeHostPortCmd := fmt.Sprintf("%s %#x/-1 %s %s", os.Getenv("REMOVE_HOSTPORT"),
+			hostPortCookie(hostPort), hostPort.Key(), hostPort.IP)
+		if _, err := execShCommand(removeHostPortCmd); err != nil {
+			log.Warning("Unable to remove host port %s: %s", hostPort.Key(), err)
+			lastErr = err
+			continue
+		}
+		if err := dbConn.DeleteHostPort(hostPort.Key()); err != nil {
+			lastErr = err
+		}
+	}
+	return lastErr
+}
+
+// ReleaseHostPorts releases, in the database, the host ports published for the
+// container with the given ID.
+func ReleaseHostPorts(dbConn ucdb.Db, containerID string) error {
+	hostPorts, err := dbConn.GetHostPorts()
+	if err != nil {
+		return err
+	}
+	for _, hostPort := range hostPorts {
+		if hostPort.Container != containerID {
+			continue
+		}
+		if err := dbConn.DeleteHostPort(hostPort.Key()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,55 @@
+package utils
+
+import (
+	"os"
+	"reflect"
+	"testing"
+
+	ucdb "github.com/cilium-team/cilium/cilium/utils/comm/db"
+	up "github.com/cilium-team/cilium/cilium/utils/profile"
+)
+
+func TestPublishHostPorts(t *testing.T) {
+	fdb := ucdb.NewMemConn()
+	os.Setenv("HOST_IP", "192.168.50.10")
+	os.Setenv("ADD_HOSTPORT", "/opt/backend/add-hostport.sh")
+	os.Setenv("REMOVE_HOSTPORT", "/opt/backend/remove-hostport.sh")
+	cmds := []string{}
+	execShCommand = func(strCmd string) ([]byte, error) {
+		cmds = append(cmds, strCmd)
+		return nil, nil
+	}
+
+	taken := up.HostPort{HostIP: "192.168.50.10", HostPort: 8443, Protocol: "tcp", Node: "192.168.50.10",
+		Container: "b", IP: "10.1.0.6", Port: 443}
+	if err := fdb.PutHostPort(taken); err != nil {
+		t.Fatal(err)
+	}
+	hostPorts := []up.HostPort{
+		{HostIP: "192.168.50.10", HostPort: 8080, Protocol: "tcp", Node: "192.168.50.10", Container: "a", IP: "10.1.0.5", Port: 80},
+		{HostIP: "192.168.50.10", HostPort: 8443, Protocol: "tcp", Node: "192.168.50.10", Container: "a", IP: "10.1.0.5", Port: 443},
+	}
+	conflicts, err := PublishHostPorts(fdb, hostPorts, 2, up.DefaultRouterMAC)
+	if err != nil {
+		t.Fatalf("error while publishing host ports: %s", err)
+	}
+	if want := hostPorts[1:]; !reflect.DeepEqual(conflicts, want) {
+		t.Errorf("invalid conflicts:\ngot  %+v\nwant %+v", conflicts, want)
+	}
+	want := []string{"/opt/backend/add-hostport.sh 0x80000001ee78478 2 192.168.50.10 8080 tcp 10.1.0.5 80 dd:dd:dd:dd:dd:dd"}
+	if !reflect.DeepEqual(cmds, want) {
+		t.Errorf("invalid commands:\ngot  %v\nwant %v", cmds, want)
+	}
+
+	// Only the host ports of the container are removed.
+	if err := UnpublishHostPorts(fdb, "a"); err != nil {
+		t.Fatalf("error while unpublishing host ports: %s", err)
+	}
+	want = append(want, "/opt/backend/remove-hostport.sh 0x80000001ee78478/-1 192.168.50.10:8080/tcp 10.1.0.5")
+	if !reflect.DeepEqual(cmds, want) {
+		t.Errorf("invalid commands after unpublishing:\ngot  %v\nwant %v", cmds, want)
+	}
+	if got, err := fdb.GetHostPorts(); err != nil || !reflect.DeepEqual(got, []up.HostPort{taken}) {
+		t.Errorf("invalid host ports after unpublishing:\ngot  %+v, %v\nwant %+v", got, err, []up.HostPort{taken})
+	}
+}
@@ -0,0 +1,28 @@
+package utils
+
+import (
+	ucdb "github.com/cilium-team/cilium/cilium/utils/comm/db"
+	up "github.com/cilium-team/cilium/cilium/utils/profile"
+)
+
+// AcquireIdentity returns the ID of the identity with the given labels,
+// allocating it if needed, and counts the given container as one of its
+// endpoints.
+func AcquireIdentity(dbConn ucdb.Db, labels map[string]string, containerID string) (int, error) {
+	id := 0
+	err := dbConn.UpdateIdentities(func(identities *up.Identities) error {
+		var err error
+		id, err = identities.Acquire(labels, containerID)
+		return err
+	})
+	return id, err
+}
+
+// ReleaseIdentity stops counting the given container as an endpoint of its
+// identity, which is released if no other endpoint uses it.
+func ReleaseIdentity(dbConn ucdb.Db, containerID string) error {
+	return dbConn.UpdateIdentities(func(identities *up.Identities) error {
+		identities.Release(containerID)
+		return nil
+	})
+}
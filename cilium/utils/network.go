@@ -0,0 +1,50 @@
+package utils
+
+import (
+	"fmt"
+
+	ucdb "github.com/cilium-team/cilium/cilium/utils/comm/db"
+	up "github.com/cilium-team/cilium/cilium/utils/profile"
+)
+
+// PutNetwork allocates the IDs of the given network, refusing it if any of its
+// subnets overlaps with the ones of the networks in the database, and stores
+// it, atomically. A network already stored keeps its IDs. Returns the stored
+// network.
+func PutNetwork(dbConn ucdb.Db, network up.Network) (up.Network, error) {
+	err := dbConn.UpdateNetworks(func(networks *up.Networks) (err error) {
+		network, err = networks.Allocate(network)
+		return err
+	})
+	if err != nil {
+		return network, err
+	}
+	log.Info("Network %s has BD %d and namespace %d", network.Name, network.BD, network.Namespace)
+	return network, nil
+}
+
+// DeleteNetwork removes the network with the given name from the database
+// unless it still has endpoints.
+func DeleteNetwork(dbConn ucdb.Db, name string) error {
+	network, err := dbConn.GetNetwork(name)
+	if err != nil {
+		return err
+	}
+	if network.Name == "" {
+		return fmt.Errorf("network %s doesn't exist", name)
+	}
+	endpoints, err := dbConn.GetEndpoints()
+	if err != nil {
+		return err
+	}
+	inUse := 0
+	for _, endpoint := range endpoints {
+		if endpoint.Network == name {
+			inUse++
+		}
+	}
+	if inUse != 0 {
+		return fmt.Errorf("network %s still has %d endpoints", name, inUse)
+	}
+	return dbConn.DeleteNetwork(name)
+}
@@ -0,0 +1,104 @@
+package utils
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+
+	ucdb "github.com/cilium-team/cilium/cilium/utils/comm/db"
+	up "github.com/cilium-team/cilium/cilium/utils/profile"
+)
+
+func TestPutNetwork(t *testing.T) {
+	fdb := ucdb.NewMemConn()
+	networks := []up.Network{
+		{Name: "web", Owner: "foo", Subnets: []string{"10.1.0.0/24"}, Isolation: up.IsolationOwner},
+		{Name: "db", Owner: "foo", Subnets: []string{"10.1.1.0/24"}, Isolation: up.IsolationOwner},
+		{Name: "shared", Owner: "bar", Subnets: []string{"10.2.0.0/24"}, Isolation: up.IsolationNone},
+		{Name: "private", Owner: "bar", Subnets: []string{"10.3.0.0/24", "f00d::/64"}},
+	}
+	want := [][2]int{{2, 2}, {3, 2}, {4, up.DefaultNamespace}, {5, 3}}
+	for i, network := range networks {
+		got, err := PutNetwork(fdb, network)
+		if err != nil {
+			t.Fatalf("error while putting network %s: %s", network.Name, err)
+		}
+		if ids := [2]int{got.BD, got.Namespace}; ids != want[i] {
+			t.Errorf("invalid BD and namespace of network %s:\ngot  %v\nwant %v", network.Name, ids, want[i])
+		}
+	}
+
+	// Stored networks keep their IDs when they are updated.
+	networks[0].Gateway = "10.1.0.1"
+	if got, err := PutNetwork(fdb, networks[0]); err != nil {
+		t.Fatalf("error while updating network %s: %s", networks[0].Name, err)
+	} else if ids := [2]int{got.BD, got.Namespace}; ids != want[0] {
+		t.Errorf("invalid BD and namespace of updated network %s:\ngot  %v\nwant %v", networks[0].Name, ids, want[0])
+	}
+
+	overlapping := up.Network{Name: "other", Owner: "baz", Subnets: []string{"10.1.0.128/25"}}
+	if _, err := PutNetwork(fdb, overlapping); err == nil {
+		t.Errorf("overlapping network %s was stored", overlapping.Name)
+	}
+	isolated := networks[2]
+	isolated.Isolation = up.IsolationNetwork
+	if _, err := PutNetwork(fdb, isolated); err == nil {
+		t.Errorf("isolation of network %s was changed", isolated.Name)
+	}
+}
+
+func TestPutNetworkConcurrent(t *testing.T) {
+	fdb := ucdb.NewMemConn()
+	const puts = 20
+	var (
+		wg      sync.WaitGroup
+		mutex   sync.Mutex
+		created []up.Network
+	)
+	for i := 0; i < puts; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			// Every network overlaps with the others.
+			network := up.Network{Name: fmt.Sprintf("net%d", i), Owner: "foo", Subnets: []string{"10.1.0.0/16"}}
+			if got, err := PutNetwork(fdb, network); err == nil {
+				mutex.Lock()
+				created = append(created, got)
+				mutex.Unlock()
+			}
+		}(i)
+	}
+	wg.Wait()
+	if len(created) != 1 {
+		t.Errorf("invalid number of overlapping networks stored:\ngot  %d\nwant 1", len(created))
+	}
+	if got, err := fdb.GetNetworks(); err != nil || len(got) != 1 {
+		t.Errorf("invalid networks:\ngot  %+v, %v\nwant %+v", got, err, created)
+	}
+}
+
+func TestDeleteNetwork(t *testing.T) {
+	fdb := ucdb.NewMemConn()
+	network := up.Network{Name: "web", Owner: "foo", Subnets: []string{"10.1.0.0/24"}}
+	if _, err := PutNetwork(fdb, network); err != nil {
+		t.Fatal(err)
+	}
+	if err := fdb.PutEndpoint(up.Endpoint{Container: "1234", Network: "web"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := DeleteNetwork(fdb, "web"); err == nil {
+		t.Errorf("network with endpoints was deleted")
+	}
+	if err := fdb.DeleteEndpoint("1234"); err != nil {
+		t.Fatal(err)
+	}
+	if err := DeleteNetwork(fdb, "web"); err != nil {
+		t.Errorf("error while deleting network without endpoints: %s", err)
+	}
+	if got, err := fdb.GetNetworks(); err != nil || len(got) != 0 {
+		t.Errorf("invalid networks after deletion:\ngot  %+v, %v\nwant []", got, err)
+	}
+	if err := DeleteNetwork(fdb, "web"); err == nil {
+		t.Errorf("unknown network was deleted")
+	}
+}
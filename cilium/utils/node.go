@@ -0,0 +1,197 @@
+package utils
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"time"
+
+	ucdb "github.com/cilium-team/cilium/cilium/utils/comm/db"
+	up "github.com/cilium-team/cilium/cilium/utils/profile"
+)
+
+const (
+	CapabilityDockerEvents = "docker-events"
+	CapabilityHooks        = "hooks"
+)
+
+// NewLocalNode returns the Node representation of this host with the given
+// capabilities. The tunnel address is read from the 'TUNNEL_IP' environment
+// variable and, if it is not set, the 'HOST_IP' is used instead.
+func NewLocalNode(capabilities ...string) up.Node {
+	tunnelIP := os.Getenv("TUNNEL_IP")
+	if tunnelIP == "" {
+		tunnelIP = os.Getenv("HOST_IP")
+	}
+	return up.Node{
+		Name:         os.Getenv("HOSTNAME"),
+		IP:           os.Getenv("HOST_IP"),
+		TunnelIP:     tunnelIP,
+		Capabilities: capabilities,
+	}
+}
+
+// RenewNodeLease renews the lease of the given node for ttl and stores it in
+// the database. Nodes are registered the first time their lease is renewed.
+// Nodes without IP aren't registered since nodes are identified by it.
+func RenewNodeLease(dbConn ucdb.Db, node *up.Node, ttl time.Duration) error {
+	if node.IP == "" {
+		return fmt.Errorf("node %s can't be registered without IP, HOST_IP isn't set", node.Name)
+	}
+	node.RenewLease(time.Now(), ttl)
+	return dbConn.PutNode(*node)
+}
+
+// ReleaseEndpoint frees all resources owned by the given endpoint: the IPs in
+// use, the DNS records, the load balancer backends, the quota usage, the
+// identity and the endpoint itself. It only changes the database and the
+// cluster's services, never this node, so it can release endpoints of other
+// nodes.
+// All errors are logged and the release continues, the last error is returned.
+func ReleaseEndpoint(dbConn ucdb.Db, endpoint up.Endpoint) error {
+	log.Debug("Releasing endpoint %+v", endpoint)
+	var lastErr error
+	ipsString := []string{}
+	for _, ip := range endpoint.IPs {
+		ipsString = append(ipsString, ip.String())
+		if err := dbConn.DeleteIP(ip); err != nil {
+			log.Warning("Unable to free IP %s of container %s: %s", ip, endpoint.Container, err)
+			lastErr = err
+		}
+	}
+	if len(endpoint.Domains) != 0 {
+		if dnsClient, err := dbConn.GetDNSConfig(); err != nil {
+			log.Warning("Unable to get DNS configuration: %s", err)
+			lastErr = err
+		} else if dnsClient.IP == "" {
+			log.Debug("There isn't any DNS configured")
+		} else if err := dnsClient.DeleteFromDNS(endpoint.Domains, ipsString); err != nil {
+			log.Warning("Unable to remove DNS entries of container %s: %s", endpoint.Container, err)
+			lastErr = err
+		}
+	}
+	if endpoint.Service != "" {
+		if haProxyClient, err := dbConn.GetHAProxyConfig(); err != nil {
+			log.Warning("Unable to get HAProxy configuration: %s", err)
+			lastErr = err
+		} else if haProxyClient.IP == "" {
+			log.Debug("There isn't any load balancer configured")
+		} else if err := haProxyClient.DeleteBackend(endpoint.Container); err != nil {
+			log.Warning("Unable to remove load balancer backend of container %s: %s", endpoint.Container, err)
+			lastErr = err
+		}
+	}
+	if err := dbConn.DeleteContainerUsage(endpoint.Container); err != nil {
+		log.Warning("Unable to delete quota usage of container %s: %s", endpoint.Container, err)
+		lastErr = err
+	}
+	if err := ReleaseServiceSlot(dbConn, endpoint.Container); err != nil {
+		log.Warning("Unable to release service slot of container %s: %s", endpoint.Container, err)
+		lastErr = err
+	}
+	if err := ReleaseIdentity(dbConn, endpoint.Container); err != nil {
+		log.Warning("Unable to release identity of container %s: %s", endpoint.Container, err)
+		lastErr = err
+	}
+	if err := ReleaseHostPorts(dbConn, endpoint.Container); err != nil {
+		log.Warning("Unable to release host ports of container %s: %s", endpoint.Container, err)
+		lastErr = err
+	}
+	if err := dbConn.DeleteEndpoint(endpoint.Container); err != nil {
+		log.Warning("Unable to delete endpoint of container %s: %s", endpoint.Container, err)
+		lastErr = err
+	}
+	return lastErr
+}
+
+// NodeWatcher tracks when the lease of each node was last renewed, as seen by
+// this node, so nodes are only considered dead after this node's clock, and
+// not the one of the node that renewed the lease, has advanced for longer than
+// the lease time.
+type NodeWatcher struct {
+	leases    map[string]time.Time
+	renewedAt map[string]time.Time
+}
+
+// NewNodeWatcher returns a NodeWatcher that hasn't seen any node.
+func NewNodeWatcher() *NodeWatcher {
+	return &NodeWatcher{leases: map[string]time.Time{}, renewedAt: map[string]time.Time{}}
+}
+
+// Watch records the leases of the given nodes, read at the given time, and
+// returns the ones whose lease wasn't renewed for ttl. Nodes seen for the
+// first time get a whole ttl to renew their lease.
+func (w *NodeWatcher) Watch(nodes []up.Node, now time.Time, ttl time.Duration) map[string]up.Node {
+	seen := map[string]bool{}
+	dead := map[string]up.Node{}
+	for _, node := range nodes {
+		seen[node.IP] = true
+		if lease, ok := w.leases[node.IP]; !ok || !lease.Equal(node.LeaseExpiration) {
+			w.leases[node.IP] = node.LeaseExpiration
+			w.renewedAt[node.IP] = now
+		}
+		if now.Sub(w.renewedAt[node.IP]) > ttl {
+			dead[node.IP] = node
+		}
+	}
+	for ip := range w.leases {
+		if !seen[ip] {
+			delete(w.leases, ip)
+			delete(w.renewedAt, ip)
+		}
+	}
+	return dead
+}
+
+// collectorOf returns the IP of the node that collects the dead nodes: the
+// alive node with the lowest IP.
+func collectorOf(nodes []up.Node, dead map[string]up.Node) string {
+	alive := []string{}
+	for _, node := range nodes {
+		if _, ok := dead[node.IP]; !ok {
+			alive = append(alive, node.IP)
+		}
+	}
+	if len(alive) == 0 {
+		return ""
+	}
+	sort.Strings(alive)
+	return alive[0]
+}
+
+// CollectDeadNodes removes, from the database, all nodes whose lease wasn't
+// renewed for ttl, according to watcher, together with all endpoints that
+// were running on them. Only the collector node, the alive one with the
+// lowest IP, removes them, the remaining nodes, with the given local IP, do
+// nothing. Returns the endpoints that were released.
+func CollectDeadNodes(dbConn ucdb.Db, watcher *NodeWatcher, localIP string, now time.Time, ttl time.Duration) ([]up.Endpoint, error) {
+	nodes, err := dbConn.GetNodes()
+	if err != nil {
+		return nil, err
+	}
+	deadNodes := watcher.Watch(nodes, now, ttl)
+	delete(deadNodes, localIP)
+	if len(deadNodes) == 0 || collectorOf(nodes, deadNodes) != localIP {
+		return nil, nil
+	}
+	endpoints, err := dbConn.GetEndpoints()
+	if err != nil {
+		return nil, err
+	}
+	released := []up.Endpoint{}
+	for _, endpoint := range endpoints {
+		if _, ok := deadNodes[endpoint.Node]; !ok {
+			continue
+		}
+		log.Info("Releasing endpoint %s from dead node %s", endpoint.Container, endpoint.Node)
+		ReleaseEndpoint(dbConn, endpoint)
+		released = append(released, endpoint)
+	}
+	for nodeIP, node := range deadNodes {
+		log.Info("Removing dead node %s (%s)", node.Name, nodeIP)
+		if err := dbConn.DeleteNode(nodeIP); err != nil {
+			log.Warning("Unable to remove node %s: %s", nodeIP, err)
+		}
+	}
+	return released, nil
+}
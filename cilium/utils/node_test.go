@@ -0,0 +1,128 @@
+package utils
+
+import (
+	"net"
+	"reflect"
+	"testing"
+	"time"
+
+	ucdb "github.com/cilium-team/cilium/cilium/utils/comm/db"
+	up "github.com/cilium-team/cilium/cilium/utils/profile"
+)
+
+func TestCollectDeadNodes(t *testing.T) {
+	now := time.Now()
+	aliveNode := up.Node{Name: "node1", IP: "192.168.50.37", LeaseExpiration: now.Add(time.Minute)}
+	// The dead node's clock is ahead, only its lease not being renewed counts.
+	deadNode := up.Node{Name: "node2", IP: "192.168.50.38", LeaseExpiration: now.Add(time.Hour)}
+	aliveEndpoint := up.Endpoint{
+		Container: "alive",
+		IPs:       up.IPs{net.IP{10, 0, 0, 1}},
+		Node:      aliveNode.IP,
+	}
+	deadEndpoint := up.Endpoint{
+		Container: "dead",
+		IPs:       up.IPs{net.IP{10, 0, 0, 2}, net.IP{10, 0, 0, 3}},
+		Node:      deadNode.IP,
+	}
+
+	fdb := ucdb.NewMemConn()
+	for _, node := range []up.Node{aliveNode, deadNode} {
+		if err := fdb.PutNode(node); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, endpoint := range []up.Endpoint{aliveEndpoint, deadEndpoint} {
+		if err := fdb.PutEndpoint(endpoint); err != nil {
+			t.Fatal(err)
+		}
+		for _, ip := range endpoint.IPs {
+			if err := fdb.PutIP(ip); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	watcher := NewNodeWatcher()
+	if released, err := CollectDeadNodes(fdb, watcher, aliveNode.IP, now, time.Minute); err != nil || len(released) != 0 {
+		t.Fatalf("invalid released endpoints of nodes seen for the first time:\ngot  %+v, %v\nwant none", released, err)
+	}
+	aliveNode.RenewLease(now.Add(time.Minute), time.Minute)
+	if err := fdb.PutNode(aliveNode); err != nil {
+		t.Fatal(err)
+	}
+	// Only the collector, the alive node with the lowest IP, releases them.
+	if released, err := CollectDeadNodes(fdb, watcher, "192.168.50.39", now.Add(2*time.Minute), time.Minute); err != nil || len(released) != 0 {
+		t.Errorf("invalid released endpoints of a node that isn't the collector:\ngot  %+v, %v\nwant none", released, err)
+	}
+	released, err := CollectDeadNodes(fdb, watcher, aliveNode.IP, now.Add(2*time.Minute), time.Minute)
+	if err != nil {
+		t.Fatalf("error while collecting dead nodes: %s", err)
+	}
+	if len(released) != 1 || released[0].Container != deadEndpoint.Container {
+		t.Errorf("invalid released endpoints:\ngot  %+v\nwant %+v", released, []up.Endpoint{deadEndpoint})
+	}
+	ips, err := fdb.GetIPs()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []net.IP(aliveEndpoint.IPs); !reflect.DeepEqual(ips, want) {
+		t.Errorf("invalid IPs in use:\ngot  %+v\nwant %+v", ips, want)
+	}
+	endpoints, err := fdb.GetEndpoints()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(endpoints) != 1 || endpoints[0].Container != aliveEndpoint.Container {
+		t.Errorf("invalid endpoints:\ngot  %+v\nwant %+v", endpoints, []up.Endpoint{aliveEndpoint})
+	}
+	nodes, err := fdb.GetNodes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(nodes) != 1 || nodes[0].IP != aliveNode.IP {
+		t.Errorf("invalid nodes:\ngot  %+v\nwant %+v", nodes, []up.Node{aliveNode})
+	}
+}
+
+func TestCollectDeadNodesAllAlive(t *testing.T) {
+	now := time.Now()
+	fdb := ucdb.NewMemConn()
+	node := up.Node{Name: "node1", IP: "192.168.50.37", LeaseExpiration: now.Add(time.Minute)}
+	if err := fdb.PutNode(node); err != nil {
+		t.Fatal(err)
+	}
+	released, err := CollectDeadNodes(fdb, NewNodeWatcher(), node.IP, now, time.Minute)
+	if err != nil {
+		t.Fatalf("error while collecting dead nodes: %s", err)
+	}
+	if len(released) != 0 {
+		t.Errorf("no endpoint should have been released: %+v", released)
+	}
+}
+
+func TestRenewNodeLeaseWithoutIP(t *testing.T) {
+	fdb := ucdb.NewMemConn()
+	if err := RenewNodeLease(fdb, &up.Node{Name: "node1"}, time.Minute); err == nil {
+		t.Errorf("node without IP was registered")
+	}
+	if nodes, _ := fdb.GetNodes(); len(nodes) != 0 {
+		t.Errorf("invalid nodes:\ngot  %+v\nwant %+v", nodes, []up.Node{})
+	}
+}
+
+func TestReleaseEndpointWithoutServices(t *testing.T) {
+	endpoint := up.Endpoint{
+		Container: "foo",
+		IPs:       up.IPs{net.IP{10, 0, 0, 1}},
+		Service:   "web",
+		Domains:   []string{"web", "foo"},
+	}
+	fdb := ucdb.NewMemConn()
+	if err := fdb.PutEndpoint(endpoint); err != nil {
+		t.Fatal(err)
+	}
+	if err := ReleaseEndpoint(fdb, endpoint); err != nil {
+		t.Errorf("error while releasing endpoint: %s", err)
+	}
+}
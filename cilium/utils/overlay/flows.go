@@ -0,0 +1,211 @@
+package overlay
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	up "github.com/cilium-team/cilium/cilium/utils/profile"
+)
+
+// Pipeline and registers, as set by backend/config.sh.
+const (
+	TablePre    = 0
+	TableMain   = 1
+	TablePolicy = 2
+
+	regSGrp = "NXM_NX_REG0[]"
+	regDGrp = "NXM_NX_REG1[]"
+	regBD   = "NXM_NX_REG2[]"
+	regNS   = "NXM_NX_REG3[]"
+	regPort = "NXM_NX_REG4[]"
+
+	LogicalRouterMAC = up.DefaultRouterMAC
+)
+
+// VNI schemes, they set how the group, broadcast domain and namespace of the
+// source endpoint are carried in the VNI of the packets sent to a peer.
+const (
+	// VNIGroup carries only the group, peers must not have endpoints
+	// of the same group in different broadcast domains or namespaces.
+	VNIGroup = "group"
+	// VNIBDGroup carries the broadcast domain in the upper 12 bits and the
+	// group in the lower 12 bits.
+	VNIBDGroup = "bd-group"
+	// VNINamespaceGroup carries the namespace in the upper 12 bits and the
+	// group in the lower 12 bits.
+	VNINamespaceGroup = "namespace-group"
+)
+
+// peerCookieFlag marks the cookies of the flows of a peer. Endpoints' cookies
+// have, at most, the 56 bits of the 14 first hex digits of their container's
+// ID so they never collide with them.
+const peerCookieFlag = uint64(1) << 56
+
+// Flow is an OpenFlow flow in the syntax of ovs-ofctl.
+type Flow struct {
+	Table int
+	// Priority is left to the switch's default if 0.
+	Priority int
+	Cookie   uint64
+	Match    string
+	Actions  []string
+}
+
+func (f Flow) String() string {
+	s := fmt.Sprintf("table=%d, ", f.Table)
+	if f.Priority != 0 {
+		s += fmt.Sprintf("priority=%d, ", f.Priority)
+	}
+	s += fmt.Sprintf("cookie=%#x, ", f.Cookie)
+	if f.Match != "" {
+		s += f.Match + ", "
+	}
+	return s + "actions=" + strings.Join(f.Actions, ", ")
+}
+
+// ValidateVNIScheme returns an error if the given scheme isn't known.
+func ValidateVNIScheme(scheme string) error {
+	switch scheme {
+	case VNIGroup, VNIBDGroup, VNINamespaceGroup:
+		return nil
+	}
+	return fmt.Errorf("unknown VNI scheme %q", scheme)
+}
+
+// VNI returns the VNI, with the given scheme, of the packets sent by an
+// endpoint of the given group, broadcast domain and namespace.
+func VNI(scheme string, group, bd, ns int) (uint32, error) {
+	switch scheme {
+	case VNIGroup:
+		if group < 0 || group >= 1<<24 {
+			return 0, fmt.Errorf("group %d doesn't fit in a VNI", group)
+		}
+		return uint32(group), nil
+	case VNIBDGroup, VNINamespaceGroup:
+		upper := bd
+		if scheme == VNINamespaceGroup {
+			upper = ns
+		}
+		if group < 0 || group >= 1<<12 || upper < 0 || upper >= 1<<12 {
+			return 0, fmt.Errorf("group %d, bd %d and namespace %d don't fit in a %s VNI", group, bd, ns, scheme)
+		}
+		return uint32(upper)<<12 | uint32(group), nil
+	}
+	return 0, ValidateVNIScheme(scheme)
+}
+
+// vniActions returns the actions that set the tunnel ID, with the given
+// scheme, from the registers of the source endpoint.
+func vniActions(scheme string) []string {
+	switch scheme {
+	case VNIBDGroup:
+		return []string{
+			"move:NXM_NX_REG0[0..11]->NXM_NX_TUN_ID[0..11]",
+			"move:NXM_NX_REG2[0..11]->NXM_NX_TUN_ID[12..23]",
+		}
+	case VNINamespaceGroup:
+		return []string{
+			"move:NXM_NX_REG0[0..11]->NXM_NX_TUN_ID[0..11]",
+			"move:NXM_NX_REG3[0..11]->NXM_NX_TUN_ID[12..23]",
+		}
+	}
+	return []string{"move:NXM_NX_REG0[0..23]->NXM_NX_TUN_ID[0..23]"}
+}
+
+// EndpointCookie returns the cookie of the flows of the given container, the
+// same one used by the backend scripts so they remove them too.
+func EndpointCookie(containerID string) (uint64, error) {
+	if len(containerID) > 14 {
+		containerID = containerID[:14]
+	}
+	return strconv.ParseUint(containerID, 16, 64)
+}
+
+// peerCookie returns the cookie of the flows of the peer with the given
+// tunnel IP.
+func peerCookie(tunnelIP net.IP) uint64 {
+	ip := tunnelIP.To4()
+	return peerCookieFlag | uint64(ip[0])<<24 | uint64(ip[1])<<16 | uint64(ip[2])<<8 | uint64(ip[3])
+}
+
+// portName returns the name of the tunnel port to the given tunnel IP.
+func portName(tunnelIP net.IP) string {
+	return fmt.Sprintf("vx%x", []byte(tunnelIP.To4()))
+}
+
+// ingress is the source group, broadcast domain and namespace of the packets
+// received, from a peer, with a VNI.
+type ingress struct {
+	group, bd, ns int
+	// endpoints is the number of endpoints of the peer using the VNI.
+	endpoints int
+}
+
+// ingressFlow returns the flow that maps the packets received through the
+// given port with the given VNI to their source's registers.
+func ingressFlow(cookie uint64, ofport int, vni uint32, in ingress) Flow {
+	return Flow{
+		Table:  TablePre,
+		Cookie: cookie,
+		Match:  fmt.Sprintf("in_port=%d, tun_id=%#x", ofport, vni),
+		Actions: []string{
+			fmt.Sprintf("load:%d->%s", in.group, regSGrp),
+			fmt.Sprintf("load:%d->%s", in.bd, regBD),
+			fmt.Sprintf("load:%d->%s", in.ns, regNS),
+			fmt.Sprintf("goto_table:%d", TableMain),
+		},
+	}
+}
+
+// endpointFlows returns the flows that send the packets to the given remote
+// endpoint through the given port, as done by backend/add-endpoint.sh. The
+// packets routed to it are the ones sent to any MAC of the given router of its
+// namespace.
+func endpointFlows(scheme string, cookie uint64, ofport int, endpoint up.Endpoint, router up.Router) []Flow {
+	toPeer := func(actions ...string) []string {
+		actions = append([]string{
+			fmt.Sprintf("load:%d->%s", endpoint.Group, regDGrp),
+			fmt.Sprintf("load:%d->%s", ofport, regPort),
+		}, actions...)
+		actions = append(actions, vniActions(scheme)...)
+		return append(actions, fmt.Sprintf("goto_table:%d", TablePolicy))
+	}
+	flows := []Flow{}
+	for i, ip := range endpoint.IPs {
+		if i >= len(endpoint.MACs) {
+			break
+		}
+		mac := endpoint.MACs[i]
+		flows = append(flows,
+			// Map dMAC to dGRP and the peer's port.
+			Flow{
+				Table:   TableMain,
+				Cookie:  cookie,
+				Match:   fmt.Sprintf("reg2=%d, dl_dst=%s", endpoint.BD, mac),
+				Actions: toPeer(),
+			},
+			// Translate ARP broadcasts to unicasts.
+			Flow{
+				Table:    TableMain,
+				Priority: 15,
+				Cookie:   cookie,
+				Match:    fmt.Sprintf("reg2=%d, arp, arp_op=1, arp_tpa=%s, dl_dst=ff:ff:ff:ff:ff:ff", endpoint.BD, ip),
+				Actions:  toPeer("mod_dl_dst:" + mac),
+			},
+		)
+		// Map dIP to dGRP and the peer's port and perform L3.
+		routerMACs := router.MACs(ip)
+		for _, routerMAC := range routerMACs {
+			flows = append(flows, Flow{
+				Table:    TableMain,
+				Priority: 15,
+				Cookie:   cookie,
+				Match:    fmt.Sprintf("reg3=%d, dl_dst=%s, ip, nw_dst=%s", endpoint.Namespace, routerMAC, ip),
+				Actions:  toPeer("mod_dl_dst:"+mac, "dec_ttl", "mod_dl_src:"+routerMACs[0]),
+			})
+		}
+	}
+	return flows
+}
@@ -0,0 +1,438 @@
+// Package overlay manages the VXLAN mesh between cilium's nodes. Every peer
+// node, learned from the database, gets its own tunnel port, with BFD to
+// detect when it's unreachable, and the flows of the remote endpoints send
+// their packets through the port of the peer where they run.
+package overlay
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"sync"
+	"time"
+
+	up "github.com/cilium-team/cilium/cilium/utils/profile"
+
+	"github.com/cilium-team/cilium/Godeps/_workspace/src/github.com/op/go-logging"
+)
+
+const (
+	DefaultMTU              = 1500
+	DefaultInterval         = 10 * time.Second
+	DefaultFailureThreshold = 3
+	// VXLANOverhead is the size of the headers added to each packet sent
+	// through a tunnel.
+	VXLANOverhead = 50
+)
+
+var log = logging.MustGetLogger("cilium")
+
+// Datapath programs the switch where the tunnels are created.
+type Datapath interface {
+	// AddTunnelPort adds a VXLAN port to remote, with the VNI set by the
+	// flows, and returns its OpenFlow port number.
+	AddTunnelPort(name string, local, remote net.IP) (int, error)
+	DeletePort(name string) error
+	AddFlows(flows ...Flow) error
+	// DeleteFlows deletes all flows with the given cookie.
+	DeleteFlows(cookie uint64) error
+	// TunnelUp returns true if the tunnel of the given port is up.
+	TunnelUp(name string) (bool, error)
+	SetMTU(port string, mtu int) error
+}
+
+// Nodes returns the nodes of the cluster and the routers of the namespaces
+// of their endpoints.
+type Nodes interface {
+	GetNodes() ([]up.Node, error)
+	GetRouters() ([]up.Router, error)
+}
+
+// Config is the configuration of a Manager.
+type Config struct {
+	// LocalIP is the IP of this node, the one endpoints have as Node.
+	LocalIP net.IP
+	// TunnelIP is the source IP of the tunnels, LocalIP if not set.
+	TunnelIP  net.IP
+	VNIScheme string
+	// MTU is the MTU of the network between the nodes, endpoints get it
+	// minus VXLANOverhead.
+	MTU int
+	// HostPort, if set, is the switch's internal port whose MTU is set to
+	// the endpoints' MTU.
+	HostPort string
+	// Interval is the time between refreshes of the peers and probes of
+	// their tunnels.
+	Interval time.Duration
+	// FailureThreshold is the number of consecutive probes a tunnel must
+	// be down before its peer is unreachable.
+	FailureThreshold int
+}
+
+// PeerStatus is the state of the tunnel to a peer.
+type PeerStatus struct {
+	Name      string `json:"name,omitempty"`
+	IP        string `json:"ip"`
+	TunnelIP  string `json:"tunnel-ip"`
+	Port      string `json:"port"`
+	OFPort    int    `json:"ofport"`
+	Reachable bool   `json:"reachable"`
+	Endpoints int    `json:"endpoints"`
+}
+
+type peer struct {
+	PeerStatus
+	cookie   uint64
+	failures int
+	ingress  map[uint32]*ingress
+}
+
+// Manager keeps a tunnel to every peer and the flows of the remote endpoints.
+type Manager struct {
+	Config
+
+	dp    Datapath
+	nodes Nodes
+
+	mutex sync.Mutex
+	// peers are indexed by node IP.
+	peers map[string]*peer
+	// endpoints are the remote endpoints programmed indexed by container.
+	endpoints map[string]up.Endpoint
+}
+
+// NewManager returns a Manager that learns the peers from nodes and programs
+// them in dp. Config's zero values are set to their defaults.
+func NewManager(dp Datapath, nodes Nodes, config Config) (*Manager, error) {
+	if config.LocalIP == nil {
+		return nil, fmt.Errorf("overlay without local IP")
+	}
+	if config.TunnelIP == nil {
+		config.TunnelIP = config.LocalIP
+	}
+	if config.TunnelIP.To4() == nil {
+		return nil, fmt.Errorf("tunnel IP %s isn't an IPv4 address", config.TunnelIP)
+	}
+	if config.VNIScheme == "" {
+		config.VNIScheme = VNIGroup
+	}
+	if err := ValidateVNIScheme(config.VNIScheme); err != nil {
+		return nil, err
+	}
+	if config.MTU == 0 {
+		config.MTU = DefaultMTU
+	}
+	if config.MTU <= VXLANOverhead+68 {
+		return nil, fmt.Errorf("MTU %d is too small for VXLAN", config.MTU)
+	}
+	if config.Interval == 0 {
+		config.Interval = DefaultInterval
+	}
+	if config.FailureThreshold == 0 {
+		config.FailureThreshold = DefaultFailureThreshold
+	}
+	return &Manager{
+		Config:    config,
+		dp:        dp,
+		nodes:     nodes,
+		peers:     map[string]*peer{},
+		endpoints: map[string]up.Endpoint{},
+	}, nil
+}
+
+// EndpointMTU returns the MTU of the endpoints' interfaces.
+func (m *Manager) EndpointMTU() int {
+	return m.MTU - VXLANOverhead
+}
+
+// Setup prepares the switch for the tunnels.
+func (m *Manager) Setup() error {
+	if m.HostPort == "" {
+		return nil
+	}
+	return m.dp.SetMTU(m.HostPort, m.EndpointMTU())
+}
+
+// Refresh adds the peers of the nodes alive and removes the others.
+func (m *Manager) Refresh() error {
+	nodes, err := m.nodes.GetNodes()
+	if err != nil {
+		return err
+	}
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return m.syncNodes(nodes, time.Now())
+}
+
+func (m *Manager) syncNodes(nodes []up.Node, now time.Time) error {
+	var lastErr error
+	alive := map[string]bool{}
+	for _, node := range nodes {
+		if node.IP == m.LocalIP.String() || !node.IsAlive(now) {
+			continue
+		}
+		alive[node.IP] = true
+		if p, ok := m.peers[node.IP]; ok && p.TunnelIP == node.TunnelIP {
+			continue
+		} else if ok {
+			log.Info("Tunnel IP of peer %s changed from %s to %s", node.IP, p.TunnelIP, node.TunnelIP)
+			m.removePeer(p)
+		}
+		if err := m.addPeer(node); err != nil {
+			log.Warning("Unable to add tunnel to peer %s: %s", node.IP, err)
+			lastErr = err
+		}
+	}
+	for ip, p := range m.peers {
+		if !alive[ip] {
+			log.Info("Removing tunnel to peer %s", ip)
+			m.removePeer(p)
+		}
+	}
+	return lastErr
+}
+
+func (m *Manager) addPeer(node up.Node) error {
+	tunnelIP := net.ParseIP(node.TunnelIP)
+	if tunnelIP == nil || tunnelIP.To4() == nil {
+		return fmt.Errorf("invalid tunnel IP %q", node.TunnelIP)
+	}
+	port := portName(tunnelIP)
+	ofport, err := m.dp.AddTunnelPort(port, m.TunnelIP, tunnelIP)
+	if err != nil {
+		return err
+	}
+	log.Info("Added tunnel %s to peer %s (%s)", port, node.IP, node.TunnelIP)
+	m.peers[node.IP] = &peer{
+		PeerStatus: PeerStatus{
+			Name:      node.Name,
+			IP:        node.IP,
+			TunnelIP:  node.TunnelIP,
+			Port:      port,
+			OFPort:    ofport,
+			Reachable: true,
+		},
+		cookie:  peerCookie(tunnelIP),
+		ingress: map[uint32]*ingress{},
+	}
+	// Endpoints kept since the peer was removed.
+	for _, endpoint := range m.endpoints {
+		if endpoint.Node == node.IP {
+			if err := m.programEndpoint(endpoint); err != nil {
+				log.Warning("Unable to program endpoint %s: %s", endpoint.Container, err)
+			}
+		}
+	}
+	return nil
+}
+
+// removePeer removes the tunnel to the given peer and the flows of its
+// endpoints. The endpoints are kept so they are programmed again if the peer
+// comes back.
+func (m *Manager) removePeer(p *peer) {
+	for _, endpoint := range m.endpoints {
+		if endpoint.Node != p.IP {
+			continue
+		}
+		if cookie, err := EndpointCookie(endpoint.Container); err == nil {
+			if err := m.dp.DeleteFlows(cookie); err != nil {
+				log.Warning("Unable to delete flows of endpoint %s: %s", endpoint.Container, err)
+			}
+		}
+	}
+	if err := m.dp.DeleteFlows(p.cookie); err != nil {
+		log.Warning("Unable to delete flows of peer %s: %s", p.IP, err)
+	}
+	if err := m.dp.DeletePort(p.Port); err != nil {
+		log.Warning("Unable to delete tunnel port %s: %s", p.Port, err)
+	}
+	delete(m.peers, p.IP)
+}
+
+// programPeer replaces the ingress flows of the given peer.
+func (m *Manager) programPeer(p *peer) error {
+	if err := m.dp.DeleteFlows(p.cookie); err != nil {
+		return err
+	}
+	vnis := []int{}
+	for vni := range p.ingress {
+		vnis = append(vnis, int(vni))
+	}
+	sort.Ints(vnis)
+	flows := []Flow{}
+	for _, vni := range vnis {
+		flows = append(flows, ingressFlow(p.cookie, p.OFPort, uint32(vni), *p.ingress[uint32(vni)]))
+	}
+	if len(flows) == 0 {
+		return nil
+	}
+	return m.dp.AddFlows(flows...)
+}
+
+// programEndpoint adds the ingress, if it's new, and the forwarding flows of
+// the given endpoint.
+func (m *Manager) programEndpoint(endpoint up.Endpoint) error {
+	p, ok := m.peers[endpoint.Node]
+	if !ok {
+		return fmt.Errorf("unknown peer %s of endpoint %s", endpoint.Node, endpoint.Container)
+	}
+	cookie, err := EndpointCookie(endpoint.Container)
+	if err != nil {
+		return fmt.Errorf("invalid container ID %q: %s", endpoint.Container, err)
+	}
+	vni, err := VNI(m.VNIScheme, endpoint.Group, endpoint.BD, endpoint.Namespace)
+	if err != nil {
+		return err
+	}
+	if in, ok := p.ingress[vni]; !ok {
+		p.ingress[vni] = &ingress{group: endpoint.Group, bd: endpoint.BD, ns: endpoint.Namespace, endpoints: 1}
+		if err := m.programPeer(p); err != nil {
+			return err
+		}
+	} else if in.group != endpoint.Group || in.bd != endpoint.BD || in.ns != endpoint.Namespace {
+		return fmt.Errorf("VNI %d of endpoint %s is used, in peer %s, by group %d, bd %d and namespace %d, "+
+			"a different VNI scheme is needed", vni, endpoint.Container, p.IP, in.group, in.bd, in.ns)
+	} else {
+		in.endpoints++
+	}
+	p.Endpoints++
+	if err := m.dp.DeleteFlows(cookie); err != nil {
+		return err
+	}
+	routers, err := m.nodes.GetRouters()
+	if err != nil {
+		return err
+	}
+	router, _ := up.RouterOf(routers, endpoint.Namespace)
+	return m.dp.AddFlows(endpointFlows(m.VNIScheme, cookie, p.OFPort, endpoint, router)...)
+}
+
+// unprogramEndpoint removes the flows of the given endpoint and, if it was
+// the last one using it, its ingress.
+func (m *Manager) unprogramEndpoint(endpoint up.Endpoint) error {
+	cookie, err := EndpointCookie(endpoint.Container)
+	if err != nil {
+		return err
+	}
+	if err := m.dp.DeleteFlows(cookie); err != nil {
+		return err
+	}
+	p, ok := m.peers[endpoint.Node]
+	if !ok {
+		return nil
+	}
+	p.Endpoints--
+	vni, err := VNI(m.VNIScheme, endpoint.Group, endpoint.BD, endpoint.Namespace)
+	if err != nil {
+		return nil
+	}
+	if in, ok := p.ingress[vni]; ok && in.group == endpoint.Group {
+		if in.endpoints--; in.endpoints == 0 {
+			delete(p.ingress, vni)
+			return m.programPeer(p)
+		}
+	}
+	return nil
+}
+
+// AddEndpoint programs the flows of the given remote endpoint. If its node
+// isn't a known peer, the peers are refreshed first.
+func (m *Manager) AddEndpoint(endpoint up.Endpoint) error {
+	if endpoint.Node == m.LocalIP.String() {
+		return nil
+	}
+	m.mutex.Lock()
+	_, known := m.peers[endpoint.Node]
+	m.mutex.Unlock()
+	if !known {
+		if err := m.Refresh(); err != nil {
+			log.Warning("Unable to refresh peers: %s", err)
+		}
+	}
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if old, ok := m.endpoints[endpoint.Container]; ok {
+		if err := m.unprogramEndpoint(old); err != nil {
+			return err
+		}
+		delete(m.endpoints, endpoint.Container)
+	}
+	if err := m.programEndpoint(endpoint); err != nil {
+		return err
+	}
+	m.endpoints[endpoint.Container] = endpoint
+	return nil
+}
+
+// RemoveEndpoint removes the flows of the remote endpoint of the given
+// container, if it was added.
+func (m *Manager) RemoveEndpoint(containerID string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	endpoint, ok := m.endpoints[containerID]
+	if !ok {
+		return nil
+	}
+	delete(m.endpoints, containerID)
+	return m.unprogramEndpoint(endpoint)
+}
+
+// Probe checks the tunnel of every peer. Peers whose tunnel is down for
+// FailureThreshold consecutive probes are unreachable until it's up again.
+func (m *Manager) Probe() {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	for _, p := range m.peers {
+		isUp, err := m.dp.TunnelUp(p.Port)
+		if err != nil {
+			log.Warning("Unable to probe tunnel %s to peer %s: %s", p.Port, p.IP, err)
+		}
+		if isUp && err == nil {
+			if !p.Reachable {
+				log.Info("Peer %s is reachable again", p.IP)
+			}
+			p.failures, p.Reachable = 0, true
+			continue
+		}
+		if p.failures++; p.failures >= m.FailureThreshold && p.Reachable {
+			log.Warning("Peer %s is unreachable through tunnel %s", p.IP, p.Port)
+			p.Reachable = false
+		}
+	}
+}
+
+// Peers returns the state of the tunnels to all peers sorted by IP.
+func (m *Manager) Peers() []PeerStatus {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	peers := []PeerStatus{}
+	for _, p := range m.peers {
+		peers = append(peers, p.PeerStatus)
+	}
+	sort.Sort(peersByIP(peers))
+	return peers
+}
+
+type peersByIP []PeerStatus
+
+func (p peersByIP) Len() int           { return len(p) }
+func (p peersByIP) Swap(i, j int)      { p[i], p[j] = p[j], p[i] }
+func (p peersByIP) Less(i, j int) bool { return p[i].IP < p[j].IP }
+
+// Run refreshes the peers and probes their tunnels every Interval until stop
+// is closed.
+func (m *Manager) Run(stop <-chan struct{}) {
+	ticker := time.NewTicker(m.Interval)
+	defer ticker.Stop()
+	for {
+		if err := m.Refresh(); err != nil {
+			log.Warning("Unable to refresh peers: %s", err)
+		}
+		m.Probe()
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
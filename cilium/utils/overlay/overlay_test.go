@@ -0,0 +1,349 @@
+package overlay
+
+import (
+	"fmt"
+	"net"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	up "github.com/cilium-team/cilium/cilium/utils/profile"
+)
+
+// fakeDatapath keeps the ports and flows programmed in memory.
+type fakeDatapath struct {
+	ports  map[string]int
+	flows  map[uint64][]string
+	up     map[string]bool
+	mtus   map[string]int
+	ofport int
+}
+
+func newFakeDatapath() *fakeDatapath {
+	return &fakeDatapath{
+		ports:  map[string]int{},
+		flows:  map[uint64][]string{},
+		up:     map[string]bool{},
+		mtus:   map[string]int{},
+		ofport: 10,
+	}
+}
+
+func (fd *fakeDatapath) AddTunnelPort(name string, local, remote net.IP) (int, error) {
+	if _, ok := fd.ports[name]; !ok {
+		fd.ofport++
+		fd.ports[name] = fd.ofport
+	}
+	return fd.ports[name], nil
+}
+
+func (fd *fakeDatapath) DeletePort(name string) error {
+	delete(fd.ports, name)
+	return nil
+}
+
+func (fd *fakeDatapath) AddFlows(flows ...Flow) error {
+	for _, flow := range flows {
+		fd.flows[flow.Cookie] = append(fd.flows[flow.Cookie], flow.String())
+	}
+	return nil
+}
+
+func (fd *fakeDatapath) DeleteFlows(cookie uint64) error {
+	delete(fd.flows, cookie)
+	return nil
+}
+
+func (fd *fakeDatapath) TunnelUp(name string) (bool, error) {
+	return fd.up[name], nil
+}
+
+func (fd *fakeDatapath) SetMTU(port string, mtu int) error {
+	fd.mtus[port] = mtu
+	return nil
+}
+
+// allFlows returns all flows programmed sorted.
+func (fd *fakeDatapath) allFlows() []string {
+	flows := []string{}
+	for _, cookieFlows := range fd.flows {
+		flows = append(flows, cookieFlows...)
+	}
+	sort.Strings(flows)
+	return flows
+}
+
+type fakeNodes []up.Node
+
+func (fn *fakeNodes) GetNodes() ([]up.Node, error) {
+	return *fn, nil
+}
+
+func (fn *fakeNodes) GetRouters() ([]up.Router, error) {
+	return nil, nil
+}
+
+const (
+	container1 = "6b27a943823d0f735346861bbce6e24acdaf435edb259748be556300d1c361f3"
+	container2 = "8a1c2e77a3b5d9f0e4c6b8a2d1f3e5c7b9a0d2f4e6c8b0a1c3e5f7d9b1a3c5e7"
+)
+
+func newTestManager(t *testing.T, scheme string) (*Manager, *fakeDatapath, *fakeNodes) {
+	lease := time.Now().Add(time.Hour)
+	nodes := &fakeNodes{
+		{Name: "node1", IP: "192.168.50.10", TunnelIP: "192.168.50.10", LeaseExpiration: lease},
+		{Name: "node2", IP: "192.168.50.11", TunnelIP: "10.0.0.11", LeaseExpiration: lease},
+		{Name: "node3", IP: "192.168.50.12", TunnelIP: "10.0.0.12", LeaseExpiration: time.Now().Add(-time.Hour)},
+	}
+	dp := newFakeDatapath()
+	m, err := NewManager(dp, nodes, Config{
+		LocalIP:   net.ParseIP("192.168.50.10"),
+		VNIScheme: scheme,
+		MTU:       9000,
+		HostPort:  "host0",
+	})
+	if err != nil {
+		t.Fatalf("error while creating manager: %s", err)
+	}
+	return m, dp, nodes
+}
+
+func TestManagerPeers(t *testing.T) {
+	m, dp, nodes := newTestManager(t, VNIGroup)
+	if err := m.Setup(); err != nil {
+		t.Fatal(err)
+	}
+	if dp.mtus["host0"] != 8950 {
+		t.Errorf("invalid host port MTU:\ngot  %d\nwant %d", dp.mtus["host0"], 8950)
+	}
+	if err := m.Refresh(); err != nil {
+		t.Fatal(err)
+	}
+	want := []PeerStatus{{Name: "node2", IP: "192.168.50.11", TunnelIP: "10.0.0.11", Port: "vx0a00000b", OFPort: 11, Reachable: true}}
+	if got := m.Peers(); !reflect.DeepEqual(got, want) {
+		t.Errorf("invalid peers:\ngot  %+v\nwant %+v", got, want)
+	}
+
+	// A tunnel down for less probes than the threshold is still reachable.
+	m.Probe()
+	m.Probe()
+	if !m.Peers()[0].Reachable {
+		t.Errorf("peer unreachable before the failure threshold")
+	}
+	m.Probe()
+	if m.Peers()[0].Reachable {
+		t.Errorf("peer still reachable after the failure threshold")
+	}
+	dp.up["vx0a00000b"] = true
+	m.Probe()
+	if !m.Peers()[0].Reachable {
+		t.Errorf("peer unreachable after its tunnel was up")
+	}
+
+	(*nodes)[1].TunnelIP = "10.0.1.11"
+	(*nodes)[2].LeaseExpiration = time.Now().Add(time.Hour)
+	if err := m.Refresh(); err != nil {
+		t.Fatal(err)
+	}
+	ports := []string{}
+	for port := range dp.ports {
+		ports = append(ports, port)
+	}
+	sort.Strings(ports)
+	if want := []string{"vx0a00000c", "vx0a00010b"}; !reflect.DeepEqual(ports, want) {
+		t.Errorf("invalid ports:\ngot  %v\nwant %v", ports, want)
+	}
+
+	*nodes = (*nodes)[:1]
+	if err := m.Refresh(); err != nil {
+		t.Fatal(err)
+	}
+	if len(m.Peers()) != 0 || len(dp.ports) != 0 {
+		t.Errorf("tunnels to dead peers weren't removed: %+v", m.Peers())
+	}
+}
+
+func TestManagerEndpointFlows(t *testing.T) {
+	m, dp, _ := newTestManager(t, VNIGroup)
+	endpoint := up.Endpoint{
+		Container: container1,
+		Node:      "192.168.50.11",
+		IPs:       up.IPs{net.ParseIP("10.1.0.2")},
+		MACs:      up.MACs{"00:01:02:03:04:05"},
+		Group:     4,
+		BD:        5,
+		Namespace: 6,
+	}
+	// The peer is learned when its first endpoint is added.
+	if err := m.AddEndpoint(endpoint); err != nil {
+		t.Fatal(err)
+	}
+	tunnel := "move:NXM_NX_REG0[0..23]->NXM_NX_TUN_ID[0..23]"
+	want := []string{
+		"table=0, cookie=0x10000000a00000b, in_port=11, tun_id=0x4, actions=load:4->NXM_NX_REG0[], " +
+			"load:5->NXM_NX_REG2[], load:6->NXM_NX_REG3[], goto_table:1",
+		"table=1, cookie=0x6b27a943823d0f, reg2=5, dl_dst=00:01:02:03:04:05, actions=load:4->NXM_NX_REG1[], " +
+			"load:11->NXM_NX_REG4[], " + tunnel + ", goto_table:2",
+		"table=1, priority=15, cookie=0x6b27a943823d0f, reg2=5, arp, arp_op=1, arp_tpa=10.1.0.2, " +
+			"dl_dst=ff:ff:ff:ff:ff:ff, actions=load:4->NXM_NX_REG1[], load:11->NXM_NX_REG4[], " +
+			"mod_dl_dst:00:01:02:03:04:05, " + tunnel + ", goto_table:2",
+		"table=1, priority=15, cookie=0x6b27a943823d0f, reg3=6, dl_dst=dd:dd:dd:dd:dd:dd, ip, nw_dst=10.1.0.2, " +
+			"actions=load:4->NXM_NX_REG1[], load:11->NXM_NX_REG4[], mod_dl_dst:00:01:02:03:04:05, dec_ttl, " +
+			"mod_dl_src:dd:dd:dd:dd:dd:dd, " + tunnel + ", goto_table:2",
+	}
+	sort.Strings(want)
+	if got := dp.allFlows(); !reflect.DeepEqual(got, want) {
+		t.Errorf("invalid flows:\ngot  %s\nwant %s", strings.Join(got, "\n     "), strings.Join(want, "\n     "))
+	}
+
+	// Endpoints of the same group share the peer's ingress flow.
+	endpoint2 := endpoint
+	endpoint2.Container, endpoint2.IPs, endpoint2.MACs = container2, up.IPs{net.ParseIP("10.1.0.3")}, up.MACs{"00:01:02:03:04:06"}
+	if err := m.AddEndpoint(endpoint2); err != nil {
+		t.Fatal(err)
+	}
+	if len(dp.allFlows()) != 7 {
+		t.Errorf("invalid number of flows:\ngot  %d\nwant %d", len(dp.allFlows()), 7)
+	}
+	// The group scheme can't tell apart groups in different broadcast
+	// domains.
+	conflict := endpoint
+	conflict.Container, conflict.BD = "0123456789abcdef", 7
+	if err := m.AddEndpoint(conflict); err == nil {
+		t.Errorf("conflicting VNI wasn't detected")
+	}
+
+	if err := m.RemoveEndpoint(container1); err != nil {
+		t.Fatal(err)
+	}
+	if len(dp.allFlows()) != 4 {
+		t.Errorf("invalid number of flows:\ngot  %d\nwant %d", len(dp.allFlows()), 4)
+	}
+	if err := m.RemoveEndpoint(container2); err != nil {
+		t.Fatal(err)
+	}
+	if flows := dp.allFlows(); len(flows) != 0 {
+		t.Errorf("flows left after removing all endpoints: %v", flows)
+	}
+	if m.Peers()[0].Endpoints != 0 {
+		t.Errorf("invalid number of endpoints:\ngot  %d\nwant %d", m.Peers()[0].Endpoints, 0)
+	}
+
+	// Local endpoints aren't programmed and endpoints of unknown nodes
+	// fail.
+	endpoint.Node = "192.168.50.10"
+	if err := m.AddEndpoint(endpoint); err != nil || len(dp.flows) != 0 {
+		t.Errorf("local endpoint was programmed: %v", err)
+	}
+	endpoint.Node = "192.168.50.99"
+	if err := m.AddEndpoint(endpoint); err == nil {
+		t.Errorf("endpoint of an unknown node was programmed")
+	}
+}
+
+func TestManagerVNISchemes(t *testing.T) {
+	for _, test := range []struct {
+		scheme string
+		vnis   []string
+		action string
+	}{
+		{VNIBDGroup, []string{"tun_id=0x5004", "tun_id=0x7004"}, "move:NXM_NX_REG2[0..11]->NXM_NX_TUN_ID[12..23]"},
+		{VNINamespaceGroup, []string{"tun_id=0x6004", "tun_id=0x8004"}, "move:NXM_NX_REG3[0..11]->NXM_NX_TUN_ID[12..23]"},
+	} {
+		m, dp, _ := newTestManager(t, test.scheme)
+		for i, container := range []string{container1, container2} {
+			endpoint := up.Endpoint{
+				Container: container,
+				Node:      "192.168.50.11",
+				IPs:       up.IPs{net.ParseIP(fmt.Sprintf("10.1.0.%d", i+2))},
+				MACs:      up.MACs{fmt.Sprintf("00:01:02:03:04:0%d", i+5)},
+				Group:     4,
+				BD:        5 + 2*i,
+				Namespace: 6 + 2*i,
+			}
+			if err := m.AddEndpoint(endpoint); err != nil {
+				t.Fatalf("error while adding endpoint with %s scheme: %s", test.scheme, err)
+			}
+		}
+		ingress := dp.flows[peerCookie(net.ParseIP("10.0.0.11"))]
+		if len(ingress) != 2 || !strings.Contains(ingress[0], test.vnis[0]) || !strings.Contains(ingress[1], test.vnis[1]) {
+			t.Errorf("invalid ingress flows of %s scheme: %v", test.scheme, ingress)
+		}
+		if flows := strings.Join(dp.allFlows(), "\n"); !strings.Contains(flows, test.action) {
+			t.Errorf("flows of %s scheme don't set the VNI with %s", test.scheme, test.action)
+		}
+	}
+
+	if _, err := VNI(VNIBDGroup, 4096, 1, 1); err == nil {
+		t.Errorf("group out of range of %s scheme wasn't detected", VNIBDGroup)
+	}
+	if _, err := NewManager(newFakeDatapath(), &fakeNodes{}, Config{LocalIP: net.ParseIP("192.168.50.10"), VNIScheme: "vlan"}); err == nil {
+		t.Errorf("unknown VNI scheme wasn't detected")
+	}
+}
+
+func TestOVSDatapath(t *testing.T) {
+	var got []string
+	runCommand = func(name string, args ...string) ([]byte, error) {
+		got = append(got, name+" "+strings.Join(args, " "))
+		if len(args) == 4 && args[3] == "ofport" {
+			return []byte("7\n"), nil
+		}
+		return []byte(`"up"` + "\n"), nil
+	}
+	dp := OVSDatapath{Bridge: "lxc-br0"}
+	ofport, err := dp.AddTunnelPort("vx0a00000b", net.ParseIP("192.168.50.10"), net.ParseIP("10.0.0.11"))
+	if err != nil || ofport != 7 {
+		t.Errorf("invalid ofport:\ngot  %d (%v)\nwant %d", ofport, err, 7)
+	}
+	if isUp, err := dp.TunnelUp("vx0a00000b"); err != nil || !isUp {
+		t.Errorf("tunnel isn't up: %v", err)
+	}
+	if err := dp.DeleteFlows(0x6b27a943823d0f); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"ovs-vsctl --may-exist add-port lxc-br0 vx0a00000b -- set interface vx0a00000b type=vxlan " +
+			"options:remote_ip=10.0.0.11 options:local_ip=192.168.50.10 options:key=flow bfd:enable=true",
+		"ovs-vsctl get interface vx0a00000b ofport",
+		"ovs-vsctl get interface vx0a00000b bfd_status:state",
+		"ovs-ofctl -O " + OpenFlowVersions + " del-flows lxc-br0 cookie=0x6b27a943823d0f/-1",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("invalid commands:\ngot  %s\nwant %s", strings.Join(got, "\n     "), strings.Join(want, "\n     "))
+	}
+}
+
+func TestEndpointFlowsWithRouter(t *testing.T) {
+	endpoint := up.Endpoint{
+		Container: container1,
+		IPs:       up.IPs{net.ParseIP("10.2.0.2")},
+		MACs:      up.MACs{"00:01:02:03:04:05"},
+		Group:     4,
+		BD:        5,
+		Namespace: 6,
+	}
+	router := up.Router{Namespace: 6, Gateways: []up.Gateway{
+		{Subnet: "10.1.0.0/24", IP: "10.1.0.1", MAC: "aa:aa:aa:aa:aa:01"},
+		{Subnet: "10.2.0.0/24", IP: "10.2.0.1", MAC: "aa:aa:aa:aa:aa:02"},
+	}}
+	got := []string{}
+	for _, flow := range endpointFlows(VNIGroup, 0x1, 11, endpoint, router) {
+		if strings.Contains(flow.Match, "nw_dst=") {
+			got = append(got, flow.String())
+		}
+	}
+	// Packets routed by any gateway reach the endpoint, with the one of its
+	// subnet as source.
+	actions := "actions=load:4->NXM_NX_REG1[], load:11->NXM_NX_REG4[], mod_dl_dst:00:01:02:03:04:05, dec_ttl, " +
+		"mod_dl_src:aa:aa:aa:aa:aa:02, move:NXM_NX_REG0[0..23]->NXM_NX_TUN_ID[0..23], goto_table:2"
+	want := []string{
+		"table=1, priority=15, cookie=0x1, reg3=6, dl_dst=aa:aa:aa:aa:aa:02, ip, nw_dst=10.2.0.2, " + actions,
+		"table=1, priority=15, cookie=0x1, reg3=6, dl_dst=aa:aa:aa:aa:aa:01, ip, nw_dst=10.2.0.2, " + actions,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("invalid L3 flows:\ngot  %s\nwant %s", strings.Join(got, "\n     "), strings.Join(want, "\n     "))
+	}
+}
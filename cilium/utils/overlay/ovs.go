@@ -0,0 +1,88 @@
+package overlay
+
+import (
+	"fmt"
+	"net"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+// OpenFlowVersions are the OpenFlow versions, as set by backend/config.sh,
+// used with ovs-ofctl.
+const OpenFlowVersions = "OpenFlow13,OpenFlow12,OpenFlow11,OpenFlow10"
+
+// OVSDatapath is a Datapath on an OpenvSwitch bridge.
+type OVSDatapath struct {
+	Bridge string
+}
+
+// This way it's easier to mock the commands run on tests.
+var runCommand = func(name string, args ...string) ([]byte, error) {
+	log.Debug("Executing %s %s", name, strings.Join(args, " "))
+	out, err := exec.Command(name, args...).CombinedOutput()
+	if err != nil {
+		return out, fmt.Errorf("%s %s failed: %s: %s", name, strings.Join(args, " "), err, strings.TrimSpace(string(out)))
+	}
+	return out, nil
+}
+
+func vsctl(args ...string) ([]byte, error) {
+	return runCommand("ovs-vsctl", args...)
+}
+
+func ofctl(args ...string) ([]byte, error) {
+	return runCommand("ovs-ofctl", append([]string{"-O", OpenFlowVersions}, args...)...)
+}
+
+func (d OVSDatapath) AddTunnelPort(name string, local, remote net.IP) (int, error) {
+	if _, err := vsctl("--may-exist", "add-port", d.Bridge, name, "--",
+		"set", "interface", name, "type=vxlan",
+		"options:remote_ip="+remote.String(),
+		"options:local_ip="+local.String(),
+		"options:key=flow",
+		"bfd:enable=true"); err != nil {
+		return 0, err
+	}
+	out, err := vsctl("get", "interface", name, "ofport")
+	if err != nil {
+		return 0, err
+	}
+	ofport, err := strconv.Atoi(strings.TrimSpace(string(out)))
+	if err != nil || ofport <= 0 {
+		return 0, fmt.Errorf("invalid ofport %q of port %s", strings.TrimSpace(string(out)), name)
+	}
+	return ofport, nil
+}
+
+func (d OVSDatapath) DeletePort(name string) error {
+	_, err := vsctl("--if-exists", "del-port", d.Bridge, name)
+	return err
+}
+
+func (d OVSDatapath) AddFlows(flows ...Flow) error {
+	for _, flow := range flows {
+		if _, err := ofctl("add-flow", d.Bridge, flow.String()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (d OVSDatapath) DeleteFlows(cookie uint64) error {
+	_, err := ofctl("del-flows", d.Bridge, fmt.Sprintf("cookie=%#x/-1", cookie))
+	return err
+}
+
+func (d OVSDatapath) TunnelUp(name string) (bool, error) {
+	out, err := vsctl("get", "interface", name, "bfd_status:state")
+	if err != nil {
+		return false, err
+	}
+	return strings.Trim(strings.TrimSpace(string(out)), `"`) == "up", nil
+}
+
+func (d OVSDatapath) SetMTU(port string, mtu int) error {
+	_, err := vsctl("set", "interface", port, fmt.Sprintf("mtu_request=%d", mtu))
+	return err
+}
@@ -10,23 +10,45 @@ import (
 	"time"
 
 	ucdb "github.com/cilium-team/cilium/cilium/utils/comm/db"
+	uo "github.com/cilium-team/cilium/cilium/utils/overlay"
 	upsi "github.com/cilium-team/cilium/cilium/utils/profile/subpolicies/intent"
 
 	"github.com/cilium-team/cilium/Godeps/_workspace/src/github.com/op/go-logging"
 )
 
-var log = logging.MustGetLogger("cilium")
+var (
+	log = logging.MustGetLogger("cilium")
 
-// CreateBridge creates an OVS bridge on the host with the help of the pipework
-// utility. Pipework full path should be set under 'PIPEWORK' environment
-// variable. Returns the interface name used inside the container with the ID
-// containerID and the MAC address of the bridge attached to it.
-func CreateBridge(ip net.IP, ipnet net.IPNet, netConf upsi.NetConf, containerPID int, containerID string) (string, string, error) {
+	// overlay, if set, programs the remote endpoints instead of the
+	// 'ADD_ENDPOINT' script.
+	overlay *uo.Manager
+)
+
+// SetOverlay sets the overlay manager that programs the tunnels to the remote
+// endpoints and whose MTU is set on the local ones.
+func SetOverlay(m *uo.Manager) {
+	overlay = m
+}
+
+// CreateBridge attaches the container to the OVS bridge requested by netConf,
+// creating it if needed, with the help of the pipework utility. Pipework full
+// path should be set under 'PIPEWORK' environment variable. Returns the
+// interface name used inside the container with the ID containerID and the
+// MAC address of the bridge attached to it. routerMACs, as returned by
+// SetupRouter, are the MACs of the router of the container's namespace.
+func CreateBridge(ip net.IP, ipnet net.IPNet, netConf upsi.NetConf, routerMACs []string, containerPID int, containerID string) (string, string, error) {
 	log.Debug("")
 	ones, _ := ipnet.Mask.Size()
-	br := "lxc-br0"
+	br := BridgeOf(netConf)
+	if err := EnsureBridge(br); err != nil {
+		return "", "", err
+	}
 	//Run magical script IFNAME=$($PIPEWORK $BRNAME $NAME ${PREFIX}@$GW $MAC)
 	pipeworkCmd := fmt.Sprintf("%s --quiet %s %d %s %s/%d", os.Getenv("PIPEWORK"), br, containerPID, containerID, ip, ones)
+	if overlay != nil {
+		pipeworkCmd = fmt.Sprintf("MTU=%d %s", overlay.EndpointMTU(), pipeworkCmd)
+	}
+	pipeworkCmd = routerEnv(routerMACs) + pipeworkCmd
 	if *netConf.Gw != "" {
 		pipeworkCmd += "@" + *netConf.Gw
 	}
@@ -65,24 +87,47 @@ func AddEndpoint(dbConn ucdb.Db, containerID string) error {
 
 		log.Debug("Found endpoint %+v", endpoint)
 
-		if endpoint.Node != os.Getenv("HOST_IP") {
-			for i := 0; i < len(endpoint.IPs); i++ {
-				ip := endpoint.IPs[i].String()
-				mac := endpoint.MACs[i]
-				addEndpointCmd := fmt.Sprintf(
-					"%s %s %d %d %d %s %s %s",
-					os.Getenv("ADD_ENDPOINT"),
-					endpoint.Node,
-					endpoint.Group,
-					endpoint.BD,
-					endpoint.Namespace,
-					ip,
-					mac,
-					containerID)
-				if _, err := execShCommand(addEndpointCmd); err != nil {
-					log.Debug("%+v", err)
-					return err
-				}
+		if endpoint.Node == os.Getenv("HOST_IP") {
+			return nil
+		}
+		bridge := endpoint.Bridge
+		if bridge == "" {
+			bridge = DefaultBridge
+		}
+		if err := EnsureBridge(bridge); err != nil {
+			return err
+		}
+		routerMACs := [][]string{}
+		for _, ip := range endpoint.IPs {
+			macs, _, err := SetupRouter(dbConn, bridge, endpoint.Namespace, ip)
+			if err != nil {
+				return err
+			}
+			routerMACs = append(routerMACs, macs)
+		}
+
+		// The overlay only manages the tunnels of DefaultBridge.
+		if overlay != nil && bridge == DefaultBridge {
+			return overlay.AddEndpoint(endpoint)
+		}
+		for i := 0; i < len(endpoint.IPs); i++ {
+			ip := endpoint.IPs[i].String()
+			mac := endpoint.MACs[i]
+			addEndpointCmd := fmt.Sprintf(
+				"%s%s%s %s %d %d %d %s %s %s",
+				bridgeEnv(bridge),
+				routerEnv(routerMACs[i]),
+				os.Getenv("ADD_ENDPOINT"),
+				endpoint.Node,
+				endpoint.Group,
+				endpoint.BD,
+				endpoint.Namespace,
+				ip,
+				mac,
+				containerID)
+			if _, err := execShCommand(addEndpointCmd); err != nil {
+				log.Debug("%+v", err)
+				return err
 			}
 		}
 		return nil
@@ -94,6 +139,11 @@ func AddEndpoint(dbConn ucdb.Db, containerID string) error {
 // the given container ID value.
 func RemoveLocalEndpoint(dbConn ucdb.Db, containerID string) error {
 	log.Debug("")
+	if overlay != nil {
+		if err := overlay.RemoveEndpoint(containerID); err != nil {
+			log.Warning("Unable to remove overlay flows of %s: %s", containerID, err)
+		}
+	}
 	attempts := 1
 	removeEndpointCmd := fmt.Sprintf("%s %s", os.Getenv("REMOVE_ENDPOINT"), containerID)
 	for attempts <= 10 {
@@ -107,6 +157,7 @@ func RemoveLocalEndpoint(dbConn ucdb.Db, containerID string) error {
 			continue
 		}
 		log.Debug("Found endpoint: %+v", endpoint)
+		removeEndpointCmd = bridgeEnv(endpoint.Bridge) + removeEndpointCmd
 		if endpoint.Node == os.Getenv("HOST_IP") {
 			removeEndpointCmd += " " + endpoint.Interface
 		}
@@ -120,13 +171,20 @@ func RemoveLocalEndpoint(dbConn ucdb.Db, containerID string) error {
 }
 
 // RemoveEndpoint removes endpoint for the container with the given container ID
-// value.
+// value from all bridges managed by cilium.
 func RemoveEndpoint(containerID string) error {
 	log.Debug("")
-	RemoveEndpointCmd := fmt.Sprintf("%s %s", os.Getenv("REMOVE_ENDPOINT"), containerID)
-	if _, err := execShCommand(RemoveEndpointCmd); err != nil {
-		log.Debug("Error: %+v", err)
-		return err
+	if overlay != nil {
+		if err := overlay.RemoveEndpoint(containerID); err != nil {
+			log.Warning("Unable to remove overlay flows of %s: %s", containerID, err)
+		}
+	}
+	for _, bridge := range Bridges() {
+		RemoveEndpointCmd := fmt.Sprintf("%s%s %s", bridgeEnv(bridge), os.Getenv("REMOVE_ENDPOINT"), containerID)
+		if _, err := execShCommand(RemoveEndpointCmd); err != nil {
+			log.Debug("Error: %+v", err)
+			return err
+		}
 	}
 	return nil
 }
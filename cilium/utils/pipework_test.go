@@ -49,7 +49,7 @@ func TestCreateBridge(t *testing.T) {
 		}
 		return []byte("foo " + mac), nil
 	}
-	ifname, gotmac, err := CreateBridge(ipaddr, *ipnet, netConf, containerPID, containerID)
+	ifname, gotmac, err := CreateBridge(ipaddr, *ipnet, netConf, nil, containerPID, containerID)
 	if err != nil {
 		t.Errorf("error while creating a bridge: %s", err)
 	}
@@ -74,7 +74,7 @@ func TestCreateBridge(t *testing.T) {
 	}
 	empty := ""
 	netConf.MAC = &empty
-	ifname, gotmac, err = CreateBridge(ipaddr, *ipnet, netConf, containerPID, containerID)
+	ifname, gotmac, err = CreateBridge(ipaddr, *ipnet, netConf, nil, containerPID, containerID)
 	if err != nil {
 		t.Errorf("error while creating a bridge: %s", err)
 	}
@@ -98,7 +98,7 @@ func TestCreateBridge(t *testing.T) {
 		return []byte("foo " + mac), nil
 	}
 	netConf.Route = &empty
-	ifname, gotmac, err = CreateBridge(ipaddr, *ipnet, netConf, containerPID, containerID)
+	ifname, gotmac, err = CreateBridge(ipaddr, *ipnet, netConf, nil, containerPID, containerID)
 	if err != nil {
 		t.Errorf("error while creating a bridge: %s", err)
 	}
@@ -127,7 +127,7 @@ func TestAddEndpoint(t *testing.T) {
 	fdb := FakeDB{}
 	fdb.OnGetEndpoint = func(cID string) (up.Endpoint, error) {
 		if cID != containerID {
-			fmt.Errorf("invalid container ID\ngot  %s\nwant %s", cID, containerID)
+			t.Errorf("invalid container ID\ngot  %s\nwant %s", cID, containerID)
 		}
 		return endpoint, nil
 	}
@@ -184,7 +184,7 @@ func TestRemoveLocalEndpoint(t *testing.T) {
 	fdb := FakeDB{}
 	fdb.OnGetEndpoint = func(cID string) (up.Endpoint, error) {
 		if cID != containerID {
-			fmt.Errorf("invalid container ID\ngot  %s\nwant %s", cID, containerID)
+			t.Errorf("invalid container ID\ngot  %s\nwant %s", cID, containerID)
 		}
 		return endpoint, nil
 	}
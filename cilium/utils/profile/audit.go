@@ -0,0 +1,99 @@
+package profile
+
+import (
+	"encoding/json"
+	"time"
+)
+
+const (
+	AuditPutPolicy      = "put-policy"
+	AuditPutUser        = "put-user"
+	AuditPutEndpoint    = "put-endpoint"
+	AuditDeleteEndpoint = "delete-endpoint"
+	AuditPutIP          = "put-ip"
+	AuditDeleteIP       = "delete-ip"
+	AuditPreHook        = "pre-hook"
+	AuditPostHook       = "post-hook"
+
+	AuditAllowed  = "allowed"
+	AuditRejected = "rejected"
+)
+
+// AuditEntry is a record of a state mutation or of a hook decision. Before and
+// After have the json representation of the modified object, before and after
+// the mutation, or, for hook decisions, of the request received and sent.
+type AuditEntry struct {
+	Timestamp time.Time       `json:"timestamp"`
+	Actor     string          `json:"actor,omitempty"`
+	Node      string          `json:"node,omitempty"`
+	Action    string          `json:"action,omitempty"`
+	Container string          `json:"container,omitempty"`
+	Owners    []string        `json:"owners,omitempty"`
+	Policies  []string        `json:"policies,omitempty"`
+	Decision  string          `json:"decision,omitempty"`
+	Reason    string          `json:"reason,omitempty"`
+	Before    json.RawMessage `json:"before,omitempty"`
+	After     json.RawMessage `json:"after,omitempty"`
+}
+
+// Value marshals the receiver AuditEntry into a json string.
+func (a AuditEntry) Value() (string, error) {
+	if data, err := json.Marshal(a); err != nil {
+		return "", err
+	} else {
+		return string(data), err
+	}
+}
+
+// Scan unmarshals the input into the receiver AuditEntry.
+func (a *AuditEntry) Scan(input string) error {
+	return json.Unmarshal([]byte(input), a)
+}
+
+// NewHookAuditEntry returns an AuditEntry for the given hook action and
+// container with the owners and names of the given policies.
+func NewHookAuditEntry(action, container string, policies []PolicySource) AuditEntry {
+	entry := AuditEntry{Action: action, Container: container}
+	for _, policySource := range policies {
+		entry.Owners = append(entry.Owners, policySource.Owner)
+		for _, policy := range policySource.Policies {
+			entry.Policies = append(entry.Policies, policy.Name)
+		}
+	}
+	return entry
+}
+
+// AuditFilter selects audit entries. Empty fields match all entries.
+type AuditFilter struct {
+	Container string
+	Owner     string
+	Since     time.Time
+	Until     time.Time
+}
+
+// Matches returns true if the given entry is selected by the receiver's
+// AuditFilter.
+func (f AuditFilter) Matches(entry AuditEntry) bool {
+	if f.Container != "" && f.Container != entry.Container {
+		return false
+	}
+	if f.Owner != "" {
+		found := false
+		for _, owner := range entry.Owners {
+			if owner == f.Owner {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	if !f.Since.IsZero() && entry.Timestamp.Before(f.Since) {
+		return false
+	}
+	if !f.Until.IsZero() && entry.Timestamp.After(f.Until) {
+		return false
+	}
+	return true
+}
@@ -0,0 +1,44 @@
+package profile
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestNewHookAuditEntry(t *testing.T) {
+	policies := []PolicySource{
+		PolicySource{Owner: "root", Policies: []Policy{Policy{Name: "p1"}, Policy{Name: "p2"}}},
+		PolicySource{Owner: "foo", Policies: []Policy{Policy{Name: "p3"}}},
+	}
+	entry := NewHookAuditEntry(AuditPreHook, "web", policies)
+	if want := []string{"root", "foo"}; !reflect.DeepEqual(entry.Owners, want) {
+		t.Errorf("invalid owners:\ngot  %s\nwant %s", entry.Owners, want)
+	}
+	if want := []string{"p1", "p2", "p3"}; !reflect.DeepEqual(entry.Policies, want) {
+		t.Errorf("invalid policies:\ngot  %s\nwant %s", entry.Policies, want)
+	}
+}
+
+func TestAuditFilterMatches(t *testing.T) {
+	now := time.Now()
+	entry := AuditEntry{Timestamp: now, Container: "web", Owners: []string{"root", "foo"}}
+	tests := []struct {
+		filter AuditFilter
+		want   bool
+	}{
+		{AuditFilter{}, true},
+		{AuditFilter{Container: "web"}, true},
+		{AuditFilter{Container: "db"}, false},
+		{AuditFilter{Owner: "foo"}, true},
+		{AuditFilter{Owner: "bar"}, false},
+		{AuditFilter{Since: now.Add(-time.Minute), Until: now.Add(time.Minute)}, true},
+		{AuditFilter{Since: now.Add(time.Minute)}, false},
+		{AuditFilter{Until: now.Add(-time.Minute)}, false},
+	}
+	for _, tt := range tests {
+		if got := tt.filter.Matches(entry); got != tt.want {
+			t.Errorf("invalid match for filter %+v:\ngot  %t\nwant %t", tt.filter, got, tt.want)
+		}
+	}
+}
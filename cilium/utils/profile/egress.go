@@ -0,0 +1,127 @@
+package profile
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"regexp"
+)
+
+// DefaultEgressDestination is the destination of the egress gateways that
+// don't set any.
+const DefaultEgressDestination = "0.0.0.0/0"
+
+var egressName = regexp.MustCompile(`^[a-zA-Z0-9_.-]+$`)
+
+// Egress is the gateway, in every node, from the endpoints of a network, or of
+// a namespace, to the destinations outside of the cluster. Their packets are
+// masqueraded through the node's uplink unless their owners have a fixed
+// egress IP.
+type Egress struct {
+	Name string `json:"name" yaml:"name"`
+	// Network, or Namespace and Subnets, are the source of the packets.
+	Network   string   `json:"network,omitempty" yaml:"network,omitempty"`
+	Namespace int      `json:"namespace,omitempty" yaml:"namespace,omitempty"`
+	Subnets   []string `json:"subnets,omitempty" yaml:"subnets,omitempty"`
+	// Destinations are routed through the gateway, DefaultEgressDestination
+	// if not set.
+	Destinations []string `json:"destinations,omitempty" yaml:"destinations,omitempty"`
+	// Uplink is the node's interface used by the gateway, any interface
+	// other than the host port if not set.
+	Uplink   string    `json:"uplink,omitempty" yaml:"uplink,omitempty"`
+	OwnerIPs []OwnerIP `json:"owner-ips,omitempty" yaml:"owner-ips,omitempty"`
+}
+
+// OwnerIP is the fixed egress IP of the endpoints covered by the policies of
+// an owner. It must be an address of every node's uplink.
+type OwnerIP struct {
+	Owner string `json:"owner" yaml:"owner"`
+	IP    string `json:"ip" yaml:"ip"`
+}
+
+// Value marshals the receiver Egress into a json string.
+func (e Egress) Value() (string, error) {
+	if data, err := json.Marshal(e); err != nil {
+		return "", err
+	} else {
+		return string(data), err
+	}
+}
+
+// Scan unmarshals the input into the receiver Egress.
+func (e *Egress) Scan(input string) error {
+	return json.Unmarshal([]byte(input), e)
+}
+
+// Validate returns an error if the receiver Egress doesn't have a valid name,
+// a network or a namespace with subnets, valid destinations and owner IPs.
+func (e Egress) Validate() error {
+	if !egressName.MatchString(e.Name) {
+		return fmt.Errorf("invalid egress name %q", e.Name)
+	}
+	switch {
+	case e.Network != "" && (e.Namespace != 0 || len(e.Subnets) != 0):
+		return fmt.Errorf("egress %s has both a network and a namespace or subnets", e.Name)
+	case e.Network == "" && (e.Namespace < DefaultNamespace || len(e.Subnets) == 0):
+		return fmt.Errorf("egress %s needs a network or a namespace with subnets", e.Name)
+	}
+	for _, cidr := range append(append([]string{}, e.Subnets...), e.Destinations...) {
+		if _, _, err := net.ParseCIDR(cidr); err != nil {
+			return fmt.Errorf("egress %s has an invalid subnet: %s", e.Name, err)
+		}
+	}
+	if len(e.Uplink) > 15 {
+		return fmt.Errorf("egress %s has an invalid uplink %q", e.Name, e.Uplink)
+	}
+	for _, ownerIP := range e.OwnerIPs {
+		if ownerIP.Owner == "" || net.ParseIP(ownerIP.IP) == nil {
+			return fmt.Errorf("egress %s has an invalid IP %q for owner %q", e.Name, ownerIP.IP, ownerIP.Owner)
+		}
+	}
+	return nil
+}
+
+// Resolve returns the receiver Egress with the namespace and subnets of its
+// network, from the given ones, and its default destination.
+func (e Egress) Resolve(networks []Network) (Egress, error) {
+	if e.Network != "" {
+		found := false
+		for _, network := range networks {
+			if network.Name == e.Network {
+				e.Namespace, e.Subnets, found = network.Namespace, network.Subnets, true
+				break
+			}
+		}
+		if !found {
+			return e, fmt.Errorf("network %s of egress %s doesn't exist", e.Network, e.Name)
+		}
+	}
+	if len(e.Destinations) == 0 {
+		e.Destinations = []string{DefaultEgressDestination}
+	}
+	return e, nil
+}
+
+// Contains returns true if the given IP belongs to the subnets of the receiver
+// Egress.
+func (e Egress) Contains(ip net.IP) bool {
+	for _, cidr := range e.Subnets {
+		if _, subnet, err := net.ParseCIDR(cidr); err == nil && subnet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// IPOf returns the fixed egress IP of the first of the given owners that has
+// one, an empty string if none has.
+func (e Egress) IPOf(owners []string) string {
+	for _, owner := range owners {
+		for _, ownerIP := range e.OwnerIPs {
+			if ownerIP.Owner == owner {
+				return ownerIP.IP
+			}
+		}
+	}
+	return ""
+}
@@ -0,0 +1,60 @@
+package profile
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestEgressValidate(t *testing.T) {
+	valid := []Egress{
+		{Name: "web", Network: "web"},
+		{Name: "ns-2", Namespace: 2, Subnets: []string{"10.1.0.0/24"}, Destinations: []string{"192.168.50.0/24"},
+			Uplink: "eth0", OwnerIPs: []OwnerIP{{Owner: "alice", IP: "192.168.50.100"}}},
+	}
+	for _, egress := range valid {
+		if err := egress.Validate(); err != nil {
+			t.Errorf("error while validating egress %+v: %s", egress, err)
+		}
+	}
+
+	invalid := []Egress{
+		{Name: "web gw", Network: "web"},
+		{Name: "web"},
+		{Name: "web", Network: "web", Namespace: 2},
+		{Name: "web", Namespace: 2},
+		{Name: "web", Network: "web", Destinations: []string{"192.168.50.1"}},
+		{Name: "web", Network: "web", Uplink: "a-very-long-uplink"},
+		{Name: "web", Network: "web", OwnerIPs: []OwnerIP{{Owner: "alice"}}},
+	}
+	for _, egress := range invalid {
+		if err := egress.Validate(); err == nil {
+			t.Errorf("invalid egress %+v was validated", egress)
+		}
+	}
+}
+
+func TestEgressResolve(t *testing.T) {
+	networks := []Network{{Name: "web", Subnets: []string{"10.1.0.0/24"}, Namespace: 2}}
+	got, err := Egress{Name: "web", Network: "web"}.Resolve(networks)
+	if err != nil {
+		t.Fatalf("error while resolving egress: %s", err)
+	}
+	want := Egress{Name: "web", Network: "web", Namespace: 2, Subnets: []string{"10.1.0.0/24"},
+		Destinations: []string{DefaultEgressDestination}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("invalid egress:\ngot  %+v\nwant %+v", got, want)
+	}
+	if _, err := (Egress{Name: "db", Network: "db"}).Resolve(networks); err == nil {
+		t.Errorf("egress of a missing network was resolved")
+	}
+}
+
+func TestEgressIPOf(t *testing.T) {
+	egress := Egress{OwnerIPs: []OwnerIP{{Owner: "alice", IP: "192.168.50.100"}, {Owner: "bob", IP: "192.168.50.101"}}}
+	if got := egress.IPOf([]string{"carol", "bob", "alice"}); got != "192.168.50.101" {
+		t.Errorf("invalid egress IP:\ngot  %s\nwant %s", got, "192.168.50.101")
+	}
+	if got := egress.IPOf([]string{"carol"}); got != "" {
+		t.Errorf("invalid egress IP:\ngot  %s\nwant none", got)
+	}
+}
@@ -0,0 +1,114 @@
+package profile
+
+import (
+	"encoding/json"
+	"net"
+	"time"
+)
+
+const (
+	EndpointAssigned = "assigned"
+	EndpointReleased = "released"
+)
+
+// EndpointRecord is a record of the assignment, or release, of the IPs of an
+// endpoint. Records are never modified so they keep which container had each
+// IP over time.
+type EndpointRecord struct {
+	Timestamp time.Time         `json:"timestamp"`
+	Action    string            `json:"action,omitempty"`
+	Container string            `json:"container,omitempty"`
+	Name      string            `json:"name,omitempty"`
+	Labels    map[string]string `json:"labels,omitempty"`
+	Node      string            `json:"node,omitempty"`
+	IPs       IPs               `json:"ips,omitempty"`
+	MACs      MACs              `json:"macs,omitempty"`
+	Group     int               `json:"group,omitempty"`
+}
+
+// NewEndpointRecord returns a record, without timestamp, of the given action
+// on the given endpoint.
+func NewEndpointRecord(action string, endpoint Endpoint) EndpointRecord {
+	return EndpointRecord{
+		Action:    action,
+		Container: endpoint.Container,
+		Name:      endpoint.Name,
+		Labels:    endpoint.Labels,
+		Node:      endpoint.Node,
+		IPs:       endpoint.IPs,
+		MACs:      endpoint.MACs,
+		Group:     endpoint.Group,
+	}
+}
+
+// Value marshals the receiver EndpointRecord into a json string.
+func (er EndpointRecord) Value() (string, error) {
+	if data, err := json.Marshal(er); err != nil {
+		return "", err
+	} else {
+		return string(data), err
+	}
+}
+
+// Scan unmarshals the input into the receiver EndpointRecord.
+func (er *EndpointRecord) Scan(input string) error {
+	return json.Unmarshal([]byte(input), er)
+}
+
+// HasIP returns true if the given IP is one of the receiver's IPs.
+func (er EndpointRecord) HasIP(ip net.IP) bool {
+	for _, recordIP := range er.IPs {
+		if recordIP.Equal(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// EndpointRecordFilter selects endpoint records. Empty fields match all
+// records.
+type EndpointRecordFilter struct {
+	Container string
+	IP        net.IP
+	Since     time.Time
+	Until     time.Time
+}
+
+// Matches returns true if the given record is selected by the receiver's
+// EndpointRecordFilter.
+func (f EndpointRecordFilter) Matches(record EndpointRecord) bool {
+	if f.Container != "" && f.Container != record.Container {
+		return false
+	}
+	if f.IP != nil && !record.HasIP(f.IP) {
+		return false
+	}
+	if !f.Since.IsZero() && record.Timestamp.Before(f.Since) {
+		return false
+	}
+	if !f.Until.IsZero() && record.Timestamp.After(f.Until) {
+		return false
+	}
+	return true
+}
+
+// IPHolderAt returns the record of the endpoint that had the given IP at the
+// given time, false if none had it. records must be sorted by timestamp.
+func IPHolderAt(records []EndpointRecord, ip net.IP, t time.Time) (EndpointRecord, bool) {
+	var (
+		last  EndpointRecord
+		found bool
+	)
+	for _, record := range records {
+		if record.Timestamp.After(t) {
+			break
+		}
+		if record.HasIP(ip) {
+			last, found = record, true
+		}
+	}
+	if !found || last.Action != EndpointAssigned {
+		return EndpointRecord{}, false
+	}
+	return last, true
+}
@@ -0,0 +1,56 @@
+package profile
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func TestIPHolderAt(t *testing.T) {
+	ip := net.ParseIP("10.0.0.2")
+	t0 := time.Date(2016, 3, 1, 10, 0, 0, 0, time.UTC)
+	records := []EndpointRecord{
+		{Timestamp: t0, Action: EndpointAssigned, Container: "1", IPs: IPs{ip}},
+		{Timestamp: t0.Add(time.Minute), Action: EndpointAssigned, Container: "2", IPs: IPs{net.ParseIP("10.0.0.3")}},
+		{Timestamp: t0.Add(time.Hour), Action: EndpointReleased, Container: "1", IPs: IPs{ip}},
+		{Timestamp: t0.Add(2 * time.Hour), Action: EndpointAssigned, Container: "3", IPs: IPs{ip}},
+	}
+	tests := []struct {
+		at   time.Time
+		want string
+	}{
+		{t0.Add(-time.Second), ""},
+		{t0, "1"},
+		{t0.Add(30 * time.Minute), "1"},
+		{t0.Add(90 * time.Minute), ""},
+		{t0.Add(3 * time.Hour), "3"},
+	}
+	for _, test := range tests {
+		record, found := IPHolderAt(records, ip, test.at)
+		if found != (test.want != "") || record.Container != test.want {
+			t.Errorf("invalid holder at %s:\ngot  %q\nwant %q", test.at, record.Container, test.want)
+		}
+	}
+}
+
+func TestEndpointRecordFilter(t *testing.T) {
+	t0 := time.Date(2016, 3, 1, 10, 0, 0, 0, time.UTC)
+	record := EndpointRecord{Timestamp: t0, Container: "1", IPs: IPs{net.ParseIP("f00d::1")}}
+	tests := []struct {
+		filter EndpointRecordFilter
+		want   bool
+	}{
+		{EndpointRecordFilter{}, true},
+		{EndpointRecordFilter{Container: "1", IP: net.ParseIP("f00d::1")}, true},
+		{EndpointRecordFilter{Container: "2"}, false},
+		{EndpointRecordFilter{IP: net.ParseIP("f00d::2")}, false},
+		{EndpointRecordFilter{Since: t0, Until: t0}, true},
+		{EndpointRecordFilter{Since: t0.Add(time.Second)}, false},
+		{EndpointRecordFilter{Until: t0.Add(-time.Second)}, false},
+	}
+	for _, test := range tests {
+		if got := test.filter.Matches(record); got != test.want {
+			t.Errorf("invalid match of %+v:\ngot  %t\nwant %t", test.filter, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,88 @@
+package profile
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+)
+
+// HostPort is a port of a node's IP published by cilium, instead of docker,
+// and DNATed to a port of a container's endpoint.
+type HostPort struct {
+	HostIP    string `json:"host-ip"`
+	HostPort  int    `json:"host-port"`
+	Protocol  string `json:"protocol"`
+	Node      string `json:"node"`
+	Container string `json:"container"`
+	IP        string `json:"ip"`
+	Port      int    `json:"port"`
+}
+
+// Key returns the receiver's host IP, port and protocol, unique in the whole
+// cluster.
+func (h HostPort) Key() string {
+	return fmt.Sprintf("%s:%d/%s", h.HostIP, h.HostPort, h.Protocol)
+}
+
+// Value marshals the receiver HostPort into a json string.
+func (h HostPort) Value() (string, error) {
+	if data, err := json.Marshal(h); err != nil {
+		return "", err
+	} else {
+		return string(data), err
+	}
+}
+
+// Scan unmarshals the input into the receiver HostPort.
+func (h *HostPort) Scan(input string) error {
+	return json.Unmarshal([]byte(input), h)
+}
+
+// HostPortsOf returns the host ports, of the given node, that publish the
+// given port bindings of the container with the given ID and IP. Bindings
+// without a host IP, or bound to all addresses, use the node's IP. Bindings
+// must have a host port, cilium doesn't pick one.
+func HostPortsOf(bindings PortBindings, node, containerID string, ip net.IP) ([]HostPort, error) {
+	hostPorts := []HostPort{}
+	for port, binds := range bindings {
+		containerPort, err := strconv.Atoi(port.Port())
+		if err != nil || containerPort <= 0 || containerPort > 65535 {
+			return nil, fmt.Errorf("invalid port %q", port)
+		}
+		if proto := port.Proto(); proto != "tcp" && proto != "udp" {
+			return nil, fmt.Errorf("invalid protocol of port %q", port)
+		}
+		for _, bind := range binds {
+			hostPort, err := strconv.Atoi(bind.HostPort)
+			if err != nil || hostPort <= 0 || hostPort > 65535 {
+				return nil, fmt.Errorf("port %q needs a host port, got %q", port, bind.HostPort)
+			}
+			hostIP := bind.HostIP
+			if hostIP == "" || hostIP == "0.0.0.0" {
+				hostIP = node
+			}
+			if net.ParseIP(hostIP) == nil {
+				return nil, fmt.Errorf("invalid host IP %q of port %q", hostIP, port)
+			}
+			hostPorts = append(hostPorts, HostPort{
+				HostIP:    hostIP,
+				HostPort:  hostPort,
+				Protocol:  port.Proto(),
+				Node:      node,
+				Container: containerID,
+				IP:        ip.String(),
+				Port:      containerPort,
+			})
+		}
+	}
+	sort.Sort(hostPortsByKey(hostPorts))
+	return hostPorts, nil
+}
+
+type hostPortsByKey []HostPort
+
+func (h hostPortsByKey) Len() int           { return len(h) }
+func (h hostPortsByKey) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+func (h hostPortsByKey) Less(i, j int) bool { return h[i].Key() < h[j].Key() }
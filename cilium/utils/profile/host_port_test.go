@@ -0,0 +1,41 @@
+package profile
+
+import (
+	"net"
+	"reflect"
+	"testing"
+)
+
+func TestHostPortsOf(t *testing.T) {
+	bindings := PortBindings{
+		"80/tcp": {{HostPort: "8080"}, {HostIP: "192.168.50.100", HostPort: "80"}},
+		"53/udp": {{HostIP: "0.0.0.0", HostPort: "5353"}},
+	}
+	got, err := HostPortsOf(bindings, "192.168.50.10", "a", net.ParseIP("10.1.0.5"))
+	if err != nil {
+		t.Fatalf("error while getting host ports: %s", err)
+	}
+	want := []HostPort{
+		{HostIP: "192.168.50.100", HostPort: 80, Protocol: "tcp", Node: "192.168.50.10", Container: "a", IP: "10.1.0.5", Port: 80},
+		{HostIP: "192.168.50.10", HostPort: 5353, Protocol: "udp", Node: "192.168.50.10", Container: "a", IP: "10.1.0.5", Port: 53},
+		{HostIP: "192.168.50.10", HostPort: 8080, Protocol: "tcp", Node: "192.168.50.10", Container: "a", IP: "10.1.0.5", Port: 80},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("invalid host ports:\ngot  %+v\nwant %+v", got, want)
+	}
+
+	invalid := []PortBindings{
+		{"80/tcp": {{}}},
+		{"80/sctp": {{HostPort: "8080"}}},
+		{"80-90/tcp": {{HostPort: "8080"}}},
+		{"80/tcp": {{HostIP: "localhost", HostPort: "8080"}}},
+	}
+	for _, bindings := range invalid {
+		if _, err := HostPortsOf(bindings, "192.168.50.10", "a", net.ParseIP("10.1.0.5")); err == nil {
+			t.Errorf("invalid port bindings %+v were accepted", bindings)
+		}
+	}
+	if got, want := (HostPort{HostIP: "192.168.50.10", HostPort: 8080, Protocol: "tcp"}).Key(), "192.168.50.10:8080/tcp"; got != want {
+		t.Errorf("invalid key:\ngot  %s\nwant %s", got, want)
+	}
+}
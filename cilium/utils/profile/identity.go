@@ -0,0 +1,200 @@
+package profile
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Identities are allocated from FirstIdentityID to LastIdentityID so they fit
+// in the 12 bits of the group of the overlay's VNI schemes. Groups set by hand
+// must be lower than FirstIdentityID.
+const (
+	FirstIdentityID = 2048
+	LastIdentityID  = 4095
+)
+
+// IdentityOwnerLabel is the identity label, when it's one of the identity
+// label keys, whose value is the owners of the policies covering the
+// container.
+const IdentityOwnerLabel = "cilium.owner"
+
+// Identity is a group ID allocated, cluster-wide, to all endpoints with the
+// same identity labels.
+type Identity struct {
+	ID     int               `json:"id" yaml:"id"`
+	Labels map[string]string `json:"labels,omitempty" yaml:"labels,omitempty"`
+	// Endpoints are the containers using the identity, it's released when
+	// none does.
+	Endpoints []string `json:"endpoints,omitempty" yaml:"endpoints,omitempty"`
+}
+
+// Selects returns true if every label of selector has a key of the receiver's
+// Identity labels whose value matches its regex expression.
+func (i Identity) Selects(selector map[string]string) bool {
+	for key, expr := range selector {
+		value, ok := i.Labels[key]
+		if !ok {
+			return false
+		}
+		if match, _ := regexp.MatchString(expr, value); !match {
+			return false
+		}
+	}
+	return true
+}
+
+// Identities are all identities allocated in the cluster.
+type Identities struct {
+	Identities []Identity `json:"identities,omitempty" yaml:"identities,omitempty"`
+}
+
+// Value marshals the receiver Identities into a json string.
+func (is Identities) Value() (string, error) {
+	if data, err := json.Marshal(is); err != nil {
+		return "", err
+	} else {
+		return string(data), err
+	}
+}
+
+// Scan unmarshals the input into the receiver Identities.
+func (is *Identities) Scan(input string) error {
+	return json.Unmarshal([]byte(input), is)
+}
+
+// Acquire returns the ID of the identity with the given labels, allocating
+// it if it doesn't exist, and counts the given container as one of its
+// endpoints. The container stops using any other identity.
+func (is *Identities) Acquire(labels map[string]string, container string) (int, error) {
+	is.Release(container)
+	used := map[int]bool{}
+	for i, identity := range is.Identities {
+		if sameLabels(identity.Labels, labels) {
+			is.Identities[i].Endpoints = append(identity.Endpoints, container)
+			return identity.ID, nil
+		}
+		used[identity.ID] = true
+	}
+	for id := FirstIdentityID; id <= LastIdentityID; id++ {
+		if !used[id] {
+			is.Identities = append(is.Identities, Identity{ID: id, Labels: labels, Endpoints: []string{container}})
+			return id, nil
+		}
+	}
+	return 0, fmt.Errorf("all %d identities are allocated", LastIdentityID-FirstIdentityID+1)
+}
+
+// sameLabels returns true if a and b have the same labels, nil and empty
+// labels are the same.
+func sameLabels(a, b map[string]string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for key, value := range a {
+		if otherValue, ok := b[key]; !ok || otherValue != value {
+			return false
+		}
+	}
+	return true
+}
+
+// Release stops counting the given container as an endpoint of its identity,
+// which is released if it doesn't have more endpoints.
+func (is *Identities) Release(container string) {
+	identities := []Identity{}
+	for _, identity := range is.Identities {
+		endpoints := []string{}
+		for _, endpoint := range identity.Endpoints {
+			if endpoint != container {
+				endpoints = append(endpoints, endpoint)
+			}
+		}
+		if len(endpoints) != 0 {
+			identity.Endpoints = endpoints
+			identities = append(identities, identity)
+		}
+	}
+	is.Identities = identities
+}
+
+// Merge adds the given identity, and its endpoints, to the receiver's
+// Identities. It returns an error, without changing them, if the identity's
+// ID or labels belong to another identity.
+func (is *Identities) Merge(identity Identity) error {
+	for i, other := range is.Identities {
+		idMatches, labelsMatch := other.ID == identity.ID, sameLabels(other.Labels, identity.Labels)
+		if idMatches != labelsMatch {
+			return fmt.Errorf("identity %d with labels %v conflicts with identity %d with labels %v",
+				identity.ID, identity.Labels, other.ID, other.Labels)
+		}
+		if !idMatches {
+			continue
+		}
+		for _, endpoint := range identity.Endpoints {
+			if !containsString(other.Endpoints, endpoint) {
+				is.Identities[i].Endpoints = append(is.Identities[i].Endpoints, endpoint)
+			}
+		}
+		return nil
+	}
+	is.Identities = append(is.Identities, identity)
+	return nil
+}
+
+func containsString(slice []string, s string) bool {
+	for _, elem := range slice {
+		if elem == s {
+			return true
+		}
+	}
+	return false
+}
+
+// Select returns the IDs, sorted, of the identities selected by the given
+// selector.
+func (is Identities) Select(selector map[string]string) []int {
+	ids := []int{}
+	for _, identity := range is.Identities {
+		if identity.Selects(selector) {
+			ids = append(ids, identity.ID)
+		}
+	}
+	sort.Ints(ids)
+	return ids
+}
+
+// IdentityLabels returns the identity labels, with the given keys, of a
+// container with the given labels covered by policies of the given owners.
+func IdentityLabels(keys, owners []string, labels map[string]string) map[string]string {
+	identityLabels := map[string]string{}
+	for _, key := range keys {
+		if key == IdentityOwnerLabel {
+			sorted := append([]string{}, owners...)
+			sort.Strings(sorted)
+			identityLabels[key] = strings.Join(sorted, ",")
+		} else if value, ok := labels[key]; ok {
+			identityLabels[key] = value
+		}
+	}
+	return identityLabels
+}
+
+// ParseSelector returns the labels of a selector with the format
+// key1=regex1,key2=regex2.
+func ParseSelector(selector string) (map[string]string, error) {
+	labels := map[string]string{}
+	for _, pair := range strings.Split(selector, ",") {
+		kv := strings.SplitN(strings.TrimSpace(pair), "=", 2)
+		if len(kv) != 2 || kv[0] == "" {
+			return nil, fmt.Errorf("invalid selector %q, it must be key=value[,key=value]", selector)
+		}
+		if _, err := regexp.Compile(kv[1]); err != nil {
+			return nil, fmt.Errorf("invalid selector %q: %s", selector, err)
+		}
+		labels[kv[0]] = kv[1]
+	}
+	return labels, nil
+}
@@ -0,0 +1,107 @@
+package profile
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestIdentities(t *testing.T) {
+	is := Identities{}
+	web := map[string]string{"com.intent.service": "web", IdentityOwnerLabel: "foo"}
+	db := map[string]string{"com.intent.service": "db", IdentityOwnerLabel: "foo"}
+	ids := []int{}
+	for _, acquire := range []struct {
+		labels    map[string]string
+		container string
+	}{{web, "1"}, {web, "2"}, {db, "3"}} {
+		id, err := is.Acquire(acquire.labels, acquire.container)
+		if err != nil {
+			t.Fatalf("error while acquiring identity of container %s: %s", acquire.container, err)
+		}
+		ids = append(ids, id)
+	}
+	if want := []int{FirstIdentityID, FirstIdentityID, FirstIdentityID + 1}; !reflect.DeepEqual(ids, want) {
+		t.Errorf("invalid identities:\ngot  %v\nwant %v", ids, want)
+	}
+
+	if got, want := is.Select(map[string]string{IdentityOwnerLabel: "^foo$"}), []int{FirstIdentityID, FirstIdentityID + 1}; !reflect.DeepEqual(got, want) {
+		t.Errorf("invalid identities selected by owner:\ngot  %v\nwant %v", got, want)
+	}
+	if got, want := is.Select(map[string]string{"com.intent.service": "^w", IdentityOwnerLabel: "foo"}), []int{FirstIdentityID}; !reflect.DeepEqual(got, want) {
+		t.Errorf("invalid identities selected by service and owner:\ngot  %v\nwant %v", got, want)
+	}
+
+	// The web identity is kept while container 2 uses it and its ID is reused
+	// once released.
+	is.Release("1")
+	is.Release("1")
+	if got := is.Select(nil); len(got) != 2 {
+		t.Errorf("invalid identities after releasing container 1:\ngot  %v\nwant 2 identities", got)
+	}
+	is.Release("2")
+	other := map[string]string{"com.intent.service": "cache"}
+	if id, err := is.Acquire(other, "4"); err != nil || id != FirstIdentityID {
+		t.Errorf("invalid identity after releasing all endpoints of %v:\ngot  %d, %v\nwant %d", web, id, err, FirstIdentityID)
+	}
+
+	// A container changing labels moves to another identity.
+	if id, _ := is.Acquire(db, "4"); id != FirstIdentityID+1 {
+		t.Errorf("invalid identity after changing labels:\ngot  %d\nwant %d", id, FirstIdentityID+1)
+	}
+	if got, want := is.Select(nil), []int{FirstIdentityID + 1}; !reflect.DeepEqual(got, want) {
+		t.Errorf("invalid identities after changing labels:\ngot  %v\nwant %v", got, want)
+	}
+}
+
+func TestIdentitiesMerge(t *testing.T) {
+	web := map[string]string{"com.intent.service": "web"}
+	db := map[string]string{"com.intent.service": "db"}
+	is := Identities{Identities: []Identity{{ID: FirstIdentityID, Labels: web, Endpoints: []string{"1"}}}}
+	if err := is.Merge(Identity{ID: FirstIdentityID, Labels: web, Endpoints: []string{"1", "2"}}); err != nil {
+		t.Fatalf("error while merging identity: %s", err)
+	}
+	if err := is.Merge(Identity{ID: FirstIdentityID + 1, Labels: db, Endpoints: []string{"3"}}); err != nil {
+		t.Fatalf("error while merging identity: %s", err)
+	}
+	want := []Identity{
+		{ID: FirstIdentityID, Labels: web, Endpoints: []string{"1", "2"}},
+		{ID: FirstIdentityID + 1, Labels: db, Endpoints: []string{"3"}},
+	}
+	if !reflect.DeepEqual(is.Identities, want) {
+		t.Errorf("invalid merged identities:\ngot  %+v\nwant %+v", is.Identities, want)
+	}
+
+	for _, identity := range []Identity{
+		{ID: FirstIdentityID, Labels: db},
+		{ID: FirstIdentityID + 2, Labels: web},
+	} {
+		if err := is.Merge(identity); err == nil {
+			t.Errorf("conflicting identity %+v was merged", identity)
+		}
+	}
+	if !reflect.DeepEqual(is.Identities, want) {
+		t.Errorf("invalid identities after conflicts:\ngot  %+v\nwant %+v", is.Identities, want)
+	}
+}
+
+func TestIdentityLabels(t *testing.T) {
+	labels := map[string]string{"com.intent.service": "web", "com.intent.version": "1"}
+	got := IdentityLabels([]string{"com.intent.service", "com.intent.tier", IdentityOwnerLabel}, []string{"foo", "bar"}, labels)
+	want := map[string]string{"com.intent.service": "web", IdentityOwnerLabel: "bar,foo"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("invalid identity labels:\ngot  %v\nwant %v", got, want)
+	}
+}
+
+func TestParseSelector(t *testing.T) {
+	got, err := ParseSelector("com.intent.service=^web$, cilium.owner=foo")
+	want := map[string]string{"com.intent.service": "^web$", "cilium.owner": "foo"}
+	if err != nil || !reflect.DeepEqual(got, want) {
+		t.Errorf("invalid selector:\ngot  %v, %v\nwant %v", got, err, want)
+	}
+	for _, invalid := range []string{"", "web", "=web", "com.intent.service=(web"} {
+		if _, err := ParseSelector(invalid); err == nil {
+			t.Errorf("invalid selector %q was parsed", invalid)
+		}
+	}
+}
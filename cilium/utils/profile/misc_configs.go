@@ -107,15 +107,20 @@ type IPs []net.IP
 type MACs []string
 
 type Endpoint struct {
-	Container string `json:"container,omitempty" yaml:"container,omitempty"`
-	IPs       IPs    `json:"ips,omitempty" yaml:"ips,omitempty"`
-	MACs      MACs   `json:"macs,omitempty" yaml:"macs,omitempty"`
-	Node      string `json:"node,omitempty" yaml:"node,omitempty"`
-	Interface string `json:"interface,omitempty" yaml:"interface,omitempty"`
-	Group     int    `json:"group,omitempty" yaml:"group,omitempty"`
-	BD        int    `json:"bd,omitempty" yaml:"bd,omitempty"`
-	Namespace int    `json:"namespace,omitempty" yaml:"namespace,omitempty"`
-	Service   string `json:"service,omitempty" yaml:"service,omitempty"`
+	Container string            `json:"container,omitempty" yaml:"container,omitempty"`
+	Name      string            `json:"name,omitempty" yaml:"name,omitempty"`
+	Labels    map[string]string `json:"labels,omitempty" yaml:"labels,omitempty"`
+	IPs       IPs               `json:"ips,omitempty" yaml:"ips,omitempty"`
+	MACs      MACs              `json:"macs,omitempty" yaml:"macs,omitempty"`
+	Node      string            `json:"node,omitempty" yaml:"node,omitempty"`
+	Interface string            `json:"interface,omitempty" yaml:"interface,omitempty"`
+	Bridge    string            `json:"bridge,omitempty" yaml:"bridge,omitempty"`
+	Group     int               `json:"group,omitempty" yaml:"group,omitempty"`
+	BD        int               `json:"bd,omitempty" yaml:"bd,omitempty"`
+	Namespace int               `json:"namespace,omitempty" yaml:"namespace,omitempty"`
+	Network   string            `json:"network,omitempty" yaml:"network,omitempty"`
+	Service   string            `json:"service,omitempty" yaml:"service,omitempty"`
+	Domains   []string          `json:"domains,omitempty" yaml:"domains,omitempty"`
 }
 
 // Value marshals the receiver Endpoint into a json string.
@@ -0,0 +1,310 @@
+package profile
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"reflect"
+)
+
+// Isolation modes of a network, they set which networks share its namespace,
+// i.e. which networks its endpoints can route to.
+const (
+	// IsolationNone shares DefaultNamespace with the endpoints that aren't
+	// in any network and with the other networks without isolation.
+	IsolationNone = "none"
+	// IsolationOwner shares the namespace with the other networks, of the
+	// same owner, isolated by owner.
+	IsolationOwner = "owner"
+	// IsolationNetwork gives the network a namespace of its own.
+	IsolationNetwork = "network"
+)
+
+// DefaultBD and DefaultNamespace are the IDs used by endpoints that aren't in
+// any network, they are never allocated to a network.
+const (
+	DefaultBD        = 1
+	DefaultNamespace = 1
+)
+
+// Network is a broadcast domain, with its subnets, whose IDs are allocated by
+// cilium so they aren't shared by accident.
+type Network struct {
+	Name  string `json:"name,omitempty" yaml:"name,omitempty"`
+	Owner string `json:"owner,omitempty" yaml:"owner,omitempty"`
+	// Members are the owners, besides Owner, whose policies can attach
+	// containers to the network.
+	Members []string `json:"members,omitempty" yaml:"members,omitempty"`
+	// Subnets are CIDRs, IPs are assigned from the first one with free IPs.
+	Subnets   []string `json:"subnets,omitempty" yaml:"subnets,omitempty"`
+	Gateway   string   `json:"gateway,omitempty" yaml:"gateway,omitempty"`
+	Isolation string   `json:"isolation,omitempty" yaml:"isolation,omitempty"`
+	// BD and Namespace are allocated by cilium, the ones set in configuration
+	// files are ignored.
+	BD        int `json:"bd,omitempty" yaml:"bd,omitempty"`
+	Namespace int `json:"namespace,omitempty" yaml:"namespace,omitempty"`
+}
+
+// Value marshals the receiver Network into a json string.
+func (n Network) Value() (string, error) {
+	if data, err := json.Marshal(n); err != nil {
+		return "", err
+	} else {
+		return string(data), err
+	}
+}
+
+// Scan unmarshals the input into the receiver Network.
+func (n *Network) Scan(input string) error {
+	return json.Unmarshal([]byte(input), n)
+}
+
+// Validate returns an error if the receiver's Network is invalid.
+func (n Network) Validate() error {
+	if n.Name == "" {
+		return fmt.Errorf("network without name")
+	}
+	if n.Owner == "" {
+		return fmt.Errorf("network %s without owner", n.Name)
+	}
+	if len(n.Subnets) == 0 {
+		return fmt.Errorf("network %s without subnets", n.Name)
+	}
+	for _, subnet := range n.Subnets {
+		if _, _, err := net.ParseCIDR(subnet); err != nil {
+			return fmt.Errorf("network %s has an invalid subnet: %s", n.Name, err)
+		}
+	}
+	if n.Gateway != "" {
+		gw := net.ParseIP(n.Gateway)
+		if gw == nil {
+			return fmt.Errorf("network %s has an invalid gateway %q", n.Name, n.Gateway)
+		}
+		if !n.Contains(gw) {
+			return fmt.Errorf("network %s has the gateway %s outside of its subnets", n.Name, n.Gateway)
+		}
+	}
+	switch n.Isolation {
+	case "", IsolationNone, IsolationOwner, IsolationNetwork:
+	default:
+		return fmt.Errorf("network %s has an unknown isolation %q", n.Name, n.Isolation)
+	}
+	return nil
+}
+
+// GetIsolation returns the isolation of the receiver's Network,
+// IsolationNetwork if it isn't set.
+func (n Network) GetIsolation() string {
+	if n.Isolation == "" {
+		return IsolationNetwork
+	}
+	return n.Isolation
+}
+
+// Allows returns true if policies of the given owner can attach containers to
+// the receiver's Network.
+func (n Network) Allows(owner string) bool {
+	if owner == n.Owner {
+		return true
+	}
+	for _, member := range n.Members {
+		if owner == member {
+			return true
+		}
+	}
+	return false
+}
+
+// Contains returns true if the given IP belongs to any of the receiver's
+// Network subnets.
+func (n Network) Contains(ip net.IP) bool {
+	for _, subnet := range n.Subnets {
+		if _, ipnet, err := net.ParseCIDR(subnet); err == nil && ipnet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// Overlaps returns the first subnet of the receiver's Network that overlaps
+// with a subnet of other, an empty string if none does.
+func (n Network) Overlaps(other Network) string {
+	for _, subnet := range n.Subnets {
+		_, a, err := net.ParseCIDR(subnet)
+		if err != nil {
+			continue
+		}
+		for _, otherSubnet := range other.Subnets {
+			_, b, err := net.ParseCIDR(otherSubnet)
+			if err != nil {
+				continue
+			}
+			if a.Contains(b.IP) || b.Contains(a.IP) {
+				return subnet
+			}
+		}
+	}
+	return ""
+}
+
+// AllocateNetwork validates the given network against the existing ones and
+// returns it with its BD and namespace IDs. A network already in existing,
+// with the same name, keeps its IDs.
+func AllocateNetwork(network Network, existing []Network) (Network, error) {
+	if err := network.Validate(); err != nil {
+		return network, err
+	}
+	network.Isolation = network.GetIsolation()
+	network.BD, network.Namespace = 0, 0
+	usedBDs := map[int]bool{DefaultBD: true}
+	usedNamespaces := map[int]bool{DefaultNamespace: true}
+	for _, other := range existing {
+		if other.Name == network.Name {
+			if other.GetIsolation() != network.Isolation {
+				return network, fmt.Errorf("isolation of network %s can't be changed from %s to %s",
+					network.Name, other.GetIsolation(), network.Isolation)
+			}
+			network.BD, network.Namespace = other.BD, other.Namespace
+			continue
+		}
+		if subnet := network.Overlaps(other); subnet != "" {
+			return network, fmt.Errorf("subnet %s of network %s overlaps with network %s", subnet, network.Name, other.Name)
+		}
+		usedBDs[other.BD] = true
+		usedNamespaces[other.Namespace] = true
+		if network.Namespace == 0 && network.Isolation == IsolationOwner &&
+			other.GetIsolation() == IsolationOwner && other.Owner == network.Owner {
+			network.Namespace = other.Namespace
+		}
+	}
+	if network.BD == 0 {
+		network.BD = lowestUnused(usedBDs)
+	}
+	if network.Isolation == IsolationNone {
+		network.Namespace = DefaultNamespace
+	} else if network.Namespace == 0 {
+		network.Namespace = lowestUnused(usedNamespaces)
+	}
+	return network, nil
+}
+
+// Networks are all networks of the cluster.
+type Networks struct {
+	Networks []Network `json:"networks,omitempty" yaml:"networks,omitempty"`
+}
+
+// Value marshals the receiver Networks into a json string.
+func (ns Networks) Value() (string, error) {
+	if data, err := json.Marshal(ns); err != nil {
+		return "", err
+	} else {
+		return string(data), err
+	}
+}
+
+// Scan unmarshals the input into the receiver Networks.
+func (ns *Networks) Scan(input string) error {
+	return json.Unmarshal([]byte(input), ns)
+}
+
+// Get returns the network with the given name, false if there isn't any.
+func (ns Networks) Get(name string) (Network, bool) {
+	for _, network := range ns.Networks {
+		if network.Name == name {
+			return network, true
+		}
+	}
+	return Network{}, false
+}
+
+// Put adds the given network to the receiver's Networks or replaces the one
+// with the same name.
+func (ns *Networks) Put(network Network) {
+	for i, other := range ns.Networks {
+		if other.Name == network.Name {
+			ns.Networks[i] = network
+			return
+		}
+	}
+	ns.Networks = append(ns.Networks, network)
+}
+
+// Delete removes the network with the given name. Returns false if it didn't
+// exist.
+func (ns *Networks) Delete(name string) bool {
+	networks := []Network{}
+	for _, network := range ns.Networks {
+		if network.Name != name {
+			networks = append(networks, network)
+		}
+	}
+	deleted := len(networks) != len(ns.Networks)
+	ns.Networks = networks
+	return deleted
+}
+
+// Allocate allocates, with AllocateNetwork, the IDs of the given network
+// against the receiver's Networks and puts it. Returns the allocated network.
+func (ns *Networks) Allocate(network Network) (Network, error) {
+	network, err := AllocateNetwork(network, ns.Networks)
+	if err != nil {
+		return network, err
+	}
+	ns.Put(network)
+	return network, nil
+}
+
+// Merge adds the given network, keeping its IDs, to the receiver's Networks.
+// It returns an error, without changing them, if a network with the same name
+// differs from it or if another network overlaps with it or has its IDs.
+// Networks without IDs are allocated.
+func (ns *Networks) Merge(network Network) error {
+	if network.BD == 0 || network.Namespace == 0 {
+		_, err := ns.Allocate(network)
+		return err
+	}
+	if err := network.Validate(); err != nil {
+		return err
+	}
+	network.Isolation = network.GetIsolation()
+	if other, ok := ns.Get(network.Name); ok {
+		if !reflect.DeepEqual(other, network) {
+			return fmt.Errorf("network %s conflicts with the existing one: %+v", network.Name, other)
+		}
+		return nil
+	}
+	for _, other := range ns.Networks {
+		if subnet := network.Overlaps(other); subnet != "" {
+			return fmt.Errorf("subnet %s of network %s overlaps with network %s", subnet, network.Name, other.Name)
+		}
+		if other.BD == network.BD {
+			return fmt.Errorf("network %s has the BD %d of network %s", network.Name, network.BD, other.Name)
+		}
+		sharesNamespace := network.Namespace == DefaultNamespace ||
+			(network.Isolation == IsolationOwner && other.GetIsolation() == IsolationOwner && other.Owner == network.Owner)
+		if other.Namespace == network.Namespace && !sharesNamespace {
+			return fmt.Errorf("network %s has the namespace %d of network %s", network.Name, network.Namespace, other.Name)
+		}
+	}
+	ns.Networks = append(ns.Networks, network)
+	return nil
+}
+
+func lowestUnused(used map[int]bool) int {
+	id := 1
+	for used[id] {
+		id++
+	}
+	return id
+}
+
+// NetworkUsing returns the network that was allocated the given BD ID or the
+// given namespace ID, false if there isn't any.
+func NetworkUsing(networks []Network, bd, namespace int) (Network, bool) {
+	for _, network := range networks {
+		if network.BD == bd || (network.Namespace == namespace && namespace != DefaultNamespace) {
+			return network, true
+		}
+	}
+	return Network{}, false
+}
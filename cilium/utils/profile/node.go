@@ -0,0 +1,51 @@
+package profile
+
+import (
+	"encoding/json"
+	"time"
+)
+
+type Capabilities []string
+
+type Node struct {
+	Name            string       `json:"name,omitempty" yaml:"name,omitempty"`
+	IP              string       `json:"ip,omitempty" yaml:"ip,omitempty"`
+	TunnelIP        string       `json:"tunnel-ip,omitempty" yaml:"tunnel-ip,omitempty"`
+	Capabilities    Capabilities `json:"capabilities,omitempty" yaml:"capabilities,omitempty"`
+	LeaseExpiration time.Time    `json:"lease-expiration,omitempty" yaml:"lease-expiration,omitempty"`
+}
+
+// Value marshals the receiver Node into a json string.
+func (n Node) Value() (string, error) {
+	if data, err := json.Marshal(n); err != nil {
+		return "", err
+	} else {
+		return string(data), err
+	}
+}
+
+// Scan unmarshals the input into the receiver Node.
+func (n *Node) Scan(input string) error {
+	return json.Unmarshal([]byte(input), n)
+}
+
+// RenewLease extends the receiver's lease to the given ttl counting from now.
+func (n *Node) RenewLease(now time.Time, ttl time.Duration) {
+	n.LeaseExpiration = now.Add(ttl)
+}
+
+// IsAlive returns true if the receiver's lease hasn't expired at the given
+// time.
+func (n Node) IsAlive(now time.Time) bool {
+	return now.Before(n.LeaseExpiration)
+}
+
+// HasCapability returns true if the receiver Node has the given capability.
+func (n Node) HasCapability(capability string) bool {
+	for _, c := range n.Capabilities {
+		if c == capability {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,46 @@
+package profile
+
+import (
+	"testing"
+	"time"
+)
+
+func TestNodeLease(t *testing.T) {
+	now := time.Now()
+	node := Node{Name: "node1", IP: "192.168.50.37"}
+	if node.IsAlive(now) {
+		t.Errorf("node without lease should not be alive")
+	}
+	node.RenewLease(now, 30*time.Second)
+	if !node.IsAlive(now.Add(29 * time.Second)) {
+		t.Errorf("node should be alive before its lease expires")
+	}
+	if node.IsAlive(now.Add(30 * time.Second)) {
+		t.Errorf("node should not be alive after its lease expires")
+	}
+}
+
+func TestNodeValueScan(t *testing.T) {
+	node := Node{
+		Name:         "node1",
+		IP:           "192.168.50.37",
+		TunnelIP:     "10.0.0.37",
+		Capabilities: Capabilities{"hooks"},
+	}
+	node.RenewLease(time.Unix(1450000000, 0).UTC(), 30*time.Second)
+	nodeStr, err := node.Value()
+	if err != nil {
+		t.Fatalf("error while marshalling node: %s", err)
+	}
+	var got Node
+	if err := got.Scan(nodeStr); err != nil {
+		t.Fatalf("error while unmarshalling node: %s", err)
+	}
+	if got.Name != node.Name || got.IP != node.IP || got.TunnelIP != node.TunnelIP ||
+		!got.LeaseExpiration.Equal(node.LeaseExpiration) {
+		t.Errorf("invalid node:\ngot  %+v\nwant %+v", got, node)
+	}
+	if !got.HasCapability("hooks") || got.HasCapability("docker-events") {
+		t.Errorf("invalid capabilities: %+v", got.Capabilities)
+	}
+}
@@ -3,13 +3,21 @@ package profile
 import (
 	"encoding/json"
 
+	upsc "github.com/cilium-team/cilium/cilium/utils/profile/subpolicies/constraints"
 	upsd "github.com/cilium-team/cilium/cilium/utils/profile/subpolicies/docker"
 	upsi "github.com/cilium-team/cilium/cilium/utils/profile/subpolicies/intent"
 	upsk "github.com/cilium-team/cilium/cilium/utils/profile/subpolicies/kubernetes"
+	upsw "github.com/cilium-team/cilium/cilium/utils/profile/subpolicies/webhook"
 )
 
 type ProfileFile struct {
-	PolicySource []PolicySource `json:"policy-source,omitempty" yaml:"policy-source,omitempty"`
+	PolicySource  []PolicySource `json:"policy-source,omitempty" yaml:"policy-source,omitempty"`
+	Networks      []Network      `json:"networks,omitempty" yaml:"networks,omitempty"`
+	Routers       []Router       `json:"routers,omitempty" yaml:"routers,omitempty"`
+	Egress        []Egress       `json:"egress,omitempty" yaml:"egress,omitempty"`
+	Quotas        []Quota        `json:"quotas,omitempty" yaml:"quotas,omitempty"`
+	Secrets       []Secret       `json:"secrets,omitempty" yaml:"secrets,omitempty"`
+	Subscriptions []Subscription `json:"subscriptions,omitempty" yaml:"subscriptions,omitempty"`
 }
 
 type PolicySource struct {
@@ -19,12 +27,14 @@ type PolicySource struct {
 
 type Policy struct {
 	//TODO remove owner redundancy present in this structure
-	Name             string                `json:"name,omitempty" yaml:"name,omitempty"`
-	Owner            string                `json:"owner,omitempty" yaml:"owner,omitempty"`
-	Coverage         Coverage              `json:"coverage,omitempty" yaml:"coverage,omitempty"`
-	DockerConfig     upsd.DockerConfig     `json:"docker-config,omitempty" yaml:"docker-config,omitempty"`
-	IntentConfig     upsi.IntentConfig     `json:"intent-config,omitempty" yaml:"intent-config,omitempty"`
-	KubernetesConfig upsk.KubernetesConfig `json:"kubernetes-config,omitempty" yaml:"kubernetes-config,omitempty"`
+	Name              string                 `json:"name,omitempty" yaml:"name,omitempty"`
+	Owner             string                 `json:"owner,omitempty" yaml:"owner,omitempty"`
+	Coverage          Coverage               `json:"coverage,omitempty" yaml:"coverage,omitempty"`
+	DockerConfig      upsd.DockerConfig      `json:"docker-config,omitempty" yaml:"docker-config,omitempty"`
+	DockerConstraints upsc.DockerConstraints `json:"docker-constraints,omitempty" yaml:"docker-constraints,omitempty"`
+	IntentConfig      upsi.IntentConfig      `json:"intent-config,omitempty" yaml:"intent-config,omitempty"`
+	KubernetesConfig  upsk.KubernetesConfig  `json:"kubernetes-config,omitempty" yaml:"kubernetes-config,omitempty"`
+	WebhookConfig     upsw.WebhookConfig     `json:"webhook-config,omitempty" yaml:"webhook-config,omitempty"`
 }
 
 // Value marshals the receiver Policy into a json string.
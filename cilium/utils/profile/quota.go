@@ -0,0 +1,231 @@
+package profile
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"time"
+)
+
+// Quota limits the resources used by the containers of an owner and/or with
+// the given labels across the whole cluster. Limits set to 0 are unlimited.
+type Quota struct {
+	Name string `json:"name,omitempty" yaml:"name,omitempty"`
+	// Owner selects the containers covered by policies of the given owner.
+	Owner string `json:"owner,omitempty" yaml:"owner,omitempty"`
+	// Labels selects the containers with labels covered by them, like the
+	// Coverage of a policy.
+	Labels        map[string]string `json:"labels,omitempty" yaml:"labels,omitempty"`
+	MaxContainers int               `json:"max-containers,omitempty" yaml:"max-containers,omitempty"`
+	MaxCPUShares  int64             `json:"max-cpu-shares,omitempty" yaml:"max-cpu-shares,omitempty"`
+	MaxMemory     int64             `json:"max-memory,omitempty" yaml:"max-memory,omitempty"`
+	MaxIPs        int               `json:"max-ips,omitempty" yaml:"max-ips,omitempty"`
+	// Pool is the CIDR of the IPs counted for MaxIPs, all IPs are counted if
+	// not set.
+	Pool string `json:"pool,omitempty" yaml:"pool,omitempty"`
+}
+
+// Value marshals the receiver Quota into a json string.
+func (q Quota) Value() (string, error) {
+	if data, err := json.Marshal(q); err != nil {
+		return "", err
+	} else {
+		return string(data), err
+	}
+}
+
+// Scan unmarshals the input into the receiver Quota.
+func (q *Quota) Scan(input string) error {
+	return json.Unmarshal([]byte(input), q)
+}
+
+// Validate returns an error if the receiver's Quota is invalid.
+func (q Quota) Validate() error {
+	if q.Name == "" {
+		return fmt.Errorf("quota without name")
+	}
+	if q.Owner == "" && len(q.Labels) == 0 {
+		return fmt.Errorf("quota %s doesn't select any container, it needs an owner and/or labels", q.Name)
+	}
+	if q.Pool != "" {
+		if _, _, err := net.ParseCIDR(q.Pool); err != nil {
+			return fmt.Errorf("quota %s has an invalid pool: %s", q.Name, err)
+		}
+	}
+	return nil
+}
+
+// Selects returns true if a container, covered by policies of the given
+// owners, with the given labels is limited by the receiver's Quota.
+func (q Quota) Selects(owners []string, labels map[string]string) bool {
+	if q.Owner != "" {
+		found := false
+		for _, owner := range owners {
+			found = found || owner == q.Owner
+		}
+		if !found {
+			return false
+		}
+	}
+	return len(q.Labels) == 0 || Coverage{Labels: q.Labels}.Covers(labels)
+}
+
+// UsageOf returns the resources used, and counted by the receiver's Quota, by
+// the given container.
+func (q Quota) UsageOf(cu ContainerUsage) ResourceUsage {
+	usage := ResourceUsage{Containers: 1, CPUShares: cu.CPUShares, Memory: cu.Memory}
+	_, pool, _ := net.ParseCIDR(q.Pool)
+	for _, ip := range cu.IPs {
+		if pool == nil || pool.Contains(ip) {
+			usage.IPs++
+		}
+	}
+	return usage
+}
+
+// Usage returns the resources used by all given containers selected by the
+// receiver's Quota.
+func (q Quota) Usage(containers []ContainerUsage) ResourceUsage {
+	usage := ResourceUsage{}
+	for _, cu := range containers {
+		if q.Selects(cu.Owners, cu.Labels) {
+			usage = usage.Add(q.UsageOf(cu))
+		}
+	}
+	return usage
+}
+
+// Exceeded returns the reason why the given usage exceeds the receiver's
+// Quota or an empty string if it doesn't.
+func (q Quota) Exceeded(usage ResourceUsage) string {
+	switch {
+	case q.MaxContainers != 0 && usage.Containers > q.MaxContainers:
+		return fmt.Sprintf("quota %s allows %d containers, %d requested", q.Name, q.MaxContainers, usage.Containers)
+	case q.MaxCPUShares != 0 && usage.CPUShares > q.MaxCPUShares:
+		return fmt.Sprintf("quota %s allows %d CPU shares, %d requested", q.Name, q.MaxCPUShares, usage.CPUShares)
+	case q.MaxMemory != 0 && usage.Memory > q.MaxMemory:
+		return fmt.Sprintf("quota %s allows %d bytes of memory, %d requested", q.Name, q.MaxMemory, usage.Memory)
+	case q.MaxIPs != 0 && usage.IPs > q.MaxIPs:
+		return fmt.Sprintf("quota %s allows %d IPs, %d requested", q.Name, q.MaxIPs, usage.IPs)
+	}
+	return ""
+}
+
+// ResourceUsage are the resources used by one or more containers.
+type ResourceUsage struct {
+	Containers int   `json:"containers" yaml:"containers"`
+	CPUShares  int64 `json:"cpu-shares" yaml:"cpu-shares"`
+	Memory     int64 `json:"memory" yaml:"memory"`
+	IPs        int   `json:"ips" yaml:"ips"`
+}
+
+// Add returns the sum of the receiver's ResourceUsage and other.
+func (ru ResourceUsage) Add(other ResourceUsage) ResourceUsage {
+	return ResourceUsage{
+		Containers: ru.Containers + other.Containers,
+		CPUShares:  ru.CPUShares + other.CPUShares,
+		Memory:     ru.Memory + other.Memory,
+		IPs:        ru.IPs + other.IPs,
+	}
+}
+
+// ContainerUsage are the resources used by a running container, with the
+// owners and labels used to select the quotas it counts for.
+type ContainerUsage struct {
+	Container string            `json:"container,omitempty" yaml:"container,omitempty"`
+	Owners    []string          `json:"owners,omitempty" yaml:"owners,omitempty"`
+	Labels    map[string]string `json:"labels,omitempty" yaml:"labels,omitempty"`
+	CPUShares int64             `json:"cpu-shares,omitempty" yaml:"cpu-shares,omitempty"`
+	Memory    int64             `json:"memory,omitempty" yaml:"memory,omitempty"`
+	IPs       IPs               `json:"ips,omitempty" yaml:"ips,omitempty"`
+}
+
+// Value marshals the receiver ContainerUsage into a json string.
+func (cu ContainerUsage) Value() (string, error) {
+	if data, err := json.Marshal(cu); err != nil {
+		return "", err
+	} else {
+		return string(data), err
+	}
+}
+
+// Scan unmarshals the input into the receiver ContainerUsage.
+func (cu *ContainerUsage) Scan(input string) error {
+	return json.Unmarshal([]byte(input), cu)
+}
+
+// QuotaReservation are the resources reserved for a container being created,
+// until it starts and its ContainerUsage is stored, or until expiration if it
+// doesn't.
+type QuotaReservation struct {
+	Reservation string         `json:"reservation" yaml:"reservation"`
+	Usage       ContainerUsage `json:"usage" yaml:"usage"`
+	Expiration  time.Time      `json:"expiration" yaml:"expiration"`
+}
+
+// QuotaReservations are all quota reservations of the cluster.
+type QuotaReservations struct {
+	Reservations []QuotaReservation `json:"reservations,omitempty" yaml:"reservations,omitempty"`
+}
+
+// Value marshals the receiver QuotaReservations into a json string.
+func (qr QuotaReservations) Value() (string, error) {
+	if data, err := json.Marshal(qr); err != nil {
+		return "", err
+	} else {
+		return string(data), err
+	}
+}
+
+// Scan unmarshals the input into the receiver QuotaReservations.
+func (qr *QuotaReservations) Scan(input string) error {
+	return json.Unmarshal([]byte(input), qr)
+}
+
+// Expire removes the reservations that expired before the given time.
+func (qr *QuotaReservations) Expire(now time.Time) {
+	qr.filter(func(r QuotaReservation) bool {
+		return now.Before(r.Expiration)
+	})
+}
+
+// Reserve adds a reservation of the given usage valid until expiration.
+func (qr *QuotaReservations) Reserve(reservation string, usage ContainerUsage, expiration time.Time) {
+	qr.Reservations = append(qr.Reservations,
+		QuotaReservation{Reservation: reservation, Usage: usage, Expiration: expiration})
+}
+
+// Release removes the given reservation. Returns false if it didn't exist.
+func (qr *QuotaReservations) Release(reservation string) bool {
+	n := len(qr.Reservations)
+	qr.filter(func(r QuotaReservation) bool {
+		return r.Reservation != reservation
+	})
+	return len(qr.Reservations) != n
+}
+
+// Usages returns the usage of every reservation of the receiver.
+func (qr QuotaReservations) Usages() []ContainerUsage {
+	usages := []ContainerUsage{}
+	for _, r := range qr.Reservations {
+		usages = append(usages, r.Usage)
+	}
+	return usages
+}
+
+// filter keeps the receiver's reservations for which keep returns true.
+func (qr *QuotaReservations) filter(keep func(QuotaReservation) bool) {
+	reservations := []QuotaReservation{}
+	for _, r := range qr.Reservations {
+		if keep(r) {
+			reservations = append(reservations, r)
+		}
+	}
+	qr.Reservations = reservations
+}
+
+// QuotaStatus is a Quota and the resources currently used of it.
+type QuotaStatus struct {
+	Quota
+	Usage ResourceUsage `json:"usage" yaml:"usage"`
+}
@@ -0,0 +1,85 @@
+package profile
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func TestQuotaSelects(t *testing.T) {
+	tests := []struct {
+		quota  Quota
+		owners []string
+		labels map[string]string
+		want   bool
+	}{
+		{Quota{Owner: "foo"}, []string{"bar", "foo"}, nil, true},
+		{Quota{Owner: "foo"}, []string{"bar"}, nil, false},
+		{Quota{Labels: map[string]string{"app": "^web$"}}, nil, map[string]string{"app": "web"}, true},
+		{Quota{Labels: map[string]string{"app": "^web$"}}, nil, map[string]string{"app": "db"}, false},
+		{Quota{Owner: "foo", Labels: map[string]string{"app": "^web$"}}, []string{"bar"}, map[string]string{"app": "web"}, false},
+	}
+	for _, tt := range tests {
+		if got := tt.quota.Selects(tt.owners, tt.labels); got != tt.want {
+			t.Errorf("invalid selection of %v %v by %+v:\ngot  %t\nwant %t", tt.owners, tt.labels, tt.quota, got, tt.want)
+		}
+	}
+}
+
+func TestQuotaUsage(t *testing.T) {
+	quota := Quota{Name: "foo", Owner: "foo", Pool: "10.0.0.0/24", MaxContainers: 2, MaxIPs: 1}
+	containers := []ContainerUsage{
+		{Container: "1", Owners: []string{"foo"}, CPUShares: 512, Memory: 1024, IPs: IPs{net.ParseIP("10.0.0.1")}},
+		{Container: "2", Owners: []string{"foo"}, CPUShares: 256, IPs: IPs{net.ParseIP("10.0.1.1")}},
+		{Container: "3", Owners: []string{"bar"}, CPUShares: 128, IPs: IPs{net.ParseIP("10.0.0.2")}},
+	}
+	got := quota.Usage(containers)
+	want := ResourceUsage{Containers: 2, CPUShares: 768, Memory: 1024, IPs: 1}
+	if got != want {
+		t.Errorf("invalid usage:\ngot  %+v\nwant %+v", got, want)
+	}
+	if reason := quota.Exceeded(got); reason != "" {
+		t.Errorf("quota exceeded by its own usage: %s", reason)
+	}
+	got = got.Add(quota.UsageOf(ContainerUsage{IPs: IPs{net.ParseIP("10.0.1.2")}}))
+	if reason, want := quota.Exceeded(got), "quota foo allows 2 containers, 3 requested"; reason != want {
+		t.Errorf("invalid reason:\ngot  %s\nwant %s", reason, want)
+	}
+}
+
+func TestQuotaValidate(t *testing.T) {
+	tests := []struct {
+		quota Quota
+		valid bool
+	}{
+		{Quota{Name: "foo", Owner: "foo"}, true},
+		{Quota{Name: "foo", Labels: map[string]string{"app": "web"}, Pool: "10.0.0.0/8"}, true},
+		{Quota{Owner: "foo"}, false},
+		{Quota{Name: "foo"}, false},
+		{Quota{Name: "foo", Owner: "foo", Pool: "10.0.0.0"}, false},
+	}
+	for _, tt := range tests {
+		if err := tt.quota.Validate(); (err == nil) != tt.valid {
+			t.Errorf("invalid validation of %+v:\ngot  %v\nwant valid=%t", tt.quota, err, tt.valid)
+		}
+	}
+}
+
+func TestQuotaReservations(t *testing.T) {
+	now := time.Now()
+	qr := QuotaReservations{}
+	qr.Reserve("a", ContainerUsage{Memory: 100}, now.Add(time.Minute))
+	qr.Reserve("b", ContainerUsage{Memory: 200}, now.Add(time.Second))
+
+	qr.Expire(now.Add(2 * time.Second))
+	usages := qr.Usages()
+	if len(usages) != 1 || usages[0].Memory != 100 {
+		t.Errorf("invalid usages after expiration:\ngot  %+v\nwant %+v", usages, []ContainerUsage{{Memory: 100}})
+	}
+	if qr.Release("b") {
+		t.Errorf("released an expired reservation")
+	}
+	if !qr.Release("a") || len(qr.Reservations) != 0 {
+		t.Errorf("invalid reservations after release:\ngot  %+v\nwant %+v", qr.Reservations, []QuotaReservation{})
+	}
+}
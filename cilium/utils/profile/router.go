@@ -0,0 +1,130 @@
+package profile
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+)
+
+// DefaultRouterIP and DefaultRouterMAC are the gateway, set up by
+// backend/setup.sh, of the namespaces without a Router.
+const (
+	DefaultRouterIP  = "192.0.2.1"
+	DefaultRouterMAC = "dd:dd:dd:dd:dd:dd"
+)
+
+// Router is the distributed logical router of a namespace, present in every
+// node. Its endpoints only reach other namespaces through its Routes.
+type Router struct {
+	Namespace int       `json:"namespace" yaml:"namespace"`
+	Gateways  []Gateway `json:"gateways,omitempty" yaml:"gateways,omitempty"`
+	Routes    []Route   `json:"routes,omitempty" yaml:"routes,omitempty"`
+}
+
+// Gateway is the address of a Router in one of the subnets of its namespace.
+type Gateway struct {
+	Subnet string `json:"subnet" yaml:"subnet"`
+	IP     string `json:"ip" yaml:"ip"`
+	MAC    string `json:"mac" yaml:"mac"`
+}
+
+// Route lets the endpoints of a Router's namespace reach the given subnets of
+// another namespace. The replies need a Route back in the other namespace's
+// Router.
+type Route struct {
+	Namespace int      `json:"namespace" yaml:"namespace"`
+	Subnets   []string `json:"subnets,omitempty" yaml:"subnets,omitempty"`
+}
+
+// Value marshals the receiver Router into a json string.
+func (r Router) Value() (string, error) {
+	if data, err := json.Marshal(r); err != nil {
+		return "", err
+	} else {
+		return string(data), err
+	}
+}
+
+// Scan unmarshals the input into the receiver Router.
+func (r *Router) Scan(input string) error {
+	return json.Unmarshal([]byte(input), r)
+}
+
+// Validate returns an error if the receiver's gateways don't have an IP, in
+// their subnet, and an unique MAC or if its routes don't go to other
+// namespaces' subnets.
+func (r Router) Validate() error {
+	if r.Namespace < DefaultNamespace {
+		return fmt.Errorf("router of invalid namespace %d", r.Namespace)
+	}
+	macs := map[string]bool{}
+	for _, gw := range r.Gateways {
+		_, subnet, err := net.ParseCIDR(gw.Subnet)
+		if err != nil {
+			return fmt.Errorf("router of namespace %d has an invalid subnet: %s", r.Namespace, err)
+		}
+		if ip := net.ParseIP(gw.IP); ip == nil || !subnet.Contains(ip) {
+			return fmt.Errorf("router of namespace %d has gateway %q outside of subnet %s", r.Namespace, gw.IP, gw.Subnet)
+		}
+		mac, err := net.ParseMAC(gw.MAC)
+		if err != nil {
+			return fmt.Errorf("router of namespace %d has an invalid MAC: %s", r.Namespace, err)
+		}
+		if macs[mac.String()] {
+			return fmt.Errorf("router of namespace %d has MAC %s in more than one gateway", r.Namespace, gw.MAC)
+		}
+		macs[mac.String()] = true
+	}
+	for _, route := range r.Routes {
+		if route.Namespace < DefaultNamespace || route.Namespace == r.Namespace {
+			return fmt.Errorf("router of namespace %d has a route to invalid namespace %d", r.Namespace, route.Namespace)
+		}
+		if len(route.Subnets) == 0 {
+			return fmt.Errorf("router of namespace %d has a route to namespace %d without subnets", r.Namespace, route.Namespace)
+		}
+		for _, subnet := range route.Subnets {
+			if _, _, err := net.ParseCIDR(subnet); err != nil {
+				return fmt.Errorf("router of namespace %d has a route with an invalid subnet: %s", r.Namespace, err)
+			}
+		}
+	}
+	return nil
+}
+
+// GatewayOf returns the receiver's gateway whose subnet contains the given IP.
+func (r Router) GatewayOf(ip net.IP) (Gateway, bool) {
+	for _, gw := range r.Gateways {
+		if _, subnet, err := net.ParseCIDR(gw.Subnet); err == nil && subnet.Contains(ip) {
+			return gw, true
+		}
+	}
+	return Gateway{}, false
+}
+
+// MACs returns the MACs of all receiver's gateways, the one of the gateway of
+// the given IP first. Returns DefaultRouterMAC if it doesn't have gateways.
+func (r Router) MACs(ip net.IP) []string {
+	macs := []string{}
+	if gw, ok := r.GatewayOf(ip); ok {
+		macs = append(macs, gw.MAC)
+	}
+	for _, gw := range r.Gateways {
+		if len(macs) == 0 || gw.MAC != macs[0] {
+			macs = append(macs, gw.MAC)
+		}
+	}
+	if len(macs) == 0 {
+		macs = append(macs, DefaultRouterMAC)
+	}
+	return macs
+}
+
+// RouterOf returns the router, from the given ones, of the given namespace.
+func RouterOf(routers []Router, namespace int) (Router, bool) {
+	for _, router := range routers {
+		if router.Namespace == namespace {
+			return router, true
+		}
+	}
+	return Router{Namespace: namespace}, false
+}
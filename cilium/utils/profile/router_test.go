@@ -0,0 +1,60 @@
+package profile
+
+import (
+	"net"
+	"reflect"
+	"testing"
+)
+
+func TestRouterValidate(t *testing.T) {
+	valid := Router{
+		Namespace: 2,
+		Gateways: []Gateway{
+			{Subnet: "10.1.0.0/24", IP: "10.1.0.1", MAC: "aa:aa:aa:aa:aa:01"},
+			{Subnet: "10.2.0.0/24", IP: "10.2.0.1", MAC: "aa:aa:aa:aa:aa:02"},
+		},
+		Routes: []Route{{Namespace: 3, Subnets: []string{"10.3.0.0/24"}}},
+	}
+	if err := valid.Validate(); err != nil {
+		t.Errorf("error while validating router: %s", err)
+	}
+
+	invalid := []Router{
+		{Namespace: 0},
+		{Namespace: 2, Gateways: []Gateway{{Subnet: "10.1.0.0/24", IP: "10.2.0.1", MAC: "aa:aa:aa:aa:aa:01"}}},
+		{Namespace: 2, Gateways: []Gateway{{Subnet: "10.1.0.0/24", IP: "10.1.0.1", MAC: "aa:aa"}}},
+		{Namespace: 2, Gateways: []Gateway{
+			{Subnet: "10.1.0.0/24", IP: "10.1.0.1", MAC: "aa:aa:aa:aa:aa:01"},
+			{Subnet: "10.2.0.0/24", IP: "10.2.0.1", MAC: "AA:AA:AA:AA:AA:01"},
+		}},
+		{Namespace: 2, Routes: []Route{{Namespace: 2, Subnets: []string{"10.3.0.0/24"}}}},
+		{Namespace: 2, Routes: []Route{{Namespace: 3}}},
+	}
+	for _, router := range invalid {
+		if err := router.Validate(); err == nil {
+			t.Errorf("invalid router %+v was validated", router)
+		}
+	}
+}
+
+func TestRouterMACs(t *testing.T) {
+	router := Router{Namespace: 2, Gateways: []Gateway{
+		{Subnet: "10.1.0.0/24", IP: "10.1.0.1", MAC: "aa:aa:aa:aa:aa:01"},
+		{Subnet: "10.2.0.0/24", IP: "10.2.0.1", MAC: "aa:aa:aa:aa:aa:02"},
+	}}
+	tests := []struct {
+		ip   string
+		want []string
+	}{
+		{"10.2.0.5", []string{"aa:aa:aa:aa:aa:02", "aa:aa:aa:aa:aa:01"}},
+		{"10.9.0.5", []string{"aa:aa:aa:aa:aa:01", "aa:aa:aa:aa:aa:02"}},
+	}
+	for _, tt := range tests {
+		if got := router.MACs(net.ParseIP(tt.ip)); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("invalid MACs of %s:\ngot  %v\nwant %v", tt.ip, got, tt.want)
+		}
+	}
+	if got, want := (Router{}).MACs(nil), []string{DefaultRouterMAC}; !reflect.DeepEqual(got, want) {
+		t.Errorf("invalid MACs of router without gateways:\ngot  %v\nwant %v", got, want)
+	}
+}
@@ -0,0 +1,116 @@
+// Package constraints implements a runnable that denies the docker create
+// requests that violate the docker-constraints of the policies covering them.
+package constraints
+
+import (
+	"strings"
+
+	m "github.com/cilium-team/cilium/cilium/messages"
+	ucdb "github.com/cilium-team/cilium/cilium/utils/comm/db"
+	up "github.com/cilium-team/cilium/cilium/utils/profile"
+	upr "github.com/cilium-team/cilium/cilium/utils/profile/runnables"
+	upsc "github.com/cilium-team/cilium/cilium/utils/profile/subpolicies/constraints"
+
+	"github.com/cilium-team/cilium/Godeps/_workspace/src/github.com/op/go-logging"
+)
+
+const (
+	Name = "constraints-runnable"
+
+	DockerSwarmCreate  = "DockerSwarmCreate"
+	DockerDaemonCreate = "DockerDaemonCreate"
+)
+
+var (
+	log = logging.MustGetLogger("cilium")
+
+	preHookHandlers = map[string]string{
+		`/docker/daemon/cilium-adapter(/v[^/]*)?/containers/create(\?.*)?`: DockerDaemonCreate,
+		`/docker/swarm/cilium-adapter(/v[^/]*)?/containers/create(\?.*)?`:  DockerSwarmCreate,
+	}
+)
+
+// policyConstraints are the docker constraints of a policy.
+type policyConstraints struct {
+	upsc.DockerConstraints
+	policy string
+	owner  string
+}
+
+type ConstraintsRunnable struct {
+	constraints []policyConstraints
+}
+
+func (cr ConstraintsRunnable) GetHandlers(typ string) map[string]string {
+	switch typ {
+	case upr.PreHook:
+		return preHookHandlers
+	default:
+		return nil
+	}
+}
+
+// GetRunnableFrom returns a ConstraintsRunnable with the docker constraints of
+// all given policies. Constraints aren't merged, a request must follow the
+// constraints of every policy that covers it.
+func (cr ConstraintsRunnable) GetRunnableFrom(users []up.User, policies []up.PolicySource) upr.PolicyRunnable {
+	log.Debug("users %+v", users)
+	constraints := []policyConstraints{}
+	up.OrderUsersByDescendingID(users)
+	for _, user := range users {
+		for _, policySource := range up.FilterPoliciesByUser(policies, user) {
+			for _, policy := range policySource.Policies {
+				if policy.DockerConstraints.IsEmpty() {
+					continue
+				}
+				constraints = append(constraints, policyConstraints{
+					DockerConstraints: policy.DockerConstraints,
+					policy:            policy.Name,
+					owner:             policySource.Owner,
+				})
+			}
+		}
+	}
+	log.Debug("constraints %+v", constraints)
+	return ConstraintsRunnable{constraints: constraints}
+}
+
+func (cr ConstraintsRunnable) DockerExec(hookType, reqType string, db ucdb.Db, cc *m.DockerCreateConfig) error {
+	if hookType != upr.PreHook || (reqType != DockerDaemonCreate && reqType != DockerSwarmCreate) {
+		return nil
+	}
+	// The body sent to docker, with the fields that go-dockerclient doesn't
+	// know about, e.g. HostConfig.Mounts, is checked so they can't bypass
+	// the rules.
+	body, err := cc.Marshal2JSONStr()
+	if err != nil {
+		return err
+	}
+	for _, constraints := range cr.constraints {
+		violations, err := constraints.Check([]byte(body))
+		if err != nil {
+			return err
+		}
+		if len(violations) == 0 {
+			continue
+		}
+		if constraints.IsAudit() {
+			for _, violation := range violations {
+				log.Warning("Container %s violates policy %s of owner %s: %s",
+					cc.Name, constraints.policy, constraints.owner, violation)
+				cc.Warn("policy %s (audit): %s", constraints.policy, violation)
+			}
+			continue
+		}
+		return upr.Denial{
+			Policy: constraints.policy,
+			Owner:  constraints.owner,
+			Reason: strings.Join(violations, "; "),
+		}
+	}
+	return nil
+}
+
+func (cr ConstraintsRunnable) KubernetesExec(hookType, reqType string, db ucdb.Db, cc *m.KubernetesObjRef) error {
+	return nil
+}
@@ -0,0 +1,125 @@
+package constraints
+
+import (
+	"reflect"
+	"testing"
+
+	m "github.com/cilium-team/cilium/cilium/messages"
+	ucdb "github.com/cilium-team/cilium/cilium/utils/comm/db"
+	up "github.com/cilium-team/cilium/cilium/utils/profile"
+	upr "github.com/cilium-team/cilium/cilium/utils/profile/runnables"
+	upsc "github.com/cilium-team/cilium/cilium/utils/profile/subpolicies/constraints"
+
+	d "github.com/cilium-team/cilium/Godeps/_workspace/src/github.com/fsouza/go-dockerclient"
+)
+
+func newRunnable(mode string) upr.PolicyRunnable {
+	users := []up.User{{ID: 1, Name: "admin"}, {ID: 2, Name: "web-team"}}
+	policies := []up.PolicySource{
+		{
+			Owner: "admin",
+			Policies: []up.Policy{{
+				Name: "no-privileged",
+				DockerConstraints: upsc.DockerConstraints{
+					Mode:  mode,
+					Rules: []upsc.Rule{{Field: "HostConfig.Privileged", Deny: []string{"^true$"}}},
+				},
+			}},
+		},
+		{
+			Owner: "web-team",
+			Policies: []up.Policy{{
+				Name: "registries",
+				DockerConstraints: upsc.DockerConstraints{
+					Images: upsc.ImageConstraints{AllowRegistries: []string{"docker.io"}},
+				},
+			}},
+		},
+	}
+	return ConstraintsRunnable{}.GetRunnableFrom(users, policies)
+}
+
+func newDockerCreateConfig(image string, privileged bool) *m.DockerCreateConfig {
+	return &m.DockerCreateConfig{
+		Name:       "web",
+		Config:     &d.Config{Image: image},
+		HostConfig: &d.HostConfig{Privileged: privileged},
+	}
+}
+
+func TestDockerExecEnforce(t *testing.T) {
+	runnable := newRunnable("")
+	if err := runnable.DockerExec(upr.PreHook, DockerDaemonCreate, ucdb.NewMemConn(),
+		newDockerCreateConfig("busybox", false)); err != nil {
+		t.Errorf("valid request was denied: %s", err)
+	}
+
+	err := runnable.DockerExec(upr.PreHook, DockerDaemonCreate, ucdb.NewMemConn(),
+		newDockerCreateConfig("busybox", true))
+	want := upr.Denial{
+		Policy: "no-privileged",
+		Owner:  "admin",
+		Reason: `HostConfig.Privileged "true" is not allowed`,
+	}
+	if err != want {
+		t.Errorf("invalid error:\ngot  %#v\nwant %#v", err, want)
+	}
+
+	err = runnable.DockerExec(upr.PreHook, DockerSwarmCreate, ucdb.NewMemConn(),
+		newDockerCreateConfig("quay.io/coreos/etcd", false))
+	want = upr.Denial{
+		Policy: "registries",
+		Owner:  "web-team",
+		Reason: `image "quay.io/coreos/etcd" from registry "quay.io" is not allowed`,
+	}
+	if err != want {
+		t.Errorf("invalid error:\ngot  %#v\nwant %#v", err, want)
+	}
+
+	if err := runnable.DockerExec(upr.PostHook, DockerDaemonCreate, ucdb.NewMemConn(),
+		newDockerCreateConfig("busybox", true)); err != nil {
+		t.Errorf("constraints were checked in the post-hook: %s", err)
+	}
+}
+
+func TestDockerExecAudit(t *testing.T) {
+	cc := newDockerCreateConfig("busybox", true)
+	if err := newRunnable(upsc.Audit).DockerExec(upr.PreHook, DockerDaemonCreate, ucdb.NewMemConn(), cc); err != nil {
+		t.Errorf("request was denied in audit mode: %s", err)
+	}
+	want := []string{`policy no-privileged (audit): HostConfig.Privileged "true" is not allowed`}
+	if !reflect.DeepEqual(cc.Warnings, want) {
+		t.Errorf("invalid Warnings:\ngot  %q\nwant %q", cc.Warnings, want)
+	}
+}
+
+func TestDockerExecUnknownFields(t *testing.T) {
+	users := []up.User{{ID: 1, Name: "admin"}}
+	policies := []up.PolicySource{{
+		Owner: "admin",
+		Policies: []up.Policy{{
+			Name: "no-host-mounts",
+			DockerConstraints: upsc.DockerConstraints{
+				Rules: []upsc.Rule{{Field: "HostConfig.Mounts", Deny: []string{"^Source=/$"}}},
+			},
+		}},
+	}}
+	runnable := ConstraintsRunnable{}.GetRunnableFrom(users, policies)
+
+	req := m.PowerstripRequest{}
+	req.ClientRequest.Request = "/v1.25/containers/create?name=web"
+	req.ClientRequest.Body = `{"Image":"busybox","HostConfig":{"Mounts":[{"Type":"bind","Source":"/","Target":"/host"}]}}`
+	cc := &m.DockerCreateConfig{}
+	if err := req.UnmarshalDockerCreateClientBody(cc); err != nil {
+		t.Fatal(err)
+	}
+	err := runnable.DockerExec(upr.PreHook, DockerDaemonCreate, ucdb.NewMemConn(), cc)
+	want := upr.Denial{
+		Policy: "no-host-mounts",
+		Owner:  "admin",
+		Reason: `HostConfig.Mounts "Source=/" is not allowed`,
+	}
+	if err != want {
+		t.Errorf("invalid error:\ngot  %#v\nwant %#v", err, want)
+	}
+}
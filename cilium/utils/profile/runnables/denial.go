@@ -0,0 +1,87 @@
+package runnables
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	up "github.com/cilium-team/cilium/cilium/utils/profile"
+)
+
+// Denial is the error returned by runnables when a policy refuses a request.
+// Code is the HTTP status code returned to the client, Forbidden if not set.
+type Denial struct {
+	Policy string
+	Owner  string
+	Reason string
+	Code   int
+	// Field is the intent field, as written in the policy files, that caused
+	// the denial. It's used to find the Policy and Owner when the runnable
+	// only knows the merged intent.
+	Field string
+}
+
+// NewDenial returns a Denial, with the Forbidden status code, caused by the
+// given intent field.
+func NewDenial(field, format string, a ...interface{}) Denial {
+	return Denial{
+		Reason: fmt.Sprintf(format, a...),
+		Code:   http.StatusForbidden,
+		Field:  field,
+	}
+}
+
+func (d Denial) Error() string {
+	if d.Policy == "" {
+		return fmt.Sprintf("denied by policy: %s", d.Reason)
+	}
+	return fmt.Sprintf("denied by policy %s of owner %s: %s", d.Policy, d.Owner, d.Reason)
+}
+
+// StatusCode returns the HTTP status code of the receiver's Denial.
+func (d Denial) StatusCode() int {
+	if d.Code == 0 {
+		return http.StatusForbidden
+	}
+	return d.Code
+}
+
+// AttributeDenial sets, if err is a Denial without a policy, the policy and
+// owner of the given policies that set the denial's intent field in the
+// merged intent. That is the one of the user with the lowest ID with the
+// lowest intent priority.
+func AttributeDenial(err error, users []up.User, policies []up.PolicySource) error {
+	denial, ok := err.(Denial)
+	if !ok || denial.Policy != "" || denial.Field == "" {
+		return err
+	}
+	sortedUsers := append([]up.User{}, users...)
+	up.OrderUsersByDescendingID(sortedUsers)
+	for i := len(sortedUsers) - 1; i >= 0; i-- {
+		userPolicies := UserPolicies(policies, sortedUsers[i], func(policy up.Policy) int {
+			return policy.IntentConfig.Priority
+		})
+		for _, policy := range userPolicies {
+			if setsIntentField(policy, denial.Field) {
+				denial.Policy, denial.Owner = policy.Name, sortedUsers[i].Name
+				return denial
+			}
+		}
+	}
+	return denial
+}
+
+// setsIntentField returns true if the given policy sets the given intent
+// field.
+func setsIntentField(policy up.Policy, field string) bool {
+	data, err := json.Marshal(policy.IntentConfig.Config)
+	if err != nil {
+		return false
+	}
+	fields := map[string]json.RawMessage{}
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return false
+	}
+	value, ok := fields[field]
+	return ok && string(value) != "null"
+}
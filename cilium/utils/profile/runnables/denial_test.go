@@ -0,0 +1,50 @@
+package runnables
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+
+	up "github.com/cilium-team/cilium/cilium/utils/profile"
+	upsi "github.com/cilium-team/cilium/cilium/utils/profile/subpolicies/intent"
+)
+
+func TestDenialError(t *testing.T) {
+	denial := Denial{Policy: "web", Owner: "root", Reason: "too many containers"}
+	if want := "denied by policy web of owner root: too many containers"; denial.Error() != want {
+		t.Errorf("invalid error message:\ngot  %s\nwant %s", denial.Error(), want)
+	}
+	if denial.StatusCode() != http.StatusForbidden {
+		t.Errorf("invalid status code:\ngot  %d\nwant %d", denial.StatusCode(), http.StatusForbidden)
+	}
+}
+
+func TestAttributeDenial(t *testing.T) {
+	maxScale := 2
+	withMaxScale := upsi.IntentConfig{Config: upsi.Intent{MaxScale: &maxScale}}
+	users := []up.User{up.User{Name: "root", ID: 1}, up.User{Name: "foo", ID: 2}}
+	policies := []up.PolicySource{
+		up.PolicySource{Owner: "foo", Policies: []up.Policy{
+			up.Policy{Name: "foo-web", IntentConfig: withMaxScale},
+		}},
+		up.PolicySource{Owner: "root", Policies: []up.Policy{
+			up.Policy{Name: "root-other"},
+			up.Policy{Name: "root-web-low", IntentConfig: upsi.IntentConfig{Config: upsi.Intent{MaxScale: &maxScale}, Priority: 2}},
+			up.Policy{Name: "root-web-high", IntentConfig: upsi.IntentConfig{Config: upsi.Intent{MaxScale: &maxScale}, Priority: 1}},
+		}},
+	}
+
+	err := AttributeDenial(NewDenial("max-scale", "reached %d", maxScale), users, policies)
+	denial, ok := err.(Denial)
+	if !ok {
+		t.Fatalf("invalid error type:\ngot  %T\nwant %T", err, Denial{})
+	}
+	if denial.Policy != "root-web-high" || denial.Owner != "root" {
+		t.Errorf("invalid attribution:\ngot  %s of %s\nwant %s of %s", denial.Policy, denial.Owner, "root-web-high", "root")
+	}
+
+	other := errors.New("other")
+	if err := AttributeDenial(other, users, policies); err != other {
+		t.Errorf("invalid error:\ngot  %s\nwant %s", err, other)
+	}
+}
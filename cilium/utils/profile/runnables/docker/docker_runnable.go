@@ -24,12 +24,12 @@ var (
 	log = logging.MustGetLogger("cilium")
 
 	preHookHandlers = map[string]string{
-		`/docker/daemon/cilium-adapter/.*/containers/create(\?.*)?`: DockerDaemonCreate,
-		`/docker/swarm/cilium-adapter/.*/containers/create(\?.*)?`:  DockerSwarmCreate,
+		`/docker/daemon/cilium-adapter(/v[^/]*)?/containers/create(\?.*)?`: DockerDaemonCreate,
+		`/docker/swarm/cilium-adapter(/v[^/]*)?/containers/create(\?.*)?`:  DockerSwarmCreate,
 	}
 	postHookHandlers = map[string]string{
-		`/docker/daemon/cilium-adapter/.*/containers/create(\?.*)?`: DockerDaemonCreate,
-		`/docker/swarm/cilium-adapter/.*/containers/create(\?.*)?`:  DockerSwarmCreate,
+		`/docker/daemon/cilium-adapter(/v[^/]*)?/containers/create(\?.*)?`: DockerDaemonCreate,
+		`/docker/swarm/cilium-adapter(/v[^/]*)?/containers/create(\?.*)?`:  DockerSwarmCreate,
 	}
 )
 
@@ -8,6 +8,7 @@ import (
 	"strings"
 
 	u "github.com/cilium-team/cilium/cilium/utils"
+	ub "github.com/cilium-team/cilium/cilium/utils/bus"
 	uc "github.com/cilium-team/cilium/cilium/utils/comm"
 	ucdb "github.com/cilium-team/cilium/cilium/utils/comm/db"
 	upl "github.com/cilium-team/cilium/cilium/utils/plugins/loadbalancer"
@@ -41,14 +42,17 @@ func hostnameIs(intent *upsi.Intent, labels map[string]string, oldHostName strin
 }
 
 func saveEndpoint(dbConn ucdb.Db, intent *upsi.Intent, labels map[string]string,
-	containerID string, ifname string, ips []net.IP, macs []string) error {
+	containerID, containerName string, ifname string, ips []net.IP, macs []string) error {
 
 	endpoint := up.Endpoint{}
 	endpoint.Container = containerID
+	endpoint.Name = strings.TrimPrefix(containerName, "/")
+	endpoint.Labels = labels
 	endpoint.IPs = ips
 	endpoint.MACs = macs
 	endpoint.Node = os.Getenv("HOST_IP")
 	endpoint.Interface = ifname
+	endpoint.Bridge = u.BridgeOf(intent.NetConf)
 	if intent.NetConf.Group != nil {
 		endpoint.Group = *intent.NetConf.Group
 	}
@@ -58,18 +62,37 @@ func saveEndpoint(dbConn ucdb.Db, intent *upsi.Intent, labels map[string]string,
 	if intent.NetConf.Namespace != nil {
 		endpoint.Namespace = *intent.NetConf.Namespace
 	}
+	endpoint.Network = networkOf(intent)
 	if svcName := u.LookupServiceName(labels); svcName != "" {
 		endpoint.Service = svcName
 	}
+	if *intent.AddToDNS {
+		endpoint.Domains = getDNSDomains(intent, labels, containerID)
+	}
 
-	return dbConn.PutEndpoint(endpoint)
+	if err := dbConn.PutEndpoint(endpoint); err != nil {
+		return err
+	}
+	ub.Publish(ub.EndpointCreated, containerID, map[string]interface{}{
+		"ips":       ipsToStrings(ips),
+		"interface": ifname,
+		"group":     endpoint.Group,
+		"service":   endpoint.Service,
+	})
+	return nil
 }
 
-func addToDNS(dbConn ucdb.Db, intent *upsi.Intent, labels map[string]string, containerID string, ips []net.IP) error {
-	if !*intent.AddToDNS {
-		return nil
+func ipsToStrings(ips []net.IP) []string {
+	ipsString := []string{}
+	for _, ip := range ips {
+		ipsString = append(ipsString, ip.String())
 	}
+	return ipsString
+}
 
+// getDNSDomains returns the domains that will be sent to the DNS for the
+// given container.
+func getDNSDomains(intent *upsi.Intent, labels map[string]string, containerID string) []string {
 	hostname := intent.GetHostNameFromLabels(labels)
 	domains := []string{hostname}
 	if containerID != "" {
@@ -77,19 +100,28 @@ func addToDNS(dbConn ucdb.Db, intent *upsi.Intent, labels map[string]string, con
 		//containerDomains.Domains = append(containerDomains.Domains, dockerServerResponse.ID)
 		domains = append(domains, containerID[0:12])
 	}
+	return domains
+}
+
+func addToDNS(dbConn ucdb.Db, intent *upsi.Intent, labels map[string]string, containerID string, ips []net.IP) error {
+	if !*intent.AddToDNS {
+		return nil
+	}
+
+	domains := getDNSDomains(intent, labels, containerID)
 	dnsClient, err := dbConn.GetDNSConfig()
 	if err != nil {
 		return err
 	}
 
-	ipsString := []string{}
-	for _, ip := range ips {
-		ipsString = append(ipsString, ip.String())
-	}
-
+	ipsString := ipsToStrings(ips)
 	if err := dnsClient.SendToDNS(domains, ipsString); err != nil {
 		return err
 	}
+	ub.Publish(ub.DNSEntryAdded, containerID, map[string]interface{}{
+		"domains": domains,
+		"ips":     ipsString,
+	})
 	return nil
 }
 
@@ -143,6 +175,11 @@ func addToLoadBalancer(dbConn ucdb.Db, intent *upsi.Intent,
 	default:
 		return fmt.Errorf("LoadBalancer '%s' unknown", *intent.LoadBalancer.Name)
 	}
+	ub.Publish(ub.LoadBalancerUpdated, contID, map[string]interface{}{
+		"load-balancer": *intent.LoadBalancer.Name,
+		"service":       svcName,
+		"ips":           ipsToStrings(ips),
+	})
 	return nil
 }
 
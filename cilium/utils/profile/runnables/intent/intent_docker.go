@@ -1,18 +1,31 @@
 package intent
 
 import (
-	"fmt"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
 	"net"
+	"time"
 
 	m "github.com/cilium-team/cilium/cilium/messages"
 	u "github.com/cilium-team/cilium/cilium/utils"
+	ub "github.com/cilium-team/cilium/cilium/utils/bus"
 	uc "github.com/cilium-team/cilium/cilium/utils/comm"
 	ucdb "github.com/cilium-team/cilium/cilium/utils/comm/db"
 	up "github.com/cilium-team/cilium/cilium/utils/profile"
+	upr "github.com/cilium-team/cilium/cilium/utils/profile/runnables"
 	upsi "github.com/cilium-team/cilium/cilium/utils/profile/subpolicies/intent"
 
 	"github.com/cilium-team/cilium/Godeps/_workspace/src/github.com/deckarep/golang-set"
-	d "github.com/cilium-team/cilium/Godeps/_workspace/src/github.com/fsouza/go-dockerclient"
+)
+
+const (
+	// SlotReservationLabel is the label with the max-scale slot reserved for
+	// a container.
+	SlotReservationLabel = "com.cilium.slot-reservation"
+	// SlotReservationTTL is the time a container has, since its slot was
+	// reserved, to start before the slot is freed.
+	SlotReservationTTL = 5 * time.Minute
 )
 
 func preHookDockerDaemonCreate(conn ucdb.Db, intent *upsi.Intent, containerConfig *m.DockerCreateConfig) error {
@@ -27,21 +40,22 @@ func preHookDockerDaemonCreate(conn ucdb.Db, intent *upsi.Intent, containerConfi
 	return nil
 }
 
-func preHookDockerSwarmCreate(conn ucdb.Db, intent *upsi.Intent, containerConfig *m.DockerCreateConfig) error {
+func preHookDockerSwarmCreate(conn ucdb.Db, intent *upsi.Intent, containerConfig *m.DockerCreateConfig) (err error) {
 	log.Debug("intent %#v", intent)
 	log.Debug("container config %+v", containerConfig.Config)
 	if len(containerConfig.Labels) == 0 {
 		return nil
 	}
-	docker, err := uc.NewDockerClient()
-	if err != nil {
-		return err
-	}
 
 	//intent.MaxScale
-	if err := maxScaleDocker(docker, intent, containerConfig.Labels); err != nil {
+	if err := maxScaleDocker(conn, intent, containerConfig); err != nil {
 		return err
 	}
+	defer func() {
+		if err != nil {
+			releaseSlotDocker(conn, containerConfig)
+		}
+	}()
 
 	//intent.HostnameIs
 	log.Debug("containerConfig.Hostname %+v", containerConfig.Hostname)
@@ -68,6 +82,11 @@ func postHookDockerDaemonStart(dbConn ucdb.Db, intent *upsi.Intent, containerCon
 		return nil
 	}
 
+	//intent.MaxScale
+	if err := confirmSlotDocker(dbConn, intent, containerConfig); err != nil {
+		return err
+	}
+
 	//intent.Netconf
 	if err := netConfDocker(dbConn, intent, containerConfig); err != nil {
 		return err
@@ -79,35 +98,105 @@ func postHookDockerDaemonStart(dbConn ucdb.Db, intent *upsi.Intent, containerCon
 	return nil
 }
 
-func maxScaleDocker(docker uc.Docker, intent *upsi.Intent, labels map[string]string) error {
-	svcName := u.LookupServiceName(labels)
+// maxScaleDocker reserves one of the intent.MaxScale slots of the
+// container's service, shared by the whole cluster, and denies the create if
+// all of them are taken. The reservation is added to the container's labels
+// so it's confirmed when the container starts.
+func maxScaleDocker(conn ucdb.Db, intent *upsi.Intent, containerConfig *m.DockerCreateConfig) error {
+	svcName := u.LookupServiceName(containerConfig.Labels)
 	if svcName == "" {
 		return nil
 	}
-	containers, err := docker.ListContainers(d.ListContainersOptions{All: true})
+	reservation, err := newReservation()
 	if err != nil {
 		return err
 	}
-	instancesRunning := 0
-	for _, container := range containers {
-		dockerContainer, err := docker.InspectContainer(container.ID)
-		if err != nil {
-			return err
-		}
-		dockerServiceName := u.LookupServiceName(dockerContainer.Config.Labels)
-		if dockerServiceName != "" && svcName == dockerServiceName {
-			instancesRunning++
-			log.Debug("intent.MaxScale  %+v", intent.MaxScale)
-			log.Debug("instancesRunning %+v", instancesRunning)
-			if instancesRunning >= *intent.MaxScale {
-				log.Warning("Reached maximum scalability for containers with labels: %s", labels)
-				return fmt.Errorf("Reached maximum scalability for containers with labels: %s", labels)
-			}
-		}
+	reserved := false
+	now := time.Now()
+	if err := conn.UpdateServiceSlots(svcName, func(slots *up.ServiceSlots) error {
+		slots.Expire(now)
+		reserved = slots.Reserve(reservation, *intent.MaxScale, now.Add(SlotReservationTTL))
+		log.Debug("intent.MaxScale %+v", *intent.MaxScale)
+		log.Debug("slots %+v", slots.Slots)
+		return nil
+	}); err != nil {
+		return err
+	}
+	if !reserved {
+		log.Warning("Reached maximum scalability for containers with labels: %s", containerConfig.Labels)
+		return upr.NewDenial("max-scale", "reached maximum scale of %d containers for service %s", *intent.MaxScale, svcName)
+	}
+	containerConfig.Labels[SlotReservationLabel] = reservation
+	log.Info("Reserved slot %s of service %s for container %s", reservation, svcName, containerConfig.Name)
+	return nil
+}
+
+// confirmSlotDocker assigns the started container to the slot it reserved on
+// creation and denies the start if the slot was lost, e.g. the reservation
+// expired, and all of the intent.MaxScale slots are taken.
+func confirmSlotDocker(conn ucdb.Db, intent *upsi.Intent, containerConfig *m.DockerCreateConfig) error {
+	svcName := u.LookupServiceName(containerConfig.Labels)
+	reservation, ok := containerConfig.Labels[SlotReservationLabel]
+	if svcName == "" || !ok {
+		return nil
+	}
+	confirmed := false
+	now := time.Now()
+	if err := conn.UpdateServiceSlots(svcName, func(slots *up.ServiceSlots) error {
+		slots.Expire(now)
+		confirmed = slots.Confirm(reservation, containerConfig.ID, *intent.MaxScale)
+		return nil
+	}); err != nil {
+		return err
+	}
+	if !confirmed {
+		log.Warning("Reached maximum scalability for containers with labels: %s", containerConfig.Labels)
+		return upr.NewDenial("max-scale", "reached maximum scale of %d containers for service %s", *intent.MaxScale, svcName)
+	}
+	return nil
+}
+
+// ReleaseFailedCreateSlot frees the slot reserved for the container of the
+// given create request body, as modified by the pre hook, once docker failed
+// to create it.
+func ReleaseFailedCreateSlot(conn ucdb.Db, body string) error {
+	var containerConfig m.DockerCreateConfig
+	if err := json.Unmarshal([]byte(body), &containerConfig); err != nil {
+		return err
+	}
+	if containerConfig.Config == nil {
+		return nil
 	}
+	releaseSlotDocker(conn, &containerConfig)
 	return nil
 }
 
+// releaseSlotDocker frees the slot reserved for a container whose create was
+// denied, or failed, after the reservation.
+func releaseSlotDocker(conn ucdb.Db, containerConfig *m.DockerCreateConfig) {
+	svcName := u.LookupServiceName(containerConfig.Labels)
+	reservation, ok := containerConfig.Labels[SlotReservationLabel]
+	if svcName == "" || !ok {
+		return
+	}
+	if err := conn.UpdateServiceSlots(svcName, func(slots *up.ServiceSlots) error {
+		slots.Release(reservation)
+		return nil
+	}); err != nil {
+		log.Warning("Unable to release slot %s of service %s: %s", reservation, svcName, err)
+	}
+	delete(containerConfig.Labels, SlotReservationLabel)
+}
+
+// newReservation returns a random reservation ID.
+func newReservation() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
 func removeDockerLinksDocker(conn ucdb.Db, intent *upsi.Intent, containerConfig *m.DockerCreateConfig) error {
 	if !*intent.RemoveDockerLinks || containerConfig.HostConfig == nil {
 		return nil
@@ -116,6 +205,9 @@ func removeDockerLinksDocker(conn ucdb.Db, intent *upsi.Intent, containerConfig
 	if err := conn.PutDockerLinksOfContainerTemp(cl); err != nil {
 		return err
 	}
+	if len(containerConfig.HostConfig.Links) != 0 {
+		containerConfig.Warn("docker links %v are resolved by cilium instead of docker", containerConfig.HostConfig.Links)
+	}
 	containerConfig.HostConfig.Links = nil
 	log.Info("Removed docker links for container %s", containerConfig.Name)
 	return nil
@@ -126,9 +218,19 @@ func removePortBindingsDocker(conn ucdb.Db, intent *upsi.Intent, containerConfig
 		return nil
 	}
 	cpb := up.ContainerPortBindings{Container: containerConfig.Name, PortBindings: containerConfig.HostConfig.PortBindings}
+	hostPorts, err := checkPortBindingsDocker(intent, containerConfig, cpb.PortBindings)
+	if err != nil {
+		return err
+	}
 	if err := conn.PutDockerPortBindingsOfContainerTemp(cpb); err != nil {
 		return err
 	}
+	if len(containerConfig.HostConfig.PortBindings) != 0 {
+		containerConfig.Warn("port bindings are published by cilium instead of docker")
+		if err := warnHostPortConflicts(conn, containerConfig, hostPorts); err != nil {
+			return err
+		}
+	}
 	containerConfig.HostConfig.PortBindings = nil
 	log.Info("Removed PortBindings for container %s", containerConfig.Name)
 	return nil
@@ -141,13 +243,36 @@ func netConfDocker(dbConn ucdb.Db, intent *upsi.Intent, containerConfig *m.Docke
 		return nil
 	}
 
-	ip, ipnet, err := getIPFrom(dbConn, *intent.NetConf.CIDR)
+	ip, ipnet, err := getIPFromNetConf(dbConn, intent)
+	if err != nil {
+		return err
+	}
+	ub.Publish(ub.IPAllocated, containerConfig.ID, map[string]interface{}{
+		"ip":      ip.String(),
+		"cidr":    ipnet.String(),
+		"network": networkOf(intent),
+	})
+
+	routerMACs, err := setupRouter(dbConn, intent, ip)
 	if err != nil {
+		dbConn.DeleteIP(ip)
+		log.Error("Fail while setting up the router for container %s: %s", containerConfig.ID, err)
+		return err
+	}
+	if err := setupEgress(dbConn, intent); err != nil {
+		dbConn.DeleteIP(ip)
+		log.Error("Fail while setting up the egress for container %s: %s", containerConfig.ID, err)
+		return err
+	}
+	hostPorts, err := hostPortsDocker(dbConn, intent, containerConfig, ip)
+	if err != nil {
+		dbConn.DeleteIP(ip)
+		log.Error("Fail while getting the host ports of container %s: %s", containerConfig.ID, err)
 		return err
 	}
 
 	//Create bridge for this container
-	ifname, mac, err := u.CreateBridge(ip, *ipnet, intent.NetConf, containerConfig.State.Pid, containerConfig.ID)
+	ifname, mac, err := u.CreateBridge(ip, *ipnet, intent.NetConf, routerMACs, containerConfig.State.Pid, containerConfig.ID)
 	if err != nil {
 		dbConn.DeleteIP(ip)
 		log.Error("Fail while setting up networking for container %s: %s", containerConfig.ID, err)
@@ -155,7 +280,7 @@ func netConfDocker(dbConn ucdb.Db, intent *upsi.Intent, containerConfig *m.Docke
 	}
 
 	//Save this container's endpoint
-	if err := saveEndpoint(dbConn, intent, containerConfig.Labels, containerConfig.ID, ifname, []net.IP{ip}, []string{mac}); err != nil {
+	if err := saveEndpoint(dbConn, intent, containerConfig.Labels, containerConfig.ID, containerConfig.Name, ifname, []net.IP{ip}, []string{mac}); err != nil {
 		dbConn.DeleteIP(ip)
 		log.Error("Fail while saving up endpoint for container %s: %s", containerConfig.ID, err)
 		return err
@@ -179,7 +304,7 @@ func netConfDocker(dbConn ucdb.Db, intent *upsi.Intent, containerConfig *m.Docke
 	}
 
 	//intent.NetPolicy
-	if err := forceNetworkRules(intent); err != nil {
+	if err := forceNetworkRules(dbConn, intent); err != nil {
 		log.Error("Fail creating network rules for %s: %s", containerConfig.ID, err)
 	}
 
@@ -190,6 +315,15 @@ func netConfDocker(dbConn ucdb.Db, intent *upsi.Intent, containerConfig *m.Docke
 		log.Error("Fail while adding container to load balancer %s: %s", containerConfig.ID, err)
 	}
 
+	//intent.RemovePortBindings
+	if err := publishHostPortsDocker(dbConn, intent, containerConfig.ID, hostPorts, routerMACs); err != nil {
+		u.UnpublishHostPorts(dbConn, containerConfig.ID)
+		dbConn.DeleteEndpoint(containerConfig.ID)
+		dbConn.DeleteIP(ip)
+		log.Error("Fail while publishing host ports of container %s: %s", containerConfig.ID, err)
+		return err
+	}
+
 	return nil
 }
 
@@ -0,0 +1,100 @@
+package intent
+
+import (
+	"sync"
+	"testing"
+
+	m "github.com/cilium-team/cilium/cilium/messages"
+	u "github.com/cilium-team/cilium/cilium/utils"
+	ucdb "github.com/cilium-team/cilium/cilium/utils/comm/db"
+	upr "github.com/cilium-team/cilium/cilium/utils/profile/runnables"
+	upsi "github.com/cilium-team/cilium/cilium/utils/profile/subpolicies/intent"
+
+	d "github.com/cilium-team/cilium/Godeps/_workspace/src/github.com/fsouza/go-dockerclient"
+)
+
+func newServiceContainerConfig(service string) *m.DockerCreateConfig {
+	return &m.DockerCreateConfig{
+		Config: &d.Config{Labels: map[string]string{"com.intent.service": service}},
+	}
+}
+
+func TestMaxScaleDockerConcurrentCreates(t *testing.T) {
+	conn := ucdb.NewMemConn()
+	intent := upsi.NewIntent()
+	*intent.MaxScale = 3
+	const creates = 20
+
+	var (
+		wg      sync.WaitGroup
+		mutex   sync.Mutex
+		created []*m.DockerCreateConfig
+	)
+	for i := 0; i < creates; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			cc := newServiceContainerConfig("web")
+			err := maxScaleDocker(conn, intent, cc)
+			if _, denied := err.(upr.Denial); err != nil && !denied {
+				t.Errorf("error while reserving slot: %s", err)
+			} else if err == nil {
+				mutex.Lock()
+				created = append(created, cc)
+				mutex.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	if len(created) != *intent.MaxScale {
+		t.Fatalf("invalid number of containers created:\ngot  %d\nwant %d", len(created), *intent.MaxScale)
+	}
+
+	// A denied create frees its slot for another container.
+	releaseSlotDocker(conn, created[0])
+	if _, ok := created[0].Labels[SlotReservationLabel]; ok {
+		t.Errorf("reservation label not removed after releasing slot")
+	}
+	if err := maxScaleDocker(conn, intent, newServiceContainerConfig("web")); err != nil {
+		t.Errorf("container denied after releasing slot: %s", err)
+	}
+
+	// So does a create that failed in docker.
+	body, err := created[2].Marshal2JSONStr()
+	if err != nil {
+		t.Fatalf("error while marshalling container config: %s", err)
+	}
+	if err := ReleaseFailedCreateSlot(conn, body); err != nil {
+		t.Fatalf("error while releasing slot of failed create: %s", err)
+	}
+	if err := maxScaleDocker(conn, intent, newServiceContainerConfig("web")); err != nil {
+		t.Errorf("container denied after releasing slot of failed create: %s", err)
+	}
+
+	// Once started, the slot belongs to the container until it's destroyed.
+	created[1].ID = "1"
+	if err := confirmSlotDocker(conn, intent, created[1]); err != nil {
+		t.Fatalf("error while confirming slot: %s", err)
+	}
+	err = maxScaleDocker(conn, intent, newServiceContainerConfig("web"))
+	want := upr.NewDenial("max-scale", "reached maximum scale of %d containers for service %s", 3, "web")
+	if err != want {
+		t.Errorf("invalid error:\ngot  %#v\nwant %#v", err, want)
+	}
+	// A container started again without its slot can't exceed the maximum.
+	lost := newServiceContainerConfig("web")
+	lost.ID, lost.Labels[SlotReservationLabel] = "2", "lost"
+	if err := confirmSlotDocker(conn, intent, lost); err != want {
+		t.Errorf("invalid error while confirming a lost slot:\ngot  %#v\nwant %#v", err, want)
+	}
+	if err := u.ReleaseServiceSlot(conn, "1"); err != nil {
+		t.Fatalf("error while releasing slot of container: %s", err)
+	}
+	if err := maxScaleDocker(conn, intent, newServiceContainerConfig("web")); err != nil {
+		t.Errorf("container denied after destroying another: %s", err)
+	}
+
+	if err := maxScaleDocker(conn, intent, newServiceContainerConfig("db")); err != nil {
+		t.Errorf("container of another service denied: %s", err)
+	}
+}
@@ -0,0 +1,124 @@
+package intent
+
+import (
+	"net"
+	"os"
+
+	m "github.com/cilium-team/cilium/cilium/messages"
+	u "github.com/cilium-team/cilium/cilium/utils"
+	ub "github.com/cilium-team/cilium/cilium/utils/bus"
+	ucdb "github.com/cilium-team/cilium/cilium/utils/comm/db"
+	up "github.com/cilium-team/cilium/cilium/utils/profile"
+	upr "github.com/cilium-team/cilium/cilium/utils/profile/runnables"
+	upsi "github.com/cilium-team/cilium/cilium/utils/profile/subpolicies/intent"
+)
+
+// loadBalancesPortBindings returns true if the load balancer publishes, as its
+// frontends, the port bindings removed from the containers with the given
+// labels instead of cilium.
+func loadBalancesPortBindings(intent *upsi.Intent, labels map[string]string) bool {
+	return *intent.MaxScale > 1 && u.LookupServiceName(labels) != "" && *intent.LoadBalancer.BindPort == 0
+}
+
+// checkPortBindingsDocker returns the host ports that publish the given port
+// bindings, removed from the container by the intent, or a Denial if cilium
+// can't publish them. It returns none if the load balancer publishes them.
+func checkPortBindingsDocker(intent *upsi.Intent, containerConfig *m.DockerCreateConfig, bindings up.PortBindings) ([]up.HostPort, error) {
+	if len(bindings) == 0 || loadBalancesPortBindings(intent, containerConfig.Labels) {
+		return nil, nil
+	}
+	if bridge := u.BridgeOf(intent.NetConf); bridge != u.DefaultBridge {
+		return nil, upr.NewDenial("remove-port-bindings", "port bindings of endpoints of bridge %s can't be published", bridge)
+	}
+	node := os.Getenv("HOST_IP")
+	if node == "" {
+		return nil, upr.NewDenial("remove-port-bindings", "port bindings can't be published without the node's HOST_IP")
+	}
+	hostPorts, err := up.HostPortsOf(bindings, node, containerConfig.ID, nil)
+	if err != nil {
+		return nil, upr.NewDenial("remove-port-bindings", "%s", err)
+	}
+	return hostPorts, nil
+}
+
+// warnHostPortConflicts warns about the given host ports already published for
+// other containers.
+func warnHostPortConflicts(conn ucdb.Db, containerConfig *m.DockerCreateConfig, hostPorts []up.HostPort) error {
+	published, err := conn.GetHostPorts()
+	if err != nil {
+		return err
+	}
+	for _, hostPort := range hostPorts {
+		for _, other := range published {
+			if hostPort.Key() == other.Key() {
+				containerConfig.Warn("host port %s is already published for container %s", other.Key(), other.Container)
+			}
+		}
+	}
+	return nil
+}
+
+// hostPortsDocker returns the host ports that publish the port bindings
+// removed from the container by the intent, unless the load balancer
+// publishes them. The intent's gateway defaults to DefaultRouterIP since the
+// endpoint replies through it.
+func hostPortsDocker(conn ucdb.Db, intent *upsi.Intent, containerConfig *m.DockerCreateConfig, ip net.IP) ([]up.HostPort, error) {
+	if !*intent.RemovePortBindings {
+		return nil, nil
+	}
+	containerPortBindings, err := conn.GetDockerPortBindingsOfContainerTemp(containerConfig.Name)
+	if err != nil {
+		return nil, err
+	}
+	hostPorts, err := checkPortBindingsDocker(intent, containerConfig, containerPortBindings.PortBindings)
+	if err != nil || len(hostPorts) == 0 {
+		return nil, err
+	}
+	for i := range hostPorts {
+		hostPorts[i].IP = ip.String()
+	}
+	if intent.NetConf.Gw == nil || *intent.NetConf.Gw == "" {
+		gw := up.DefaultRouterIP
+		intent.NetConf.Gw = &gw
+	}
+	return hostPorts, nil
+}
+
+// publishHostPortsDocker publishes the given host ports of the container,
+// whose endpoint has the given router MACs, and reports the ones already
+// published for other containers.
+func publishHostPortsDocker(conn ucdb.Db, intent *upsi.Intent, containerID string, hostPorts []up.HostPort, routerMACs []string) error {
+	if len(hostPorts) == 0 {
+		return nil
+	}
+	namespace := up.DefaultNamespace
+	if intent.NetConf.Namespace != nil {
+		namespace = *intent.NetConf.Namespace
+	}
+	routerMAC := up.DefaultRouterMAC
+	if len(routerMACs) != 0 {
+		routerMAC = routerMACs[0]
+	}
+	conflicts, err := u.PublishHostPorts(conn, hostPorts, namespace, routerMAC)
+	published := []string{}
+	for _, hostPort := range hostPorts {
+		conflicted := false
+		for _, conflict := range conflicts {
+			conflicted = conflicted || conflict.Key() == hostPort.Key()
+		}
+		if conflicted {
+			log.Error("Host port %s of container %s is already published for another container", hostPort.Key(), containerID)
+			ub.Publish(ub.HostPortConflict, containerID, map[string]interface{}{
+				"host-port": hostPort.Key(),
+			})
+		} else if err == nil {
+			published = append(published, hostPort.Key())
+		}
+	}
+	if len(published) != 0 {
+		ub.Publish(ub.HostPortsPublished, containerID, map[string]interface{}{
+			"host-ports": published,
+		})
+	}
+	return err
+}
@@ -0,0 +1,100 @@
+package intent
+
+import (
+	"net"
+	"os"
+	"testing"
+
+	m "github.com/cilium-team/cilium/cilium/messages"
+	ucdb "github.com/cilium-team/cilium/cilium/utils/comm/db"
+	up "github.com/cilium-team/cilium/cilium/utils/profile"
+	upr "github.com/cilium-team/cilium/cilium/utils/profile/runnables"
+	upsi "github.com/cilium-team/cilium/cilium/utils/profile/subpolicies/intent"
+
+	d "github.com/cilium-team/cilium/Godeps/_workspace/src/github.com/fsouza/go-dockerclient"
+)
+
+func TestHostPortsDocker(t *testing.T) {
+	conn := ucdb.NewMemConn()
+	os.Setenv("HOST_IP", "192.168.50.10")
+	cpb := up.ContainerPortBindings{Container: "web", PortBindings: up.PortBindings{"80/tcp": {{HostPort: "8080"}}}}
+	if err := conn.PutDockerPortBindingsOfContainerTemp(cpb); err != nil {
+		t.Fatal(err)
+	}
+	cc := &m.DockerCreateConfig{
+		ID:     "a",
+		Name:   "web",
+		Config: &d.Config{Labels: map[string]string{"com.intent.service": "web"}},
+	}
+	intent := upsi.NewIntent()
+	*intent.RemovePortBindings = true
+	hostPorts, err := hostPortsDocker(conn, intent, cc, net.ParseIP("10.1.0.5"))
+	if err != nil {
+		t.Fatalf("error while getting host ports: %s", err)
+	}
+	want := up.HostPort{HostIP: "192.168.50.10", HostPort: 8080, Protocol: "tcp", Node: "192.168.50.10",
+		Container: "a", IP: "10.1.0.5", Port: 80}
+	if len(hostPorts) != 1 || hostPorts[0] != want {
+		t.Errorf("invalid host ports:\ngot  %+v\nwant %+v", hostPorts, []up.HostPort{want})
+	}
+	if *intent.NetConf.Gw != up.DefaultRouterIP {
+		t.Errorf("invalid gateway:\ngot  %s\nwant %s", *intent.NetConf.Gw, up.DefaultRouterIP)
+	}
+
+	// Port bindings published by the load balancer are left to it.
+	intent = upsi.NewIntent()
+	*intent.RemovePortBindings = true
+	*intent.MaxScale = 2
+	if hostPorts, err := hostPortsDocker(conn, intent, cc, net.ParseIP("10.1.0.5")); err != nil || len(hostPorts) != 0 {
+		t.Errorf("invalid host ports of load balanced container:\ngot  %+v, %v\nwant none", hostPorts, err)
+	}
+}
+
+func TestCheckPortBindingsDocker(t *testing.T) {
+	os.Setenv("HOST_IP", "192.168.50.10")
+	defer os.Setenv("HOST_IP", "")
+	cc := &m.DockerCreateConfig{Name: "web", Config: &d.Config{Labels: map[string]string{}}}
+	intent := upsi.NewIntent()
+	*intent.RemovePortBindings = true
+
+	hostPorts, err := checkPortBindingsDocker(intent, cc, up.PortBindings{"80/tcp": {{HostIP: "0.0.0.0", HostPort: "8080"}}})
+	if err != nil {
+		t.Fatalf("error while checking port bindings: %s", err)
+	}
+	if len(hostPorts) != 1 || hostPorts[0].Key() != "192.168.50.10:8080/tcp" {
+		t.Errorf("invalid host ports:\ngot  %+v\nwant %s", hostPorts, "192.168.50.10:8080/tcp")
+	}
+
+	if _, err := checkPortBindingsDocker(intent, cc, up.PortBindings{"80/tcp": {{}}}); err == nil {
+		t.Errorf("port binding without host port wasn't denied")
+	} else if _, ok := err.(upr.Denial); !ok {
+		t.Errorf("invalid error type:\ngot  %T\nwant %T", err, upr.Denial{})
+	}
+
+	os.Setenv("HOST_IP", "")
+	if _, err := checkPortBindingsDocker(intent, cc, up.PortBindings{"80/tcp": {{HostPort: "8080"}}}); err == nil {
+		t.Errorf("port binding without HOST_IP wasn't denied")
+	}
+}
+
+func TestWarnHostPortConflicts(t *testing.T) {
+	conn := ucdb.NewMemConn()
+	conn.PutHostPort(up.HostPort{HostIP: "192.168.50.10", HostPort: 8080, Protocol: "tcp", Container: "b"})
+	os.Setenv("HOST_IP", "192.168.50.10")
+	defer os.Setenv("HOST_IP", "")
+	cc := &m.DockerCreateConfig{Name: "web", Config: &d.Config{Labels: map[string]string{}}}
+	intent := upsi.NewIntent()
+	*intent.RemovePortBindings = true
+
+	// A binding without host IP conflicts with the same port of the node's IP.
+	hostPorts, err := checkPortBindingsDocker(intent, cc, up.PortBindings{"80/tcp": {{HostPort: "8080"}}})
+	if err != nil {
+		t.Fatalf("error while checking port bindings: %s", err)
+	}
+	if err := warnHostPortConflicts(conn, cc, hostPorts); err != nil {
+		t.Fatalf("error while checking host port conflicts: %s", err)
+	}
+	if len(cc.Warnings) != 1 {
+		t.Errorf("invalid warnings:\ngot  %v\nwant %v", cc.Warnings, []string{"host port 192.168.50.10:8080/tcp is already published for container b"})
+	}
+}
@@ -0,0 +1,105 @@
+package intent
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	m "github.com/cilium-team/cilium/cilium/messages"
+	u "github.com/cilium-team/cilium/cilium/utils"
+	ucdb "github.com/cilium-team/cilium/cilium/utils/comm/db"
+	up "github.com/cilium-team/cilium/cilium/utils/profile"
+	upsi "github.com/cilium-team/cilium/cilium/utils/profile/subpolicies/intent"
+)
+
+var (
+	// identityLabels are the keys of the labels that make the identity of a
+	// container.
+	identityLabels = []string{"com.intent.service", up.IdentityOwnerLabel}
+
+	// groupSelector matches the $group(selector) keywords of OVS rules.
+	groupSelector = regexp.MustCompile(`\$group\(([^)]*)\)`)
+)
+
+// SetIdentityLabels sets the keys of the labels that make the identity of a
+// container. up.IdentityOwnerLabel is the owners of the policies covering it.
+func SetIdentityLabels(keys []string) {
+	identityLabels = keys
+}
+
+func isAutoGroup(intent *upsi.Intent) bool {
+	return intent.NetConf.AutoGroup != nil && *intent.NetConf.AutoGroup
+}
+
+// acquireIdentityDocker sets, if the intent has auto-group, the group of the
+// container to the identity allocated to its identity labels.
+func acquireIdentityDocker(conn ucdb.Db, owners []string, intent *upsi.Intent, containerConfig *m.DockerCreateConfig) error {
+	if !isAutoGroup(intent) {
+		return nil
+	}
+	var containerLabels map[string]string
+	if containerConfig.Config != nil {
+		containerLabels = containerConfig.Labels
+	}
+	labels := up.IdentityLabels(identityLabels, owners, containerLabels)
+	id, err := u.AcquireIdentity(conn, labels, containerConfig.ID)
+	if err != nil {
+		return err
+	}
+	log.Info("Container %s has identity %d %v", containerConfig.ID, id, labels)
+	intent.NetConf.Group = &id
+	return nil
+}
+
+// releaseIdentityDocker releases the identity acquired for a container whose
+// networking failed.
+func releaseIdentityDocker(conn ucdb.Db, intent *upsi.Intent, containerConfig *m.DockerCreateConfig) {
+	if !isAutoGroup(intent) || containerConfig.ID == "" {
+		return
+	}
+	if err := u.ReleaseIdentity(conn, containerConfig.ID); err != nil {
+		log.Warning("Unable to release identity of container %s: %s", containerConfig.ID, err)
+	}
+}
+
+// expandGroupSelectors returns the given OVS rules with every
+// $group(selector) replaced by the ID of each identity it selects. A rule is
+// repeated for every combination of IDs and dropped if a selector doesn't
+// select any identity.
+func expandGroupSelectors(identities up.Identities, rules []string) ([]string, error) {
+	expanded := []string{}
+	for _, rule := range rules {
+		loc := groupSelector.FindStringSubmatchIndex(rule)
+		if loc == nil {
+			expanded = append(expanded, rule)
+			continue
+		}
+		selector, err := up.ParseSelector(rule[loc[2]:loc[3]])
+		if err != nil {
+			return nil, fmt.Errorf("invalid OVS rule %q: %s", rule, err)
+		}
+		replaced := []string{}
+		for _, id := range identities.Select(selector) {
+			replaced = append(replaced, rule[:loc[0]]+strconv.Itoa(id)+rule[loc[1]:])
+		}
+		// The remaining selectors of the rule.
+		replaced, err = expandGroupSelectors(identities, replaced)
+		if err != nil {
+			return nil, err
+		}
+		expanded = append(expanded, replaced...)
+	}
+	return expanded, nil
+}
+
+// hasGroupSelectors returns true if any of the given rules has a
+// $group(selector).
+func hasGroupSelectors(rules []string) bool {
+	for _, rule := range rules {
+		if strings.Contains(rule, "$group(") {
+			return true
+		}
+	}
+	return false
+}
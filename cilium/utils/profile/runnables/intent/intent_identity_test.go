@@ -0,0 +1,78 @@
+package intent
+
+import (
+	"reflect"
+	"testing"
+
+	d "github.com/cilium-team/cilium/Godeps/_workspace/src/github.com/fsouza/go-dockerclient"
+	m "github.com/cilium-team/cilium/cilium/messages"
+	ucdb "github.com/cilium-team/cilium/cilium/utils/comm/db"
+	up "github.com/cilium-team/cilium/cilium/utils/profile"
+	upsi "github.com/cilium-team/cilium/cilium/utils/profile/subpolicies/intent"
+)
+
+func TestAcquireIdentityDocker(t *testing.T) {
+	conn := ucdb.NewMemConn()
+	intent := upsi.NewIntent()
+	cc := &m.DockerCreateConfig{
+		ID:     "1234",
+		Config: &d.Config{Labels: map[string]string{"com.intent.service": "web"}},
+	}
+	if err := acquireIdentityDocker(conn, []string{"foo"}, intent, cc); err != nil {
+		t.Fatalf("error while acquiring identity without auto-group: %s", err)
+	}
+	if *intent.NetConf.Group == up.FirstIdentityID {
+		t.Errorf("identity acquired without auto-group")
+	}
+
+	*intent.NetConf.AutoGroup = true
+	if err := acquireIdentityDocker(conn, []string{"foo"}, intent, cc); err != nil {
+		t.Fatalf("error while acquiring identity: %s", err)
+	}
+	if *intent.NetConf.Group != up.FirstIdentityID {
+		t.Errorf("invalid group:\ngot  %d\nwant %d", *intent.NetConf.Group, up.FirstIdentityID)
+	}
+	identities, _ := conn.GetIdentities()
+	want := []up.Identity{{
+		ID:        up.FirstIdentityID,
+		Labels:    map[string]string{"com.intent.service": "web", up.IdentityOwnerLabel: "foo"},
+		Endpoints: []string{"1234"},
+	}}
+	if !reflect.DeepEqual(identities.Identities, want) {
+		t.Errorf("invalid identities:\ngot  %+v\nwant %+v", identities.Identities, want)
+	}
+
+	releaseIdentityDocker(conn, intent, cc)
+	if identities, _ := conn.GetIdentities(); len(identities.Identities) != 0 {
+		t.Errorf("invalid identities after release:\ngot  %+v\nwant []", identities.Identities)
+	}
+}
+
+func TestExpandGroupSelectors(t *testing.T) {
+	identities := up.Identities{Identities: []up.Identity{
+		{ID: 2048, Labels: map[string]string{"com.intent.service": "web"}},
+		{ID: 2049, Labels: map[string]string{"com.intent.service": "db"}},
+		{ID: 2050, Labels: map[string]string{"com.intent.service": "web-admin"}},
+	}}
+	rules := []string{
+		"priority=100,ip,reg0=$group(com.intent.service=^web),reg1=$group(com.intent.service=^db$),action=normal",
+		"priority=100,ip,reg0=$group(com.intent.service=cache),action=normal",
+		"priority=0,action=drop",
+	}
+	got, err := expandGroupSelectors(identities, rules)
+	if err != nil {
+		t.Fatalf("error while expanding group selectors: %s", err)
+	}
+	want := []string{
+		"priority=100,ip,reg0=2048,reg1=2049,action=normal",
+		"priority=100,ip,reg0=2050,reg1=2049,action=normal",
+		"priority=0,action=drop",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("invalid expanded rules:\ngot  %v\nwant %v", got, want)
+	}
+
+	if _, err := expandGroupSelectors(identities, []string{"reg0=$group(web)"}); err == nil {
+		t.Errorf("rule with an invalid selector was expanded")
+	}
+}
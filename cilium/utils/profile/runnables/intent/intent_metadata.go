@@ -0,0 +1,175 @@
+package intent
+
+import (
+	"net"
+
+	m "github.com/cilium-team/cilium/cilium/messages"
+	up "github.com/cilium-team/cilium/cilium/utils/profile"
+	upr "github.com/cilium-team/cilium/cilium/utils/profile/runnables"
+	upsi "github.com/cilium-team/cilium/cilium/utils/profile/subpolicies/intent"
+)
+
+// metadataRef is a label or annotation template, from add-labels or
+// add-annotations, and the policy it comes from.
+type metadataRef struct {
+	template string
+	policy   string
+	owner    string
+}
+
+// metadataRefs are the labels and annotations, indexed by key, that a request
+// covered by some policies gets.
+type metadataRefs struct {
+	labels      map[string]metadataRef
+	annotations map[string]metadataRef
+}
+
+func newMetadataRefs() metadataRefs {
+	return metadataRefs{
+		labels:      map[string]metadataRef{},
+		annotations: map[string]metadataRef{},
+	}
+}
+
+// addFrom adds the labels and annotations of the given user's policies to the
+// receiver, overwriting the ones already there. Like the rest of the intent
+// config, between the user's policies the ones with lower priority take
+// precedence.
+func (mr metadataRefs) addFrom(user up.User, policies []up.PolicySource) {
+	userPolicies := upr.UserPolicies(policies, user, func(policy up.Policy) int {
+		return policy.IntentConfig.Priority
+	})
+	userRefs := newMetadataRefs()
+	for _, policy := range userPolicies {
+		intent := policy.IntentConfig.Config
+		for key, value := range intent.AddLabels {
+			if _, ok := userRefs.labels[key]; !ok {
+				userRefs.labels[key] = metadataRef{template: value, policy: policy.Name, owner: user.Name}
+			}
+		}
+		for key, value := range intent.AddAnnotations {
+			if _, ok := userRefs.annotations[key]; !ok {
+				userRefs.annotations[key] = metadataRef{template: value, policy: policy.Name, owner: user.Name}
+			}
+		}
+	}
+	for key, ref := range userRefs.labels {
+		mr.labels[key] = ref
+	}
+	for key, ref := range userRefs.annotations {
+		mr.annotations[key] = ref
+	}
+}
+
+// render returns the given labels or annotations with their templates
+// executed with values and the owner and policy each one comes from.
+func render(refs map[string]metadataRef, values upsi.MetadataValues) (map[string]string, error) {
+	rendered := map[string]string{}
+	for key, ref := range refs {
+		values.Owner, values.Policy = ref.owner, ref.policy
+		value, err := upsi.RenderMetadata(map[string]string{key: ref.template}, values)
+		if err != nil {
+			return nil, err
+		}
+		rendered[key] = value[key]
+	}
+	return rendered, nil
+}
+
+// metadataValues returns the values, known before the container or
+// kubernetes object is created, for the templates of intent's labels and
+// annotations.
+func metadataValues(intent *upsi.Intent, name string) upsi.MetadataValues {
+	values := upsi.MetadataValues{Name: name}
+	if intent.NetConf.Group != nil {
+		values.Group = *intent.NetConf.Group
+	}
+	if intent.NetConf.CIDR != nil {
+		if ip, ipnet, err := net.ParseCIDR(*intent.NetConf.CIDR); err == nil {
+			if ones, bits := ipnet.Mask.Size(); ones == bits {
+				values.IP = ip.String()
+			}
+		}
+	}
+	return values
+}
+
+// addMetadataDocker adds the labels and annotations to the container's
+// labels, docker doesn't have annotations. It must run before any other
+// intent handler so they see the added labels, e.g. to look up the
+// container's service name.
+func addMetadataDocker(refs metadataRefs, intent *upsi.Intent, containerConfig *m.DockerCreateConfig) error {
+	if len(refs.labels) == 0 && len(refs.annotations) == 0 {
+		return nil
+	}
+	values := metadataValues(intent, containerConfig.Name)
+	annotations, err := render(refs.annotations, values)
+	if err != nil {
+		return err
+	}
+	labels, err := render(refs.labels, values)
+	if err != nil {
+		return err
+	}
+	if containerConfig.Labels == nil {
+		containerConfig.Labels = map[string]string{}
+	}
+	// Labels take precedence over annotations with the same key.
+	for _, metadata := range []map[string]string{annotations, labels} {
+		for key, value := range metadata {
+			containerConfig.Labels[key] = value
+		}
+	}
+	log.Info("Added labels %v of policies to container %s", containerConfig.Labels, containerConfig.Name)
+	return nil
+}
+
+// addMetadataKubernetes adds the labels and annotations to the kubernetes
+// object's metadata and, for replication controllers, to the metadata of
+// their pods' template.
+func addMetadataKubernetes(refs metadataRefs, intent *upsi.Intent, kor *m.KubernetesObjRef) error {
+	if len(refs.labels) == 0 && len(refs.annotations) == 0 {
+		return nil
+	}
+	metadata := getObjMap(kor.BodyObj, "metadata")
+	name, _ := metadata["name"].(string)
+	values := metadataValues(intent, name)
+	labels, err := render(refs.labels, values)
+	if err != nil {
+		return err
+	}
+	annotations, err := render(refs.annotations, values)
+	if err != nil {
+		return err
+	}
+	metadatas := []map[string]interface{}{metadata}
+	if kor.Kind == "ReplicationController" {
+		metadatas = append(metadatas, getObjMap(getObjMap(kor.BodyObj, "spec"), "template", "metadata"))
+	}
+	for _, metadata := range metadatas {
+		setObjStrings(getObjMap(metadata, "labels"), labels)
+		setObjStrings(getObjMap(metadata, "annotations"), annotations)
+	}
+	log.Info("Added labels %v and annotations %v of policies to %s %s", labels, annotations, kor.Kind, name)
+	return nil
+}
+
+// getObjMap returns the object under the given path of obj, creating it, and
+// replacing whatever isn't an object, if needed.
+func getObjMap(obj map[string]interface{}, path ...string) map[string]interface{} {
+	for _, key := range path {
+		child, ok := obj[key].(map[string]interface{})
+		if !ok {
+			child = map[string]interface{}{}
+			obj[key] = child
+		}
+		obj = child
+	}
+	return obj
+}
+
+func setObjStrings(obj map[string]interface{}, values map[string]string) {
+	for key, value := range values {
+		obj[key] = value
+	}
+}
@@ -0,0 +1,93 @@
+package intent
+
+import (
+	"reflect"
+	"testing"
+
+	m "github.com/cilium-team/cilium/cilium/messages"
+	up "github.com/cilium-team/cilium/cilium/utils/profile"
+	upr "github.com/cilium-team/cilium/cilium/utils/profile/runnables"
+	upsi "github.com/cilium-team/cilium/cilium/utils/profile/subpolicies/intent"
+
+	d "github.com/cilium-team/cilium/Godeps/_workspace/src/github.com/fsouza/go-dockerclient"
+	k8s "github.com/cilium-team/cilium/Godeps/_workspace/src/k8s.io/kubernetes/pkg/api"
+)
+
+func newMetadataRunnable() upr.PolicyRunnable {
+	users := []up.User{{ID: 1, Name: "admin"}, {ID: 2, Name: "web-team"}}
+	policies := []up.PolicySource{
+		{
+			Owner: "admin",
+			Policies: []up.Policy{{
+				Name: "admin-labels",
+				IntentConfig: upsi.IntentConfig{Config: upsi.Intent{
+					AddLabels: map[string]string{"com.cilium.owner": "{{.Owner}}"},
+				}},
+			}},
+		},
+		{
+			Owner: "web-team",
+			Policies: []up.Policy{
+				{
+					Name: "web-low",
+					IntentConfig: upsi.IntentConfig{Priority: 2, Config: upsi.Intent{
+						AddLabels: map[string]string{"com.intent.service": "low"},
+					}},
+				},
+				{
+					Name: "web",
+					IntentConfig: upsi.IntentConfig{Priority: 1, Config: upsi.Intent{
+						AddLabels:      map[string]string{"com.cilium.owner": "{{.Owner}}", "com.intent.service": "{{.Name}}"},
+						AddAnnotations: map[string]string{"com.cilium.policy": "{{.Policy}}"},
+					}},
+				},
+			},
+		},
+	}
+	return IntentRunnable{}.GetRunnableFrom(users, policies)
+}
+
+func TestAddMetadataDocker(t *testing.T) {
+	runnable := newMetadataRunnable()
+	cc := &m.DockerCreateConfig{Name: "nginx", Config: &d.Config{Labels: map[string]string{"app": "web"}}}
+	if err := addMetadataDocker(runnable.(IntentRunnable).metadata, upsi.NewIntent(), cc); err != nil {
+		t.Fatalf("error while adding metadata: %s", err)
+	}
+	want := map[string]string{
+		"app":                "web",
+		"com.cilium.owner":   "admin",
+		"com.cilium.policy":  "web",
+		"com.intent.service": "nginx",
+	}
+	if !reflect.DeepEqual(cc.Labels, want) {
+		t.Errorf("invalid labels:\ngot  %v\nwant %v", cc.Labels, want)
+	}
+}
+
+func TestAddMetadataKubernetes(t *testing.T) {
+	runnable := newMetadataRunnable()
+	kor := &m.KubernetesObjRef{
+		ObjectReference: k8s.ObjectReference{Kind: "ReplicationController"},
+		BodyObj: map[string]interface{}{
+			"metadata": map[string]interface{}{"name": "nginx", "labels": map[string]interface{}{"app": "web"}},
+		},
+	}
+	if err := runnable.KubernetesExec(upr.PreHook, KubernetesMasterCreate, nil, kor); err != nil {
+		t.Fatalf("error while adding metadata: %s", err)
+	}
+	var rc k8s.ReplicationController
+	if err := convertMapTo(kor.BodyObj, &rc); err != nil {
+		t.Fatalf("error while converting replication controller: %s", err)
+	}
+	wantLabels := map[string]string{"app": "web", "com.cilium.owner": "admin", "com.intent.service": "nginx"}
+	wantAnnotations := map[string]string{"com.cilium.policy": "web"}
+	if !reflect.DeepEqual(rc.Labels, wantLabels) {
+		t.Errorf("invalid labels:\ngot  %v\nwant %v", rc.Labels, wantLabels)
+	}
+	if !reflect.DeepEqual(rc.Annotations, wantAnnotations) {
+		t.Errorf("invalid annotations:\ngot  %v\nwant %v", rc.Annotations, wantAnnotations)
+	}
+	if rc.Spec.Template == nil || rc.Spec.Template.Labels["com.intent.service"] != "nginx" {
+		t.Errorf("labels not added to the pods' template: %+v", rc.Spec.Template)
+	}
+}
@@ -0,0 +1,151 @@
+package intent
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	u "github.com/cilium-team/cilium/cilium/utils"
+	ucdb "github.com/cilium-team/cilium/cilium/utils/comm/db"
+	up "github.com/cilium-team/cilium/cilium/utils/profile"
+	upr "github.com/cilium-team/cilium/cilium/utils/profile/runnables"
+	upsi "github.com/cilium-team/cilium/cilium/utils/profile/subpolicies/intent"
+)
+
+// networkOf returns the name of the network referenced by intent, an empty
+// string if it doesn't reference any.
+func networkOf(intent *upsi.Intent) string {
+	if intent.NetConf.Network == nil {
+		return ""
+	}
+	return *intent.NetConf.Network
+}
+
+// resolveNetwork replaces the intent's CIDR, gateway, BD and namespace with
+// the ones of the network it references, which must allow any of the given
+// owners. Intents without a network can't use the BD or namespace of any
+// network.
+func resolveNetwork(conn ucdb.Db, owners []string, intent *upsi.Intent) error {
+	name := networkOf(intent)
+	if name == "" {
+		if intent.NetConf.BD == nil || intent.NetConf.Namespace == nil {
+			return nil
+		}
+		networks, err := conn.GetNetworks()
+		if err != nil {
+			return err
+		}
+		if network, ok := up.NetworkUsing(networks, *intent.NetConf.BD, *intent.NetConf.Namespace); ok {
+			return upr.Denial{Reason: fmt.Sprintf("bd %d or namespace %d belong to network %s, reference it by name",
+				*intent.NetConf.BD, *intent.NetConf.Namespace, network.Name)}
+		}
+		return nil
+	}
+	network, err := conn.GetNetwork(name)
+	if err != nil {
+		return err
+	}
+	if network.Name == "" {
+		return upr.Denial{Reason: fmt.Sprintf("network %s doesn't exist", name)}
+	}
+	allowed := false
+	for _, owner := range owners {
+		allowed = allowed || network.Allows(owner)
+	}
+	if !allowed {
+		return upr.Denial{Reason: fmt.Sprintf("network %s doesn't allow owners %v", name, owners)}
+	}
+	intent.NetConf.CIDR = &network.Subnets[0]
+	if network.Gateway != "" {
+		intent.NetConf.Gw = &network.Gateway
+	}
+	intent.NetConf.BD = &network.BD
+	intent.NetConf.Namespace = &network.Namespace
+	return nil
+}
+
+// resolveBridge sets the intent's bridge to the one its endpoints are attached
+// to, so its policy is installed there. Bridges not managed by cilium are
+// denied.
+func resolveBridge(intent *upsi.Intent) error {
+	bridge := u.BridgeOf(intent.NetConf)
+	if !u.IsManagedBridge(bridge) {
+		return upr.Denial{Reason: fmt.Sprintf("bridge %s isn't managed by cilium", bridge)}
+	}
+	intent.NetConf.Br = &bridge
+	return nil
+}
+
+// setupRouter installs the router of the intent's namespace, in the intent's
+// bridge, and returns the router MACs of an endpoint with the given IP. The
+// intent's gateway defaults to the router's gateway of the IP's subnet.
+func setupRouter(conn ucdb.Db, intent *upsi.Intent, ip net.IP) ([]string, error) {
+	if intent.NetConf.Namespace == nil {
+		return nil, nil
+	}
+	macs, gw, err := u.SetupRouter(conn, u.BridgeOf(intent.NetConf), *intent.NetConf.Namespace, ip)
+	if err != nil {
+		return nil, err
+	}
+	if (intent.NetConf.Gw == nil || *intent.NetConf.Gw == "") && gw.IP != "" {
+		intent.NetConf.Gw = &gw.IP
+	}
+	return macs, nil
+}
+
+// setupEgress routes, through the intent's gateway, the destinations of the
+// egress gateways of the intent's namespace. The default destination uses the
+// default route and the gateway defaults to DefaultRouterIP. Only the
+// endpoints of DefaultBridge reach the egress gateways.
+func setupEgress(conn ucdb.Db, intent *upsi.Intent) error {
+	if intent.NetConf.Namespace == nil || u.BridgeOf(intent.NetConf) != u.DefaultBridge {
+		return nil
+	}
+	egresses, err := u.EgressesOf(conn, *intent.NetConf.Namespace)
+	if err != nil || len(egresses) == 0 {
+		return err
+	}
+	if intent.NetConf.Gw == nil || *intent.NetConf.Gw == "" {
+		gw := up.DefaultRouterIP
+		intent.NetConf.Gw = &gw
+	}
+	routes := []string{}
+	if intent.NetConf.Route != nil && *intent.NetConf.Route != "" {
+		routes = append(routes, *intent.NetConf.Route)
+	}
+	for _, egress := range egresses {
+		for _, dest := range egress.Destinations {
+			if dest != up.DefaultEgressDestination {
+				routes = append(routes, dest+" via "+*intent.NetConf.Gw)
+			}
+		}
+	}
+	route := strings.Join(routes, ";")
+	intent.NetConf.Route = &route
+	return nil
+}
+
+// getIPFromNetConf gets an unused IP from the intent's network, trying its
+// subnets in order, or from its CIDR if it doesn't reference any.
+func getIPFromNetConf(conn ucdb.Db, intent *upsi.Intent) (net.IP, *net.IPNet, error) {
+	name := networkOf(intent)
+	if name == "" {
+		return getIPFrom(conn, *intent.NetConf.CIDR)
+	}
+	network, err := conn.GetNetwork(name)
+	if err != nil {
+		return nil, nil, err
+	}
+	err = fmt.Errorf("network %s doesn't exist", name)
+	for _, subnet := range network.Subnets {
+		var (
+			ip    net.IP
+			ipnet *net.IPNet
+		)
+		if ip, ipnet, err = getIPFrom(conn, subnet); err == nil {
+			return ip, ipnet, nil
+		}
+		log.Debug("Unable to get an IP from subnet %s of network %s: %s", subnet, name, err)
+	}
+	return nil, nil, err
+}
@@ -0,0 +1,153 @@
+package intent
+
+import (
+	"net"
+	"os"
+	"reflect"
+	"testing"
+
+	u "github.com/cilium-team/cilium/cilium/utils"
+	ucdb "github.com/cilium-team/cilium/cilium/utils/comm/db"
+	up "github.com/cilium-team/cilium/cilium/utils/profile"
+	upr "github.com/cilium-team/cilium/cilium/utils/profile/runnables"
+	upsi "github.com/cilium-team/cilium/cilium/utils/profile/subpolicies/intent"
+)
+
+func TestResolveNetwork(t *testing.T) {
+	conn := ucdb.NewMemConn()
+	network := up.Network{
+		Name:      "web",
+		Owner:     "foo",
+		Members:   []string{"bar"},
+		Subnets:   []string{"10.1.0.0/30", "10.2.0.0/24"},
+		Gateway:   "10.2.0.1",
+		Isolation: up.IsolationNetwork,
+		BD:        2,
+		Namespace: 3,
+	}
+	conn.PutNetwork(network)
+
+	intent := upsi.NewIntent()
+	*intent.NetConf.Network = "web"
+	if err := resolveNetwork(conn, []string{"bar"}, intent); err != nil {
+		t.Fatalf("error while resolving network: %s", err)
+	}
+	if *intent.NetConf.CIDR != "10.1.0.0/30" || *intent.NetConf.Gw != "10.2.0.1" ||
+		*intent.NetConf.BD != 2 || *intent.NetConf.Namespace != 3 {
+		t.Errorf("invalid net-conf:\ngot  %#v\nwant the CIDR, gateway, BD and namespace of %+v", intent.NetConf, network)
+	}
+
+	ips := []string{}
+	for i := 0; i < 3; i++ {
+		ip, _, err := getIPFromNetConf(conn, intent)
+		if err != nil {
+			t.Fatalf("error while getting IP %d: %s", i, err)
+		}
+		ips = append(ips, ip.String())
+	}
+	// 10.1.0.3 is the broadcast address of the first subnet but getIPFrom
+	// doesn't skip it.
+	if want := []string{"10.1.0.1", "10.1.0.2", "10.1.0.3"}; !reflect.DeepEqual(ips, want) {
+		t.Errorf("invalid IPs:\ngot  %v\nwant %v", ips, want)
+	}
+	if ip, _, err := getIPFromNetConf(conn, intent); err != nil {
+		t.Errorf("error while getting an IP from the second subnet: %s", err)
+	} else if !ip.Equal(net.ParseIP("10.2.0.1")) {
+		t.Errorf("invalid IP from the second subnet:\ngot  %s\nwant %s", ip, "10.2.0.1")
+	}
+
+	tests := []struct {
+		owners  []string
+		network string
+		bd      int
+		want    error
+	}{
+		{[]string{"baz"}, "web", 1, upr.Denial{Reason: "network web doesn't allow owners [baz]"}},
+		{[]string{"foo"}, "db", 1, upr.Denial{Reason: "network db doesn't exist"}},
+		{[]string{"baz"}, "", 2, upr.Denial{Reason: "bd 2 or namespace 1 belong to network web, reference it by name"}},
+		{[]string{"baz"}, "", 1, nil},
+	}
+	for _, tt := range tests {
+		intent := upsi.NewIntent()
+		*intent.NetConf.Network = tt.network
+		*intent.NetConf.BD = tt.bd
+		if err := resolveNetwork(conn, tt.owners, intent); err != tt.want {
+			t.Errorf("invalid error resolving network %q for %v:\ngot  %#v\nwant %#v", tt.network, tt.owners, err, tt.want)
+		}
+	}
+}
+
+func TestResolveBridge(t *testing.T) {
+	defer u.SetBridges(nil)
+	if err := u.SetBridges([]string{"tenant-a"}); err != nil {
+		t.Fatal(err)
+	}
+	intent := upsi.NewIntent()
+	if err := resolveBridge(intent); err != nil || *intent.NetConf.Br != u.DefaultBridge {
+		t.Errorf("invalid bridge of intent without br:\ngot  %s, %v\nwant %s", *intent.NetConf.Br, err, u.DefaultBridge)
+	}
+	*intent.NetConf.Br = "tenant-a"
+	if err := resolveBridge(intent); err != nil || *intent.NetConf.Br != "tenant-a" {
+		t.Errorf("invalid bridge:\ngot  %s, %v\nwant %s", *intent.NetConf.Br, err, "tenant-a")
+	}
+	*intent.NetConf.Br = "tenant-b"
+	want := upr.Denial{Reason: "bridge tenant-b isn't managed by cilium"}
+	if err := resolveBridge(intent); err != want {
+		t.Errorf("invalid error resolving unmanaged bridge:\ngot  %#v\nwant %#v", err, want)
+	}
+}
+
+func TestSetupRouter(t *testing.T) {
+	conn := ucdb.NewMemConn()
+	router := up.Router{Namespace: 2, Gateways: []up.Gateway{
+		{Subnet: "10.1.0.0/24", IP: "10.1.0.1", MAC: "aa:aa:aa:aa:aa:01"},
+	}}
+	if err := conn.PutRouter(router); err != nil {
+		t.Fatal(err)
+	}
+	// The router is installed by utils, which runs the script.
+	os.Setenv("ADD_ROUTER", "true")
+	intent := upsi.NewIntent()
+	*intent.NetConf.Namespace = 2
+	macs, err := setupRouter(conn, intent, net.ParseIP("10.1.0.5"))
+	if err != nil {
+		t.Fatalf("error while setting up router: %s", err)
+	}
+	if want := []string{"aa:aa:aa:aa:aa:01"}; !reflect.DeepEqual(macs, want) || *intent.NetConf.Gw != "10.1.0.1" {
+		t.Errorf("invalid router MACs and gateway:\ngot  %v, %s\nwant %v, %s", macs, *intent.NetConf.Gw, want, "10.1.0.1")
+	}
+
+	// Gateways set by the intent are kept.
+	*intent.NetConf.Gw = "10.1.0.254"
+	if _, err := setupRouter(conn, intent, net.ParseIP("10.1.0.5")); err != nil || *intent.NetConf.Gw != "10.1.0.254" {
+		t.Errorf("invalid gateway:\ngot  %s, %v\nwant %s", *intent.NetConf.Gw, err, "10.1.0.254")
+	}
+}
+
+func TestSetupEgress(t *testing.T) {
+	conn := ucdb.NewMemConn()
+	egress := up.Egress{Name: "ns-2", Namespace: 2, Subnets: []string{"10.1.0.0/24"},
+		Destinations: []string{up.DefaultEgressDestination, "192.168.50.0/24"}}
+	if err := conn.PutEgress(egress); err != nil {
+		t.Fatal(err)
+	}
+	intent := upsi.NewIntent()
+	*intent.NetConf.Namespace = 2
+	*intent.NetConf.Route = "10.9.0.0/16 via 10.1.0.254"
+	if err := setupEgress(conn, intent); err != nil {
+		t.Fatalf("error while setting up egress: %s", err)
+	}
+	if *intent.NetConf.Gw != up.DefaultRouterIP {
+		t.Errorf("invalid gateway:\ngot  %s\nwant %s", *intent.NetConf.Gw, up.DefaultRouterIP)
+	}
+	if want := "10.9.0.0/16 via 10.1.0.254;192.168.50.0/24 via " + up.DefaultRouterIP; *intent.NetConf.Route != want {
+		t.Errorf("invalid routes:\ngot  %s\nwant %s", *intent.NetConf.Route, want)
+	}
+
+	// Namespaces without egress gateways are left alone.
+	intent = upsi.NewIntent()
+	*intent.NetConf.Namespace = 3
+	if err := setupEgress(conn, intent); err != nil || *intent.NetConf.Gw != "" || *intent.NetConf.Route != "" {
+		t.Errorf("invalid gateway and routes:\ngot  %s, %s, %v\nwant none", *intent.NetConf.Gw, *intent.NetConf.Route, err)
+	}
+}
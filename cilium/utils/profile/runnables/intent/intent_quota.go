@@ -0,0 +1,187 @@
+package intent
+
+import (
+	"net"
+	"time"
+
+	m "github.com/cilium-team/cilium/cilium/messages"
+	ucdb "github.com/cilium-team/cilium/cilium/utils/comm/db"
+	up "github.com/cilium-team/cilium/cilium/utils/profile"
+	upr "github.com/cilium-team/cilium/cilium/utils/profile/runnables"
+	upsi "github.com/cilium-team/cilium/cilium/utils/profile/subpolicies/intent"
+)
+
+const (
+	// QuotaReservationLabel is the label with the quota usage reserved for a
+	// container.
+	QuotaReservationLabel = "com.cilium.quota-reservation"
+	// QuotaReservationTTL is the time a container has, since its quota usage
+	// was reserved, to start before the reservation is freed.
+	QuotaReservationTTL = 5 * time.Minute
+)
+
+// newContainerUsage returns the resources requested by the given container
+// config, without its IPs.
+func newContainerUsage(owners []string, containerConfig *m.DockerCreateConfig) up.ContainerUsage {
+	usage := up.ContainerUsage{Container: containerConfig.ID, Owners: owners}
+	if c := containerConfig.Config; c != nil {
+		usage.Labels, usage.CPUShares, usage.Memory = c.Labels, c.CPUShares, c.Memory
+	}
+	if hc := containerConfig.HostConfig; hc != nil {
+		if hc.CPUShares != 0 {
+			usage.CPUShares = hc.CPUShares
+		}
+		if hc.Memory != 0 {
+			usage.Memory = hc.Memory
+		}
+	}
+	return usage
+}
+
+// checkQuotasDocker denies the creation of a container that would exceed any
+// of the quotas selecting it, otherwise it reserves the resources the
+// container requests, cluster-wide, until it starts. The reservation is added
+// to the container's labels so it's released when the container starts. The
+// IP the container will have, if intent sets a net-conf, is counted as the
+// first IP of the net-conf's CIDR.
+func checkQuotasDocker(conn ucdb.Db, owners []string, intent *upsi.Intent, containerConfig *m.DockerCreateConfig) error {
+	quotas, err := conn.GetQuotas()
+	if err != nil {
+		return err
+	}
+	requested := newContainerUsage(owners, containerConfig)
+	if *intent.NetConf.CIDR != "" {
+		if ip, _, err := net.ParseCIDR(*intent.NetConf.CIDR); err == nil {
+			requested.IPs = up.IPs{ip}
+		}
+	}
+	selected := selectedQuotas(quotas, owners, requested.Labels)
+	if len(selected) == 0 || containerConfig.Config == nil {
+		return nil
+	}
+	reservation, err := newReservation()
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	if err := conn.UpdateQuotaReservations(func(reservations *up.QuotaReservations, usages []up.ContainerUsage) error {
+		reservations.Expire(now)
+		usages = append(usages, reservations.Usages()...)
+		if err := checkQuotas(containerConfig.Name, selected, usages, requested); err != nil {
+			return err
+		}
+		reservations.Reserve(reservation, requested, now.Add(QuotaReservationTTL))
+		return nil
+	}); err != nil {
+		return err
+	}
+	if containerConfig.Labels == nil {
+		containerConfig.Labels = map[string]string{}
+	}
+	containerConfig.Labels[QuotaReservationLabel] = reservation
+	log.Info("Reserved quota usage %s for container %s", reservation, containerConfig.Name)
+	return nil
+}
+
+// selectedQuotas returns the given quotas that select the containers of the
+// given owners with the given labels.
+func selectedQuotas(quotas []up.Quota, owners []string, labels map[string]string) []up.Quota {
+	selected := []up.Quota{}
+	for _, quota := range quotas {
+		if quota.Selects(owners, labels) {
+			selected = append(selected, quota)
+		}
+	}
+	return selected
+}
+
+// checkQuotas returns a Denial if adding the usage requested by the given
+// container to the given usages exceeds any of the given quotas.
+func checkQuotas(container string, quotas []up.Quota, usages []up.ContainerUsage, requested up.ContainerUsage) error {
+	for _, quota := range quotas {
+		usage := quota.Usage(usages).Add(quota.UsageOf(requested))
+		if reason := quota.Exceeded(usage); reason != "" {
+			log.Warning("Container %s exceeds quota %s: %s", container, quota.Name, reason)
+			return upr.Denial{Reason: reason}
+		}
+	}
+	return nil
+}
+
+// releaseQuotaDocker frees the quota usage reserved for a container whose
+// create was denied after the reservation.
+func releaseQuotaDocker(conn ucdb.Db, containerConfig *m.DockerCreateConfig) {
+	reservation, ok := containerConfig.Labels[QuotaReservationLabel]
+	if !ok {
+		return
+	}
+	if err := releaseQuotaReservation(conn, reservation); err != nil {
+		log.Warning("Unable to release quota usage %s: %s", reservation, err)
+	}
+	delete(containerConfig.Labels, QuotaReservationLabel)
+}
+
+// releaseQuotaReservation removes the given quota reservation.
+func releaseQuotaReservation(conn ucdb.Db, reservation string) error {
+	return conn.UpdateQuotaReservations(func(reservations *up.QuotaReservations, usages []up.ContainerUsage) error {
+		reservations.Release(reservation)
+		return nil
+	})
+}
+
+// saveContainerUsageDocker stores the resources used by a container that has
+// started, including the IPs of its endpoint, so they are counted for the
+// quotas of the following containers, and releases the quota usage reserved
+// for it on creation. A container started without a reservation, e.g. once it
+// was stopped and its usage removed, has its quotas checked again, along with
+// its reservation, and is denied if it exceeds any of them.
+func saveContainerUsageDocker(conn ucdb.Db, owners []string, intent *upsi.Intent, containerConfig *m.DockerCreateConfig) error {
+	usage := newContainerUsage(owners, containerConfig)
+	endpoint, err := conn.GetEndpoint(containerConfig.ID)
+	if err != nil {
+		return err
+	}
+	usage.IPs = endpoint.IPs
+	quotas, err := conn.GetQuotas()
+	if err != nil {
+		return err
+	}
+	selected := selectedQuotas(quotas, owners, usage.Labels)
+	reservation, reserved := containerConfig.Labels[QuotaReservationLabel]
+	if len(selected) == 0 && !reserved {
+		return conn.PutContainerUsage(usage)
+	}
+	if !reserved {
+		if reservation, err = newReservation(); err != nil {
+			return err
+		}
+	}
+	// The usage is reserved until it's stored so the containers started
+	// concurrently count each other.
+	now := time.Now()
+	if err := conn.UpdateQuotaReservations(func(reservations *up.QuotaReservations, usages []up.ContainerUsage) error {
+		reservations.Expire(now)
+		if !reservations.Release(reservation) {
+			others := []up.ContainerUsage{}
+			for _, other := range append(usages, reservations.Usages()...) {
+				if other.Container != usage.Container {
+					others = append(others, other)
+				}
+			}
+			if err := checkQuotas(containerConfig.ID, selected, others, usage); err != nil {
+				return err
+			}
+		}
+		reservations.Reserve(reservation, usage, now.Add(QuotaReservationTTL))
+		return nil
+	}); err != nil {
+		return err
+	}
+	// The usage is stored before the reservation is released so the
+	// container is never left out of the quotas.
+	if err := conn.PutContainerUsage(usage); err != nil {
+		releaseQuotaReservation(conn, reservation)
+		return err
+	}
+	return releaseQuotaReservation(conn, reservation)
+}
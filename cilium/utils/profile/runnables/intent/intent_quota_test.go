@@ -0,0 +1,161 @@
+package intent
+
+import (
+	"net"
+	"sync"
+	"testing"
+
+	m "github.com/cilium-team/cilium/cilium/messages"
+	ucdb "github.com/cilium-team/cilium/cilium/utils/comm/db"
+	up "github.com/cilium-team/cilium/cilium/utils/profile"
+	upr "github.com/cilium-team/cilium/cilium/utils/profile/runnables"
+	upsi "github.com/cilium-team/cilium/cilium/utils/profile/subpolicies/intent"
+
+	d "github.com/cilium-team/cilium/Godeps/_workspace/src/github.com/fsouza/go-dockerclient"
+)
+
+func newQuotaContainerConfig(id string, memory int64) *m.DockerCreateConfig {
+	return &m.DockerCreateConfig{
+		ID:         id,
+		Config:     &d.Config{Labels: map[string]string{"app": "web"}},
+		HostConfig: &d.HostConfig{Memory: memory},
+	}
+}
+
+func TestCheckQuotasDocker(t *testing.T) {
+	conn := ucdb.NewMemConn()
+	conn.PutQuota(up.Quota{Name: "foo-memory", Owner: "foo", MaxMemory: 1000})
+	conn.PutQuota(up.Quota{Name: "web-ips", Labels: map[string]string{"app": "^web$"}, MaxIPs: 1, Pool: "10.0.0.0/24"})
+	intent := upsi.NewIntent()
+	owners := []string{"foo"}
+
+	cc := newQuotaContainerConfig("", 600)
+	if err := checkQuotasDocker(conn, owners, intent, cc); err != nil {
+		t.Errorf("container within quota was denied: %s", err)
+	}
+	cc.ID = "1"
+	conn.PutEndpoint(up.Endpoint{Container: "1", IPs: up.IPs{net.ParseIP("10.0.0.1")}})
+	if err := saveContainerUsageDocker(conn, owners, intent, cc); err != nil {
+		t.Fatalf("error while saving container usage: %s", err)
+	}
+
+	err := checkQuotasDocker(conn, owners, intent, newQuotaContainerConfig("", 600))
+	want := upr.Denial{Reason: "quota foo-memory allows 1000 bytes of memory, 1200 requested"}
+	if err != want {
+		t.Errorf("invalid error:\ngot  %#v\nwant %#v", err, want)
+	}
+	if err := checkQuotasDocker(conn, []string{"bar"}, intent, newQuotaContainerConfig("", 600)); err != nil {
+		t.Errorf("container of another owner was denied: %s", err)
+	}
+
+	*intent.NetConf.CIDR = "10.0.0.0/24"
+	err = checkQuotasDocker(conn, []string{"bar"}, intent, newQuotaContainerConfig("", 0))
+	want = upr.Denial{Reason: "quota web-ips allows 1 IPs, 2 requested"}
+	if err != want {
+		t.Errorf("invalid error:\ngot  %#v\nwant %#v", err, want)
+	}
+
+	conn.DeleteContainerUsage("1")
+	if err := checkQuotasDocker(conn, owners, intent, newQuotaContainerConfig("", 600)); err != nil {
+		t.Errorf("container within quota was denied after releasing usage: %s", err)
+	}
+}
+
+func TestCheckQuotasDockerRelease(t *testing.T) {
+	conn := ucdb.NewMemConn()
+	conn.PutQuota(up.Quota{Name: "foo-containers", Owner: "foo", MaxContainers: 1})
+	intent := upsi.NewIntent()
+	owners := []string{"foo"}
+
+	cc := newQuotaContainerConfig("", 0)
+	if err := checkQuotasDocker(conn, owners, intent, cc); err != nil {
+		t.Fatalf("container within quota was denied: %s", err)
+	}
+	if _, ok := cc.Labels[QuotaReservationLabel]; !ok {
+		t.Fatalf("container without quota reservation label: %+v", cc.Labels)
+	}
+	want := upr.Denial{Reason: "quota foo-containers allows 1 containers, 2 requested"}
+	if err := checkQuotasDocker(conn, owners, intent, newQuotaContainerConfig("", 0)); err != want {
+		t.Errorf("invalid error:\ngot  %#v\nwant %#v", err, want)
+	}
+
+	releaseQuotaDocker(conn, cc)
+	if _, ok := cc.Labels[QuotaReservationLabel]; ok {
+		t.Errorf("quota reservation label wasn't removed: %+v", cc.Labels)
+	}
+	if err := checkQuotasDocker(conn, owners, intent, newQuotaContainerConfig("", 0)); err != nil {
+		t.Errorf("container within quota was denied after releasing reservation: %s", err)
+	}
+}
+
+func TestSaveContainerUsageDockerRestart(t *testing.T) {
+	conn := ucdb.NewMemConn()
+	conn.PutQuota(up.Quota{Name: "foo-containers", Owner: "foo", MaxContainers: 1})
+	intent := upsi.NewIntent()
+	owners := []string{"foo"}
+
+	start := func(id string) (*m.DockerCreateConfig, error) {
+		cc := newQuotaContainerConfig("", 0)
+		if err := checkQuotasDocker(conn, owners, intent, cc); err != nil {
+			return cc, err
+		}
+		cc.ID = id
+		conn.PutEndpoint(up.Endpoint{Container: id})
+		return cc, saveContainerUsageDocker(conn, owners, intent, cc)
+	}
+	first, err := start("1")
+	if err != nil {
+		t.Fatalf("error while starting container: %s", err)
+	}
+	// Starting a running container again doesn't count it twice.
+	if err := saveContainerUsageDocker(conn, owners, intent, first); err != nil {
+		t.Errorf("running container was denied: %s", err)
+	}
+
+	// Once stopped, its usage can be taken by another container.
+	conn.DeleteContainerUsage("1")
+	if _, err := start("2"); err != nil {
+		t.Fatalf("error while starting container: %s", err)
+	}
+	want := upr.Denial{Reason: "quota foo-containers allows 1 containers, 2 requested"}
+	if err := saveContainerUsageDocker(conn, owners, intent, first); err != want {
+		t.Errorf("invalid error:\ngot  %#v\nwant %#v", err, want)
+	}
+	usages, err := conn.GetContainerUsages()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(usages) != 1 || usages[0].Container != "2" {
+		t.Errorf("invalid container usages:\ngot  %+v\nwant the usage of container 2", usages)
+	}
+}
+
+func TestCheckQuotasDockerConcurrent(t *testing.T) {
+	conn := ucdb.NewMemConn()
+	conn.PutQuota(up.Quota{Name: "foo-containers", Owner: "foo", MaxContainers: 3})
+	intent := upsi.NewIntent()
+	owners := []string{"foo"}
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 20)
+	for i := 0; i < cap(errs); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs <- checkQuotasDocker(conn, owners, intent, newQuotaContainerConfig("", 0))
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	created := 0
+	for err := range errs {
+		if err == nil {
+			created++
+		} else if _, ok := err.(upr.Denial); !ok {
+			t.Errorf("unexpected error: %s", err)
+		}
+	}
+	if created != 3 {
+		t.Errorf("invalid number of containers created:\ngot  %d\nwant %d", created, 3)
+	}
+}
@@ -24,15 +24,15 @@ var (
 	log = logging.MustGetLogger("cilium")
 
 	preHookHandlers = map[string]string{
-		`/docker/daemon/cilium-adapter/.*/containers/create(\?.*)?`:                            DockerDaemonCreate,
-		`/docker/swarm/cilium-adapter/.*/containers/create(\?.*)?`:                             DockerSwarmCreate,
+		`/docker/daemon/cilium-adapter(/v[^/]*)?/containers/create(\?.*)?`:                     DockerDaemonCreate,
+		`/docker/swarm/cilium-adapter(/v[^/]*)?/containers/create(\?.*)?`:                      DockerSwarmCreate,
 		`/kubernetes/master/cilium-adapter/api/v1/namespaces/.*/pods(\?.*)?`:                   KubernetesMasterCreate,
 		`/kubernetes/master/cilium-adapter/api/v1/namespaces/.*/replicationcontrollers(\?.*)?`: KubernetesMasterCreate,
 		`/kubernetes/master/cilium-adapter/api/v1/namespaces/.*/service(\?.*)?`:                KubernetesMasterCreate,
 	}
 	postHookHandlers = map[string]string{
-		`/docker/daemon/cilium-adapter/.*/containers/.*/start(\?.*)?`:   DockerDaemonStart,
-		`/docker/daemon/cilium-adapter/.*/containers/.*/restart(\?.*)?`: DockerDaemonRestart,
+		`/docker/daemon/cilium-adapter(/v[^/]*)?/containers/.*/start(\?.*)?`:   DockerDaemonStart,
+		`/docker/daemon/cilium-adapter(/v[^/]*)?/containers/.*/restart(\?.*)?`: DockerDaemonRestart,
 	}
 
 	dockerHookHandlers = map[string]func(ucdb.Db, *upsi.Intent, *m.DockerCreateConfig) error{
@@ -41,6 +41,12 @@ var (
 		upr.PostHook + DockerDaemonStart:   postHookDockerDaemonStart,
 		upr.PostHook + DockerDaemonRestart: postHookDockerDaemonStart,
 	}
+	dockerQuotaHandlers = map[string]func(ucdb.Db, []string, *upsi.Intent, *m.DockerCreateConfig) error{
+		upr.PreHook + DockerDaemonCreate:   checkQuotasDocker,
+		upr.PreHook + DockerSwarmCreate:    checkQuotasDocker,
+		upr.PostHook + DockerDaemonStart:   saveContainerUsageDocker,
+		upr.PostHook + DockerDaemonRestart: saveContainerUsageDocker,
+	}
 	kubernetesHookHandlers = map[string]func(ucdb.Db, *upsi.Intent, *m.KubernetesObjRef) error{
 		upr.PreHook + KubernetesMasterCreate: preHookKubernetesMasterCreate,
 	}
@@ -48,6 +54,12 @@ var (
 
 type IntentRunnable struct {
 	intent *upsi.Intent
+	// owners are the names of the users with policies covering the request,
+	// used to select the quotas it counts for.
+	owners []string
+	// metadata are the labels and annotations added by the policies
+	// covering the request.
+	metadata metadataRefs
 }
 
 func (ir IntentRunnable) GetHandlers(typ string) map[string]string {
@@ -62,13 +74,51 @@ func (ir IntentRunnable) GetHandlers(typ string) map[string]string {
 }
 
 func (ir IntentRunnable) DockerExec(hookType, reqType string, db ucdb.Db, cc *m.DockerCreateConfig) error {
+	if err := resolveNetwork(db, ir.owners, ir.intent); err != nil {
+		return err
+	}
+	if err := resolveBridge(ir.intent); err != nil {
+		return err
+	}
+	if hookType == upr.PostHook && (reqType == DockerDaemonStart || reqType == DockerDaemonRestart) {
+		if err := acquireIdentityDocker(db, ir.owners, ir.intent, cc); err != nil {
+			return err
+		}
+	}
+	if hookType == upr.PreHook && (reqType == DockerDaemonCreate || reqType == DockerSwarmCreate) {
+		if err := addMetadataDocker(ir.metadata, ir.intent, cc); err != nil {
+			return err
+		}
+	}
+	quota, hasQuota := dockerQuotaHandlers[hookType+reqType]
+	// Quotas are checked before a max-scale slot is reserved for the
+	// container and its usage is saved once its endpoint exists.
+	if hasQuota && hookType == upr.PreHook {
+		if err := quota(db, ir.owners, ir.intent, cc); err != nil {
+			return err
+		}
+	}
 	if f, ok := dockerHookHandlers[hookType+reqType]; ok {
-		return f(db, ir.intent, cc)
+		if err := f(db, ir.intent, cc); err != nil {
+			releaseIdentityDocker(db, ir.intent, cc)
+			if hasQuota && hookType == upr.PreHook {
+				releaseQuotaDocker(db, cc)
+			}
+			return err
+		}
+	}
+	if hasQuota && hookType == upr.PostHook {
+		return quota(db, ir.owners, ir.intent, cc)
 	}
 	return nil
 }
 
 func (ir IntentRunnable) KubernetesExec(hookType, reqType string, db ucdb.Db, cc *m.KubernetesObjRef) error {
+	if hookType == upr.PreHook && reqType == KubernetesMasterCreate {
+		if err := addMetadataKubernetes(ir.metadata, ir.intent, cc); err != nil {
+			return err
+		}
+	}
 	if f, ok := kubernetesHookHandlers[hookType+reqType]; ok {
 		return f(db, ir.intent, cc)
 	}
@@ -81,6 +131,8 @@ func (ir IntentRunnable) GetRunnableFrom(users []up.User, policies []up.PolicySo
 	lastUserIntentCfgCovered := upsi.NewIntentConfig()
 	usersIntentCfg := upsi.NewIntentConfig()
 	usersIntentCfg.Config = upsi.Intent{}
+	owners := []string{}
+	metadata := newMetadataRefs()
 	up.OrderUsersByDescendingID(users)
 	for _, user := range users {
 		log.Debug("user %+v", user)
@@ -88,6 +140,8 @@ func (ir IntentRunnable) GetRunnableFrom(users []up.User, policies []up.PolicySo
 		if len(userPolicies) == 0 {
 			continue
 		}
+		owners = append(owners, user.Name)
+		metadata.addFrom(user, userPolicies)
 		intentConfigs := up.GetIntentConfigs(userPolicies)
 		upsi.OrderIntentConfigsByAscendingPriority(intentConfigs)
 		for i, iConfig := range intentConfigs {
@@ -125,5 +179,5 @@ func (ir IntentRunnable) GetRunnableFrom(users []up.User, policies []up.PolicySo
 	finalIntentCfg := upsi.NewIntentConfig()
 	finalIntentCfg.MergeWithOverwrite(*usersIntentCfg)
 	log.Info("Final intent loaded: %#v", finalIntentCfg.Config)
-	return IntentRunnable{intent: &finalIntentCfg.Config}
+	return IntentRunnable{intent: &finalIntentCfg.Config, owners: owners, metadata: metadata}
 }
@@ -10,15 +10,27 @@ import (
 	upsi "github.com/cilium-team/cilium/cilium/utils/profile/subpolicies/intent"
 )
 
-func forceNetworkRules(intent *upsi.Intent) error {
+func forceNetworkRules(dbConn ucdb.Db, intent *upsi.Intent) error {
 	log.Debug("intent %#v\n", intent)
+	ovsConfig := intent.NetPolicy.OVSConfig
+	if ovsConfig.Rules != nil && hasGroupSelectors(*ovsConfig.Rules) {
+		identities, err := dbConn.GetIdentities()
+		if err != nil {
+			return err
+		}
+		rules, err := expandGroupSelectors(identities, *ovsConfig.Rules)
+		if err != nil {
+			return err
+		}
+		ovsConfig.Rules = &rules
+	}
 	//Install OVS Rules
-	return forceOVSRules(*intent.NetConf.Br, intent.NetPolicy.OVSConfig)
+	return forceOVSRules(*intent.NetConf.Br, ovsConfig)
 }
 
 func forceOVSRules(bridge string, ovsConfig upsi.OVSConfig) error {
 	log.Debug("bridge %+v ovsConfig %+v\n", bridge, ovsConfig)
-	if bridge == "" {
+	if bridge == "" || ovsConfig.Rules == nil {
 		return nil
 	}
 	for _, rule := range *ovsConfig.Rules {
@@ -0,0 +1,36 @@
+package runnables
+
+import (
+	"sort"
+
+	up "github.com/cilium-team/cilium/cilium/utils/profile"
+)
+
+// UserPolicies returns the policies of the given user ordered by ascending
+// priority, as returned by priorityOf. Policies with the same priority keep
+// their declaration order.
+func UserPolicies(policies []up.PolicySource, user up.User, priorityOf func(up.Policy) int) []up.Policy {
+	userPolicies := []up.Policy{}
+	for _, policySource := range up.FilterPoliciesByUser(policies, user) {
+		userPolicies = append(userPolicies, policySource.Policies...)
+	}
+	sort.Stable(policiesByPriority{policies: userPolicies, priorityOf: priorityOf})
+	return userPolicies
+}
+
+type policiesByPriority struct {
+	policies   []up.Policy
+	priorityOf func(up.Policy) int
+}
+
+func (p policiesByPriority) Len() int {
+	return len(p.policies)
+}
+
+func (p policiesByPriority) Swap(i, j int) {
+	p.policies[i], p.policies[j] = p.policies[j], p.policies[i]
+}
+
+func (p policiesByPriority) Less(i, j int) bool {
+	return p.priorityOf(p.policies[i]) < p.priorityOf(p.policies[j])
+}
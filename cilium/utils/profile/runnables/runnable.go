@@ -8,31 +8,71 @@ import (
 	up "github.com/cilium-team/cilium/cilium/utils/profile"
 )
 
-type Runnables map[string]PolicyRunnable
+// Stage is the step of the request processing where a runnable is executed.
+// Runnables are executed by ascending stage.
+type Stage int
+
+const (
+	// MergeStage runnables merge and patch the request's configurations.
+	MergeStage Stage = iota
+	// WebhookStage runnables send the merged request to external services
+	// that may patch it as well.
+	WebhookStage
+	// ConstraintsStage runnables check the final, merged and patched,
+	// request.
+	ConstraintsStage
+	// SecretsStage runnables set the secrets' values, they are never seen by
+	// the runnables of the previous stages.
+	SecretsStage
+	// IntentStage runnables perform actions based on all merged
+	// configurations and policies.
+	IntentStage
+)
+
+// Runnables are the registered runnables in the order they are executed.
+type Runnables []PolicyRunnable
 
 const (
 	PreHook  = "pre-hook"
 	PostHook = "post-hook"
 )
 
+type registered struct {
+	name     string
+	stage    Stage
+	runnable PolicyRunnable
+}
+
 var (
-	runnables Runnables
+	runnables []registered
 )
 
-func init() {
-	runnables = make(Runnables)
-}
-
-func Register(name string, policyRun PolicyRunnable) error {
-	if _, ok := runnables[name]; ok {
-		return fmt.Errorf("\"%s\" is already registered, please use a different name", name)
+// Register registers the given runnable to be executed at the given stage,
+// after the runnables of the same stage registered before.
+func Register(name string, stage Stage, policyRun PolicyRunnable) error {
+	i := len(runnables)
+	for j, r := range runnables {
+		if r.name == name {
+			return fmt.Errorf("\"%s\" is already registered, please use a different name", name)
+		}
+		if r.stage > stage && j < i {
+			i = j
+		}
 	}
-	runnables[name] = policyRun
+	runnables = append(runnables, registered{})
+	copy(runnables[i+1:], runnables[i:])
+	runnables[i] = registered{name: name, stage: stage, runnable: policyRun}
 	return nil
 }
 
+// GetRunnables returns the registered runnables in the order they must be
+// executed.
 func GetRunnables() Runnables {
-	return runnables
+	rs := Runnables{}
+	for _, r := range runnables {
+		rs = append(rs, r.runnable)
+	}
+	return rs
 }
 
 type PolicyRunnable interface {
@@ -0,0 +1,131 @@
+// Package secrets implements a runnable that sets, on docker create requests,
+// the environment variables of the secret-env of the policies covering them
+// to the values of the referenced secrets.
+package secrets
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	m "github.com/cilium-team/cilium/cilium/messages"
+	ucdb "github.com/cilium-team/cilium/cilium/utils/comm/db"
+	up "github.com/cilium-team/cilium/cilium/utils/profile"
+	upr "github.com/cilium-team/cilium/cilium/utils/profile/runnables"
+	us "github.com/cilium-team/cilium/cilium/utils/secrets"
+
+	"github.com/cilium-team/cilium/Godeps/_workspace/src/github.com/op/go-logging"
+)
+
+const (
+	Name = "secrets-runnable"
+
+	DockerSwarmCreate  = "DockerSwarmCreate"
+	DockerDaemonCreate = "DockerDaemonCreate"
+)
+
+var (
+	log = logging.MustGetLogger("cilium")
+
+	preHookHandlers = map[string]string{
+		`/docker/daemon/cilium-adapter(/v[^/]*)?/containers/create(\?.*)?`: DockerDaemonCreate,
+		`/docker/swarm/cilium-adapter(/v[^/]*)?/containers/create(\?.*)?`:  DockerSwarmCreate,
+	}
+)
+
+// secretRef is a reference, from a policy, to a secret.
+type secretRef struct {
+	secret string
+	policy string
+	owner  string
+}
+
+type SecretsRunnable struct {
+	// env maps environment variables to the secrets they are set to.
+	env map[string]secretRef
+}
+
+func (sr SecretsRunnable) GetHandlers(typ string) map[string]string {
+	switch typ {
+	case upr.PreHook:
+		return preHookHandlers
+	default:
+		return nil
+	}
+}
+
+// GetRunnableFrom returns a SecretsRunnable with the secret-env of all given
+// policies. Like the rest of the docker config, variables set by users with a
+// lower ID, and by policies with higher priority, take precedence.
+func (sr SecretsRunnable) GetRunnableFrom(users []up.User, policies []up.PolicySource) upr.PolicyRunnable {
+	log.Debug("users %+v", users)
+	env := map[string]secretRef{}
+	up.OrderUsersByDescendingID(users)
+	for _, user := range users {
+		userPolicies := upr.UserPolicies(policies, user, func(policy up.Policy) int {
+			return policy.DockerConfig.Priority
+		})
+		for _, policy := range userPolicies {
+			for name, secret := range policy.DockerConfig.SecretEnv {
+				env[name] = secretRef{secret: secret, policy: policy.Name, owner: user.Name}
+			}
+		}
+	}
+	log.Debug("secret env %+v", env)
+	return SecretsRunnable{env: env}
+}
+
+func (sr SecretsRunnable) DockerExec(hookType, reqType string, db ucdb.Db, cc *m.DockerCreateConfig) error {
+	if hookType != upr.PreHook || (reqType != DockerDaemonCreate && reqType != DockerSwarmCreate) {
+		return nil
+	}
+	names := []string{}
+	for name := range sr.env {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		ref := sr.env[name]
+		value, err := resolve(db, ref)
+		if err != nil {
+			return err
+		}
+		cc.Env = setEnv(cc.Env, name, value)
+		log.Info("Set %s of container %s to secret %s", name, cc.Name, ref.secret)
+	}
+	return nil
+}
+
+func (sr SecretsRunnable) KubernetesExec(hookType, reqType string, db ucdb.Db, cc *m.KubernetesObjRef) error {
+	return nil
+}
+
+// resolve returns the value of the referenced secret if the owner of the
+// policy referencing it is allowed to read it.
+func resolve(db ucdb.Db, ref secretRef) (string, error) {
+	secret, err := db.GetSecret(ref.secret)
+	if err != nil {
+		return "", err
+	}
+	if secret.Name == "" {
+		return "", upr.Denial{Policy: ref.policy, Owner: ref.owner,
+			Reason: fmt.Sprintf("secret %s doesn't exist", ref.secret)}
+	}
+	if !secret.Allows(ref.owner) {
+		log.Warning("Policy %s of owner %s references secret %s that it can't read", ref.policy, ref.owner, ref.secret)
+		return "", upr.Denial{Policy: ref.policy, Owner: ref.owner,
+			Reason: fmt.Sprintf("owner %s can't read secret %s", ref.owner, ref.secret)}
+	}
+	return us.Decrypt(secret)
+}
+
+// setEnv returns env with the given variable set to value.
+func setEnv(env []string, name, value string) []string {
+	for i, v := range env {
+		if strings.HasPrefix(v, name+"=") || v == name {
+			env[i] = name + "=" + value
+			return env
+		}
+	}
+	return append(env, name+"="+value)
+}
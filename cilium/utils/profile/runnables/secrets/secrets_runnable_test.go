@@ -0,0 +1,85 @@
+package secrets
+
+import (
+	"reflect"
+	"testing"
+
+	m "github.com/cilium-team/cilium/cilium/messages"
+	ucdb "github.com/cilium-team/cilium/cilium/utils/comm/db"
+	up "github.com/cilium-team/cilium/cilium/utils/profile"
+	upr "github.com/cilium-team/cilium/cilium/utils/profile/runnables"
+	upsd "github.com/cilium-team/cilium/cilium/utils/profile/subpolicies/docker"
+	us "github.com/cilium-team/cilium/cilium/utils/secrets"
+
+	d "github.com/cilium-team/cilium/Godeps/_workspace/src/github.com/fsouza/go-dockerclient"
+)
+
+func newSecretsConn(t *testing.T) ucdb.Db {
+	if err := us.SetKey("000102030405060708090a0b0c0d0e0f101112131415161718191a1b1c1d1e1f"); err != nil {
+		t.Fatalf("error while setting secrets key: %s", err)
+	}
+	conn := ucdb.NewMemConn()
+	for _, secret := range []up.Secret{
+		{Name: "db/prod/password", Owner: "db-team", Readers: []string{"web-team"}, Plaintext: "s3cr3t"},
+		{Name: "admin/token", Owner: "admin", Plaintext: "t0k3n"},
+	} {
+		secret, err := us.Encrypt(secret)
+		if err != nil {
+			t.Fatalf("error while encrypting secret: %s", err)
+		}
+		conn.PutSecret(secret)
+	}
+	return conn
+}
+
+func newRunnable(secretEnv map[string]string) upr.PolicyRunnable {
+	users := []up.User{{ID: 1, Name: "admin"}, {ID: 2, Name: "web-team"}}
+	policies := []up.PolicySource{
+		{
+			Owner: "web-team",
+			Policies: []up.Policy{{
+				Name:         "web",
+				DockerConfig: upsd.DockerConfig{SecretEnv: secretEnv},
+			}},
+		},
+	}
+	return SecretsRunnable{}.GetRunnableFrom(users, policies)
+}
+
+func TestDockerExec(t *testing.T) {
+	conn := newSecretsConn(t)
+	runnable := newRunnable(map[string]string{"DB_PASSWORD": "db/prod/password"})
+	cc := &m.DockerCreateConfig{Config: &d.Config{Env: []string{"DB_PASSWORD=plain", "DB_USER=web"}}}
+	if err := runnable.DockerExec(upr.PreHook, DockerDaemonCreate, conn, cc); err != nil {
+		t.Fatalf("error while setting secrets: %s", err)
+	}
+	if want := []string{"DB_PASSWORD=s3cr3t", "DB_USER=web"}; !reflect.DeepEqual(cc.Env, want) {
+		t.Errorf("invalid env:\ngot  %v\nwant %v", cc.Env, want)
+	}
+	if got := us.RedactString("DB_PASSWORD=s3cr3t"); got != "DB_PASSWORD="+us.Redacted {
+		t.Errorf("secret value not redacted: %s", got)
+	}
+}
+
+func TestDockerExecDenied(t *testing.T) {
+	conn := newSecretsConn(t)
+	tests := []struct {
+		secret string
+		reason string
+	}{
+		{"admin/token", "owner web-team can't read secret admin/token"},
+		{"db/dev/password", "secret db/dev/password doesn't exist"},
+	}
+	for _, tt := range tests {
+		runnable := newRunnable(map[string]string{"TOKEN": tt.secret})
+		cc := &m.DockerCreateConfig{Config: &d.Config{}}
+		err := runnable.DockerExec(upr.PreHook, DockerSwarmCreate, conn, cc)
+		want := upr.Denial{Policy: "web", Owner: "web-team", Reason: tt.reason}
+		if err != want {
+			t.Errorf("invalid error:\ngot  %#v\nwant %#v", err, want)
+		}
+		if len(cc.Env) != 0 {
+			t.Errorf("env set on denied request: %v", cc.Env)
+		}
+	}
+}
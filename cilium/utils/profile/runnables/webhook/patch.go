@@ -0,0 +1,243 @@
+package webhook
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// PatchOperation is a JSON patch (RFC 6902) operation.
+type PatchOperation struct {
+	Op    string          `json:"op"`
+	Path  string          `json:"path"`
+	From  string          `json:"from,omitempty"`
+	Value json.RawMessage `json:"value,omitempty"`
+}
+
+// decodeJSON decodes the given json document keeping numbers as they were
+// encoded, so big integers aren't converted to floats.
+func decodeJSON(data []byte) (interface{}, error) {
+	var v interface{}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	if err := dec.Decode(&v); err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+// parsePointer returns the reference tokens of the given JSON pointer
+// (RFC 6901).
+func parsePointer(pointer string) ([]string, error) {
+	if pointer == "" {
+		return []string{}, nil
+	}
+	if !strings.HasPrefix(pointer, "/") {
+		return nil, fmt.Errorf("invalid json pointer %q", pointer)
+	}
+	tokens := strings.Split(pointer[1:], "/")
+	for i, token := range tokens {
+		tokens[i] = strings.Replace(strings.Replace(token, "~1", "/", -1), "~0", "~", -1)
+	}
+	return tokens, nil
+}
+
+// arrayIndex returns the index referenced by the given token in an array of
+// the given length. If end is true, "-" and length are valid indexes.
+func arrayIndex(token string, length int, end bool) (int, error) {
+	if end && token == "-" {
+		return length, nil
+	}
+	i, err := strconv.Atoi(token)
+	if err != nil || i < 0 || i > length || (i == length && !end) {
+		return 0, fmt.Errorf("invalid array index %q", token)
+	}
+	return i, nil
+}
+
+// get returns the value referenced by the given tokens.
+func get(node interface{}, tokens []string) (interface{}, error) {
+	for _, token := range tokens {
+		switch n := node.(type) {
+		case map[string]interface{}:
+			child, ok := n[token]
+			if !ok {
+				return nil, fmt.Errorf("member %q not found", token)
+			}
+			node = child
+		case []interface{}:
+			i, err := arrayIndex(token, len(n), false)
+			if err != nil {
+				return nil, err
+			}
+			node = n[i]
+		default:
+			return nil, fmt.Errorf("can't reference %q in a scalar value", token)
+		}
+	}
+	return node, nil
+}
+
+// update calls f with the container referenced by all but the last of the
+// given tokens and the last token, and replaces that container with the one
+// returned by f. Returns the updated node.
+func update(node interface{}, tokens []string, f func(container interface{}, token string) (interface{}, error)) (interface{}, error) {
+	if len(tokens) == 1 {
+		return f(node, tokens[0])
+	}
+	child, err := get(node, tokens[:1])
+	if err != nil {
+		return nil, err
+	}
+	newChild, err := update(child, tokens[1:], f)
+	if err != nil {
+		return nil, err
+	}
+	switch n := node.(type) {
+	case map[string]interface{}:
+		n[tokens[0]] = newChild
+	case []interface{}:
+		i, _ := arrayIndex(tokens[0], len(n), false)
+		n[i] = newChild
+	}
+	return node, nil
+}
+
+// add adds value to the given container at the given token.
+func add(value interface{}) func(interface{}, string) (interface{}, error) {
+	return func(container interface{}, token string) (interface{}, error) {
+		switch c := container.(type) {
+		case map[string]interface{}:
+			c[token] = value
+			return c, nil
+		case []interface{}:
+			i, err := arrayIndex(token, len(c), true)
+			if err != nil {
+				return nil, err
+			}
+			c = append(c, nil)
+			copy(c[i+1:], c[i:])
+			c[i] = value
+			return c, nil
+		default:
+			return nil, fmt.Errorf("can't add %q to a scalar value", token)
+		}
+	}
+}
+
+// remove removes the value of the given container at the given token.
+func remove(container interface{}, token string) (interface{}, error) {
+	switch c := container.(type) {
+	case map[string]interface{}:
+		if _, ok := c[token]; !ok {
+			return nil, fmt.Errorf("member %q not found", token)
+		}
+		delete(c, token)
+		return c, nil
+	case []interface{}:
+		i, err := arrayIndex(token, len(c), false)
+		if err != nil {
+			return nil, err
+		}
+		return append(c[:i], c[i+1:]...), nil
+	default:
+		return nil, fmt.Errorf("can't remove %q from a scalar value", token)
+	}
+}
+
+// applyOperation applies the given operation to doc and returns the modified
+// doc.
+func applyOperation(doc interface{}, op PatchOperation) (interface{}, error) {
+	path, err := parsePointer(op.Path)
+	if err != nil {
+		return nil, err
+	}
+	var value interface{}
+	switch op.Op {
+	case "add", "replace", "test":
+		if len(op.Value) == 0 {
+			return nil, fmt.Errorf("missing value")
+		}
+		if value, err = decodeJSON(op.Value); err != nil {
+			return nil, err
+		}
+	case "move", "copy":
+		from, err := parsePointer(op.From)
+		if err != nil {
+			return nil, err
+		}
+		if value, err = get(doc, from); err != nil {
+			return nil, err
+		}
+		if op.Op == "move" {
+			if len(from) == 0 {
+				return nil, fmt.Errorf("can't move the whole document")
+			}
+			if doc, err = update(doc, from, remove); err != nil {
+				return nil, err
+			}
+		} else {
+			// Copies must not share maps or slices with their source.
+			data, err := json.Marshal(value)
+			if err != nil {
+				return nil, err
+			}
+			if value, err = decodeJSON(data); err != nil {
+				return nil, err
+			}
+		}
+	case "remove":
+	default:
+		return nil, fmt.Errorf("unsupported operation %q", op.Op)
+	}
+
+	switch op.Op {
+	case "test":
+		current, err := get(doc, path)
+		if err != nil {
+			return nil, err
+		}
+		if !reflect.DeepEqual(current, value) {
+			return nil, fmt.Errorf("test failed for %q", op.Path)
+		}
+		return doc, nil
+	case "remove":
+		if len(path) == 0 {
+			return nil, fmt.Errorf("can't remove the whole document")
+		}
+		return update(doc, path, remove)
+	case "replace":
+		if len(path) == 0 {
+			return value, nil
+		}
+		if _, err := get(doc, path); err != nil {
+			return nil, err
+		}
+		if doc, err = update(doc, path, remove); err != nil {
+			return nil, err
+		}
+		return update(doc, path, add(value))
+	default:
+		if len(path) == 0 {
+			return value, nil
+		}
+		return update(doc, path, add(value))
+	}
+}
+
+// ApplyPatch applies the given JSON patch to the given json document.
+func ApplyPatch(data []byte, patch []PatchOperation) ([]byte, error) {
+	doc, err := decodeJSON(data)
+	if err != nil {
+		return nil, err
+	}
+	for _, op := range patch {
+		if doc, err = applyOperation(doc, op); err != nil {
+			return nil, fmt.Errorf("invalid patch operation %s %s: %s", op.Op, op.Path, err)
+		}
+	}
+	return json.Marshal(doc)
+}
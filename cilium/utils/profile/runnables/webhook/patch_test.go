@@ -0,0 +1,93 @@
+package webhook
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestApplyPatch(t *testing.T) {
+	doc := `{"Image":"busybox","Env":["A=1","B=2"],"HostConfig":{"Dns":["8.8.8.8"],"Memory":12345678901234567}}`
+	tests := []struct {
+		op   PatchOperation
+		want string
+	}{
+		{
+			PatchOperation{Op: "add", Path: "/Labels", Value: json.RawMessage(`{"a":"b"}`)},
+			`{"Env":["A=1","B=2"],"HostConfig":{"Dns":["8.8.8.8"],"Memory":12345678901234567},"Image":"busybox","Labels":{"a":"b"}}`,
+		},
+		{
+			PatchOperation{Op: "add", Path: "/Env/1", Value: json.RawMessage(`"C=3"`)},
+			`{"Env":["A=1","C=3","B=2"],"HostConfig":{"Dns":["8.8.8.8"],"Memory":12345678901234567},"Image":"busybox"}`,
+		},
+		{
+			PatchOperation{Op: "add", Path: "/Env/-", Value: json.RawMessage(`"C=3"`)},
+			`{"Env":["A=1","B=2","C=3"],"HostConfig":{"Dns":["8.8.8.8"],"Memory":12345678901234567},"Image":"busybox"}`,
+		},
+		{
+			PatchOperation{Op: "remove", Path: "/Env/0"},
+			`{"Env":["B=2"],"HostConfig":{"Dns":["8.8.8.8"],"Memory":12345678901234567},"Image":"busybox"}`,
+		},
+		{
+			PatchOperation{Op: "replace", Path: "/HostConfig/Dns/0", Value: json.RawMessage(`"1.2.3.4"`)},
+			`{"Env":["A=1","B=2"],"HostConfig":{"Dns":["1.2.3.4"],"Memory":12345678901234567},"Image":"busybox"}`,
+		},
+		{
+			PatchOperation{Op: "move", From: "/HostConfig/Dns", Path: "/Dns"},
+			`{"Dns":["8.8.8.8"],"Env":["A=1","B=2"],"HostConfig":{"Memory":12345678901234567},"Image":"busybox"}`,
+		},
+		{
+			PatchOperation{Op: "copy", From: "/Env/1", Path: "/Env/0"},
+			`{"Env":["B=2","A=1","B=2"],"HostConfig":{"Dns":["8.8.8.8"],"Memory":12345678901234567},"Image":"busybox"}`,
+		},
+		{
+			PatchOperation{Op: "test", Path: "/Image", Value: json.RawMessage(`"busybox"`)},
+			`{"Env":["A=1","B=2"],"HostConfig":{"Dns":["8.8.8.8"],"Memory":12345678901234567},"Image":"busybox"}`,
+		},
+	}
+	for _, test := range tests {
+		got, err := ApplyPatch([]byte(doc), []PatchOperation{test.op})
+		if err != nil {
+			t.Errorf("error while applying %+v: %s", test.op, err)
+			continue
+		}
+		if string(got) != test.want {
+			t.Errorf("invalid patched document for %+v:\ngot  %s\nwant %s", test.op, got, test.want)
+		}
+	}
+}
+
+func TestApplyPatchErrors(t *testing.T) {
+	doc := `{"Image":"busybox","Env":["A=1"]}`
+	tests := []PatchOperation{
+		{Op: "test", Path: "/Image", Value: json.RawMessage(`"ubuntu"`)},
+		{Op: "remove", Path: "/Labels"},
+		{Op: "replace", Path: "/Env/1", Value: json.RawMessage(`"B=2"`)},
+		{Op: "add", Path: "/Env/2", Value: json.RawMessage(`"B=2"`)},
+		{Op: "add", Path: "/Image/a", Value: json.RawMessage(`"b"`)},
+		{Op: "add", Path: "Image", Value: json.RawMessage(`"b"`)},
+		{Op: "add", Path: "/Image"},
+		{Op: "remove", Path: ""},
+		{Op: "merge", Path: "/Image"},
+	}
+	for _, op := range tests {
+		if got, err := ApplyPatch([]byte(doc), []PatchOperation{op}); err == nil {
+			t.Errorf("invalid patch operation %+v was applied: %s", op, got)
+		}
+	}
+}
+
+func TestParsePointer(t *testing.T) {
+	got, err := parsePointer("/a~1b/c~0d/~01")
+	if err != nil {
+		t.Fatalf("error while parsing pointer: %s", err)
+	}
+	want := []string{"a/b", "c~d", "~1"}
+	if len(got) != len(want) {
+		t.Fatalf("invalid tokens:\ngot  %q\nwant %q", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("invalid tokens:\ngot  %q\nwant %q", got, want)
+		}
+	}
+}
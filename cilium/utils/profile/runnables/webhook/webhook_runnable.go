@@ -0,0 +1,312 @@
+// Package webhook implements a runnable that calls external HTTP endpoints,
+// declared in the policies, so they can patch or deny the requests covered by
+// those policies.
+package webhook
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	m "github.com/cilium-team/cilium/cilium/messages"
+	ucdb "github.com/cilium-team/cilium/cilium/utils/comm/db"
+	up "github.com/cilium-team/cilium/cilium/utils/profile"
+	upr "github.com/cilium-team/cilium/cilium/utils/profile/runnables"
+	upsw "github.com/cilium-team/cilium/cilium/utils/profile/subpolicies/webhook"
+	us "github.com/cilium-team/cilium/cilium/utils/secrets"
+
+	"github.com/cilium-team/cilium/Godeps/_workspace/src/github.com/op/go-logging"
+	k8s "github.com/cilium-team/cilium/Godeps/_workspace/src/k8s.io/kubernetes/pkg/api"
+)
+
+const (
+	Name = "webhook-runnable"
+
+	DockerSwarmCreate      = "DockerSwarmCreate"
+	DockerDaemonCreate     = "DockerDaemonCreate"
+	DockerDaemonStart      = "DockerDaemonStart"
+	DockerDaemonRestart    = "DockerDaemonRestart"
+	KubernetesMasterCreate = "KubernetesMasterCreate"
+)
+
+var (
+	log = logging.MustGetLogger("cilium")
+
+	preHookHandlers = map[string]string{
+		`/docker/daemon/cilium-adapter(/v[^/]*)?/containers/create(\?.*)?`:                     DockerDaemonCreate,
+		`/docker/swarm/cilium-adapter(/v[^/]*)?/containers/create(\?.*)?`:                      DockerSwarmCreate,
+		`/kubernetes/master/cilium-adapter/api/v1/namespaces/.*/pods(\?.*)?`:                   KubernetesMasterCreate,
+		`/kubernetes/master/cilium-adapter/api/v1/namespaces/.*/replicationcontrollers(\?.*)?`: KubernetesMasterCreate,
+		`/kubernetes/master/cilium-adapter/api/v1/namespaces/.*/service(\?.*)?`:                KubernetesMasterCreate,
+	}
+	postHookHandlers = map[string]string{
+		`/docker/daemon/cilium-adapter(/v[^/]*)?/containers/.*/start(\?.*)?`:   DockerDaemonStart,
+		`/docker/daemon/cilium-adapter(/v[^/]*)?/containers/.*/restart(\?.*)?`: DockerDaemonRestart,
+	}
+)
+
+// Request is the body sent to webhooks.
+type Request struct {
+	Hook               string            `json:"hook"`
+	Request            string            `json:"request"`
+	Webhook            string            `json:"webhook"`
+	Name               string            `json:"name,omitempty"`
+	ID                 string            `json:"id,omitempty"`
+	DockerCreateConfig json.RawMessage   `json:"docker-create-config,omitempty"`
+	KubernetesObject   json.RawMessage   `json:"kubernetes-object,omitempty"`
+	Policies           []up.PolicySource `json:"policies"`
+}
+
+// Response is the body returned by webhooks. Requests are allowed unless Deny
+// is set, in which case Code, if it's a 4xx or 5xx HTTP status code, is the
+// status code returned to the client, 403 otherwise. Patch is a JSON patch
+// applied to the docker create config or to the kubernetes object sent in the
+// Request, it can't remove the docker create config's Config nor HostConfig.
+type Response struct {
+	Deny     bool             `json:"deny,omitempty"`
+	Reason   string           `json:"reason,omitempty"`
+	Code     int              `json:"code,omitempty"`
+	Patch    []PatchOperation `json:"patch,omitempty"`
+	Warnings []string         `json:"warnings,omitempty"`
+}
+
+// policyWebhook is a webhook and the policy where it was declared.
+type policyWebhook struct {
+	upsw.Webhook
+	policy string
+	owner  string
+	// policies are the policies of owner covering the request, the only
+	// ones sent to the webhook.
+	policies []up.PolicySource
+}
+
+type WebhookRunnable struct {
+	webhooks []policyWebhook
+	// secretEnv are the environment variables set to secrets by the
+	// policies covering the request, never sent to webhooks.
+	secretEnv map[string]bool
+}
+
+func (wr WebhookRunnable) GetHandlers(typ string) map[string]string {
+	switch typ {
+	case upr.PreHook:
+		return preHookHandlers
+	case upr.PostHook:
+		return postHookHandlers
+	default:
+		return nil
+	}
+}
+
+// GetRunnableFrom returns a WebhookRunnable with the webhooks of all given
+// policies. Webhooks aren't merged, all of them are called in the same order
+// the other runnables merge their configurations: users by descending ID and,
+// for each user, policies in declaration order.
+func (wr WebhookRunnable) GetRunnableFrom(users []up.User, policies []up.PolicySource) upr.PolicyRunnable {
+	log.Debug("users %+v", users)
+	webhooks := []policyWebhook{}
+	secretEnv := map[string]bool{}
+	up.OrderUsersByDescendingID(users)
+	for _, user := range users {
+		userPolicies := up.FilterPoliciesByUser(policies, user)
+		for _, policySource := range userPolicies {
+			for _, policy := range policySource.Policies {
+				for name := range policy.DockerConfig.SecretEnv {
+					secretEnv[name] = true
+				}
+				for _, webhook := range policy.WebhookConfig.Webhooks {
+					webhooks = append(webhooks, policyWebhook{
+						Webhook:  webhook,
+						policy:   policy.Name,
+						owner:    policySource.Owner,
+						policies: userPolicies,
+					})
+				}
+			}
+		}
+	}
+	log.Debug("webhooks %+v", webhooks)
+	return WebhookRunnable{webhooks: webhooks, secretEnv: secretEnv}
+}
+
+func (wr WebhookRunnable) DockerExec(hookType, reqType string, db ucdb.Db, cc *m.DockerCreateConfig) error {
+	for _, webhook := range wr.webhooks {
+		if !webhook.Matches(hookType, reqType) {
+			continue
+		}
+		// Only the fields known by go-dockerclient are sent and can be
+		// patched by webhooks. On post-hooks, the container already has
+		// the values of its secrets, they are removed from what is sent.
+		sent, secrets := wr.withoutSecretEnv(cc)
+		body, err := json.Marshal(sent)
+		if err != nil {
+			return err
+		}
+		body = []byte(us.RedactString(string(body)))
+		req := Request{
+			Hook:               hookType,
+			Request:            reqType,
+			Webhook:            webhook.Name,
+			Name:               cc.Name,
+			ID:                 cc.ID,
+			DockerCreateConfig: body,
+			Policies:           webhook.policies,
+		}
+		resp, err := wr.call(webhook, req, cc.Warn)
+		if err != nil || resp == nil {
+			return err
+		}
+		if len(resp.Patch) == 0 {
+			continue
+		}
+		patched, err := ApplyPatch(body, resp.Patch)
+		if err != nil {
+			return wr.failure(webhook, err, cc.Warn)
+		}
+		var patchedConfig m.DockerCreateConfig
+		if err := json.Unmarshal(patched, &patchedConfig); err != nil {
+			return wr.failure(webhook, err, cc.Warn)
+		}
+		if (cc.Config != nil && patchedConfig.Config == nil) || (cc.HostConfig != nil && patchedConfig.HostConfig == nil) {
+			return wr.failure(webhook, errors.New("patch removes the Config or the HostConfig"), cc.Warn)
+		}
+		if patchedConfig.Config != nil {
+			patchedConfig.Env = append(patchedConfig.Env, secrets...)
+		}
+		cc.Config, cc.HostConfig = patchedConfig.Config, patchedConfig.HostConfig
+	}
+	return nil
+}
+
+// withoutSecretEnv returns a copy of cc without the receiver's secretEnv
+// variables and those variables.
+func (wr WebhookRunnable) withoutSecretEnv(cc *m.DockerCreateConfig) (m.DockerCreateConfig, []string) {
+	sent := *cc
+	if cc.Config == nil {
+		return sent, nil
+	}
+	config := *cc.Config
+	config.Env = nil
+	secrets := []string{}
+	for _, v := range cc.Env {
+		if wr.secretEnv[strings.SplitN(v, "=", 2)[0]] {
+			secrets = append(secrets, v)
+		} else {
+			config.Env = append(config.Env, v)
+		}
+	}
+	sent.Config = &config
+	return sent, secrets
+}
+
+func (wr WebhookRunnable) KubernetesExec(hookType, reqType string, db ucdb.Db, cc *m.KubernetesObjRef) error {
+	warn := func(format string, a ...interface{}) {
+		log.Warning("Kubernetes object %s: %s", cc.Name, fmt.Sprintf(format, a...))
+	}
+	for _, webhook := range wr.webhooks {
+		if !webhook.Matches(hookType, reqType) {
+			continue
+		}
+		body, err := json.Marshal(cc.BodyObj)
+		if err != nil {
+			return err
+		}
+		req := Request{
+			Hook:             hookType,
+			Request:          reqType,
+			Webhook:          webhook.Name,
+			Name:             cc.Name,
+			KubernetesObject: body,
+			Policies:         webhook.policies,
+		}
+		resp, err := wr.call(webhook, req, warn)
+		if err != nil || resp == nil {
+			return err
+		}
+		if len(resp.Patch) == 0 {
+			continue
+		}
+		patched, err := ApplyPatch(body, resp.Patch)
+		if err != nil {
+			return wr.failure(webhook, err, warn)
+		}
+		var bodyObj map[string]interface{}
+		var objRef k8s.ObjectReference
+		if err := json.Unmarshal(patched, &bodyObj); err != nil {
+			return wr.failure(webhook, err, warn)
+		}
+		if err := json.Unmarshal(patched, &objRef); err != nil {
+			return wr.failure(webhook, err, warn)
+		}
+		cc.BodyObj, cc.ObjectReference = bodyObj, objRef
+	}
+	return nil
+}
+
+// call sends the given request to the given webhook. It returns a nil
+// Response, without error, if the webhook failed but it fails open, and a
+// Denial if the webhook denied the request or failed and it fails closed.
+func (wr WebhookRunnable) call(webhook policyWebhook, req Request, warn func(string, ...interface{})) (*Response, error) {
+	log.Debug("Calling webhook %s of policy %s", webhook.Name, webhook.policy)
+	timeout, err := webhook.GetTimeout()
+	if err != nil {
+		return nil, wr.failure(webhook, err, warn)
+	}
+	data, err := json.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+	client := http.Client{Timeout: timeout}
+	httpResp, err := client.Post(webhook.URL, "application/json", bytes.NewReader(data))
+	if err != nil {
+		return nil, wr.failure(webhook, err, warn)
+	}
+	defer httpResp.Body.Close()
+	body, err := ioutil.ReadAll(httpResp.Body)
+	if err != nil {
+		return nil, wr.failure(webhook, err, warn)
+	}
+	if httpResp.StatusCode < 200 || httpResp.StatusCode > 299 {
+		return nil, wr.failure(webhook, fmt.Errorf("unexpected status code %d", httpResp.StatusCode), warn)
+	}
+	var resp Response
+	if err := json.Unmarshal(body, &resp); err != nil {
+		return nil, wr.failure(webhook, err, warn)
+	}
+	for _, warning := range resp.Warnings {
+		warn("webhook %s: %s", webhook.Name, warning)
+	}
+	if resp.Deny {
+		code := resp.Code
+		if code < 400 || code > 599 {
+			code = http.StatusForbidden
+		}
+		return nil, upr.Denial{
+			Policy: webhook.policy,
+			Owner:  webhook.owner,
+			Reason: fmt.Sprintf("webhook %s: %s", webhook.Name, resp.Reason),
+			Code:   code,
+		}
+	}
+	return &resp, nil
+}
+
+// failure returns nil, after warning about the given error, if the given
+// webhook fails open, or a Denial otherwise.
+func (wr WebhookRunnable) failure(webhook policyWebhook, err error, warn func(string, ...interface{})) error {
+	if webhook.FailsOpen() {
+		warn("webhook %s failed and was ignored: %s", webhook.Name, err)
+		return nil
+	}
+	log.Error("Webhook %s of policy %s failed: %s", webhook.Name, webhook.policy, err)
+	return upr.Denial{
+		Policy: webhook.policy,
+		Owner:  webhook.owner,
+		Reason: fmt.Sprintf("webhook %s failed: %s", webhook.Name, err),
+		Code:   http.StatusServiceUnavailable,
+	}
+}
@@ -0,0 +1,291 @@
+package webhook
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	m "github.com/cilium-team/cilium/cilium/messages"
+	ucdb "github.com/cilium-team/cilium/cilium/utils/comm/db"
+	up "github.com/cilium-team/cilium/cilium/utils/profile"
+	upr "github.com/cilium-team/cilium/cilium/utils/profile/runnables"
+	upsd "github.com/cilium-team/cilium/cilium/utils/profile/subpolicies/docker"
+	upsw "github.com/cilium-team/cilium/cilium/utils/profile/subpolicies/webhook"
+
+	d "github.com/cilium-team/cilium/Godeps/_workspace/src/github.com/fsouza/go-dockerclient"
+)
+
+// newWebhookServer returns a webhook stand-in that replies with the given
+// response and sends the requests it receives to the returned channel.
+func newWebhookServer(t *testing.T, resp Response, delay time.Duration) (*httptest.Server, chan Request) {
+	requests := make(chan Request, 10)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req Request
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Errorf("error while decoding webhook request: %s", err)
+		}
+		requests <- req
+		time.Sleep(delay)
+		json.NewEncoder(w).Encode(resp)
+	}))
+	return server, requests
+}
+
+func newRunnable(webhooks ...upsw.Webhook) upr.PolicyRunnable {
+	users := []up.User{{ID: 1, Name: "admin"}}
+	policies := []up.PolicySource{{
+		Owner: "admin",
+		Policies: []up.Policy{{
+			Name:          "webhooks",
+			Owner:         "admin",
+			WebhookConfig: upsw.WebhookConfig{Webhooks: webhooks},
+		}},
+	}}
+	return WebhookRunnable{}.GetRunnableFrom(users, policies)
+}
+
+func newDockerCreateConfig() *m.DockerCreateConfig {
+	return &m.DockerCreateConfig{
+		Name: "web",
+		Config: &d.Config{
+			Image: "busybox",
+			Env:   []string{"A=1"},
+		},
+		HostConfig: &d.HostConfig{DNS: []string{"8.8.8.8"}},
+	}
+}
+
+func TestDockerExecPatch(t *testing.T) {
+	server, requests := newWebhookServer(t, Response{
+		Patch: []PatchOperation{
+			{Op: "add", Path: "/Env/-", Value: json.RawMessage(`"B=2"`)},
+			{Op: "replace", Path: "/HostConfig/Dns", Value: json.RawMessage(`["1.2.3.4"]`)},
+		},
+		Warnings: []string{"env patched"},
+	}, 0)
+	defer server.Close()
+
+	runnable := newRunnable(upsw.Webhook{Name: "env", URL: server.URL})
+	cc := newDockerCreateConfig()
+	if err := runnable.DockerExec(upr.PreHook, DockerDaemonCreate, ucdb.NewMemConn(), cc); err != nil {
+		t.Fatalf("error while running webhook: %s", err)
+	}
+
+	req := <-requests
+	if req.Hook != upr.PreHook || req.Request != DockerDaemonCreate || req.Webhook != "env" || req.Name != "web" {
+		t.Errorf("invalid webhook request: %+v", req)
+	}
+	if len(req.Policies) != 1 || req.Policies[0].Policies[0].Name != "webhooks" {
+		t.Errorf("invalid webhook request policies: %+v", req.Policies)
+	}
+	if want := []string{"A=1", "B=2"}; !reflect.DeepEqual(cc.Env, want) {
+		t.Errorf("invalid Env:\ngot  %s\nwant %s", cc.Env, want)
+	}
+	if want := []string{"1.2.3.4"}; !reflect.DeepEqual(cc.HostConfig.DNS, want) {
+		t.Errorf("invalid Dns:\ngot  %s\nwant %s", cc.HostConfig.DNS, want)
+	}
+	if cc.Name != "web" || cc.Image != "busybox" {
+		t.Errorf("invalid patched config: %+v", cc)
+	}
+	if want := []string{"webhook env: env patched"}; !reflect.DeepEqual(cc.Warnings, want) {
+		t.Errorf("invalid Warnings:\ngot  %s\nwant %s", cc.Warnings, want)
+	}
+}
+
+func TestDockerExecPoliciesOfOwner(t *testing.T) {
+	server, requests := newWebhookServer(t, Response{}, 0)
+	defer server.Close()
+
+	users := []up.User{{ID: 1, Name: "admin"}, {ID: 2, Name: "alice"}}
+	policies := []up.PolicySource{
+		{Owner: "admin", Policies: []up.Policy{{
+			Name:          "webhooks",
+			Owner:         "admin",
+			WebhookConfig: upsw.WebhookConfig{Webhooks: []upsw.Webhook{{Name: "env", URL: server.URL}}},
+		}}},
+		{Owner: "alice", Policies: []up.Policy{{Name: "alice-policy", Owner: "alice"}}},
+	}
+	runnable := WebhookRunnable{}.GetRunnableFrom(users, policies)
+	if err := runnable.DockerExec(upr.PreHook, DockerDaemonCreate, ucdb.NewMemConn(), newDockerCreateConfig()); err != nil {
+		t.Fatalf("error while running webhook: %s", err)
+	}
+
+	req := <-requests
+	if len(req.Policies) != 1 || req.Policies[0].Owner != "admin" {
+		t.Errorf("invalid webhook request policies:\ngot  %+v\nwant %+v", req.Policies, policies[:1])
+	}
+}
+
+func TestDockerExecSecretEnvNotSent(t *testing.T) {
+	server, requests := newWebhookServer(t, Response{
+		Patch: []PatchOperation{{Op: "add", Path: "/Env/-", Value: json.RawMessage(`"B=2"`)}},
+	}, 0)
+	defer server.Close()
+
+	users := []up.User{{ID: 1, Name: "admin"}, {ID: 2, Name: "alice"}}
+	policies := []up.PolicySource{
+		{Owner: "admin", Policies: []up.Policy{{
+			Name:          "webhooks",
+			Owner:         "admin",
+			WebhookConfig: upsw.WebhookConfig{Webhooks: []upsw.Webhook{{Name: "env", URL: server.URL, Hooks: []string{upr.PostHook}}}},
+		}}},
+		{Owner: "alice", Policies: []up.Policy{{
+			Name:         "secrets",
+			Owner:        "alice",
+			DockerConfig: upsd.DockerConfig{SecretEnv: map[string]string{"DB_PASSWORD": "db/password"}},
+		}}},
+	}
+	runnable := WebhookRunnable{}.GetRunnableFrom(users, policies)
+	// On post-hooks, the container config is inspected from docker and has
+	// the values of its secrets.
+	cc := newDockerCreateConfig()
+	cc.Env = append(cc.Env, "DB_PASSWORD=s3cr3t")
+	if err := runnable.DockerExec(upr.PostHook, DockerDaemonStart, ucdb.NewMemConn(), cc); err != nil {
+		t.Fatalf("error while running webhook: %s", err)
+	}
+
+	req := <-requests
+	if strings.Contains(string(req.DockerCreateConfig), "s3cr3t") {
+		t.Errorf("webhook received the plaintext secret: %s", req.DockerCreateConfig)
+	}
+	if want := []string{"A=1", "B=2", "DB_PASSWORD=s3cr3t"}; !reflect.DeepEqual(cc.Env, want) {
+		t.Errorf("invalid patched env:\ngot  %s\nwant %s", cc.Env, want)
+	}
+}
+
+func TestDockerExecDeny(t *testing.T) {
+	tests := []struct {
+		code int
+		want int
+	}{
+		{http.StatusUnauthorized, http.StatusUnauthorized},
+		{http.StatusServiceUnavailable, http.StatusServiceUnavailable},
+		{0, http.StatusForbidden},
+		{http.StatusOK, http.StatusForbidden},
+		{http.StatusFound, http.StatusForbidden},
+		{999, http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		server, _ := newWebhookServer(t, Response{Deny: true, Reason: "image not allowed", Code: tt.code}, 0)
+		runnable := newRunnable(upsw.Webhook{Name: "images", URL: server.URL, FailurePolicy: upsw.FailOpen})
+		err := runnable.DockerExec(upr.PreHook, DockerDaemonCreate, ucdb.NewMemConn(), newDockerCreateConfig())
+		server.Close()
+		want := upr.Denial{
+			Policy: "webhooks",
+			Owner:  "admin",
+			Reason: "webhook images: image not allowed",
+			Code:   tt.want,
+		}
+		if err != want {
+			t.Errorf("invalid error for code %d:\ngot  %#v\nwant %#v", tt.code, err, want)
+		}
+	}
+}
+
+func TestDockerExecPatchRemovesHostConfig(t *testing.T) {
+	server, _ := newWebhookServer(t, Response{
+		Patch: []PatchOperation{{Op: "remove", Path: "/HostConfig"}},
+	}, 0)
+	defer server.Close()
+
+	runnable := newRunnable(upsw.Webhook{Name: "strip", URL: server.URL})
+	cc := newDockerCreateConfig()
+	err := runnable.DockerExec(upr.PreHook, DockerDaemonCreate, ucdb.NewMemConn(), cc)
+	if denial, ok := err.(upr.Denial); !ok || denial.StatusCode() != http.StatusServiceUnavailable {
+		t.Errorf("invalid error for patch removing HostConfig: %#v", err)
+	}
+	if cc.HostConfig == nil || cc.Config == nil {
+		t.Errorf("config patched by a rejected patch: %+v", cc)
+	}
+}
+
+func TestDockerExecTimeout(t *testing.T) {
+	server, _ := newWebhookServer(t, Response{Deny: true}, 200*time.Millisecond)
+	defer server.Close()
+
+	runnable := newRunnable(upsw.Webhook{Name: "slow", URL: server.URL, Timeout: "10ms", FailurePolicy: upsw.FailOpen})
+	cc := newDockerCreateConfig()
+	if err := runnable.DockerExec(upr.PreHook, DockerDaemonCreate, ucdb.NewMemConn(), cc); err != nil {
+		t.Errorf("fail-open webhook returned an error: %s", err)
+	}
+	if len(cc.Warnings) != 1 {
+		t.Errorf("invalid Warnings: %s", cc.Warnings)
+	}
+
+	runnable = newRunnable(upsw.Webhook{Name: "slow", URL: server.URL, Timeout: "10ms"})
+	err := runnable.DockerExec(upr.PreHook, DockerDaemonCreate, ucdb.NewMemConn(), newDockerCreateConfig())
+	denial, ok := err.(upr.Denial)
+	if !ok || denial.StatusCode() != http.StatusServiceUnavailable || denial.Policy != "webhooks" {
+		t.Errorf("invalid error for fail-closed webhook: %#v", err)
+	}
+}
+
+func TestDockerExecFailure(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "boom", http.StatusInternalServerError)
+	}))
+	defer server.Close()
+
+	runnable := newRunnable(upsw.Webhook{Name: "broken", URL: server.URL})
+	err := runnable.DockerExec(upr.PreHook, DockerDaemonCreate, ucdb.NewMemConn(), newDockerCreateConfig())
+	if denial, ok := err.(upr.Denial); !ok || denial.StatusCode() != http.StatusServiceUnavailable {
+		t.Errorf("invalid error for failed webhook: %#v", err)
+	}
+}
+
+func TestDockerExecMatches(t *testing.T) {
+	server, requests := newWebhookServer(t, Response{}, 0)
+	defer server.Close()
+
+	runnable := newRunnable(
+		upsw.Webhook{Name: "swarm", URL: server.URL, Requests: []string{DockerSwarmCreate}},
+		upsw.Webhook{Name: "start", URL: server.URL, Hooks: []string{upr.PostHook}},
+		upsw.Webhook{Name: "create", URL: server.URL, Requests: []string{DockerDaemonCreate}},
+	)
+	if err := runnable.DockerExec(upr.PreHook, DockerDaemonCreate, ucdb.NewMemConn(), newDockerCreateConfig()); err != nil {
+		t.Fatalf("error while running webhooks: %s", err)
+	}
+	close(requests)
+	got := []string{}
+	for req := range requests {
+		got = append(got, req.Webhook)
+	}
+	if want := []string{"create"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("invalid webhooks called:\ngot  %s\nwant %s", got, want)
+	}
+}
+
+func TestKubernetesExecPatch(t *testing.T) {
+	server, requests := newWebhookServer(t, Response{
+		Patch: []PatchOperation{
+			{Op: "add", Path: "/metadata/labels/team", Value: json.RawMessage(`"web"`)},
+		},
+	}, 0)
+	defer server.Close()
+
+	runnable := newRunnable(upsw.Webhook{Name: "labels", URL: server.URL})
+	cc := &m.KubernetesObjRef{}
+	cc.Name = "redis"
+	cc.Kind = "Pod"
+	cc.BodyObj = map[string]interface{}{
+		"kind":     "Pod",
+		"metadata": map[string]interface{}{"name": "redis", "labels": map[string]interface{}{}},
+	}
+	if err := runnable.KubernetesExec(upr.PreHook, KubernetesMasterCreate, ucdb.NewMemConn(), cc); err != nil {
+		t.Fatalf("error while running webhook: %s", err)
+	}
+	if req := <-requests; req.Name != "redis" || len(req.KubernetesObject) == 0 {
+		t.Errorf("invalid webhook request: %+v", req)
+	}
+	labels := cc.BodyObj["metadata"].(map[string]interface{})["labels"]
+	if want := map[string]interface{}{"team": "web"}; !reflect.DeepEqual(labels, want) {
+		t.Errorf("invalid labels:\ngot  %v\nwant %v", labels, want)
+	}
+	if cc.Kind != "Pod" {
+		t.Errorf("invalid Kind:\ngot  %s\nwant %s", cc.Kind, "Pod")
+	}
+}
@@ -0,0 +1,61 @@
+package profile
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// Secret is a value, like a password, injected into containers without being
+// stored in plain text. Plaintext is only set when the secret is read from a
+// configuration file; once stored, the secret only has its Ciphertext.
+type Secret struct {
+	Name  string `json:"name,omitempty" yaml:"name,omitempty"`
+	Owner string `json:"owner,omitempty" yaml:"owner,omitempty"`
+	// Readers are the owners, besides Owner, whose policies can reference
+	// the secret.
+	Readers    []string `json:"readers,omitempty" yaml:"readers,omitempty"`
+	Plaintext  string   `json:"value,omitempty" yaml:"value,omitempty"`
+	Ciphertext string   `json:"ciphertext,omitempty" yaml:"ciphertext,omitempty"`
+}
+
+// Value marshals the receiver Secret into a json string.
+func (s Secret) Value() (string, error) {
+	if data, err := json.Marshal(s); err != nil {
+		return "", err
+	} else {
+		return string(data), err
+	}
+}
+
+// Scan unmarshals the input into the receiver Secret.
+func (s *Secret) Scan(input string) error {
+	return json.Unmarshal([]byte(input), s)
+}
+
+// Validate returns an error if the receiver's Secret is invalid.
+func (s Secret) Validate() error {
+	if s.Name == "" {
+		return fmt.Errorf("secret without name")
+	}
+	if s.Owner == "" {
+		return fmt.Errorf("secret %s without owner", s.Name)
+	}
+	if s.Plaintext == "" && s.Ciphertext == "" {
+		return fmt.Errorf("secret %s without value", s.Name)
+	}
+	return nil
+}
+
+// Allows returns true if policies of the given owner can reference the
+// receiver's Secret.
+func (s Secret) Allows(owner string) bool {
+	if owner == s.Owner {
+		return true
+	}
+	for _, reader := range s.Readers {
+		if owner == reader {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,124 @@
+package profile
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// ServiceSlots are the instances of a service counted for its max-scale: the
+// containers running and the containers being created.
+type ServiceSlots struct {
+	Service string `json:"service" yaml:"service"`
+	Slots   []Slot `json:"slots,omitempty" yaml:"slots,omitempty"`
+}
+
+// Slot is an instance of a service. It is reserved before its container is
+// created and confirmed once the container starts. A reservation that is not
+// confirmed before its expiration, e.g. because the create failed, is
+// discarded.
+type Slot struct {
+	Reservation string    `json:"reservation" yaml:"reservation"`
+	Container   string    `json:"container,omitempty" yaml:"container,omitempty"`
+	Expiration  time.Time `json:"expiration,omitempty" yaml:"expiration,omitempty"`
+}
+
+// IsConfirmed returns true if the container of the receiver's Slot has
+// started.
+func (s Slot) IsConfirmed() bool {
+	return s.Container != ""
+}
+
+// Value marshals the receiver ServiceSlots into a json string.
+func (ss ServiceSlots) Value() (string, error) {
+	if data, err := json.Marshal(ss); err != nil {
+		return "", err
+	} else {
+		return string(data), err
+	}
+}
+
+// Scan unmarshals the input into the receiver ServiceSlots.
+func (ss *ServiceSlots) Scan(input string) error {
+	return json.Unmarshal([]byte(input), ss)
+}
+
+// Expire removes the reservations that weren't confirmed before the given
+// time.
+func (ss *ServiceSlots) Expire(now time.Time) {
+	ss.filter(func(s Slot) bool {
+		return s.IsConfirmed() || now.Before(s.Expiration)
+	})
+}
+
+// Reserve adds a reservation, valid until expiration, if the receiver has
+// less than max slots. Returns false if all slots are taken.
+func (ss *ServiceSlots) Reserve(reservation string, max int, expiration time.Time) bool {
+	if len(ss.Slots) >= max {
+		return false
+	}
+	ss.Slots = append(ss.Slots, Slot{Reservation: reservation, Expiration: expiration})
+	return true
+}
+
+// Confirm assigns the given container to the given reservation. If neither
+// the reservation nor a slot of the container exist anymore, e.g. the
+// reservation expired, a confirmed slot is added for it if the receiver has
+// less than max slots. Returns false if all slots are taken.
+func (ss *ServiceSlots) Confirm(reservation, container string, max int) bool {
+	for i, s := range ss.Slots {
+		if s.Reservation == reservation || s.Container == container {
+			ss.Slots[i] = Slot{Reservation: reservation, Container: container}
+			return true
+		}
+	}
+	if len(ss.Slots) >= max {
+		return false
+	}
+	ss.Slots = append(ss.Slots, Slot{Reservation: reservation, Container: container})
+	return true
+}
+
+// Merge adds the slots of other whose reservation and container the receiver
+// doesn't have yet. The receiver's slots are kept as they are.
+func (ss *ServiceSlots) Merge(other ServiceSlots) {
+	for _, o := range other.Slots {
+		taken := false
+		for _, s := range ss.Slots {
+			if s.Reservation == o.Reservation || (o.IsConfirmed() && s.Container == o.Container) {
+				taken = true
+				break
+			}
+		}
+		if !taken {
+			ss.Slots = append(ss.Slots, o)
+		}
+	}
+}
+
+// Release removes the given reservation.
+func (ss *ServiceSlots) Release(reservation string) {
+	ss.filter(func(s Slot) bool {
+		return s.Reservation != reservation
+	})
+}
+
+// ReleaseContainer removes the slot of the given container. Returns false if
+// the container didn't have any slot.
+func (ss *ServiceSlots) ReleaseContainer(container string) bool {
+	n := len(ss.Slots)
+	ss.filter(func(s Slot) bool {
+		return s.Container != container
+	})
+	return len(ss.Slots) != n
+}
+
+// filter keeps the receiver's slots for which keep returns true.
+func (ss *ServiceSlots) filter(keep func(Slot) bool) {
+	slots := []Slot{}
+	for _, s := range ss.Slots {
+		if keep(s) {
+			slots = append(slots, s)
+		}
+	}
+	ss.Slots = slots
+}
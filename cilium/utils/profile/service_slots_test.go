@@ -0,0 +1,49 @@
+package profile
+
+import (
+	"testing"
+	"time"
+)
+
+func TestServiceSlots(t *testing.T) {
+	now := time.Now()
+	ss := ServiceSlots{Service: "web"}
+	if !ss.Reserve("a", 2, now.Add(time.Minute)) || !ss.Reserve("b", 2, now.Add(time.Second)) {
+		t.Fatalf("unable to reserve free slots: %+v", ss)
+	}
+	if ss.Reserve("c", 2, now.Add(time.Minute)) {
+		t.Errorf("reserved more slots than the maximum: %+v", ss)
+	}
+
+	if !ss.Confirm("a", "1", 2) {
+		t.Errorf("unable to confirm a reservation: %+v", ss)
+	}
+	ss.Expire(now.Add(2 * time.Second))
+	want := []Slot{{Reservation: "a", Container: "1"}}
+	if len(ss.Slots) != 1 || ss.Slots[0] != want[0] {
+		t.Errorf("invalid slots after expiration:\ngot  %+v\nwant %+v", ss.Slots, want)
+	}
+
+	if !ss.ReleaseContainer("1") || len(ss.Slots) != 0 {
+		t.Errorf("invalid slots after releasing container:\ngot  %+v\nwant %+v", ss.Slots, []Slot{})
+	}
+	if ss.ReleaseContainer("1") {
+		t.Errorf("released a container without slot")
+	}
+
+	// A started container gets a slot back even without reservation, unless
+	// all slots are taken.
+	if !ss.Confirm("a", "1", 1) || !ss.Confirm("a", "1", 1) {
+		t.Errorf("unable to confirm a released container: %+v", ss)
+	}
+	if len(ss.Slots) != 1 || !ss.Slots[0].IsConfirmed() {
+		t.Errorf("invalid slots after confirming a released container:\ngot  %+v\nwant %+v", ss.Slots, want)
+	}
+	if ss.Confirm("b", "2", 1) {
+		t.Errorf("confirmed more slots than the maximum: %+v", ss)
+	}
+	ss.Release("a")
+	if len(ss.Slots) != 0 {
+		t.Errorf("invalid slots after releasing reservation:\ngot  %+v\nwant %+v", ss.Slots, []Slot{})
+	}
+}
@@ -0,0 +1,273 @@
+package constraints_policy
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+const (
+	// Enforce denies the requests that violate the constraints.
+	Enforce = "enforce"
+	// Audit lets the requests that violate the constraints through, the
+	// violations are logged and sent to the client as warnings.
+	Audit = "audit"
+
+	// DefaultRegistry is the registry of the images that don't specify one.
+	DefaultRegistry = "docker.io"
+
+	configField     = "Config"
+	hostConfigField = "HostConfig"
+)
+
+// DockerConstraints are the rules that docker create requests covered by a
+// policy must follow.
+type DockerConstraints struct {
+	// Mode is either enforce, the default, or audit.
+	Mode   string           `json:"mode,omitempty" yaml:"mode,omitempty"`
+	Rules  []Rule           `json:"rules,omitempty" yaml:"rules,omitempty"`
+	Images ImageConstraints `json:"images,omitempty" yaml:"images,omitempty"`
+}
+
+// Rule allows or denies values of a Config or HostConfig field.
+type Rule struct {
+	// Field is the path of the field, e.g. "HostConfig.Privileged" or
+	// "Config.User". Lists are checked element by element and maps as
+	// "key=value" elements.
+	Field string `json:"field,omitempty" yaml:"field,omitempty"`
+	// Allow are regular expressions, when set, every value must match at
+	// least one of them.
+	Allow []string `json:"allow,omitempty" yaml:"allow,omitempty"`
+	// Deny are regular expressions that no value can match.
+	Deny []string `json:"deny,omitempty" yaml:"deny,omitempty"`
+	// Message replaces the default message sent to the client when the rule
+	// is violated.
+	Message string `json:"message,omitempty" yaml:"message,omitempty"`
+}
+
+// ImageConstraints restrict the registries where images can be pulled from.
+// Images without a registry are from DefaultRegistry.
+type ImageConstraints struct {
+	AllowRegistries []string `json:"allow-registries,omitempty" yaml:"allow-registries,omitempty"`
+	DenyRegistries  []string `json:"deny-registries,omitempty" yaml:"deny-registries,omitempty"`
+}
+
+// IsEmpty returns true if the receiver's DockerConstraints doesn't have any
+// constraint.
+func (dc DockerConstraints) IsEmpty() bool {
+	return len(dc.Rules) == 0 && len(dc.Images.AllowRegistries) == 0 &&
+		len(dc.Images.DenyRegistries) == 0
+}
+
+// IsAudit returns true if the receiver's DockerConstraints are only audited.
+func (dc DockerConstraints) IsAudit() bool {
+	return dc.Mode == Audit
+}
+
+// Validate returns an error if the receiver's DockerConstraints is invalid.
+func (dc DockerConstraints) Validate() error {
+	switch dc.Mode {
+	case "", Enforce, Audit:
+	default:
+		return fmt.Errorf("invalid docker-constraints mode %q, valid options are (%s|%s)",
+			dc.Mode, Enforce, Audit)
+	}
+	for _, rule := range dc.Rules {
+		if err := rule.Validate(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Validate returns an error if the receiver's Rule is invalid.
+func (r Rule) Validate() error {
+	path := strings.Split(r.Field, ".")
+	if len(path) < 2 || (path[0] != configField && path[0] != hostConfigField) {
+		return fmt.Errorf("invalid rule field %q, it must start with %s. or %s.",
+			r.Field, configField, hostConfigField)
+	}
+	if len(r.Allow) == 0 && len(r.Deny) == 0 {
+		return fmt.Errorf("rule for field %q doesn't allow nor deny any value", r.Field)
+	}
+	for _, expr := range append(append([]string{}, r.Allow...), r.Deny...) {
+		if _, err := regexp.Compile(expr); err != nil {
+			return fmt.Errorf("rule for field %q has an invalid regular expression: %s", r.Field, err)
+		}
+	}
+	return nil
+}
+
+// Check returns the violations of the receiver's DockerConstraints by the
+// given docker create body, encoded from the go-dockerclient structures.
+func (dc DockerConstraints) Check(body []byte) ([]string, error) {
+	var doc map[string]interface{}
+	dec := json.NewDecoder(bytes.NewReader(body))
+	dec.UseNumber()
+	if err := dec.Decode(&doc); err != nil {
+		return nil, err
+	}
+	violations := []string{}
+	for _, rule := range dc.Rules {
+		v, err := rule.check(doc)
+		if err != nil {
+			return nil, err
+		}
+		violations = append(violations, v...)
+	}
+	// Docker decodes the body's keys case-insensitively, so must the image.
+	for _, image := range fieldValues(doc, configField+".Image") {
+		violations = append(violations, dc.Images.check(image)...)
+	}
+	return violations, nil
+}
+
+// check returns the violations of the receiver's Rule by the given document.
+func (r Rule) check(doc map[string]interface{}) ([]string, error) {
+	allow, err := compile(r.Allow)
+	if err != nil {
+		return nil, err
+	}
+	deny, err := compile(r.Deny)
+	if err != nil {
+		return nil, err
+	}
+	violations := []string{}
+	for _, value := range fieldValues(doc, r.Field) {
+		if matchesAny(deny, value) || (len(allow) != 0 && !matchesAny(allow, value)) {
+			if r.Message != "" {
+				violations = append(violations, fmt.Sprintf("%s: %s", r.Field, r.Message))
+			} else {
+				violations = append(violations, fmt.Sprintf("%s %q is not allowed", r.Field, value))
+			}
+		}
+	}
+	return violations, nil
+}
+
+// check returns the violations of the receiver's ImageConstraints by the
+// given image.
+func (ic ImageConstraints) check(image string) []string {
+	registry := ImageRegistry(image)
+	if containsFold(ic.DenyRegistries, registry) ||
+		(len(ic.AllowRegistries) != 0 && !containsFold(ic.AllowRegistries, registry)) {
+		return []string{fmt.Sprintf("image %q from registry %q is not allowed", image, registry)}
+	}
+	return nil
+}
+
+// ImageRegistry returns the registry of the given image reference.
+func ImageRegistry(image string) string {
+	i := strings.Index(image, "/")
+	if i == -1 {
+		return DefaultRegistry
+	}
+	if host := image[:i]; strings.ContainsAny(host, ".:") || host == "localhost" {
+		return host
+	}
+	return DefaultRegistry
+}
+
+// fieldValues returns the values, as strings, of the given field path in the
+// given docker create body. Config fields are at the root of the body. Keys
+// are matched case-insensitively, like docker does, and the values of all
+// keys matching the field are returned, so none of them escapes the rules.
+func fieldValues(doc map[string]interface{}, field string) []string {
+	path := strings.Split(field, ".")
+	if path[0] == configField {
+		path = path[1:]
+	}
+	nodes := []interface{}{doc}
+	for _, key := range path {
+		next := []interface{}{}
+		for _, node := range nodes {
+			obj, ok := node.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			keys := []string{}
+			for k := range obj {
+				if strings.EqualFold(k, key) {
+					keys = append(keys, k)
+				}
+			}
+			sort.Strings(keys)
+			for _, k := range keys {
+				next = append(next, obj[k])
+			}
+		}
+		nodes = next
+	}
+	values := []string{}
+	for _, node := range nodes {
+		values = append(values, flatten(node)...)
+	}
+	return values
+}
+
+// flatten returns the given json value as a list of strings.
+func flatten(node interface{}) []string {
+	switch n := node.(type) {
+	case nil:
+		return nil
+	case string:
+		if n == "" {
+			return nil
+		}
+		return []string{n}
+	case []interface{}:
+		values := []string{}
+		for _, elem := range n {
+			values = append(values, flatten(elem)...)
+		}
+		return values
+	case map[string]interface{}:
+		values := []string{}
+		for k, v := range n {
+			if s := flatten(v); len(s) == 0 {
+				values = append(values, k)
+			} else {
+				for _, elem := range s {
+					values = append(values, k+"="+elem)
+				}
+			}
+		}
+		sort.Strings(values)
+		return values
+	default:
+		return []string{fmt.Sprint(n)}
+	}
+}
+
+func compile(exprs []string) ([]*regexp.Regexp, error) {
+	regexps := []*regexp.Regexp{}
+	for _, expr := range exprs {
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			return nil, err
+		}
+		regexps = append(regexps, re)
+	}
+	return regexps, nil
+}
+
+func matchesAny(regexps []*regexp.Regexp, value string) bool {
+	for _, re := range regexps {
+		if re.MatchString(value) {
+			return true
+		}
+	}
+	return false
+}
+
+func containsFold(slice []string, s string) bool {
+	for _, elem := range slice {
+		if strings.EqualFold(elem, s) {
+			return true
+		}
+	}
+	return false
+}
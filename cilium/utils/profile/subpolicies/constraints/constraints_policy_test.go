@@ -0,0 +1,126 @@
+package constraints_policy
+
+import (
+	"reflect"
+	"testing"
+)
+
+const createBody = `{"Image":"registry.example.com:5000/web:1.0","User":"root","Labels":{"app":"web"},` +
+	`"HostConfig":{"Privileged":true,"NetworkMode":"host","CapAdd":["NET_ADMIN","CHOWN"],"Binds":["/etc:/etc:ro","data:/data"]}}`
+
+func TestCheck(t *testing.T) {
+	tests := []struct {
+		constraints DockerConstraints
+		want        []string
+	}{
+		{
+			DockerConstraints{Rules: []Rule{{Field: "HostConfig.Privileged", Deny: []string{"^true$"}}}},
+			[]string{`HostConfig.Privileged "true" is not allowed`},
+		},
+		{
+			DockerConstraints{Rules: []Rule{{Field: "HostConfig.NetworkMode", Deny: []string{"^host$"}, Message: "host networking is forbidden"}}},
+			[]string{`HostConfig.NetworkMode: host networking is forbidden`},
+		},
+		{
+			DockerConstraints{Rules: []Rule{{Field: "HostConfig.CapAdd", Allow: []string{"^CHOWN$"}}}},
+			[]string{`HostConfig.CapAdd "NET_ADMIN" is not allowed`},
+		},
+		{
+			DockerConstraints{Rules: []Rule{{Field: "HostConfig.Binds", Deny: []string{"^/"}}}},
+			[]string{`HostConfig.Binds "/etc:/etc:ro" is not allowed`},
+		},
+		{
+			DockerConstraints{Rules: []Rule{{Field: "Config.user", Deny: []string{"^(root|0)$"}}}},
+			[]string{`Config.user "root" is not allowed`},
+		},
+		{
+			DockerConstraints{Rules: []Rule{{Field: "Config.Labels", Allow: []string{"^app="}}}},
+			[]string{},
+		},
+		{
+			DockerConstraints{Rules: []Rule{{Field: "HostConfig.PidMode", Deny: []string{"^host$"}}}},
+			[]string{},
+		},
+		{
+			DockerConstraints{Images: ImageConstraints{AllowRegistries: []string{"docker.io"}}},
+			[]string{`image "registry.example.com:5000/web:1.0" from registry "registry.example.com:5000" is not allowed`},
+		},
+		{
+			DockerConstraints{Images: ImageConstraints{DenyRegistries: []string{"docker.io"}}},
+			[]string{},
+		},
+	}
+	for _, tt := range tests {
+		got, err := tt.constraints.Check([]byte(createBody))
+		if err != nil {
+			t.Errorf("error while checking %+v: %s", tt.constraints, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("invalid violations of %+v:\ngot  %q\nwant %q", tt.constraints, got, tt.want)
+		}
+	}
+}
+
+func TestCheckImageCase(t *testing.T) {
+	constraints := DockerConstraints{Images: ImageConstraints{AllowRegistries: []string{"docker.io"}}}
+	got, err := constraints.Check([]byte(`{"image":"evil.example.com/x"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{`image "evil.example.com/x" from registry "evil.example.com" is not allowed`}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("invalid violations:\ngot  %q\nwant %q", got, want)
+	}
+}
+
+func TestCheckKeysDifferingByCase(t *testing.T) {
+	constraints := DockerConstraints{Rules: []Rule{{Field: "HostConfig.Privileged", Deny: []string{"^true$"}}}}
+	for i := 0; i < 100; i++ {
+		got, err := constraints.Check([]byte(`{"HostConfig":{"Privileged":false,"privileged":true}}`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := []string{`HostConfig.Privileged "true" is not allowed`}
+		if !reflect.DeepEqual(got, want) {
+			t.Fatalf("invalid violations:\ngot  %q\nwant %q", got, want)
+		}
+	}
+}
+
+func TestImageRegistry(t *testing.T) {
+	tests := []struct {
+		image string
+		want  string
+	}{
+		{"busybox", DefaultRegistry},
+		{"library/busybox:latest", DefaultRegistry},
+		{"quay.io/coreos/etcd", "quay.io"},
+		{"localhost/web", "localhost"},
+		{"localhost:5000/web", "localhost:5000"},
+	}
+	for _, tt := range tests {
+		if got := ImageRegistry(tt.image); got != tt.want {
+			t.Errorf("invalid registry of %s:\ngot  %s\nwant %s", tt.image, got, tt.want)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		constraints DockerConstraints
+		valid       bool
+	}{
+		{DockerConstraints{}, true},
+		{DockerConstraints{Mode: Audit, Rules: []Rule{{Field: "HostConfig.Privileged", Deny: []string{"true"}}}}, true},
+		{DockerConstraints{Mode: "dry"}, false},
+		{DockerConstraints{Rules: []Rule{{Field: "Privileged", Deny: []string{"true"}}}}, false},
+		{DockerConstraints{Rules: []Rule{{Field: "HostConfig.Privileged"}}}, false},
+		{DockerConstraints{Rules: []Rule{{Field: "HostConfig.Binds", Deny: []string{"("}}}}, false},
+	}
+	for _, tt := range tests {
+		if err := tt.constraints.Validate(); (err == nil) != tt.valid {
+			t.Errorf("invalid validation of %+v:\ngot  %v\nwant valid=%t", tt.constraints, err, tt.valid)
+		}
+	}
+}
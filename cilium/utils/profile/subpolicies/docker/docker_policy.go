@@ -38,7 +38,10 @@ func (hc *HostConfig) OverwriteWith(other HostConfig) error {
 type DockerConfig struct {
 	Config     Config     `json:"config,omitempty" yaml:"config,omitempty"`
 	HostConfig HostConfig `json:"host-config,omitempty" yaml:"host-config,omitempty"`
-	Priority   int        `json:"priority,omitempty" yaml:"priority,omitempty"`
+	// SecretEnv maps environment variables to the names of the secrets whose
+	// values they are set to when the container is created.
+	SecretEnv map[string]string `json:"secret-env,omitempty" yaml:"secret-env,omitempty"`
+	Priority  int               `json:"priority,omitempty" yaml:"priority,omitempty"`
 }
 
 type Config d.Config
@@ -16,27 +16,33 @@ import (
 )
 
 type Intent struct {
-	AddArguments       *[]string      `json:"add-arguments,omitempty" yaml:"add-arguments,omitempty"`
-	AddToDNS           *bool          `json:"add-to-dns,omitempty" yaml:"add-to-dns,omitempty" default_value:"true"`
-	HostNameIs         HostNameType   `json:"hostname-is" yaml:"hostname-is"`
-	LoadBalancer       LoadBalancer   `json:"load-balancer" yaml:"load-balancer"`
-	MaxScale           *int           `json:"max-scale,omitempty" yaml:"max-scale,omitempty" default_value:"1"`
-	NetConf            NetConf        `json:"net-conf" yaml:"net-conf"`
-	NetPolicy          NetPolicy      `json:"net-policy" yaml:"net-policy"`
-	RemoveDockerLinks  *bool          `json:"remove-docker-links,omitempty" yaml:"remove-docker-links,omitempty" default_value:"false"`
-	RemovePortBindings *bool          `json:"remove-port-bindings,omitempty" yaml:"remove-port-bindings,omitempty" default_value:"false"`
-	ServiceKeyIs       ServiceKeyType `json:"service-key-is" yaml:"service-key-is"`
+	// AddAnnotations and AddLabels are added to the container or kubernetes
+	// object, their values are templates executed with MetadataValues.
+	AddAnnotations     map[string]string `json:"add-annotations,omitempty" yaml:"add-annotations,omitempty"`
+	AddArguments       *[]string         `json:"add-arguments,omitempty" yaml:"add-arguments,omitempty"`
+	AddLabels          map[string]string `json:"add-labels,omitempty" yaml:"add-labels,omitempty"`
+	AddToDNS           *bool             `json:"add-to-dns,omitempty" yaml:"add-to-dns,omitempty" default_value:"true"`
+	HostNameIs         HostNameType      `json:"hostname-is" yaml:"hostname-is"`
+	LoadBalancer       LoadBalancer      `json:"load-balancer" yaml:"load-balancer"`
+	MaxScale           *int              `json:"max-scale,omitempty" yaml:"max-scale,omitempty" default_value:"1"`
+	NetConf            NetConf           `json:"net-conf" yaml:"net-conf"`
+	NetPolicy          NetPolicy         `json:"net-policy" yaml:"net-policy"`
+	RemoveDockerLinks  *bool             `json:"remove-docker-links,omitempty" yaml:"remove-docker-links,omitempty" default_value:"false"`
+	RemovePortBindings *bool             `json:"remove-port-bindings,omitempty" yaml:"remove-port-bindings,omitempty" default_value:"false"`
+	ServiceKeyIs       ServiceKeyType    `json:"service-key-is" yaml:"service-key-is"`
 }
 
 // GoString is the implementation of the GoStringer interface so we can easily
 // print the intent fields.
 func (i Intent) GoString() string {
 	var retStr string
+	retStr += fmt.Sprintf("Intent.AddAnnotations: %v, ", i.AddAnnotations)
 	if i.AddArguments != nil {
 		retStr += fmt.Sprintf("Intent.AddArguments: '%s', ", strings.Join(*i.AddArguments, "', '"))
 	} else {
 		retStr += "Intent.AddArguments: (nil), "
 	}
+	retStr += fmt.Sprintf("Intent.AddLabels: %v, ", i.AddLabels)
 	if i.AddToDNS != nil {
 		retStr += fmt.Sprintf("Intent.AddToDNS: %t, ", *i.AddToDNS)
 	} else {
@@ -132,6 +138,12 @@ type NetConf struct {
 	Group     *int    `json:"group,omitempty" yaml:"group,omitempty" default_value:"1"`
 	BD        *int    `json:"bd,omitempty" yaml:"bd,omitempty" default_value:"1"`
 	Namespace *int    `json:"namespace,omitempty" yaml:"namespace,omitempty" default_value:"1"`
+	// Network is the name of a network, when set its subnets, gateway, BD
+	// and namespace replace CIDR, Gw, BD and Namespace.
+	Network *string `json:"network,omitempty" yaml:"network,omitempty" default_value:""`
+	// AutoGroup replaces Group with the ID of the identity allocated to the
+	// container's identity labels.
+	AutoGroup *bool `json:"auto-group,omitempty" yaml:"auto-group,omitempty" default_value:"false"`
 }
 
 // GoString is the implementation of the GoStringer interface so we can easily
@@ -174,9 +186,19 @@ func (nc NetConf) GoString() string {
 		retStr += "NetConf.BD: (nil), "
 	}
 	if nc.Namespace != nil {
-		retStr += fmt.Sprintf("NetConf.Namespace: %d", *nc.Namespace)
+		retStr += fmt.Sprintf("NetConf.Namespace: %d, ", *nc.Namespace)
 	} else {
-		retStr += "NetConf.Namespace: (nil)"
+		retStr += "NetConf.Namespace: (nil), "
+	}
+	if nc.Network != nil {
+		retStr += fmt.Sprintf("NetConf.Network: %s, ", *nc.Network)
+	} else {
+		retStr += "NetConf.Network: (nil), "
+	}
+	if nc.AutoGroup != nil {
+		retStr += fmt.Sprintf("NetConf.AutoGroup: %t", *nc.AutoGroup)
+	} else {
+		retStr += "NetConf.AutoGroup: (nil)"
 	}
 	return retStr
 }
@@ -250,8 +272,10 @@ func (i *Intent) GetHostNameFromLabels(labels map[string]string) string {
 
 // getDefaultOf returns the field's value of Default's tag.
 // For example:
-//  foo int `default_value:"1234"`
-//  bar string `default_value:"something"`
+//
+//	foo int `default_value:"1234"`
+//	bar string `default_value:"something"`
+//
 // will return "1234" for 'foo' field and "something" for 'bar' field.
 func getDefaultOf(structure interface{}, field string) string {
 	if val, ok := reflect.ValueOf(structure).Type().FieldByName(field); ok {
@@ -304,6 +328,12 @@ func (i *Intent) SetDefaults() {
 	if namespace, err := strconv.ParseInt(getDefaultOf(i.NetConf, "Namespace"), 10, 32); err == nil {
 		*i.NetConf.Namespace = int(namespace)
 	}
+	i.NetConf.Network = new(string)
+	*i.NetConf.Network = getDefaultOf(i.NetConf, "Network")
+	i.NetConf.AutoGroup = new(bool)
+	if autoGroup, err := strconv.ParseBool(getDefaultOf(i.NetConf, "AutoGroup")); err == nil {
+		*i.NetConf.AutoGroup = autoGroup
+	}
 	i.NetPolicy.OVSConfig.ConfigFiles = &[]string{}
 	i.NetPolicy.OVSConfig.Rules = &[]string{}
 	i.RemoveDockerLinks = new(bool)
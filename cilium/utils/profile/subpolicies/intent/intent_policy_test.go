@@ -7,13 +7,14 @@ import (
 )
 
 var wantintentconfiggostr = `IntentConfig.Priority: 500, IntentConfig.Config ` +
-	`Intent.AddArguments: 'foo', 'bar', Intent.AddToDNS: true, Intent.HostNameIs ` +
+	`Intent.AddAnnotations: map[], Intent.AddArguments: 'foo', 'bar', ` +
+	`Intent.AddLabels: map[], Intent.AddToDNS: true, Intent.HostNameIs ` +
 	`HostNameType.Label: ^com\.intent\.logical-name$, Intent.LoadBalancer ` +
 	`LoadBalancer.Name: web, LoadBalancer.TrafficType: http, LoadBalancer.BindPort: ` +
 	`80, Intent.MaxScale: 4, Intent.NetConf: NetConf.Br: lxc-br0, NetConf.CIDR: ` +
 	`1.1.0.0/25, NetConf.MAC: 00:01:02:03:04:05, NetConf.Gw: 1.1.0.126, ` +
 	`NetConf.Route: 192.168.50.0/24 via 172.17.42.1, NetConf.Group: 3, NetConf.BD: 5, ` +
-	`NetConf.Namespace: 9, Intent.NetPolicy: NetPolicy.OVSConfig: OVSConfig.ConfigFiles: ` +
+	`NetConf.Namespace: 9, NetConf.Network: (nil), NetConf.AutoGroup: (nil), Intent.NetPolicy: NetPolicy.OVSConfig: OVSConfig.ConfigFiles: ` +
 	`'operator-ovs-intent-web-service.yml', 'operator-ovs-intent-dns.yml', ` +
 	`OVSConfig.Rules: (nil), Intent.RemoveDockerLinks: true, Intent.RemovePortBindings: ` +
 	`false, Intent.ServiceKeyIs ServiceKeyType.Label: ^com\.intent\.logical-name$`
@@ -16,14 +16,16 @@ var (
 		`"operator-ovs-intent-web-service.yml","operator-ovs-intent-dns.yml"]}},` +
 		`"remove-docker-links":true,"remove-port-bindings":false,"service-key-is":` +
 		`{"label":"^com\\.intent\\.logical-name$"}}`
-	wantintentgostr = `Intent.AddArguments: 'foo', 'bar', Intent.AddToDNS: true, ` +
+	wantintentgostr = `Intent.AddAnnotations: map[], Intent.AddArguments: 'foo', 'bar', ` +
+		`Intent.AddLabels: map[], Intent.AddToDNS: true, ` +
 		`Intent.HostNameIs HostNameType.Label: ^com\.intent\.logical-name$, ` +
 		`Intent.LoadBalancer LoadBalancer.Name: web, LoadBalancer.TrafficType: ` +
 		`http, LoadBalancer.BindPort: 80, Intent.MaxScale: 4, Intent.NetConf: ` +
 		`NetConf.Br: lxc-br0, NetConf.CIDR: 1.1.0.0/25, NetConf.MAC: ` +
 		`00:01:02:03:04:05, NetConf.Gw: 1.1.0.126, NetConf.Route: ` +
 		`192.168.50.0/24 via 172.17.42.1, NetConf.Group: 3, NetConf.BD: 5, ` +
-		`NetConf.Namespace: 9, Intent.NetPolicy: NetPolicy.OVSConfig: OVSConfig.ConfigFiles: ` +
+		`NetConf.Namespace: 9, NetConf.Network: (nil), NetConf.AutoGroup: (nil), ` +
+		`Intent.NetPolicy: NetPolicy.OVSConfig: OVSConfig.ConfigFiles: ` +
 		`'operator-ovs-intent-web-service.yml', 'operator-ovs-intent-dns.yml', ` +
 		`OVSConfig.Rules: (nil), Intent.RemoveDockerLinks: true, ` +
 		`Intent.RemovePortBindings: false, Intent.ServiceKeyIs ServiceKeyType.Label: ` +
@@ -141,7 +143,8 @@ func TestNetConfGoString(t *testing.T) {
 	gotNetConfStr := i.NetConf.GoString()
 	wantNetConfgostr := `NetConf.Br: lxc-br0, NetConf.CIDR: 1.1.0.0/25, ` +
 		`NetConf.MAC: 00:01:02:03:04:05, NetConf.Gw: 1.1.0.126, NetConf.Route: ` +
-		`192.168.50.0/24 via 172.17.42.1, NetConf.Group: 3, NetConf.BD: 5, NetConf.Namespace: 9`
+		`192.168.50.0/24 via 172.17.42.1, NetConf.Group: 3, NetConf.BD: 5, NetConf.Namespace: 9, ` +
+		`NetConf.Network: (nil), NetConf.AutoGroup: (nil)`
 	if gotNetConfStr != wantNetConfgostr {
 		t.Errorf("invalid NetConf gotten:\ngot  %s\nwant %s\n", gotNetConfStr, wantNetConfgostr)
 	}
@@ -264,6 +267,12 @@ func TestSetDefaults(t *testing.T) {
 	if i.NetConf.Namespace == nil || *i.NetConf.Namespace != 1 {
 		t.Errorf("invalid NetConf.Namespace:\ngot  %+v\nwant %d", i.NetConf.Namespace, 1)
 	}
+	if i.NetConf.Network == nil || *i.NetConf.Network != "" {
+		t.Errorf("invalid NetConf.Network:\ngot  %+v\nwant %s", i.NetConf.Network, "")
+	}
+	if i.NetConf.AutoGroup == nil || *i.NetConf.AutoGroup != false {
+		t.Errorf("invalid NetConf.AutoGroup:\ngot  %+v\nwant %t", i.NetConf.AutoGroup, false)
+	}
 	if i.NetPolicy.OVSConfig.ConfigFiles == nil || len(*i.NetPolicy.OVSConfig.ConfigFiles) != 0 {
 		t.Errorf("invalid NetPolicy.OVSConfig.ConfigFiles:\ngot  %+v\nwant %s", i.NetPolicy.OVSConfig.ConfigFiles, "&[]")
 	}
@@ -0,0 +1,54 @@
+package intent
+
+import (
+	"bytes"
+	"fmt"
+	"text/template"
+)
+
+// MetadataValues are the values available to the templates of AddLabels and
+// AddAnnotations, e.g. "{{.Owner}}-{{.Group}}".
+type MetadataValues struct {
+	// Owner and Policy are the owner and name of the policy that adds the
+	// label or annotation.
+	Owner  string
+	Policy string
+	// Name is the name of the container or kubernetes object.
+	Name  string
+	Group int
+	// IP is the IP set by net-conf, empty unless its CIDR is a single IP
+	// since IPs from a network are only allocated once the container
+	// starts.
+	IP string
+}
+
+// ValidateMetadata returns an error if any template of the receiver's
+// AddLabels or AddAnnotations is invalid.
+func (i Intent) ValidateMetadata() error {
+	for _, metadata := range []map[string]string{i.AddLabels, i.AddAnnotations} {
+		for key, value := range metadata {
+			if _, err := template.New(key).Option("missingkey=error").Parse(value); err != nil {
+				return fmt.Errorf("invalid template of %s: %s", key, err)
+			}
+		}
+	}
+	return nil
+}
+
+// RenderMetadata returns the given labels or annotations with their values
+// replaced by the result of executing them, as templates, with values.
+func RenderMetadata(metadata map[string]string, values MetadataValues) (map[string]string, error) {
+	rendered := map[string]string{}
+	for key, value := range metadata {
+		tmpl, err := template.New(key).Option("missingkey=error").Parse(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid template of %s: %s", key, err)
+		}
+		var buf bytes.Buffer
+		if err := tmpl.Execute(&buf, values); err != nil {
+			return nil, fmt.Errorf("invalid template of %s: %s", key, err)
+		}
+		rendered[key] = buf.String()
+	}
+	return rendered, nil
+}
@@ -0,0 +1,41 @@
+package intent
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestRenderMetadata(t *testing.T) {
+	metadata := map[string]string{
+		"com.intent.service": "{{.Owner}}-{{.Name}}",
+		"com.cilium.group":   "{{.Group}}",
+		"com.cilium.ip":      "{{.IP}}",
+		"team":               "web",
+	}
+	values := MetadataValues{Owner: "foo", Policy: "web", Name: "nginx", Group: 2, IP: "10.0.0.5"}
+	got, err := RenderMetadata(metadata, values)
+	if err != nil {
+		t.Fatalf("error while rendering metadata: %s", err)
+	}
+	want := map[string]string{
+		"com.intent.service": "foo-nginx",
+		"com.cilium.group":   "2",
+		"com.cilium.ip":      "10.0.0.5",
+		"team":               "web",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("invalid metadata:\ngot  %v\nwant %v", got, want)
+	}
+	if _, err := RenderMetadata(map[string]string{"bad": "{{.Unknown}}"}, values); err == nil {
+		t.Errorf("unknown value was rendered")
+	}
+}
+
+func TestValidateMetadata(t *testing.T) {
+	if err := (Intent{AddLabels: map[string]string{"owner": "{{.Owner}}"}}).ValidateMetadata(); err != nil {
+		t.Errorf("valid template was rejected: %s", err)
+	}
+	if err := (Intent{AddAnnotations: map[string]string{"owner": "{{.Owner"}}).ValidateMetadata(); err == nil {
+		t.Errorf("invalid template was accepted")
+	}
+}
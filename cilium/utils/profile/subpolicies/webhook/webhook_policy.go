@@ -0,0 +1,110 @@
+package webhook_policy
+
+import (
+	"fmt"
+	"time"
+)
+
+const (
+	// FailOpen lets requests through when the webhook can't be reached or
+	// returns an invalid response.
+	FailOpen = "fail-open"
+	// FailClosed denies requests when the webhook can't be reached or returns
+	// an invalid response.
+	FailClosed = "fail-closed"
+
+	// DefaultTimeout is the time given to webhooks that don't set one.
+	DefaultTimeout = 5 * time.Second
+	// DefaultHook is the hook type where webhooks that don't set any are
+	// called.
+	DefaultHook = "pre-hook"
+)
+
+// WebhookConfig has the external HTTP endpoints that are called, in order,
+// with the requests covered by a policy.
+type WebhookConfig struct {
+	Webhooks []Webhook `json:"webhooks,omitempty" yaml:"webhooks,omitempty"`
+}
+
+// Validate returns an error if any of the receiver's webhooks is invalid.
+func (wc WebhookConfig) Validate() error {
+	for _, webhook := range wc.Webhooks {
+		if err := webhook.Validate(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Webhook is an external HTTP endpoint that receives the request being
+// processed and returns a decision and, optionally, a JSON patch for it.
+type Webhook struct {
+	Name string `json:"name,omitempty" yaml:"name,omitempty"`
+	URL  string `json:"url,omitempty" yaml:"url,omitempty"`
+	// Hooks are the hook types, pre-hook and/or post-hook, where the webhook
+	// is called. Defaults to pre-hook.
+	Hooks []string `json:"hooks,omitempty" yaml:"hooks,omitempty"`
+	// Requests are the request types, e.g. DockerDaemonCreate, where the
+	// webhook is called. Empty means all request types.
+	Requests []string `json:"requests,omitempty" yaml:"requests,omitempty"`
+	// Timeout is a duration, e.g. "500ms", defaults to DefaultTimeout.
+	Timeout       string `json:"timeout,omitempty" yaml:"timeout,omitempty"`
+	FailurePolicy string `json:"failure-policy,omitempty" yaml:"failure-policy,omitempty"`
+}
+
+// Validate returns an error if the receiver's Webhook is invalid.
+func (w Webhook) Validate() error {
+	if w.URL == "" {
+		return fmt.Errorf("webhook %q has an empty url", w.Name)
+	}
+	if _, err := w.GetTimeout(); err != nil {
+		return err
+	}
+	switch w.FailurePolicy {
+	case "", FailOpen, FailClosed:
+	default:
+		return fmt.Errorf("webhook %q has an invalid failure-policy %q, valid options are (%s|%s)",
+			w.Name, w.FailurePolicy, FailOpen, FailClosed)
+	}
+	return nil
+}
+
+// GetTimeout returns the receiver's timeout or DefaultTimeout if not set.
+func (w Webhook) GetTimeout() (time.Duration, error) {
+	if w.Timeout == "" {
+		return DefaultTimeout, nil
+	}
+	timeout, err := time.ParseDuration(w.Timeout)
+	if err != nil {
+		return 0, fmt.Errorf("webhook %q has an invalid timeout: %s", w.Name, err)
+	}
+	return timeout, nil
+}
+
+// FailsOpen returns true if requests should be let through when the
+// receiver's Webhook fails. Webhooks fail closed by default.
+func (w Webhook) FailsOpen() bool {
+	return w.FailurePolicy == FailOpen
+}
+
+// Matches returns true if the receiver's Webhook should be called for the
+// given hook and request types.
+func (w Webhook) Matches(hookType, reqType string) bool {
+	hooks := w.Hooks
+	if len(hooks) == 0 {
+		hooks = []string{DefaultHook}
+	}
+	if !contains(hooks, hookType) {
+		return false
+	}
+	return len(w.Requests) == 0 || contains(w.Requests, reqType)
+}
+
+func contains(slice []string, s string) bool {
+	for _, elem := range slice {
+		if elem == s {
+			return true
+		}
+	}
+	return false
+}
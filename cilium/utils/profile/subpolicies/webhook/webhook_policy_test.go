@@ -0,0 +1,50 @@
+package webhook_policy
+
+import (
+	"testing"
+	"time"
+)
+
+func TestWebhookMatches(t *testing.T) {
+	tests := []struct {
+		webhook  Webhook
+		hookType string
+		reqType  string
+		want     bool
+	}{
+		{Webhook{}, "pre-hook", "DockerDaemonCreate", true},
+		{Webhook{}, "post-hook", "DockerDaemonStart", false},
+		{Webhook{Hooks: []string{"post-hook"}}, "post-hook", "DockerDaemonStart", true},
+		{Webhook{Requests: []string{"DockerSwarmCreate"}}, "pre-hook", "DockerDaemonCreate", false},
+		{Webhook{Requests: []string{"DockerSwarmCreate"}}, "pre-hook", "DockerSwarmCreate", true},
+	}
+	for _, tt := range tests {
+		if got := tt.webhook.Matches(tt.hookType, tt.reqType); got != tt.want {
+			t.Errorf("invalid match of %+v for %s %s:\ngot  %t\nwant %t", tt.webhook, tt.hookType, tt.reqType, got, tt.want)
+		}
+	}
+}
+
+func TestWebhookValidate(t *testing.T) {
+	tests := []struct {
+		webhook Webhook
+		valid   bool
+	}{
+		{Webhook{URL: "http://localhost"}, true},
+		{Webhook{}, false},
+		{Webhook{URL: "http://localhost", Timeout: "forever"}, false},
+		{Webhook{URL: "http://localhost", FailurePolicy: "maybe"}, false},
+		{Webhook{URL: "http://localhost", Timeout: "100ms", FailurePolicy: FailOpen}, true},
+	}
+	for _, tt := range tests {
+		if err := tt.webhook.Validate(); (err == nil) != tt.valid {
+			t.Errorf("invalid validation of %+v:\ngot  %v\nwant valid=%t", tt.webhook, err, tt.valid)
+		}
+	}
+	if timeout, _ := (Webhook{}).GetTimeout(); timeout != DefaultTimeout {
+		t.Errorf("invalid default timeout:\ngot  %s\nwant %s", timeout, DefaultTimeout)
+	}
+	if timeout, _ := (Webhook{Timeout: "100ms"}).GetTimeout(); timeout != 100*time.Millisecond {
+		t.Errorf("invalid timeout:\ngot  %s\nwant %s", timeout, 100*time.Millisecond)
+	}
+}
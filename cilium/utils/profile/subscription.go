@@ -0,0 +1,75 @@
+package profile
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"time"
+)
+
+// DefaultSubscriptionTimeout is the time given to subscriptions that don't
+// set one to receive an event.
+const DefaultSubscriptionTimeout = 5 * time.Second
+
+// Subscription is an outbound webhook where every node posts its cilium
+// events, e.g. IP allocations or denied requests.
+type Subscription struct {
+	Name string `json:"name,omitempty" yaml:"name,omitempty"`
+	URL  string `json:"url,omitempty" yaml:"url,omitempty"`
+	// Types are the types of the events posted, all if not set.
+	Types []string `json:"types,omitempty" yaml:"types,omitempty"`
+	// Timeout is a duration, e.g. "500ms", defaults to
+	// DefaultSubscriptionTimeout.
+	Timeout string `json:"timeout,omitempty" yaml:"timeout,omitempty"`
+}
+
+// Value marshals the receiver Subscription into a json string.
+func (s Subscription) Value() (string, error) {
+	if data, err := json.Marshal(s); err != nil {
+		return "", err
+	} else {
+		return string(data), err
+	}
+}
+
+// Scan unmarshals the input into the receiver Subscription.
+func (s *Subscription) Scan(input string) error {
+	return json.Unmarshal([]byte(input), s)
+}
+
+// Validate returns an error if the receiver's Subscription is invalid.
+func (s Subscription) Validate() error {
+	if s.Name == "" {
+		return fmt.Errorf("subscription without name")
+	}
+	if u, err := url.Parse(s.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return fmt.Errorf("subscription %s has an invalid url %q", s.Name, s.URL)
+	}
+	if _, err := s.GetTimeout(); err != nil {
+		return fmt.Errorf("subscription %s has an invalid timeout: %s", s.Name, err)
+	}
+	return nil
+}
+
+// GetTimeout returns the receiver's Timeout or DefaultSubscriptionTimeout if
+// it isn't set.
+func (s Subscription) GetTimeout() (time.Duration, error) {
+	if s.Timeout == "" {
+		return DefaultSubscriptionTimeout, nil
+	}
+	return time.ParseDuration(s.Timeout)
+}
+
+// Wants returns true if events of the given type are posted to the receiver's
+// Subscription.
+func (s Subscription) Wants(eventType string) bool {
+	if len(s.Types) == 0 {
+		return true
+	}
+	for _, typ := range s.Types {
+		if typ == eventType {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,94 @@
+package utils
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"sync"
+
+	ucdb "github.com/cilium-team/cilium/cilium/utils/comm/db"
+	up "github.com/cilium-team/cilium/cilium/utils/profile"
+)
+
+// routerCookieFlag marks the cookies of the flows of the namespaces' routers.
+// Endpoints' cookies have, at most, 56 bits and the overlay peers' ones have
+// the 57th bit so they never collide with them.
+const routerCookieFlag = uint64(1) << 57
+
+var (
+	// installedRouters are the commands that installed the routers on this
+	// node, by bridge and namespace.
+	installedRouters      = map[string]string{}
+	installedRoutersMutex sync.Mutex
+)
+
+// SetupRouter installs, on the given bridge, the router of the given namespace
+// and returns the MACs that the L3 flows of an endpoint with the given IP
+// match, its gateway's first, and the endpoint's gateway. The MACs are nil if
+// the namespace doesn't have a router, its endpoints use the default one.
+func SetupRouter(dbConn ucdb.Db, bridge string, namespace int, ip net.IP) ([]string, up.Gateway, error) {
+	routers, err := dbConn.GetRouters()
+	if err != nil {
+		return nil, up.Gateway{}, err
+	}
+	router, ok := up.RouterOf(routers, namespace)
+	if !ok {
+		return nil, up.Gateway{}, nil
+	}
+	if err := EnsureRouter(routers, bridge, namespace); err != nil {
+		return nil, up.Gateway{}, err
+	}
+	gw, _ := router.GatewayOf(ip)
+	return router.MACs(ip), gw, nil
+}
+
+// EnsureRouter installs, on the given bridge, the flows of the router, from
+// the given ones, of the given namespace: the ARP responders of its gateways
+// and its routes to other namespaces. They are installed again if the router,
+// or the routers it routes to, changed. The script is set under 'ADD_ROUTER'
+// environment variable.
+func EnsureRouter(routers []up.Router, bridge string, namespace int) error {
+	router, ok := up.RouterOf(routers, namespace)
+	if !ok {
+		return nil
+	}
+	gateways := []string{}
+	for _, gw := range router.Gateways {
+		gateways = append(gateways, gw.IP+","+gw.MAC)
+	}
+	routes := []string{}
+	for _, route := range router.Routes {
+		// Any MAC of the other namespace's router reaches its endpoints.
+		to, _ := up.RouterOf(routers, route.Namespace)
+		mac := to.MACs(nil)[0]
+		for _, subnet := range route.Subnets {
+			routes = append(routes, fmt.Sprintf("%s,%d,%s", subnet, route.Namespace, mac))
+		}
+	}
+	addRouterCmd := fmt.Sprintf("%s%s %d %#x '%s' '%s'", bridgeEnv(bridge), os.Getenv("ADD_ROUTER"),
+		namespace, routerCookieFlag|uint64(namespace), strings.Join(gateways, " "), strings.Join(routes, " "))
+
+	key := fmt.Sprintf("%s/%d", bridge, namespace)
+	installedRoutersMutex.Lock()
+	defer installedRoutersMutex.Unlock()
+	if installedRouters[key] == addRouterCmd {
+		return nil
+	}
+	if _, err := execShCommand(addRouterCmd); err != nil {
+		return fmt.Errorf("unable to install router of namespace %d: %s", namespace, err)
+	}
+	log.Info("Router of namespace %d is installed in bridge %s", namespace, bridge)
+	installedRouters[key] = addRouterCmd
+	return nil
+}
+
+// routerEnv returns the environment, to prefix the backend scripts with, that
+// makes the L3 flows of an endpoint match the given router MACs, instead of
+// the default router's one, and use the first one as source.
+func routerEnv(macs []string) string {
+	if len(macs) == 0 {
+		return ""
+	}
+	return "ROUTER_MACS=" + strings.Join(macs, ",") + " "
+}
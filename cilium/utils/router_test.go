@@ -0,0 +1,74 @@
+package utils
+
+import (
+	"net"
+	"os"
+	"reflect"
+	"testing"
+
+	ucdb "github.com/cilium-team/cilium/cilium/utils/comm/db"
+	up "github.com/cilium-team/cilium/cilium/utils/profile"
+)
+
+func TestSetupRouter(t *testing.T) {
+	fdb := ucdb.NewMemConn()
+	routers := []up.Router{
+		{
+			Namespace: 2,
+			Gateways: []up.Gateway{
+				{Subnet: "10.1.0.0/24", IP: "10.1.0.1", MAC: "aa:aa:aa:aa:aa:01"},
+				{Subnet: "10.2.0.0/24", IP: "10.2.0.1", MAC: "aa:aa:aa:aa:aa:02"},
+			},
+			Routes: []up.Route{{Namespace: 3, Subnets: []string{"10.3.0.0/24"}}},
+		},
+		{
+			Namespace: 3,
+			Gateways:  []up.Gateway{{Subnet: "10.3.0.0/24", IP: "10.3.0.1", MAC: "aa:aa:aa:aa:aa:03"}},
+		},
+	}
+	for _, router := range routers {
+		if err := fdb.PutRouter(router); err != nil {
+			t.Fatal(err)
+		}
+	}
+	os.Setenv("ADD_ROUTER", "/opt/backend/add-router.sh")
+	cmds := []string{}
+	execShCommand = func(strCmd string) ([]byte, error) {
+		cmds = append(cmds, strCmd)
+		return nil, nil
+	}
+
+	for i := 0; i < 2; i++ {
+		macs, gw, err := SetupRouter(fdb, DefaultBridge, 2, net.ParseIP("10.2.0.5"))
+		if err != nil {
+			t.Fatalf("error while setting up router: %s", err)
+		}
+		if want := []string{"aa:aa:aa:aa:aa:02", "aa:aa:aa:aa:aa:01"}; !reflect.DeepEqual(macs, want) {
+			t.Errorf("invalid router MACs:\ngot  %v\nwant %v", macs, want)
+		}
+		if gw != routers[0].Gateways[1] {
+			t.Errorf("invalid gateway:\ngot  %+v\nwant %+v", gw, routers[0].Gateways[1])
+		}
+	}
+	// The router is only installed again when it changes.
+	want := []string{"/opt/backend/add-router.sh 2 0x200000000000002 " +
+		"'10.1.0.1,aa:aa:aa:aa:aa:01 10.2.0.1,aa:aa:aa:aa:aa:02' '10.3.0.0/24,3,aa:aa:aa:aa:aa:03'"}
+	if !reflect.DeepEqual(cmds, want) {
+		t.Errorf("invalid commands:\ngot  %v\nwant %v", cmds, want)
+	}
+	routers[1].Gateways[0].MAC = "aa:aa:aa:aa:aa:04"
+	if err := fdb.PutRouter(routers[1]); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := SetupRouter(fdb, DefaultBridge, 2, net.ParseIP("10.2.0.5")); err != nil {
+		t.Fatal(err)
+	}
+	if len(cmds) != 2 || cmds[1] != "/opt/backend/add-router.sh 2 0x200000000000002 "+
+		"'10.1.0.1,aa:aa:aa:aa:aa:01 10.2.0.1,aa:aa:aa:aa:aa:02' '10.3.0.0/24,3,aa:aa:aa:aa:aa:04'" {
+		t.Errorf("router wasn't installed again after the routed namespace changed:\ngot  %v", cmds)
+	}
+
+	if macs, gw, err := SetupRouter(fdb, DefaultBridge, 4, net.ParseIP("10.4.0.5")); err != nil || macs != nil || gw.IP != "" {
+		t.Errorf("invalid router of namespace without router:\ngot  %v, %+v, %v\nwant no MACs nor gateway", macs, gw, err)
+	}
+}
@@ -0,0 +1,81 @@
+package secrets
+
+import (
+	"encoding/json"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Redacted replaces the values of secrets in redacted output.
+const Redacted = "[REDACTED]"
+
+var (
+	redactMutex sync.RWMutex
+	redacted    = map[string]bool{}
+	replacer    = strings.NewReplacer()
+)
+
+// Redact marks the given value to be replaced by Redacted in the output of
+// RedactString and of the writers returned by NewRedactingWriter. The value
+// is also redacted when it's escaped inside a json string.
+func Redact(value string) {
+	if value == "" {
+		return
+	}
+	redactMutex.Lock()
+	defer redactMutex.Unlock()
+	if redacted[value] {
+		return
+	}
+	redacted[value] = true
+	values := []string{}
+	for v := range redacted {
+		values = append(values, v)
+	}
+	// Longer values go first so a value that contains another one is
+	// completely redacted.
+	sort.Sort(byDescendingLength(values))
+	oldnew := []string{}
+	for _, v := range values {
+		oldnew = append(oldnew, v, Redacted)
+		if data, err := json.Marshal(v); err == nil {
+			if escaped := string(data[1 : len(data)-1]); escaped != v {
+				oldnew = append(oldnew, escaped, Redacted)
+			}
+		}
+	}
+	replacer = strings.NewReplacer(oldnew...)
+}
+
+// RedactString returns s with all values marked to be redacted replaced by
+// Redacted.
+func RedactString(s string) string {
+	redactMutex.RLock()
+	defer redactMutex.RUnlock()
+	return replacer.Replace(s)
+}
+
+type redactingWriter struct {
+	w io.Writer
+}
+
+// NewRedactingWriter returns a writer that writes into w everything written
+// into it with all values marked to be redacted replaced by Redacted.
+func NewRedactingWriter(w io.Writer) io.Writer {
+	return redactingWriter{w: w}
+}
+
+func (rw redactingWriter) Write(p []byte) (int, error) {
+	if _, err := io.WriteString(rw.w, RedactString(string(p))); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+type byDescendingLength []string
+
+func (s byDescendingLength) Len() int           { return len(s) }
+func (s byDescendingLength) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+func (s byDescendingLength) Less(i, j int) bool { return len(s[i]) > len(s[j]) }
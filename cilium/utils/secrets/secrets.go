@@ -0,0 +1,123 @@
+package secrets
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+
+	up "github.com/cilium-team/cilium/cilium/utils/profile"
+
+	"github.com/cilium-team/cilium/Godeps/_workspace/src/github.com/op/go-logging"
+)
+
+// DefaultKeyFile is the file with the key used to encrypt and decrypt the
+// secrets stored in the database. All nodes must have the same key.
+const DefaultKeyFile = "/etc/cilium/secrets.key"
+
+var (
+	log = logging.MustGetLogger("cilium")
+
+	keyMutex sync.RWMutex
+	aead     cipher.AEAD
+)
+
+// SetKey sets the key, 32 bytes hex encoded, used to encrypt and decrypt
+// secrets.
+func SetKey(hexKey string) error {
+	key, err := hex.DecodeString(strings.TrimSpace(hexKey))
+	if err != nil {
+		return fmt.Errorf("invalid secrets key: %s", err)
+	}
+	if len(key) != 32 {
+		return fmt.Errorf("invalid secrets key: it has %d bytes, 32 are needed", len(key))
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return err
+	}
+	keyMutex.Lock()
+	defer keyMutex.Unlock()
+	aead = gcm
+	return nil
+}
+
+// LoadKeyFile sets the key used to encrypt and decrypt secrets from the given
+// file. If the file doesn't exist, secrets can't be stored nor used.
+func LoadKeyFile(file string) error {
+	data, err := ioutil.ReadFile(file)
+	if os.IsNotExist(err) {
+		log.Info("Secrets key file %s doesn't exist, secrets are disabled", file)
+		return nil
+	} else if err != nil {
+		return err
+	}
+	return SetKey(string(data))
+}
+
+func getAEAD() (cipher.AEAD, error) {
+	keyMutex.RLock()
+	defer keyMutex.RUnlock()
+	if aead == nil {
+		return nil, fmt.Errorf("there isn't any secrets key loaded")
+	}
+	return aead, nil
+}
+
+// Encrypt returns the given secret with its plain text replaced by its
+// ciphertext. The secret's name is authenticated with the ciphertext so it
+// can't be copied into another secret.
+func Encrypt(secret up.Secret) (up.Secret, error) {
+	gcm, err := getAEAD()
+	if err != nil {
+		return secret, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return secret, err
+	}
+	sealed := gcm.Seal(nonce, nonce, []byte(secret.Plaintext), []byte(secret.Name))
+	secret.Plaintext = ""
+	secret.Ciphertext = base64.StdEncoding.EncodeToString(sealed)
+	return secret, nil
+}
+
+// Decrypt returns the plain text of the given secret. The plain text is
+// redacted from all output written through a RedactingWriter from now on.
+func Decrypt(secret up.Secret) (string, error) {
+	gcm, err := getAEAD()
+	if err != nil {
+		return "", err
+	}
+	sealed, err := base64.StdEncoding.DecodeString(secret.Ciphertext)
+	if err != nil || len(sealed) < gcm.NonceSize() {
+		return "", fmt.Errorf("secret %s has an invalid ciphertext", secret.Name)
+	}
+	nonce, ciphertext := sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():]
+	plaintext, err := gcm.Open(nil, nonce, ciphertext, []byte(secret.Name))
+	if err != nil {
+		return "", fmt.Errorf("unable to decrypt secret %s: %s", secret.Name, err)
+	}
+	Redact(string(plaintext))
+	return string(plaintext), nil
+}
+
+// RedactAll marks the values of all given secrets, that can be decrypted, to
+// be redacted.
+func RedactAll(secrets []up.Secret) {
+	for _, secret := range secrets {
+		if _, err := Decrypt(secret); err != nil {
+			log.Warning("Unable to redact secret %s: %s", secret.Name, err)
+		}
+	}
+}
@@ -0,0 +1,46 @@
+package secrets
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	up "github.com/cilium-team/cilium/cilium/utils/profile"
+)
+
+const testKey = "000102030405060708090a0b0c0d0e0f101112131415161718191a1b1c1d1e1f"
+
+func TestEncryptDecrypt(t *testing.T) {
+	if err := SetKey(testKey); err != nil {
+		t.Fatalf("error while setting key: %s", err)
+	}
+	secret, err := Encrypt(up.Secret{Name: "db/prod/password", Owner: "foo", Plaintext: "s3cr3t"})
+	if err != nil {
+		t.Fatalf("error while encrypting secret: %s", err)
+	}
+	if secret.Plaintext != "" || strings.Contains(secret.Ciphertext, "s3cr3t") {
+		t.Errorf("encrypted secret has its plain text: %+v", secret)
+	}
+	if got, err := Decrypt(secret); err != nil || got != "s3cr3t" {
+		t.Errorf("invalid plain text:\ngot  %q, %v\nwant %q", got, err, "s3cr3t")
+	}
+
+	secret.Name = "db/dev/password"
+	if _, err := Decrypt(secret); err == nil {
+		t.Errorf("secret decrypted with another name")
+	}
+	if err := SetKey("0001"); err == nil {
+		t.Errorf("short key accepted")
+	}
+}
+
+func TestRedactingWriter(t *testing.T) {
+	Redact("pass")
+	Redact(`pass"word`)
+	var buf bytes.Buffer
+	w := NewRedactingWriter(&buf)
+	w.Write([]byte(`password={"Env":["A=pass\"word"]} pass` + "\n"))
+	if got, want := buf.String(), `[REDACTED]word={"Env":["A=[REDACTED]"]} [REDACTED]`+"\n"; got != want {
+		t.Errorf("invalid output:\ngot  %s\nwant %s", got, want)
+	}
+}
@@ -1,5 +1,10 @@
 package utils
 
+import (
+	ucdb "github.com/cilium-team/cilium/cilium/utils/comm/db"
+	up "github.com/cilium-team/cilium/cilium/utils/profile"
+)
+
 // LookupServiceName returns the service name from some pre defined labels.
 // Can be from "com.docker.compose.service" and "com.intent.service".
 func LookupServiceName(labels map[string]string) string {
@@ -14,3 +19,25 @@ func LookupServiceName(labels map[string]string) string {
 
 	return ""
 }
+
+// ReleaseServiceSlot frees the max-scale slot taken by the given container,
+// if any, so another instance of its service can be created.
+func ReleaseServiceSlot(dbConn ucdb.Db, containerID string) error {
+	services, err := dbConn.GetServiceSlots()
+	if err != nil {
+		return err
+	}
+	for _, slots := range services {
+		for _, slot := range slots.Slots {
+			if slot.Container != containerID {
+				continue
+			}
+			log.Info("Releasing slot of container %s from service %s", containerID, slots.Service)
+			return dbConn.UpdateServiceSlots(slots.Service, func(ss *up.ServiceSlots) error {
+				ss.ReleaseContainer(containerID)
+				return nil
+			})
+		}
+	}
+	return nil
+}
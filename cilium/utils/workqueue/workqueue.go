@@ -0,0 +1,219 @@
+// Package workqueue implements a keyed work queue. Tasks with the same key,
+// e.g. the events and hooks of one container, run strictly in the order they
+// were added while tasks with different keys run in parallel on a bounded
+// number of workers.
+package workqueue
+
+import (
+	"sync"
+	"time"
+
+	"github.com/cilium-team/cilium/Godeps/_workspace/src/github.com/op/go-logging"
+)
+
+const (
+	DefaultWorkers     = 8
+	DefaultCapacity    = 1024
+	DefaultMaxAttempts = 3
+	DefaultBackoff     = 1 * time.Second
+	// MaxDeadLetters is the number of failed tasks kept, the oldest ones
+	// are dropped first.
+	MaxDeadLetters = 256
+)
+
+var log = logging.MustGetLogger("cilium")
+
+// Task is a unit of work. Tasks returning an error are retried.
+type Task func() error
+
+// DeadLetter is a task that failed all its attempts.
+type DeadLetter struct {
+	Key      string    `json:"key"`
+	Name     string    `json:"name"`
+	Error    string    `json:"error"`
+	Attempts int       `json:"attempts"`
+	Time     time.Time `json:"time"`
+}
+
+type item struct {
+	name        string
+	task        Task
+	maxAttempts int
+	// done, if not nil, receives the result of the task.
+	done chan error
+}
+
+// Queue runs tasks in order by key.
+type Queue struct {
+	// MaxAttempts is the number of times a task added with Add is run
+	// before it's moved to the dead letters. Backoff is the time waited
+	// before the first retry, it doubles on each retry.
+	MaxAttempts int
+	Backoff     time.Duration
+
+	mutex    sync.Mutex
+	cond     *sync.Cond
+	capacity int
+	size     int
+	pending  map[string][]item
+	// ready are the keys with pending tasks and none running.
+	ready       []string
+	running     map[string]bool
+	deadLetters []DeadLetter
+	stopped     bool
+	wg          sync.WaitGroup
+}
+
+// NewQueue returns a Queue that runs tasks on the given number of workers.
+// Adding tasks blocks while capacity tasks are pending.
+func NewQueue(workers, capacity int) *Queue {
+	q := &Queue{
+		MaxAttempts: DefaultMaxAttempts,
+		Backoff:     DefaultBackoff,
+		capacity:    capacity,
+		pending:     map[string][]item{},
+		running:     map[string]bool{},
+	}
+	q.cond = sync.NewCond(&q.mutex)
+	for i := 0; i < workers; i++ {
+		q.wg.Add(1)
+		go q.work()
+	}
+	return q
+}
+
+// Add adds the given task to the ones with the given key. Failed tasks are
+// retried, with exponential backoff, up to MaxAttempts times and then added
+// to the dead letters. Add blocks while the queue is full.
+func (q *Queue) Add(key, name string, task Task) {
+	it := item{name: name, task: task, maxAttempts: q.MaxAttempts}
+	if !q.add(key, it) {
+		q.run(key, it)
+	}
+}
+
+// Do runs the given task, without retries, after the ones already added with
+// the same key and returns its result.
+func (q *Queue) Do(key, name string, task Task) error {
+	done := make(chan error, 1)
+	if !q.add(key, item{name: name, task: task, maxAttempts: 1, done: done}) {
+		return task()
+	}
+	return <-done
+}
+
+// add adds the given item to the ones pending with the given key, it returns
+// false if the queue is stopped.
+func (q *Queue) add(key string, it item) bool {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	for q.size >= q.capacity && !q.stopped {
+		q.cond.Wait()
+	}
+	if q.stopped {
+		return false
+	}
+	q.size++
+	q.pending[key] = append(q.pending[key], it)
+	if len(q.pending[key]) == 1 && !q.running[key] {
+		q.ready = append(q.ready, key)
+	}
+	q.cond.Broadcast()
+	return true
+}
+
+// DeadLetters returns the tasks that failed all their attempts, the most
+// recent last.
+func (q *Queue) DeadLetters() []DeadLetter {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	return append([]DeadLetter{}, q.deadLetters...)
+}
+
+// Len returns the number of tasks pending or running.
+func (q *Queue) Len() int {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	return q.size
+}
+
+// Stop waits for all pending tasks to finish and stops the queue's workers.
+// Tasks added afterwards run right away.
+func (q *Queue) Stop() {
+	q.mutex.Lock()
+	for q.size > 0 {
+		q.cond.Wait()
+	}
+	q.stopped = true
+	q.cond.Broadcast()
+	q.mutex.Unlock()
+	q.wg.Wait()
+}
+
+func (q *Queue) work() {
+	defer q.wg.Done()
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	for {
+		for len(q.ready) == 0 && !q.stopped {
+			q.cond.Wait()
+		}
+		if q.stopped {
+			return
+		}
+		key := q.ready[0]
+		q.ready = q.ready[1:]
+		it := q.pending[key][0]
+		q.running[key] = true
+
+		q.mutex.Unlock()
+		q.run(key, it)
+		q.mutex.Lock()
+
+		delete(q.running, key)
+		if q.pending[key] = q.pending[key][1:]; len(q.pending[key]) == 0 {
+			delete(q.pending, key)
+		} else {
+			q.ready = append(q.ready, key)
+		}
+		q.size--
+		q.cond.Broadcast()
+	}
+}
+
+// run runs the given task until it succeeds or runs out of attempts.
+func (q *Queue) run(key string, it item) {
+	backoff := q.Backoff
+	var err error
+	attempts := 0
+	for attempts < it.maxAttempts {
+		if attempts > 0 {
+			log.Debug("Retrying %s of %s in %s", it.name, key, backoff)
+			time.Sleep(backoff)
+			backoff *= 2
+		}
+		attempts++
+		if err = it.task(); err == nil {
+			break
+		}
+		log.Warning("Attempt %d of %s of %s failed: %s", attempts, it.name, key, err)
+	}
+	if it.done != nil {
+		it.done <- err
+		return
+	}
+	if err != nil {
+		q.mutex.Lock()
+		q.deadLetters = append(q.deadLetters, DeadLetter{
+			Key:      key,
+			Name:     it.name,
+			Error:    err.Error(),
+			Attempts: attempts,
+			Time:     time.Now(),
+		})
+		if len(q.deadLetters) > MaxDeadLetters {
+			q.deadLetters = q.deadLetters[len(q.deadLetters)-MaxDeadLetters:]
+		}
+		q.mutex.Unlock()
+	}
+}
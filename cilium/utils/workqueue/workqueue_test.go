@@ -0,0 +1,140 @@
+package workqueue
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestQueueOrderByKey(t *testing.T) {
+	const workers = 3
+	q := NewQueue(workers, 4)
+	var (
+		mutex            sync.Mutex
+		got              = map[string][]int{}
+		running, maxRuns int32
+	)
+	for i := 0; i < 20; i++ {
+		for _, key := range []string{"a", "b", "c", "d"} {
+			i, key := i, key
+			q.Add(key, "task", func() error {
+				if n := atomic.AddInt32(&running, 1); n > atomic.LoadInt32(&maxRuns) {
+					atomic.StoreInt32(&maxRuns, n)
+				}
+				defer atomic.AddInt32(&running, -1)
+				time.Sleep(time.Millisecond)
+				mutex.Lock()
+				defer mutex.Unlock()
+				got[key] = append(got[key], i)
+				return nil
+			})
+		}
+	}
+	q.Stop()
+
+	for key, values := range got {
+		for i, value := range values {
+			if i != value {
+				t.Fatalf("tasks of %s run out of order: %v", key, values)
+			}
+		}
+		if len(values) != 20 {
+			t.Errorf("invalid number of tasks run for %s:\ngot  %d\nwant %d", key, len(values), 20)
+		}
+	}
+	if maxRuns > workers {
+		t.Errorf("more tasks than workers run in parallel: %d", maxRuns)
+	}
+}
+
+func TestQueueRetriesAndDeadLetters(t *testing.T) {
+	q := NewQueue(2, 10)
+	q.Backoff = time.Millisecond
+	var attempts int32
+	q.Add("1", "create", func() error {
+		if atomic.AddInt32(&attempts, 1) < 2 {
+			return fmt.Errorf("endpoint not found")
+		}
+		return nil
+	})
+	q.Add("2", "create", func() error {
+		return fmt.Errorf("endpoint not found")
+	})
+	var after string
+	q.Add("2", "die", func() error {
+		after = "die"
+		return nil
+	})
+	q.Stop()
+
+	if attempts != 2 {
+		t.Errorf("invalid number of attempts:\ngot  %d\nwant %d", attempts, 2)
+	}
+	if after != "die" {
+		t.Errorf("task after a dead letter wasn't run")
+	}
+	deadLetters := q.DeadLetters()
+	for i := range deadLetters {
+		deadLetters[i].Time = time.Time{}
+	}
+	want := []DeadLetter{{Key: "2", Name: "create", Error: "endpoint not found", Attempts: DefaultMaxAttempts}}
+	if !reflect.DeepEqual(deadLetters, want) {
+		t.Errorf("invalid dead letters:\ngot  %+v\nwant %+v", deadLetters, want)
+	}
+}
+
+func TestQueueBackpressure(t *testing.T) {
+	q := NewQueue(1, 2)
+	release := make(chan struct{})
+	for i := 0; i < 2; i++ {
+		q.Add(strconv.Itoa(i), "block", func() error {
+			<-release
+			return nil
+		})
+	}
+	added := make(chan struct{})
+	go func() {
+		q.Add("2", "task", func() error { return nil })
+		close(added)
+	}()
+	select {
+	case <-added:
+		t.Fatalf("task added to a full queue")
+	case <-time.After(20 * time.Millisecond):
+	}
+	close(release)
+	select {
+	case <-added:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("task not added after the queue had room")
+	}
+	q.Stop()
+}
+
+func TestQueueDo(t *testing.T) {
+	q := NewQueue(2, 10)
+	var order []string
+	q.Add("1", "create", func() error {
+		time.Sleep(5 * time.Millisecond)
+		order = append(order, "create")
+		return nil
+	})
+	err := q.Do("1", "hook", func() error {
+		order = append(order, "hook")
+		return fmt.Errorf("denied")
+	})
+	if err == nil || err.Error() != "denied" {
+		t.Errorf("invalid error:\ngot  %v\nwant %s", err, "denied")
+	}
+	if want := []string{"create", "hook"}; !reflect.DeepEqual(order, want) {
+		t.Errorf("invalid order:\ngot  %v\nwant %v", order, want)
+	}
+	q.Stop()
+	if len(q.DeadLetters()) != 0 {
+		t.Errorf("failed Do added to the dead letters: %+v", q.DeadLetters())
+	}
+}